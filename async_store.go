@@ -0,0 +1,166 @@
+package caches
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAsyncStoreWorkers   = 4
+	defaultAsyncStoreQueueSize = 128
+)
+
+type storeJob struct {
+	ctx        context.Context
+	table      string
+	identifier string
+	q          *Query[any]
+	ttl        time.Duration
+}
+
+// asyncStorer is the pool of workers storing the results in the background when AsyncStore is set,
+// it is started upon the first asynchronous store
+type asyncStorer struct {
+	once   sync.Once
+	mu     sync.RWMutex // guards closed, so that no job is sent once the queue is closed
+	closed bool
+	jobs   chan storeJob
+	wg     sync.WaitGroup
+}
+
+// detachedDestOf returns a deep copy of the destination of q, the values deepCopy does not support, e.g. the
+// structs with unexported fields, go through the configured Serializer, which is what the Cacher stores anyway
+func detachedDestOf(q *Query[any]) (interface{}, error) {
+	dest := newDestOf(q.Dest)
+	if err := deepCopy(q.Dest, dest); err == nil {
+		return dest, nil
+	}
+
+	codec := &queryCodec{serializer: q.codec.serializerOf()}
+	dst := &Query[any]{Dest: newDestOf(q.Dest), codec: codec}
+	if err := (&Query[any]{Dest: q.Dest, RowsAffected: q.RowsAffected, codec: codec}).copyTo(dst); err != nil {
+		return nil, err
+	}
+	return dst.Dest, nil
+}
+
+func (c *Caches) storeAsync(db *gorm.DB, identifier string, q *Query[any], ttl time.Duration) {
+	// The caller owns the destination and may reuse it as soon as the query returns, so it is deep copied, as the
+	// one BeforeStore is handed is, and the Cacher stores the same value it would synchronously
+	dest, err := detachedDestOf(q)
+	if err != nil {
+		atomic.AddUint64(&c.stats.storeErrors, 1)
+		c.cacheError(db, CacheOpStore, identifier, err)
+		return
+	}
+	detached := *q
+	detached.Dest = dest
+
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	job := storeJob{
+		ctx:        detachedContext{ctx},
+		table:      db.Statement.Table,
+		identifier: identifier,
+		q:          &detached,
+		ttl:        ttl,
+	}
+
+	s := &c.storer
+	s.once.Do(func() {
+		c.startStorer()
+	})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		// Closing the plugin does not lose the results of the queries still running
+		if err := c.store(job.ctx, job.table, job.identifier, job.q, job.ttl); err != nil {
+			c.cacheError(db, CacheOpStore, identifier, err)
+		}
+		return
+	}
+
+	select {
+	case s.jobs <- job:
+	default:
+		c.skipStore(db, SkipDropped)
+	}
+}
+
+func (c *Caches) startStorer() {
+	workers, size := c.Conf.AsyncStoreWorkers, c.Conf.AsyncStoreQueueSize
+	if workers <= 0 {
+		workers = defaultAsyncStoreWorkers
+	}
+	if size <= 0 {
+		size = defaultAsyncStoreQueueSize
+	}
+
+	s := &c.storer
+	s.jobs = make(chan storeJob, size)
+	s.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer s.wg.Done()
+			for job := range s.jobs {
+				if err := c.store(job.ctx, job.table, job.identifier, job.q, job.ttl); err != nil {
+					c.reportError(job.ctx, cacheOpError(CacheOpStore, job.identifier, job.table, err))
+				}
+			}
+		}()
+	}
+}
+
+// Close waits for the queued AsyncStore results to be stored and stops the workers,
+// the results of the queries completing afterwards are stored synchronously
+// It flushes the increments buffered by the write-back mode as well, returning the error of the flush, and the
+// later increments run straight away.
+func (c *Caches) Close() error {
+	err := c.closeWriteBack()
+
+	s := &c.storer
+	s.once.Do(func() {}) // Stores racing with Close must not start a new pool
+
+	s.mu.Lock()
+	if s.closed || s.jobs == nil {
+		s.closed = true
+		s.mu.Unlock()
+		return err
+	}
+	s.closed = true
+	close(s.jobs)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+// detachedContext keeps the values of its parent while ignoring its cancellation,
+// since the query context is usually canceled once the request which ran the query completes
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,203 @@
+package caches
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+// blockingCacherMock blocks every Store until unblock is closed
+type blockingCacherMock struct {
+	cacherMock
+	started chan struct{}
+	unblock chan struct{}
+
+	mu   sync.Mutex
+	ctxs []context.Context
+}
+
+func (c *blockingCacherMock) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	c.mu.Lock()
+	c.ctxs = append(c.ctxs, ctx)
+	c.mu.Unlock()
+
+	c.started <- struct{}{}
+	<-c.unblock
+	return c.cacherMock.Store(ctx, key, val, ttl)
+}
+
+func TestCaches_AsyncStore(t *testing.T) {
+	newCaches := func(cacher Cacher) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher:              cacher,
+				AsyncStore:          true,
+				AsyncStoreWorkers:   1,
+				AsyncStoreQueueSize: 1,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
+				},
+			},
+		}
+	}
+	newDB := func(ctx context.Context, sql string) *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Context = ctx
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString(sql)
+		return db
+	}
+
+	t.Run("deep copied results", func(t *testing.T) {
+		cacher := &cacherMock{}
+		caches := newCaches(cacher)
+
+		db := newDB(context.Background(), "demo-query")
+		caches.query(db)
+		db.Statement.Dest.(*mockDest).Result = "reused by the caller"
+		_ = caches.Close()
+
+		if act := caches.Stats(); act.Stores != 1 {
+			t.Fatalf("expected the result to be stored upon Close, got %+v", act)
+		}
+		res, _ := cacher.Get(context.Background(), caches.identifierOf(newDB(context.Background(), "demo-query")), nil)
+		if act := res.Dest.(*mockDest).Result; act != "demo-query" {
+			t.Errorf("expected the stored result not to be affected by the caller, got `%s`", act)
+		}
+	})
+
+	t.Run("serialized as the synchronous stores", func(t *testing.T) {
+		type row struct {
+			Value  interface{}
+			Secret string `json:"-"`
+		}
+		for name, async := range map[string]bool{"sync": false, "async": true} {
+			t.Run(name, func(t *testing.T) {
+				cacher := NewMemoryCacher()
+				caches := &Caches{
+					Conf: &Config{Cacher: cacher, Serializer: GobSerializer{}, AsyncStore: async},
+					callbacks: map[queryType]func(db *gorm.DB){
+						uponQuery: func(db *gorm.DB) {
+							*db.Statement.Dest.(*[]row) = []row{{Value: 1, Secret: "kept"}}
+							db.Statement.RowsAffected = 1
+						},
+					},
+				}
+				newDB := func() *gorm.DB {
+					db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+					db.Statement.Dest = &[]row{}
+					db.Statement.Table = "rows"
+					db.Statement.SQL.WriteString("demo-query")
+					return db
+				}
+				caches.query(newDB())
+				_ = caches.Close()
+
+				db := newDB()
+				caches.query(db)
+				rows := *db.Statement.Dest.(*[]row)
+				if len(rows) != 1 || rows[0].Value != 1 || rows[0].Secret != "kept" {
+					t.Errorf("expected the value to be stored as the Serializer encodes it, got %+v", rows)
+				}
+				if act := caches.Stats(); act.Hits != 1 {
+					t.Errorf("expected the second query to hit the cache, got %+v", act)
+				}
+			})
+		}
+	})
+
+	t.Run("unexported fields", func(t *testing.T) {
+		type row struct {
+			Name   string
+			hidden string
+		}
+		cacher := &cacherMock{}
+		caches := newCaches(cacher)
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			db.Statement.Dest.(*row).Name = "jinzhu"
+		}
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &row{hidden: "dropped"}
+		db.Statement.SQL.WriteString("demo-query")
+		caches.query(db)
+		_ = caches.Close()
+
+		if act := caches.Stats(); act.Stores != 1 || act.StoreErrors != 0 {
+			t.Fatalf("expected the struct deepCopy does not support to be detached through the Serializer, got %+v", act)
+		}
+	})
+
+	t.Run("dropped under backpressure", func(t *testing.T) {
+		observer := &observerMock{}
+		cacher := &blockingCacherMock{started: make(chan struct{}), unblock: make(chan struct{})}
+		caches := newCaches(cacher)
+		caches.Conf.Observer = observer
+
+		caches.query(newDB(context.Background(), "first"))
+		<-cacher.started // The single worker is busy with the first result
+		caches.query(newDB(context.Background(), "second"))
+
+		start := time.Now()
+		caches.query(newDB(context.Background(), "third"))
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("expected the query not to block on the full queue, it took %s", elapsed)
+		}
+
+		close(cacher.unblock)
+		go func() {
+			for range cacher.started {
+			}
+		}()
+		_ = caches.Close()
+
+		if act := caches.Stats(); act.Stores != 2 || act.Dropped != 1 {
+			t.Errorf("expected two results to be stored and one to be dropped, got %+v", act)
+		}
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		var skipped bool
+		for _, event := range observer.events {
+			skipped = skipped || event == fmt.Sprintf("skip::%s", SkipDropped)
+		}
+		if !skipped {
+			t.Errorf("expected the observer to be notified about the dropped result, got %v", observer.events)
+		}
+	})
+
+	t.Run("query context cancellation", func(t *testing.T) {
+		type ctxKey struct{}
+		cacher := &blockingCacherMock{started: make(chan struct{}, 1), unblock: make(chan struct{})}
+		caches := newCaches(cacher)
+
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+		caches.query(newDB(ctx, "demo-query"))
+		cancel()
+		close(cacher.unblock)
+		_ = caches.Close()
+
+		storeCtx := cacher.ctxs[0]
+		if storeCtx.Err() != nil {
+			t.Errorf("expected the store not to be canceled along with the query, got %v", storeCtx.Err())
+		}
+		if storeCtx.Value(ctxKey{}) != "value" {
+			t.Error("expected the store context to keep the values of the query context")
+		}
+	})
+
+	t.Run("after close", func(t *testing.T) {
+		caches := newCaches(&cacherMock{})
+		_ = caches.Close()
+
+		caches.query(newDB(context.Background(), "demo-query"))
+		if act := caches.Stats(); act.Stores != 1 {
+			t.Errorf("expected the result to be stored synchronously once closed, got %+v", act)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BatchEntry is a value handed to BatchCacher.BatchStore, along with its key and ttl
+type BatchEntry struct {
+	Key   string
+	Value *Query[any]
+	TTL   time.Duration
+}
+
+// BatchCacher is an optional extension of Cacher, for backends able to pipeline several operations in a single round-trip
+// It is used by WarmBatch, the Cacher's single operations remain the baseline every backend has to implement.
+type BatchCacher interface {
+	// BatchGet impl should behave as Get for every key, qs holding the query Get would receive for the key of same index
+	// It returns a value per key, which is nil for the missing keys
+	BatchGet(ctx context.Context, keys []string, qs []*Query[any]) ([]*Query[any], error)
+	// BatchStore impl should behave as Store for every entry
+	BatchStore(ctx context.Context, entries []BatchEntry) error
+}
+
+// batchStore collects the results of the queries run by WarmBatch, so they are stored at once
+type batchStore struct {
+	mu      sync.Mutex
+	entries []BatchEntry
+	tables  []string // the statement's table of each entry
+}
+
+func (b *batchStore) add(table string, entry BatchEntry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries = append(b.entries, entry)
+	b.tables = append(b.tables, table)
+}
+
+// batchStoreOf returns the batch collecting the results of the query, if it is run by WarmBatch
+func batchStoreOf(db *gorm.DB) *batchStore {
+	if ctx := db.Statement.Context; ctx != nil {
+		b, _ := ctx.Value(batchContextKey).(*batchStore)
+		return b
+	}
+	return nil
+}
+
+// storeBatch stores the collected results with a single BatchStore when the Cacher is a BatchCacher,
+// and one by one otherwise, it returns the errors of the failed stores
+func (c *Caches) storeBatch(ctx context.Context, b *batchStore) []error {
+	if len(b.entries) == 0 {
+		return nil
+	}
+
+	batcher, ok := c.Conf.Cacher.(BatchCacher)
+	if !ok {
+		var errs []error
+		for i, entry := range b.entries {
+			if err := c.store(ctx, b.tables[i], entry.Key, entry.Value, entry.TTL); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errs
+	}
+
+	if err := batcher.BatchStore(ctx, b.entries); err != nil {
+		if errors.Is(err, ErrSkipCache) {
+			for _, table := range b.tables {
+				c.skipStoreOf(table, SkipCacher)
+			}
+			return nil
+		}
+		atomic.AddUint64(&c.stats.storeErrors, uint64(len(b.entries)))
+		return []error{err}
+	}
+
+	atomic.AddUint64(&c.stats.stores, uint64(len(b.entries)))
+	for i, entry := range b.entries {
+		if c.Conf.Observer != nil {
+			c.Conf.Observer.OnStore(b.tables[i], int(entry.Value.RowsAffected))
+		}
+		c.log(ctx, LogStore, b.tables[i], entry.Key, nil)
+	}
+	return nil
+}
@@ -2,16 +2,25 @@ package caches
 
 import (
 	"context"
+	"time"
 )
 
 type Cacher interface {
 	// Get impl should check if a specific key exists in the cache and return its value
 	// look at Query.Marshal
+	// A value is best decoded into q, the plugin deep copies the values returned in another destination beforehand,
+	// since the caller may mutate its result
 	Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error)
 	// Store impl should store a cached representation of the val param
+	// for the given ttl, a zero ttl means the value should never expire
+	// The destination of val is the one of the caller, which may mutate it once the query returns,
+	// so it should not be retained as is
 	// look at Query.Unmarshal
-	Store(ctx context.Context, key string, val *Query[any]) error
-	// Invalidate impl should invalidate all cached values
+	// Returning ErrSkipCache refuses the value without failing the query, e.g. when it is too large for the backend
+	Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error
+	// Invalidate impl should invalidate the cached values reading from any of the tables,
+	// along with the values whose tables are unknown (see Query.Tables)
+	// When no tables are given, all cached values should be invalidated
 	// It will be called when INSERT / UPDATE / DELETE queries are sent to the DB
-	Invalidate(ctx context.Context) error
+	Invalidate(ctx context.Context, tables ...string) error
 }
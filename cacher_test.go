@@ -4,13 +4,20 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"time"
 )
 
 type cacherMock struct {
+	mu    sync.Mutex
 	store *sync.Map
+	ttl   time.Duration
+
+	invalidatedTables []string
 }
 
 func (c *cacherMock) init() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if c.store == nil {
 		c.store = &sync.Map{}
 	}
@@ -26,13 +33,19 @@ func (c *cacherMock) Get(_ context.Context, key string, _ *Query[any]) (*Query[a
 	return val.(*Query[any]), nil
 }
 
-func (c *cacherMock) Store(_ context.Context, key string, val *Query[any]) error {
+func (c *cacherMock) Store(_ context.Context, key string, val *Query[any], ttl time.Duration) error {
 	c.init()
+	c.mu.Lock()
+	c.ttl = ttl
+	c.mu.Unlock()
 	c.store.Store(key, val)
 	return nil
 }
 
-func (c *cacherMock) Invalidate(context.Context) error {
+func (c *cacherMock) Invalidate(_ context.Context, tables ...string) error {
+	c.mu.Lock()
+	c.invalidatedTables = tables
+	c.mu.Unlock()
 	return nil
 }
 
@@ -42,11 +55,11 @@ func (c *cacherStoreErrorMock) Get(context.Context, string, *Query[any]) (*Query
 	return nil, nil
 }
 
-func (c *cacherStoreErrorMock) Store(context.Context, string, *Query[any]) error {
+func (c *cacherStoreErrorMock) Store(context.Context, string, *Query[any], time.Duration) error {
 	return errors.New("store-error")
 }
 
-func (c *cacherStoreErrorMock) Invalidate(context.Context) error {
+func (c *cacherStoreErrorMock) Invalidate(context.Context, ...string) error {
 	return nil
 }
 
@@ -56,10 +69,10 @@ func (c *cacherGetErrorMock) Get(context.Context, string, *Query[any]) (*Query[a
 	return nil, errors.New("get-error")
 }
 
-func (c *cacherGetErrorMock) Store(context.Context, string, *Query[any]) error {
+func (c *cacherGetErrorMock) Store(context.Context, string, *Query[any], time.Duration) error {
 	return nil
 }
 
-func (c *cacherGetErrorMock) Invalidate(context.Context) error {
+func (c *cacherGetErrorMock) Invalidate(context.Context, ...string) error {
 	return nil
 }
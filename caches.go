@@ -1,28 +1,277 @@
 package caches
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
 )
 
+// Caches is the gorm plugin, an instance is registered on a single gorm.DB with db.Use
+//
+// Several instances, e.g. the ones of a primary and of a replica gorm.DB, may share a Cacher: the identifiers are
+// built from the queries and the name of their database only (see Config.Database), so a value cached through one
+// instance is served through the others, and the invalidations of any instance reach the shared Cacher. The table decisions and the easer are local to every
+// instance, which is harmless as long as they share the same table rules, KeyPrefix, KeyBuilder and Tagger.
 type Caches struct {
+	stats cacheStats // Kept first so its counters are 64-bit aligned for atomic access on 32-bit platforms
+
 	callbacks map[queryType]func(db *gorm.DB)
 	Conf      *Config
 
-	queue *sync.Map
+	queue          *easeQueue
+	tableTTLs      decisionCache[time.Duration]
+	cacheDecisions decisionCache[bool]
+	tablePatterns  sync.Map
+	storer         asyncStorer
+	transactions   sync.Map // the pending invalidations of the transactions run by Transaction, per ConnPool
+	tombstones     *tombstones
+	writeBack      *writeBack
+	database       string // the database the identifiers are scoped to, see Config.Database
+	disabled       int32  // set by Disable, read atomically by every query
+
+	tableRulesByName bool // set upon Initialize when the table rules are all regexes, which only match the table name
 }
 
 type Config struct {
 	Easer  bool
 	Cacher Cacher
+
+	// OnCoalesce is optional, it is called whenever the easer serves a query with the result of an identical one
+	// instead of the database, with their identifier and the number of queries which waited for that same result,
+	// e.g. three queries joining a running one make three calls with 3. It is called with or without a Cacher.
+	// The identifier is not redacted, unlike the Logger's ones, so the keys of a KeyBuilder may expose bind variables
+	OnCoalesce func(identifier string, waiters int)
+	// MaxEaseWait bounds how long a query waits for an identical one already running, once exceeded it queries
+	// the database itself, which trades some duplicate load for a bounded latency when the running query is stuck
+	// The running query carries on for its remaining waiters. Zero means the queries wait as long as their context allows
+	MaxEaseWait time.Duration
+	// MaxEaseWaiters bounds the number of queries waiting for an identical one already running, the later ones query
+	// the database themselves, which bounds the queries failing at once along with a failing or stuck one
+	// Zero means any number of queries may wait
+	MaxEaseWaiters int
+
+	// DefaultTTL is the ttl passed to Cacher.Store, zero means the cached values never expire
+	DefaultTTL time.Duration
+	// TableTTL overrides DefaultTTL for the tables matching its regex keys
+	TableTTL map[string]time.Duration
+
+	// MaxTableDecisions bounds the number of tables whose decisions are memoized, i.e. whether they may be cached
+	// or eased and their ttl, the ones not used lately are evicted beyond it. It defaults to 4096 when zero,
+	// which keeps the memory of the apps querying many dynamically named tables, e.g. sharded ones, bounded.
+	MaxTableDecisions int
+	// TTLJitter randomizes the ttl of every stored value within that fraction around it, e.g. 0.1 stores a value
+	// cached for 10m for 9m to 11m, so the values stored at once, e.g. by Warm, do not all expire at once
+	// It has to be between 0 and 1, zero disables it
+	TTLJitter float64
+	// NegativeTTL caps the ttl of the results without rows, zero means they are stored like any other result
+	// When set, the results of the queries failing with gorm.ErrRecordNotFound (e.g. First) are stored as well,
+	// and the cache hits on them fail with that same error
+	NegativeTTL time.Duration
+	// StaleWhileRevalidate keeps serving the values which outlived their ttl for that much longer,
+	// while a single background query per value refreshes them, which trades freshness for the latency of the misses
+	// Zero disables it, and it does not apply to the values stored without a ttl
+	StaleWhileRevalidate time.Duration
+	// RefreshAheadFactor refreshes the values served within that fraction of the end of their ttl in the background,
+	// e.g. 0.2 for the last 20%, while the value itself is served, so the expiry of a hot value never makes a query
+	// wait on the database. It has to be between 0 and 1, zero disables it, and it does not apply to the values
+	// stored without a ttl
+	RefreshAheadFactor float64
+	// ReadYourWritesWindow is optional, when set the queries reading from a table mutated within that window skip
+	// the Cacher and the easer, e.g. so that a read following a write on a replica lagging behind the primary is not
+	// served a value cached before the write, which bounds the staleness to the replication lag rather than the ttl.
+	// It should exceed the replication lag. The mutated tables are tracked in memory per instance, so only the writes
+	// run through this instance (or its dbresolver replicas) are accounted for, those of a Transaction once it commits
+	ReadYourWritesWindow time.Duration
+
+	// CanCachedTables restricts caching to the tables matching any of its rules, all tables are cached when it is empty
+	// A string rule is a regex matched against the table name, any other rule is a model (or its reflect.Type)
+	// The regexes match anywhere within the table name, e.g. `user` matches `user_audit_log`, see AnchorTablePatterns
+	CanCachedTables []any
+	// CanNotCachedTables excludes the tables matching any of its rules from caching, it takes precedence over CanCachedTables
+	CanNotCachedTables []any
+	// EaseTables restricts the easer to the tables matching any of its rules, with the same rules as CanCachedTables,
+	// so the queries of the other tables are never coalesced. It applies independently from the caching rules, e.g.
+	// an expensive table excluded by CanNotCachedTables may still be eased. The easer applies to all tables when empty
+	EaseTables []any
+	// AnchorTablePatterns makes the regexes of CanCachedTables, CanNotCachedTables and EaseTables match the whole
+	// table name, as if they were wrapped in `^(?:...)$`, so that `user` only matches `user`
+	AnchorTablePatterns bool
+
+	// MaxCacheRows skips storing the results with more rows, zero means no limit
+	MaxCacheRows int
+	// MaxCacheBytes skips storing the results whose estimated size in bytes is larger, zero means no limit
+	MaxCacheBytes int
+	// CachePredicate is optional, it is called with the statement once the query ran, its Dest and RowsAffected
+	// populated, and returning false skips storing the result, which is still returned, e.g. to only cache the lists
+	// of some tables below a size. It is only called for the results passing MaxCacheRows and MaxCacheBytes
+	CachePredicate func(db *gorm.DB) bool
+	// BeforeStore is optional, it is called with a deep copy of every query result about to be stored, so it can
+	// transform or redact its Dest, e.g. strip a sensitive field from the rows, while the caller is still returned
+	// the result as the database returned it. It runs on the Go values, before the Serializer and the Compressor.
+	BeforeStore func(q *Query[any])
+	// AfterGet is optional, it is called with every valid value found in the Cacher before it is served, e.g. to
+	// re-derive the fields BeforeStore stripped. It runs on the Go values, once decompressed, decoded and detached
+	// from the Cacher, so it may modify them. Neither hook applies to the results the easer shares between
+	// identical queries running at the same time, which are the database's ones.
+	AfterGet func(q *Query[any])
+	// MinQueryDuration skips storing the results of the queries which took less time to run against the database,
+	// e.g. the primary key lookups which are already fast, zero stores them all. The queries coalesced by the easer
+	// are measured by the database time of the query they were served from. The results of Warm are always stored
+	MinQueryDuration time.Duration
+	// RecordQueryDuration stores how long every query took to run against the database along with its result, so
+	// the hits, including the ones of the other processes sharing the Cacher, report the time they saved to an
+	// Observer implementing SavedDurationObserver
+	RecordQueryDuration bool
+
+	// AsyncStore hands the results to a bounded pool of background workers instead of storing them within the query,
+	// so a slow Cacher does not delay the cache misses. The results are deep copied beforehand, and when the queue is
+	// full they are dropped rather than blocking the query. Store errors are then only counted in Stats.StoreErrors.
+	// AsyncStoreWorkers (defaults to 4) and AsyncStoreQueueSize (defaults to 128) size the pool, see Caches.Close
+	AsyncStore          bool
+	AsyncStoreWorkers   int
+	AsyncStoreQueueSize int
+
+	// WriteBackTables enables the write-back mode for the tables matching its rules, which are the ones of
+	// CanCachedTables: the counter increments of these tables, e.g. the updates of a hot `page_views` table by
+	//
+	//	db.Model(&PageView{}).Where("page = ?", page).UpdateColumn("views", gorm.Expr("views + ?", 1))
+	//
+	// are buffered in memory instead of running, and summed per column and WHERE clause, until they are flushed
+	// as a single UPDATE every WriteBackInterval (defaults to 1s), once WriteBackThreshold increments are buffered,
+	// or upon FlushWrites and Close. The other updates of these tables run as usual, see Caches.FlushWrites.
+	//
+	// The buffered increments are lost if the process dies before they are flushed, and are neither visible to the
+	// reads nor to the other processes meanwhile. The buffered updates report no affected rows, skip the invalidation,
+	// and do not fail when no row matches. The flushes run through the mutation callbacks, so they invalidate as
+	// usual, and a failed flush is retried, so an increment whose flush failed after it was committed is applied twice.
+	WriteBackTables    []any
+	WriteBackInterval  time.Duration
+	WriteBackThreshold int
+
+	// WarmConcurrency bounds the number of queries Warm runs in parallel, it defaults to 4
+	WarmConcurrency int
+
+	// KeyPrefix is prepended to the identifiers of all the queries, including the ones built by the KeyBuilder,
+	// it namespaces the keys of the services sharing a single cache backend
+	KeyPrefix string
+	// Database scopes the identifiers of the queries to a database, so the same SQL run against two databases,
+	// e.g. `tenant_a` and `tenant_b` over two connections sharing a Cacher, is never served the other's result
+	// It defaults to the name the migrator's CurrentDatabase returns, which is queried once upon Initialize.
+	// Set it when the connections differ otherwise, e.g. by their Postgres search_path, or to skip that query.
+	// The instances meant to share their values, e.g. the ones of a primary and of its replicas, share the same name
+	Database string
+	// KeyContextKeys lists the context keys whose values scope the identifiers of the queries, e.g. the locale or the
+	// currency of a request whose session variables, set with `SET`, change the results of an identical SQL
+	// The values present in the query's context are formatted deterministically, as the bind variables are, so the
+	// queries run with the same values share their identifier, while the ones run without any of them are identified
+	// as if the option was not set. Identifier and Warm use the context of the db they are given
+	KeyContextKeys []any
+
+	// KeyBuilder is optional, when set it replaces the default identifier of the queries in the Cacher and the easer
+	// It is called once the statement's SQL and Vars are built, and must return the same key for identical queries
+	// while never returning the same key for different ones, so it has to include the bind variables (db.Statement.Vars)
+	// A builder dropping them would serve the result of a query to any other query sharing its SQL
+	// The keys are placed under IdentifierPrefix, unless they start with it already
+	KeyBuilder func(db *gorm.DB) string
+	// HashKeys digests the keys of the KeyBuilder, along with their database, into IdentifierPrefix followed by
+	// 32 hex characters, as the default identifiers are, so every key fits the backends limiting their length,
+	// e.g. the 250 bytes of memcached, once prefixed with the KeyPrefix. The raw keys are logged along with their
+	// digest, as LogKey events, when LogIdentifiers is set
+	HashKeys bool
+	// PrimaryKeyIdentifiers identifies the lookups of a single row by its primary key, e.g. `First(&user, 1)`, by
+	// `<table>:pk:<value>` rather than by a digest of their SQL, which is not built to serve a hit, so gorm logs
+	// the hits without their SQL. The keys are handled as the ones of a KeyBuilder, which takes precedence over them,
+	// e.g. placed under IdentifierPrefix, scoped to the database and digested with HashKeys. The other queries keep
+	// their default identifier
+	PrimaryKeyIdentifiers bool
+
+	// Tagger is optional, when set along with a Cacher implementing TagInvalidator, the updates and deletes
+	// of rows with known tags only invalidate the values tagged with them, instead of all the values of their table
+	// e.g. with the PrimaryKeyTagger, updating a user only invalidates the cached values holding that user,
+	// which means a cached value is not invalidated by a mutated row which it did not include, but may have to now
+	// The values it returns no tags for, and the creates, keep being invalidated per table
+	Tagger Tagger
+
+	// Locker is optional, when set the queries missing the Cacher are serialized across the processes sharing it,
+	// see Locker. LockWait bounds how long a query waits for the result of another process (defaults to 1s),
+	// while LockPollInterval sets how often the Cacher and the lock are checked meanwhile (defaults to 50ms), a query
+	// waiting on a lock released without a stored result querying the database once it sees it released
+	Locker           Locker
+	LockWait         time.Duration
+	LockPollInterval time.Duration
+
+	// Serializer is optional, when set it replaces the JSON encoding of Query.Marshal and Query.Unmarshal,
+	// e.g. caches.GobSerializer{}, so it applies to any Cacher relying on them. Its payloads are the ones compressed.
+	// A value which fails to decode, e.g. one cached with the previous Serializer, is treated as a cache miss
+	Serializer Serializer
+
+	// Compressor is optional, when set the values produced by Query.Marshal are compressed with it
+	// A value which fails to decompress is treated as a cache miss
+	Compressor Compressor
+
+	// CacheErrorMode tells whether the errors of the Cacher's Get and Store fail the queries, which is the default,
+	// or are only reported while the queries fall back on the database, see CacheErrorIgnore
+	CacheErrorMode CacheErrorMode
+
+	// CacheGetTimeout is optional, when set every Cacher's Get is handed a context derived from the statement's one
+	// which expires after it, the query itself keeps the statement's context. A Get failing with the expired context
+	// is a lookup error, which only falls back on the database with the CacheErrorIgnore mode
+	// The Cacher has to honor the cancellation of its context, as the built-in RedisCacher does
+	CacheGetTimeout time.Duration
+
+	// DryRun makes the queries and mutations behave as if the plugin was not registered, never calling the Cacher
+	// nor coalescing queries, while the Logger receives a LogDryRun event about every query, with its identifier and
+	// whether it would be cached, and about every mutation, with the tables it would invalidate, e.g. to check
+	// the table rules of a service before enabling the cache. Warm, Transaction and the manual invalidations
+	// do not call the Cacher either.
+	DryRun bool
+	// SkipInvalidateWhenDisabled makes the mutations leave the cache untouched while the plugin is disabled with
+	// Disable, as SkipInvalidate does, e.g. when the Cacher itself is the cause of the incident. By default they still
+	// invalidate, since the values they make stale would be served once the plugin is enabled again otherwise
+	SkipInvalidateWhenDisabled bool
+
+	// ExposeMetadata sets the ServedFromSetting of every query going through the plugin, and the AgeSetting of
+	// the ones served from the cache, on their statement settings, so the caller can tell whether a result came
+	// from the cache and how old it was, e.g. `tx.Get(caches.AgeSetting)`. The values cached without it have no age.
+	ExposeMetadata bool
+
+	// Logger is optional, when set it receives debug level events about the hits, misses, stores, coalesced queries
+	// and invalidations, see NewGormLogger to log them with gorm's logger
+	// The identifiers are redacted, since they may hold bind variables, unless LogIdentifiers is set
+	Logger         Logger
+	LogIdentifiers bool
+
+	// Tracer is optional, when set the queries are traced with a span nesting the spans of the cache lookup,
+	// of the easer and of the store, under the span of the statement's context (see Tracer for an OpenTelemetry adapter)
+	Tracer Tracer
+
+	// Observer is optional, when set it is notified about every cache operation
+	Observer MetricsObserver
 }
 
 func (c *Caches) Name() string {
 	return "gorm:caches"
 }
 
+// Initialize installs the plugin on the db, it is called by db.Use, which already rejects a second plugin of the same
+// name with gorm.ErrRegistered. Initialize itself returns gorm.ErrRegistered as well when the plugin is already
+// installed on the db, or when this instance was already initialized, since it would otherwise capture its own
+// callbacks, or the ones of another plugin instance, as the original ones and cache every query twice.
 func (c *Caches) Initialize(db *gorm.DB) error {
+	if _, ok := db.Plugins[c.Name()]; ok || c.callbacks != nil {
+		return gorm.ErrRegistered
+	}
+
 	if c.Conf == nil {
 		c.Conf = &Config{
 			Easer:  false,
@@ -30,8 +279,21 @@ func (c *Caches) Initialize(db *gorm.DB) error {
 		}
 	}
 
-	if c.Conf.Easer {
-		c.queue = &sync.Map{}
+	if c.Conf.Easer || c.Conf.StaleWhileRevalidate > 0 || c.Conf.RefreshAheadFactor > 0 {
+		c.queue = newEaseQueue()
+	}
+
+	if c.Conf.ReadYourWritesWindow > 0 {
+		c.tombstones = &tombstones{}
+	}
+
+	if err := c.compileTablePatterns(); err != nil {
+		return err
+	}
+
+	c.database = c.Conf.Database
+	if c.database == "" {
+		c.database = currentDatabase(db)
 	}
 
 	callbacks := make(map[queryType]func(db *gorm.DB), 4)
@@ -57,65 +319,249 @@ func (c *Caches) Initialize(db *gorm.DB) error {
 		return err
 	}
 
-	return nil
+	return c.startWriteBack(db)
 }
 
 // query is a decorator around the default "gorm:query" callback
 // it takes care to both ease database load and cache results
+//
+// Raw SQL run with Find is cached as well, keyed by its SQL and bind variables, even without a model.
+// Raw SQL run with Scan, Row or Rows goes through gorm's "gorm:row" callback instead, which hands the database rows
+// to the caller, so it is never cached.
+//
+// FindInBatches runs a query per batch, each one reading the rows past the primary key the previous one ended on,
+// so every batch has an identifier of its own, and a hit restores the RowsAffected the loop relies on to end.
+//
+// The associations loaded by Joins are part of the cached rows. The ones loaded by Preload are not, since gorm
+// preloads them once this callback returns, with queries of their own which are cached separately, so a hit is
+// preloaded like a miss. The cached rows only hold the fields the Serializer encodes, e.g. the JSON one drops
+// the unexported fields and the ones tagged `json:"-"`, while the GobSerializer only drops the unexported ones.
 func (c *Caches) query(db *gorm.DB) {
-	if c.Conf.Easer == false && c.Conf.Cacher == nil {
+	if capture := identifying(db); capture != nil {
+		if *capture == "" { // The preloads of the query run after it, with identifiers of their own
+			*capture = c.identifierOf(db)
+		}
+		return
+	}
+
+	if !c.Enabled() || (c.Conf.Easer == false && c.Conf.Cacher == nil) || !hasDest(db) || isBypassed(db) ||
+		holdsRowLocks(db) || inTransaction(db) || !readOnly(db) {
 		c.callbacks[uponQuery](db)
+		c.exposeSource(db, nil)
 		return
 	}
 
-	identifier := buildIdentifier(db)
+	identifier := c.identifierOf(db)
+	if c.Conf.DryRun {
+		c.logEvent(db.Statement.Context, LogEvent{
+			Operation:  LogDryRun,
+			Table:      db.Statement.Table,
+			Identifier: identifier,
+			Cacheable:  c.canCacheTable(db),
+		})
+		c.callbacks[uponQuery](db)
+		c.exposeSource(db, nil)
+		return
+	}
+	if c.recentlyWritten(db) {
+		// The Cacher, or an identical query which started before the write, may still hold the previous rows
+		c.callbacks[uponQuery](db)
+		c.exposeSource(db, nil)
+		return
+	}
 
-	if c.checkCache(db, identifier) {
+	cacheable := c.canCacheTable(db)
+	warming := isWarming(db)
+	refresh := warming || isRefreshed(db)
+
+	span, end := c.startSpan(db, SpanQuery, identifier)
+	defer end()
+
+	if cacheable && !refresh && c.checkCache(db, identifier) {
+		span.SetAttribute(AttributeHit, true)
 		return
 	}
+	span.SetAttribute(AttributeHit, false)
+	c.exposeSource(db, nil)
+
+	easeIdentifier := identifier
+	if refresh {
+		easeIdentifier = refreshEasePrefix + identifier
+	}
 
-	c.ease(db, identifier)
-	if db.Error != nil {
+	var done bool
+	queryCb := func(db *gorm.DB) {
+		done = c.fetch(db, identifier, cacheable && !refresh)
+	}
+	if warming {
+		// A warming query must store its own result, rather than the one of a query which may have started earlier
+		queryCb(db)
+	} else {
+		c.ease(db, easeIdentifier, queryCb)
+	}
+	if done || !cacheable || (db.Error != nil && !c.cachesNotFound(db)) {
 		return
 	}
 
 	c.storeInCache(db, identifier)
-	if db.Error != nil {
-		return
+}
+
+var rowLockPattern = regexp.MustCompile(`(?i)\bFOR\s+(?:NO\s+KEY\s+)?(?:UPDATE|SHARE|KEY\s+SHARE)\b|\bLOCK\s+IN\s+SHARE\s+MODE\b`)
+
+// holdsRowLocks reports whether the query locks the rows it reads, through a clause.Locking or in its raw SQL,
+// such a query is part of a transaction relying on reading the current rows, so it is neither served from the
+// Cacher, stored, nor coalesced with another one
+func holdsRowLocks(db *gorm.DB) bool {
+	if _, ok := db.Statement.Clauses[clause.Locking{}.Name()]; ok {
+		return true
 	}
+	return db.Statement.SQL.Len() > 0 && rowLockPattern.MatchString(db.Statement.SQL.String())
 }
 
-// getMutatorCb returns a decorator which calls the Cacher's Invalidate method
+var (
+	leadingCommentPattern = regexp.MustCompile(`^(?:\s+|/\*(?s:.*?)\*/|--[^\n]*(?:\n|$))*`)
+	leadingVerbPattern    = regexp.MustCompile(`^\(*\s*([A-Za-z]+)`)
+	writeVerbPattern      = regexp.MustCompile(`(?i)\b(?:INSERT|UPDATE|DELETE|MERGE)\b`)
+	writeVerbs            = map[string]bool{
+		"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "REPLACE": true, "UPSERT": true,
+		"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "CALL": true, "EXEC": true, "EXECUTE": true,
+		"DO": true, "SET": true, "LOCK": true, "GRANT": true, "REVOKE": true, "VACUUM": true, "COPY": true,
+	}
+)
+
+// readOnly reports whether the query may be cached as a read, the raw SQL routed through the query callbacks may
+// have side effects instead, e.g. an INSERT ... RETURNING run with Find, in which case it is run against the database
+// as is, neither cached nor coalesced. The raw statements starting with a write verb are such ones, and so are the
+// common table expressions mentioning one, since they may modify data, e.g. with Postgres. The statements built by
+// gorm's query callback are always SELECTs.
+func readOnly(db *gorm.DB) bool {
+	if db.Statement.SQL.Len() == 0 {
+		return true
+	}
+
+	sql := db.Statement.SQL.String()
+	sql = sql[len(leadingCommentPattern.FindString(sql)):]
+	match := leadingVerbPattern.FindStringSubmatch(sql)
+	if match == nil {
+		return true
+	}
+	verb := strings.ToUpper(match[1])
+	if verb == "WITH" {
+		return !writeVerbPattern.MatchString(sql)
+	}
+	return !writeVerbs[verb]
+}
+
+// cachesNotFound reports whether the query failed with gorm.ErrRecordNotFound and such results should be stored
+func (c *Caches) cachesNotFound(db *gorm.DB) bool {
+	return c.Conf.NegativeTTL > 0 && errors.Is(db.Error, gorm.ErrRecordNotFound)
+}
+
+// getMutatorCb returns a decorator which calls the Cacher's Invalidate method, or its InvalidateTags one (see Config.Tagger),
+// and records the tombstones of the mutated tables (see Config.ReadYourWritesWindow)
 func (c *Caches) getMutatorCb(typ queryType) func(db *gorm.DB) {
 	return func(db *gorm.DB) {
-		if c.Conf.Cacher != nil {
-			if err := c.Conf.Cacher.Invalidate(db.Statement.Context); err != nil {
-				_ = db.AddError(err)
+		if skipsInvalidation(db) || bufferedWrite(db) || (c.Conf.SkipInvalidateWhenDisabled && !c.Enabled()) {
+			if cb := c.callbacks[typ]; cb != nil {
+				cb(db)
+			}
+			return
+		}
+
+		if c.Conf.DryRun {
+			c.logEvent(db.Statement.Context, LogEvent{
+				Operation:   LogDryRun,
+				Table:       db.Statement.Table,
+				Invalidated: dryRunInvalidated(mutationTables(db)),
+			})
+		} else if c.Conf.Cacher != nil {
+			if err := c.invalidate(db, typ); err != nil {
+				opErr := cacheOpError(CacheOpInvalidate, "", db.Statement.Table, err)
+				c.reportError(db.Statement.Context, opErr)
+				_ = db.AddError(opErr)
 			}
 		}
 		if cb := c.callbacks[typ]; cb != nil { // By default, gorm has no callbacks associated with mutating behaviors
 			cb(db)
 		}
+		if c.pendingOf(db) == nil { // The tombstones of a Transaction are recorded once it commits
+			c.markWritten(mutationTables(db))
+		}
 	}
 }
 
-func (c *Caches) ease(db *gorm.DB, identifier string) {
-	if c.Conf.Easer == false {
-		c.callbacks[uponQuery](db)
+// ease runs queryCb unless an identical query is already running, in which case its result is copied instead
+func (c *Caches) ease(db *gorm.DB, identifier string, queryCb func(db *gorm.DB)) {
+	_, end := c.startSpan(db, SpanEase, identifier)
+	defer end()
+
+	if c.Conf.Easer == false || !c.canEaseTable(db) {
+		queryCb(db)
 		return
 	}
 
-	res := ease(&queryTask{
+	t := &queryTask{
 		id:      identifier,
 		db:      db,
-		queryCb: c.callbacks[uponQuery],
-	}, c.queue).(*queryTask)
+		queryCb: queryCb,
+	}
+	ctx := db.Statement.Context
+	waitCtx := ctx
+	if c.Conf.MaxEaseWait > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, c.Conf.MaxEaseWait)
+		defer cancel()
+	}
+	leader, waiters := ease(t, c.queue, &c.stats.inFlight, waitCtx.Done(), c.Conf.MaxEaseWaiters)
+	res, _ := leader.(*queryTask)
+	if res == nil {
+		// The identical query carries on for its other waiters, which it hands its result to as if this one had not waited
+		if err := ctx.Err(); err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		// Waited for MaxEaseWait
+		queryCb(db)
+		return
+	}
+	if res != t {
+		atomic.AddUint64(&c.stats.coalesced, 1)
+		if c.Conf.Observer != nil {
+			c.Conf.Observer.OnEaseCoalesced(db.Statement.Table)
+		}
+		c.log(ctx, LogCoalesced, db.Statement.Table, identifier, nil)
+		if c.Conf.OnCoalesce != nil {
+			c.Conf.OnCoalesce(identifier, waiters)
+		}
+
+		if err := res.db.Error; err != nil {
+			if leaderCtx := res.db.Statement.Context; leaderCtx.Err() != nil && errors.Is(err, leaderCtx.Err()) {
+				// The identical query was cancelled by its own caller, which says nothing about this one
+				queryCb(db)
+				return
+			}
+			_ = db.AddError(err)
+		}
+	}
 
-	if db.Error != nil {
+	// The query which ran holds its own result already
+	if res == t || db.Error != nil {
 		return
 	}
+	if elapsed, ok := res.db.InstanceGet(queryDurationKey); ok {
+		db.InstanceSet(queryDurationKey, elapsed)
+	}
 
-	if res.db.Statement.Dest == db.Statement.Dest {
+	if samePointer(res.db.Statement.Dest, db.Statement.Dest) {
+		// The queries share their destination, which already holds the result, but not their statement,
+		// so the count is copied all the same
+		db.Statement.RowsAffected = res.db.Statement.RowsAffected
+		return
+	}
+
+	if copyScalar(res.db.Statement.Dest, db.Statement.Dest) {
+		// A scalar, e.g. the count of a Count, shares no memory once assigned, so it skips the deep copy
+		db.Statement.RowsAffected = res.db.Statement.RowsAffected
 		return
 	}
 
@@ -137,34 +583,456 @@ func (c *Caches) ease(db *gorm.DB, identifier string) {
 
 func (c *Caches) checkCache(db *gorm.DB, identifier string) bool {
 	if c.Conf.Cacher != nil {
-		res, err := c.Conf.Cacher.Get(db.Statement.Context, identifier, &Query[any]{
-			Dest:         db.Statement.Dest,
-			RowsAffected: db.Statement.RowsAffected,
-		})
-		if err != nil {
-			_ = db.AddError(err)
-		}
+		span, end := c.startSpan(db, SpanCheckCache, identifier)
+		defer end()
 
-		if res != nil {
-			res.replaceOn(db)
+		if res := c.lookup(db, identifier); res != nil {
+			span.SetAttribute(AttributeHit, true)
+			c.serve(db, identifier, res)
+			if res.stale() || res.refreshDue() {
+				c.revalidate(db, identifier)
+			}
 			return true
 		}
+		span.SetAttribute(AttributeHit, false)
+		atomic.AddUint64(&c.stats.misses, 1)
+		if c.Conf.Observer != nil {
+			c.Conf.Observer.OnMiss(db.Statement.Table)
+		}
+		c.log(db.Statement.Context, LogMiss, db.Statement.Table, identifier, nil)
 	}
 	return false
 }
 
+// lookup returns the valid value cached for the query, if any
+//
+// The value is set on the statement's destination as is, which is safe when the Cacher decoded it into the
+// destination it was handed. A value the Cacher returns in its own destination may be retained by it, e.g. by
+// a Cacher holding the stored queries in a map, so it is deep copied first, otherwise a caller mutating the nested
+// slices, maps or pointers of its result would mutate the cached value along with it.
+func (c *Caches) lookup(db *gorm.DB, identifier string) *Query[any] {
+	ctx := db.Statement.Context
+	if c.Conf.CacheGetTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Conf.CacheGetTimeout)
+		defer cancel()
+	}
+
+	dest := newDestOf(db.Statement.Dest)
+	res, expiresAt, err := getWithExpiryOf(ctx, c.Conf.Cacher, identifier, &Query[any]{
+		Dest:         dest,
+		RowsAffected: db.Statement.RowsAffected,
+		codec:        c.codecOf(db),
+	})
+	if err == nil && res != nil && !samePointer(res.Dest, dest) {
+		if err = destTypeMismatch(res.Dest, dest); err == nil {
+			res, err = detach(res, dest)
+		}
+	}
+	if err != nil {
+		if isDestTypeError(err) {
+			// A value of another type, e.g. decoded by a faulty Serializer or cached for another model before a deploy,
+			// would corrupt the result, so it is reported but treated as a miss whatever the CacheErrorMode
+			atomic.AddUint64(&c.stats.mismatches, 1)
+			c.reportError(db.Statement.Context, cacheOpError(CacheOpGet, identifier, db.Statement.Table, err))
+			return nil
+		}
+		if !isDecompressError(err) && !isDecodeError(err) {
+			atomic.AddUint64(&c.stats.getErrors, 1)
+			c.cacheError(db, CacheOpGet, identifier, err)
+		}
+		return nil
+	}
+
+	if res == nil {
+		return nil
+	}
+	c.withExpiry(db, res, expiresAt)
+	if res.expired() {
+		return nil
+	}
+	if c.Conf.AfterGet != nil {
+		c.Conf.AfterGet(res)
+	}
+	return res
+}
+
+// detach returns a copy of the query holding a deep copy of its destination in dest
+func detach(q *Query[any], dest any) (*Query[any], error) {
+	if err := deepCopy(q.Dest, dest); err != nil {
+		return nil, err
+	}
+
+	detached := *q
+	detached.Dest = dest
+	return &detached, nil
+}
+
+// destTypeError reports a value returned by the Cacher whose destination is not of the statement's destination type
+type destTypeError struct {
+	expected, actual reflect.Type
+}
+
+func (e *destTypeError) Error() string {
+	return fmt.Sprintf("caches: the cached value holds a %v while the query expects a %v", e.actual, e.expected)
+}
+
+func isDestTypeError(err error) bool {
+	var target *destTypeError
+	return errors.As(err, &target)
+}
+
+// destTypeMismatch returns a destTypeError unless the cached destination, or the value it points to,
+// is of the type dest points to
+func destTypeMismatch(cached, dest any) error {
+	expected := reflect.TypeOf(dest).Elem()
+	actual := reflect.TypeOf(cached)
+	if actual != nil && actual.Kind() == reflect.Ptr && actual.Elem() == expected {
+		return nil
+	}
+	if actual == expected {
+		return nil
+	}
+	return &destTypeError{expected: expected, actual: actual}
+}
+
+// samePointer reports whether a and b are the same pointer
+func samePointer(a, b any) bool {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	return va.Kind() == reflect.Ptr && vb.Kind() == reflect.Ptr && va.Pointer() == vb.Pointer()
+}
+
+// serve sets a cached value on the statement
+func (c *Caches) serve(db *gorm.DB, identifier string, res *Query[any]) {
+	atomic.AddUint64(&c.stats.hits, 1)
+	if c.Conf.Observer != nil {
+		c.Conf.Observer.OnHit(db.Statement.Table)
+	}
+	c.reportSaved(db, res.QueryDuration)
+	c.log(db.Statement.Context, LogHit, db.Statement.Table, identifier, nil)
+	c.exposeSource(db, res)
+	res.replaceOn(db)
+	if res.RowsAffected == 0 && db.Statement.RaiseErrorOnNotFound {
+		_ = db.AddError(gorm.ErrRecordNotFound)
+	}
+}
+
+// reportSaved notifies a SavedDurationObserver about the query duration a hit saved, the values stored without
+// Config.RecordQueryDuration are not reported
+func (c *Caches) reportSaved(db *gorm.DB, saved time.Duration) {
+	if saved <= 0 || !c.Conf.RecordQueryDuration {
+		return
+	}
+	if o, ok := c.Conf.Observer.(SavedDurationObserver); ok {
+		o.OnHitSaved(db.Statement.Table, saved)
+	}
+}
+
 func (c *Caches) storeInCache(db *gorm.DB, identifier string) {
 	if c.Conf.Cacher != nil {
-		err := c.Conf.Cacher.Store(db.Statement.Context, identifier, &Query[any]{
+		_, end := c.startSpan(db, SpanStoreInCache, identifier)
+		defer end()
+
+		if c.oversized(db) {
+			c.skipStore(db, SkipOversized)
+			return
+		}
+		if c.Conf.CachePredicate != nil && !c.Conf.CachePredicate(db) {
+			c.skipStore(db, SkipPredicate)
+			return
+		}
+		if c.tooFast(db) {
+			c.skipStore(db, SkipFast)
+			return
+		}
+
+		freshTTL := c.storeTTLOf(db)
+		staleAt, ttl := c.staleTTLs(freshTTL)
+		q := &Query[any]{
 			Dest:         db.Statement.Dest,
 			RowsAffected: db.Statement.RowsAffected,
-		})
-		if err != nil {
-			_ = db.AddError(err)
+			StaleAt:      staleAt,
+			RefreshAt:    c.refreshAt(freshTTL),
+			Tables:       queryTables(db),
+			codec:        c.storeCodecOf(db),
+		}
+		if c.Conf.ExposeMetadata {
+			q.StoredAt = time.Now()
+		}
+		if c.Conf.RecordQueryDuration {
+			if elapsed, ok := db.InstanceGet(queryDurationKey); ok {
+				q.QueryDuration = elapsed.(time.Duration)
+			}
 		}
+		if ttl > 0 {
+			q.ExpiresAt = time.Now().Add(ttl)
+		}
+		q.Tags = c.queryTags(db, q)
+		if c.Conf.BeforeStore != nil {
+			// The hook transforms a copy, the caller is returned the result as the database returned it
+			dest := newDestOf(q.Dest)
+			if err := deepCopy(q.Dest, dest); err != nil {
+				atomic.AddUint64(&c.stats.storeErrors, 1)
+				c.cacheError(db, CacheOpStore, identifier, err)
+				return
+			}
+			q.Dest = dest
+			c.Conf.BeforeStore(q)
+		}
+
+		if b := batchStoreOf(db); b != nil {
+			b.add(db.Statement.Table, BatchEntry{Key: identifier, Value: q, TTL: ttl})
+			return
+		}
+
+		if c.Conf.AsyncStore {
+			c.storeAsync(db, identifier, q, ttl)
+			return
+		}
+
+		if err := c.store(db.Statement.Context, db.Statement.Table, identifier, q, ttl); err != nil {
+			c.cacheError(db, CacheOpStore, identifier, err)
+		}
+	}
+}
+
+// queryDurationKey is the instance setting holding how long the query took to run against the database,
+// it is only set when Config.MinQueryDuration or Config.RecordQueryDuration is
+const queryDurationKey = "caches:query_duration"
+
+// runQuery runs the original query callback, timing it when Config.MinQueryDuration or Config.RecordQueryDuration
+// is set
+func (c *Caches) runQuery(db *gorm.DB) {
+	if c.Conf.MinQueryDuration <= 0 && !c.Conf.RecordQueryDuration {
+		c.callbacks[uponQuery](db)
+		return
+	}
+
+	start := time.Now()
+	c.callbacks[uponQuery](db)
+	db.InstanceSet(queryDurationKey, time.Since(start))
+}
+
+// tooFast reports whether the query ran faster than Config.MinQueryDuration, a query which was not timed is not
+func (c *Caches) tooFast(db *gorm.DB) bool {
+	if c.Conf.MinQueryDuration <= 0 || isWarming(db) {
+		return false
+	}
+	elapsed, ok := db.InstanceGet(queryDurationKey)
+	return ok && elapsed.(time.Duration) < c.Conf.MinQueryDuration
+}
+
+// exposeSource sets the metadata settings of the statement when Config.ExposeMetadata is set,
+// hit is the cached value it is served, nil when it is served from the database
+func (c *Caches) exposeSource(db *gorm.DB, hit *Query[any]) {
+	if !c.Conf.ExposeMetadata {
+		return
+	}
+	// The settings are inherited by the statements chained from this one, which must not report its age as theirs
+	if hit == nil || hit.StoredAt.IsZero() {
+		db.Statement.Settings.Delete(AgeSetting)
+	} else {
+		db.Statement.Settings.Store(AgeSetting, time.Since(hit.StoredAt))
+	}
+
+	if hit == nil {
+		db.Statement.Settings.Store(ServedFromSetting, ServedFromDatabase)
+	} else {
+		db.Statement.Settings.Store(ServedFromSetting, ServedFromCache)
+	}
+}
+
+// cacheError reports an error of the Cacher as a CacheOpError, failing the query with it unless CacheErrorMode is
+// CacheErrorIgnore
+func (c *Caches) cacheError(db *gorm.DB, op CacheOp, identifier string, err error) {
+	opErr := cacheOpError(op, identifier, db.Statement.Table, err)
+	c.reportError(db.Statement.Context, opErr)
+	if c.Conf.CacheErrorMode != CacheErrorIgnore {
+		_ = db.AddError(opErr)
 	}
 }
 
+// reportError notifies the ErrorObserver about the error of a cache operation and logs it as a LogError event
+func (c *Caches) reportError(ctx context.Context, err *CacheOpError) {
+	c.notifyError(err.Table, err)
+	c.logEvent(ctx, LogEvent{Operation: LogError, Table: err.Table, Identifier: err.Key, Err: err})
+}
+
+func (c *Caches) notifyError(table string, err error) {
+	if o, ok := c.Conf.Observer.(ErrorObserver); ok {
+		o.OnCacheError(table, err)
+	}
+}
+
+// store hands the query to the Cacher and records the outcome
+func (c *Caches) store(ctx context.Context, table, identifier string, q *Query[any], ttl time.Duration) error {
+	if err := c.Conf.Cacher.Store(ctx, identifier, q, ttl); err != nil {
+		if errors.Is(err, ErrSkipCache) {
+			c.skipStoreOf(table, SkipCacher)
+			return nil
+		}
+		atomic.AddUint64(&c.stats.storeErrors, 1)
+		return err
+	}
+
+	atomic.AddUint64(&c.stats.stores, 1)
+	if c.Conf.Observer != nil {
+		c.Conf.Observer.OnStore(table, int(q.RowsAffected))
+	}
+	c.log(ctx, LogStore, table, identifier, nil)
+	return nil
+}
+
+func (c *Caches) skipStore(db *gorm.DB, reason SkipReason) {
+	c.skipStoreOf(db.Statement.Table, reason)
+}
+
+func (c *Caches) skipStoreOf(table string, reason SkipReason) {
+	if reason == SkipDropped {
+		atomic.AddUint64(&c.stats.dropped, 1)
+	} else {
+		atomic.AddUint64(&c.stats.skipped, 1)
+	}
+	if o, ok := c.Conf.Observer.(SkipObserver); ok {
+		o.OnSkipStore(table, reason)
+	}
+}
+
+// identifierOf returns the identifier of the query, the key given by WithKey or KeySetting when any, or the one
+// built by the KeyBuilder when one is configured, or the primary key one of a lookup when PrimaryKeyIdentifiers is set,
+// and prefixed with the KeyPrefix. They are scoped to the database, the keys other than the default identifiers by
+// prefixing them with its name, and to the values of the KeyContextKeys, the other keys by suffixing them.
+// The other keys are digested as well when HashKeys is set, and are otherwise placed under IdentifierPrefix.
+func (c *Caches) identifierOf(db *gorm.DB) string {
+	contextValues := c.keyContextValues(db.Statement.Context)
+	key, custom := queryKey(db)
+	if !custom && c.Conf.KeyBuilder == nil {
+		if c.Conf.PrimaryKeyIdentifiers {
+			key, custom = primaryKeyIdentifier(db)
+		}
+		if !custom {
+			return c.Conf.KeyPrefix + buildIdentifier(db, c.database, contextValues)
+		}
+	} else {
+		callbacks.BuildQuerySQL(db)
+		if !custom {
+			key = c.Conf.KeyBuilder(db)
+		}
+	}
+	// The keys are namespaced by IdentifierPrefix, as the default identifiers are, so the backends invalidating every
+	// key sharing it, e.g. the RedisCacher, reach them as well
+	key = scopeKey(strings.TrimPrefix(key, IdentifierPrefix), contextValues)
+	if c.database != "" {
+		key = c.database + ":" + key
+	}
+	if c.Conf.HashKeys {
+		hashed := hashKey(key)
+		if c.Conf.LogIdentifiers {
+			c.logEvent(db.Statement.Context, LogEvent{
+				Operation:  LogKey,
+				Table:      db.Statement.Table,
+				Identifier: c.Conf.KeyPrefix + hashed,
+				Key:        key,
+			})
+		}
+		return c.Conf.KeyPrefix + hashed
+	}
+	return c.Conf.KeyPrefix + IdentifierPrefix + key
+}
+
+// dryRunInvalidated returns the tables a LogDryRun event reports as invalidated, the unknown tables of a mutation
+// being reported as an empty, non nil, list since they would invalidate every cached value
+func dryRunInvalidated(tables []string) []string {
+	if tables == nil {
+		return []string{}
+	}
+	return tables
+}
+
+// currentDatabase returns the name of the database the gorm.DB is connected to, empty when it cannot be queried,
+// e.g. in DryRun mode or with a dialector without migrator
+func currentDatabase(db *gorm.DB) string {
+	if db.DryRun || db.ConnPool == nil {
+		return ""
+	}
+	m := db.Migrator()
+	if m == nil {
+		return ""
+	}
+	return m.CurrentDatabase()
+}
+
+// codecOf returns the codec of the queries handed to the Cacher, nil when neither a Serializer nor a Compressor
+// is configured
+func (c *Caches) codecOf(db *gorm.DB) *queryCodec {
+	if c.Conf.Serializer == nil && c.Conf.Compressor == nil {
+		return nil
+	}
+
+	codec := &queryCodec{serializer: c.Conf.Serializer, compressor: c.Conf.Compressor}
+	if o, ok := c.Conf.Observer.(CompressionObserver); ok {
+		table := db.Statement.Table
+		codec.onCompress = func(original, compressed int) {
+			o.OnCompress(table, original, compressed)
+		}
+	}
+	return codec
+}
+
+// storeCodecOf returns the codec of the queries handed to Cacher.Store, which reports the size of their payloads
+// to a PayloadObserver as well
+func (c *Caches) storeCodecOf(db *gorm.DB) *queryCodec {
+	codec := c.codecOf(db)
+	o, ok := c.Conf.Observer.(PayloadObserver)
+	if !ok {
+		return codec
+	}
+
+	if codec == nil {
+		codec = &queryCodec{}
+	}
+	table := db.Statement.Table
+	codec.onMarshal = func(size int) {
+		o.OnPayloadSize(table, size)
+	}
+	return codec
+}
+
+// hasDest reports whether the statement has a destination to read the results into, a nil one, e.g. of a statement
+// meant for Exec which reached the query callback, or a nil pointer, can neither be cached nor copied to
+// Neither can a destination which is not a pointer, e.g. the map[string]interface{} gorm scans into in place,
+// since a cached value is written through the pointer, so such queries always run against the database
+func hasDest(db *gorm.DB) bool {
+	dest := reflect.ValueOf(db.Statement.Dest)
+	return dest.IsValid() && dest.Kind() == reflect.Ptr && !dest.IsNil()
+}
+
+// newDestOf allocates an empty value of the same type as dest,
+// so that a cached value is only written on the statement once it is known to be valid
+// Scalar destinations, e.g. the *int64 of a Count, are allocated the same way
+func newDestOf(dest interface{}) interface{} {
+	destType := reflect.TypeOf(dest)
+	if destType == nil || destType.Kind() != reflect.Ptr {
+		return dest
+	}
+	return reflect.New(destType.Elem()).Interface()
+}
+
+// CacheErrorMode tells how the errors of the Cacher are handled, see Config.CacheErrorMode
+type CacheErrorMode int
+
+const (
+	// CacheErrorFailFast fails the queries with the errors of the Cacher, wrapped into a *CacheOpError
+	CacheErrorFailFast CacheErrorMode = iota
+	// CacheErrorIgnore only counts the errors of the Cacher in the Stats and reports them to an ErrorObserver,
+	// a query failing to Get falls back on the database, and a query failing to Store still returns its result
+	// The invalidation errors still fail the mutations, since ignoring them would leave stale values behind
+	CacheErrorIgnore
+)
+
+// refreshEasePrefix separates the refreshing queries from the regular ones in the easer queue
+const refreshEasePrefix = "refresh::"
+
 // queryType is used to mark callbacks
 type queryType int
 
@@ -1,14 +1,18 @@
 package caches
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"gorm.io/gorm/utils/tests"
 )
 
@@ -158,7 +162,7 @@ func TestCaches_query(t *testing.T) {
 			caches := &Caches{
 				Conf: conf,
 
-				queue: &sync.Map{},
+				queue: newEaseQueue(),
 				callbacks: map[queryType]func(db *gorm.DB){
 					uponQuery: func(db *gorm.DB) {
 						db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
@@ -196,7 +200,7 @@ func TestCaches_query(t *testing.T) {
 				caches := &Caches{
 					Conf: conf,
 
-					queue: &sync.Map{},
+					queue: newEaseQueue(),
 					callbacks: map[queryType]func(db *gorm.DB){
 						uponQuery: func(db *gorm.DB) {
 							time.Sleep(1 * time.Second)
@@ -249,7 +253,7 @@ func TestCaches_query(t *testing.T) {
 			caches := &Caches{
 				Conf: conf,
 
-				queue: &sync.Map{},
+				queue: newEaseQueue(),
 				callbacks: map[queryType]func(db *gorm.DB){
 					uponQuery: func(db *gorm.DB) {
 						time.Sleep(1 * time.Second)
@@ -306,7 +310,7 @@ func TestCaches_query(t *testing.T) {
 							Cacher: &cacherStoreErrorMock{},
 						},
 
-						queue: &sync.Map{},
+						queue: newEaseQueue(),
 						callbacks: map[queryType]func(db *gorm.DB){
 							uponQuery: func(db *gorm.DB) {
 								db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
@@ -334,7 +338,7 @@ func TestCaches_query(t *testing.T) {
 							Cacher: &cacherGetErrorMock{},
 						},
 
-						queue: &sync.Map{},
+						queue: newEaseQueue(),
 						callbacks: map[queryType]func(db *gorm.DB){
 							uponQuery: func(db *gorm.DB) {
 								db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
@@ -363,7 +367,7 @@ func TestCaches_query(t *testing.T) {
 						Cacher: &cacherMock{},
 					},
 
-					queue: &sync.Map{},
+					queue: newEaseQueue(),
 					callbacks: map[queryType]func(db *gorm.DB){
 						uponQuery: func(db *gorm.DB) {
 							db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
@@ -404,7 +408,7 @@ func TestCaches_query(t *testing.T) {
 					Cacher: &cacherMock{},
 				},
 
-				queue: &sync.Map{},
+				queue: newEaseQueue(),
 				callbacks: map[queryType]func(db *gorm.DB){
 					uponQuery: func(db *gorm.DB) {
 						time.Sleep(1 * time.Second)
@@ -449,7 +453,7 @@ func TestCaches_query(t *testing.T) {
 					Cacher: &cacherMock{},
 				},
 
-				queue: &sync.Map{},
+				queue: newEaseQueue(),
 				callbacks: map[queryType]func(db *gorm.DB){
 					uponQuery: func(db *gorm.DB) {
 						time.Sleep(1 * time.Second)
@@ -481,6 +485,140 @@ func TestCaches_query(t *testing.T) {
 			}
 		})
 	})
+
+	t.Run("cacher with ttl", func(t *testing.T) {
+		newCaches := func(ttl time.Duration, incr *int32) *Caches {
+			return &Caches{
+				Conf: &Config{
+					Easer:      false,
+					Cacher:     &cacherMock{},
+					DefaultTTL: ttl,
+				},
+
+				queue: newEaseQueue(),
+				callbacks: map[queryType]func(db *gorm.DB){
+					uponQuery: func(db *gorm.DB) {
+						atomic.AddInt32(incr, 1)
+
+						db.Statement.Dest.(*mockDest).Result = fmt.Sprintf("%d", atomic.LoadInt32(incr))
+					},
+				},
+			}
+		}
+		runQuery := func(caches *Caches) *mockDest {
+			db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			db.Statement.Dest = &mockDest{}
+			db.Statement.SQL.WriteString("demo-query")
+			caches.query(db)
+			if db.Error != nil {
+				t.Fatalf("an unexpected error has occurred, %v", db.Error)
+			}
+			return db.Statement.Dest.(*mockDest)
+		}
+
+		t.Run("passes the ttl to the cacher", func(t *testing.T) {
+			var incr int32
+			caches := newCaches(time.Minute, &incr)
+			runQuery(caches)
+
+			cacher := caches.Conf.Cacher.(*cacherMock)
+			if cacher.ttl != time.Minute {
+				t.Errorf("expected Store to receive a ttl of %s, got %s", time.Minute, cacher.ttl)
+			}
+		})
+
+		t.Run("not expired", func(t *testing.T) {
+			var incr int32
+			caches := newCaches(time.Minute, &incr)
+			runQuery(caches)
+			res := runQuery(caches)
+
+			if act := atomic.LoadInt32(&incr); act != 1 {
+				t.Errorf("when executing two identical queries, expected to run %d time, but %d", 1, act)
+			}
+			if res.Result != "1" {
+				t.Errorf("expected the cached result `1`, got `%s`", res.Result)
+			}
+		})
+
+		t.Run("expired", func(t *testing.T) {
+			var incr int32
+			caches := newCaches(100*time.Millisecond, &incr)
+			runQuery(caches)
+			time.Sleep(200 * time.Millisecond)
+			res := runQuery(caches)
+
+			if act := atomic.LoadInt32(&incr); act != 2 {
+				t.Errorf("when executing an expired query, expected to run %d times, but %d", 2, act)
+			}
+			if res.Result != "2" {
+				t.Errorf("expected the fresh result `2`, got `%s`", res.Result)
+			}
+		})
+
+		t.Run("expired with easer", func(t *testing.T) {
+			var incr int32
+			caches := newCaches(100*time.Millisecond, &incr)
+			caches.Conf.Easer = true
+			runQuery(caches)
+			time.Sleep(200 * time.Millisecond)
+			res := runQuery(caches)
+
+			if act := atomic.LoadInt32(&incr); act != 2 {
+				t.Errorf("when executing an expired query through the easer, expected to run %d times, but %d", 2, act)
+			}
+			if res.Result != "2" {
+				t.Errorf("expected the fresh result `2`, got `%s`", res.Result)
+			}
+		})
+
+		t.Run("zero ttl never expires", func(t *testing.T) {
+			var incr int32
+			caches := newCaches(0, &incr)
+			runQuery(caches)
+
+			cacher := caches.Conf.Cacher.(*cacherMock)
+			cacher.store.Range(func(_, val any) bool {
+				if q := val.(*Query[any]); !q.ExpiresAt.IsZero() {
+					t.Errorf("expected no expiry to be set for a zero ttl, got %s", q.ExpiresAt)
+				}
+				return true
+			})
+		})
+	})
+
+	t.Run("cacher with excluded table", func(t *testing.T) {
+		var incr int32
+		cacher := &cacherMock{}
+		caches := &Caches{
+			Conf: &Config{
+				Cacher:             cacher,
+				CanNotCachedTables: []any{"^users$"},
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					atomic.AddInt32(&incr, 1)
+				},
+			},
+		}
+
+		for i := 0; i < 2; i++ {
+			db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			db.Statement.Dest = &mockDest{}
+			db.Statement.Table = "users"
+			db.Statement.SQL.WriteString("demo-query")
+			caches.query(db)
+		}
+
+		if act := atomic.LoadInt32(&incr); act != 2 {
+			t.Errorf("when executing two identical queries on an excluded table, expected to run %d times, but %d", 2, act)
+		}
+		cacher.init()
+		cacher.store.Range(func(key, _ any) bool {
+			t.Errorf("expected nothing to be stored for an excluded table, got `%s`", key)
+			return true
+		})
+	})
 }
 
 func TestCaches_getMutatorCb(t *testing.T) {
@@ -512,7 +650,2252 @@ func TestCaches_getMutatorCb(t *testing.T) {
 			if mutator == nil {
 				t.Errorf("loading of gorm:caches, expected generate mutator but it did not")
 			}
+			expectedDb.Statement.Table = "users"
 			mutator(expectedDb)
+
+			if act := caches.Conf.Cacher.(*cacherMock).invalidatedTables; !reflect.DeepEqual(act, []string{"users"}) {
+				t.Errorf("the mutator was expected to invalidate the statement table, got %v", act)
+			}
+		})
+	}
+}
+
+func TestCaches_rawQueries(t *testing.T) {
+	type reportRow struct {
+		Name  string
+		Total int
+	}
+
+	var executed int
+	caches := &Caches{Conf: &Config{Cacher: &cacherMock{}}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+		switch dest := db.Statement.Dest.(type) {
+		case *[]reportRow:
+			*dest = []reportRow{{Name: fmt.Sprint(db.Statement.Vars...), Total: executed}}
+		case *[]map[string]interface{}:
+			*dest = []map[string]interface{}{{"name": fmt.Sprint(db.Statement.Vars...)}}
+		}
+		db.Statement.RowsAffected = 1
+	}
+
+	t.Run("ad-hoc struct without model", func(t *testing.T) {
+		executed = 0
+		for i := 0; i < 2; i++ {
+			var rows []reportRow
+			if err := db.Raw("SELECT name, SUM(total) AS total FROM reports WHERE name = ? GROUP BY name", "daily").
+				Find(&rows).Error; err != nil {
+				t.Fatalf("the raw query resulted into an unexpected error, %v", err)
+			}
+			if len(rows) != 1 || rows[0].Name != "daily" || rows[0].Total != 1 {
+				t.Errorf("expected the raw query result to be the one of the first run, got %+v", rows)
+			}
+		}
+		if executed != 1 {
+			t.Errorf("expected the second raw query to hit the cache, the database was queried %d times", executed)
+		}
+
+		var rows []reportRow
+		db.Raw("SELECT name, SUM(total) AS total FROM reports WHERE name = ? GROUP BY name", "weekly").Find(&rows)
+		if executed != 2 || len(rows) != 1 || rows[0].Name != "weekly" {
+			t.Errorf("expected a raw query with other bind variables not to share the cached value, got %+v", rows)
+		}
+	})
+
+	t.Run("maps", func(t *testing.T) {
+		executed = 0
+		for i := 0; i < 2; i++ {
+			var rows []map[string]interface{}
+			db.Raw("SELECT name FROM reports WHERE name = @name", map[string]interface{}{"name": "daily"}).Find(&rows)
+			if len(rows) != 1 || rows[0]["name"] != "daily" {
+				t.Errorf("expected the raw query result to be the one of the first run, got %+v", rows)
+			}
+		}
+		if executed != 1 {
+			t.Errorf("expected the second raw query to hit the cache, the database was queried %d times", executed)
+		}
+	})
+}
+
+func TestCaches_mapDestinations(t *testing.T) {
+	for name, serializer := range map[string]Serializer{"json": nil, "gob": GobSerializer{}} {
+		t.Run(name, func(t *testing.T) {
+			var executed int
+			caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher(), Serializer: serializer}}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				executed++
+				row := map[string]interface{}{"name": "daily", "total": "42"}
+				switch dest := db.Statement.Dest.(type) {
+				case *[]map[string]interface{}:
+					*dest = []map[string]interface{}{row, {"name": "weekly", "total": "7"}}
+				case *map[string]interface{}:
+					*dest = row
+				case map[string]interface{}:
+					for k, v := range row {
+						dest[k] = v
+					}
+				}
+				db.Statement.RowsAffected = 1
+			}
+
+			t.Run("slice of maps", func(t *testing.T) {
+				executed = 0
+				for i := 0; i < 2; i++ {
+					var rows []map[string]interface{}
+					if err := db.Table("reports").Find(&rows).Error; err != nil {
+						t.Fatalf("the query resulted into an unexpected error, %v", err)
+					}
+					if len(rows) != 2 || rows[0]["name"] != "daily" || rows[1]["total"] != "7" {
+						t.Errorf("expected the rows to round-trip the cache, got %+v", rows)
+					}
+				}
+				if executed != 1 {
+					t.Errorf("expected the second query to hit the cache, the database was queried %d times", executed)
+				}
+			})
+
+			t.Run("pointer to a map", func(t *testing.T) {
+				executed = 0
+				for i := 0; i < 2; i++ {
+					var row map[string]interface{}
+					if err := db.Table("reports").Where("name = ?", "daily").Take(&row).Error; err != nil {
+						t.Fatalf("the query resulted into an unexpected error, %v", err)
+					}
+					if row["name"] != "daily" || row["total"] != "42" {
+						t.Errorf("expected the row to round-trip the cache, got %+v", row)
+					}
+				}
+				if executed != 1 {
+					t.Errorf("expected the second query to hit the cache, the database was queried %d times", executed)
+				}
+			})
+
+			t.Run("map falls through to the database", func(t *testing.T) {
+				executed = 0
+				for i := 0; i < 2; i++ {
+					row := map[string]interface{}{}
+					if err := db.Table("reports").Where("name = ?", "daily").Take(row).Error; err != nil {
+						t.Fatalf("the query resulted into an unexpected error, %v", err)
+					}
+					if row["name"] != "daily" {
+						t.Errorf("expected the row to be scanned in place, got %+v", row)
+					}
+				}
+				if executed != 2 {
+					t.Errorf("expected the map destinations not to be cached, the database was queried %d times", executed)
+				}
+			})
+
+			t.Run("stable identifier", func(t *testing.T) {
+				find := func(tx *gorm.DB) *gorm.DB {
+					var rows []map[string]interface{}
+					return tx.Raw("SELECT name, total FROM reports WHERE name = ?", "daily").Find(&rows)
+				}
+				first, err := caches.Identifier(db, find)
+				if err != nil {
+					t.Fatalf("the identifier resulted into an unexpected error, %v", err)
+				}
+				second, _ := caches.Identifier(db, find)
+				if first == "" || first != second {
+					t.Errorf("expected the identifiers of identical raw queries to be equal, got %q and %q", first, second)
+				}
+			})
+		})
+	}
+}
+
+func TestCaches_rowLocks(t *testing.T) {
+	testCases := map[string]func(db *gorm.DB) *gorm.DB{
+		"for update": func(db *gorm.DB) *gorm.DB {
+			return db.Clauses(clause.Locking{Strength: "UPDATE"})
+		},
+		"for share": func(db *gorm.DB) *gorm.DB {
+			return db.Clauses(clause.Locking{Strength: "SHARE", Options: "NOWAIT"})
+		},
+		"raw sql": func(db *gorm.DB) *gorm.DB {
+			return db.Raw("SELECT * FROM users WHERE id = ? FOR UPDATE", 1)
+		},
+	}
+
+	for name, lock := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var executed int
+			caches := &Caches{Conf: &Config{Easer: true, Cacher: &cacherMock{}}}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				executed++
+				*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: fmt.Sprint(executed)}}
+				db.Statement.RowsAffected = 1
+			}
+
+			// The unlocked read is cached, and must not be served to the locked ones
+			db.Table("users").Where("id = ?", 1).Find(&[]mockDest{})
+
+			for i := 0; i < 2; i++ {
+				var rows []mockDest
+				lock(db.Table("users").Where("id = ?", 1)).Find(&rows)
+				if len(rows) != 1 || rows[0].Result != fmt.Sprint(i+2) {
+					t.Errorf("expected the locked read to be served by the database, got %+v", rows)
+				}
+			}
+			if executed != 3 {
+				t.Errorf("expected every locked read to hit the database, it was queried %d times", executed)
+			}
+			if act := caches.Stats(); act.Stores != 1 {
+				t.Errorf("expected only the unlocked read to be stored, got %+v", act)
+			}
+		})
+	}
+}
+
+func TestCaches_CacheErrorMode(t *testing.T) {
+	newCaches := func(cacher Cacher, mode CacheErrorMode, observer MetricsObserver) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher:         cacher,
+				CacheErrorMode: mode,
+				Observer:       observer,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					if db.Error == nil {
+						db.Statement.Dest.(*mockDest).Result = "from-database"
+					}
+				},
+			},
+		}
+	}
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.Table = "users"
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	testCases := map[string]struct {
+		cacher   Cacher
+		mode     CacheErrorMode
+		fails    bool
+		expected Stats
+	}{
+		"fail fast get": {
+			cacher:   &cacherGetErrorMock{},
+			mode:     CacheErrorFailFast,
+			fails:    true,
+			expected: Stats{Misses: 1, GetErrors: 1},
+		},
+		"ignored get": {
+			cacher:   &cacherGetErrorMock{},
+			mode:     CacheErrorIgnore,
+			expected: Stats{Misses: 1, GetErrors: 1, Stores: 1},
+		},
+		"fail fast store": {
+			cacher:   &cacherStoreErrorMock{},
+			mode:     CacheErrorFailFast,
+			fails:    true,
+			expected: Stats{Misses: 1, StoreErrors: 1},
+		},
+		"ignored store": {
+			cacher:   &cacherStoreErrorMock{},
+			mode:     CacheErrorIgnore,
+			expected: Stats{Misses: 1, StoreErrors: 1},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			observer := &observerMock{}
+			caches := newCaches(tc.cacher, tc.mode, observer)
+			db := newDB()
+			caches.query(db)
+
+			if (db.Error != nil) != tc.fails {
+				t.Errorf("expected the query failure to be %t, got %v", tc.fails, db.Error)
+			}
+			if !tc.fails && db.Statement.Dest.(*mockDest).Result != "from-database" {
+				t.Error("expected the query to return the result of the database")
+			}
+			if act := caches.Stats(); act != tc.expected {
+				t.Errorf("expected the stats to be %+v, got %+v", tc.expected, act)
+			}
+
+			var reported bool
+			for _, event := range observer.events {
+				reported = reported || strings.HasPrefix(event, "error:users:")
+			}
+			if !reported {
+				t.Errorf("expected the error to be reported to the observer, got %v", observer.events)
+			}
+		})
+	}
+}
+
+// retainingCacherMock holds the stored values in a map, it copies them upon Store as it should,
+// but returns its own copy upon Get
+type retainingCacherMock struct {
+	mu     sync.Mutex
+	values map[string]*Query[any]
+}
+
+func (c *retainingCacherMock) Get(_ context.Context, key string, q *Query[any]) (*Query[any], error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key], nil
+}
+
+func (c *retainingCacherMock) Store(_ context.Context, key string, val *Query[any], _ time.Duration) error {
+	dest := newDestOf(val.Dest)
+	if err := deepCopy(val.Dest, dest); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = make(map[string]*Query[any])
+	}
+	c.values[key] = &Query[any]{Dest: dest, RowsAffected: val.RowsAffected}
+	return nil
+}
+
+func (c *retainingCacherMock) Invalidate(context.Context, ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values = nil
+	return nil
+}
+
+func TestCaches_hitsAreDetached(t *testing.T) {
+	for name, cacher := range map[string]func() Cacher{
+		"memory cacher":    func() Cacher { return NewMemoryCacher() },
+		"retaining cacher": func() Cacher { return &retainingCacherMock{} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			caches := &Caches{Conf: &Config{Cacher: cacher()}}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				*db.Statement.Dest.(*[]nestedMockStruct) = []nestedMockStruct{newNestedMockStruct()}
+				db.Statement.RowsAffected = 1
+			}
+			query := func() []nestedMockStruct {
+				var rows []nestedMockStruct
+				db.Table("nested").Find(&rows)
+				return rows
+			}
+
+			stored := query()
+			stored[0].Child.Name = "mutated after the miss"
+
+			for i := 0; i < 2; i++ {
+				hit := query()
+				if !reflect.DeepEqual(hit, []nestedMockStruct{newNestedMockStruct()}) {
+					t.Fatalf("expected the hit to be unmutated, got %+v", hit[0])
+				}
+				hit[0].Child.Tags[0] = "mutated after the hit"
+				hit[0].Children[0].Name = "mutated after the hit"
+				hit[0].Attributes["nested"].(map[string]interface{})["key"] = "mutated after the hit"
+			}
+			if act := caches.Stats(); act.Hits != 2 {
+				t.Errorf("expected the queries to hit the cache, got %+v", act)
+			}
+		})
+	}
+}
+
+func TestCaches_Count(t *testing.T) {
+	type countUserModel struct {
+		gorm.Model
+		Name string
+	}
+
+	testCases := map[string]func() *Config{
+		"memory cacher": func() *Config {
+			return &Config{Cacher: NewMemoryCacher()}
+		},
+		"retaining cacher": func() *Config {
+			return &Config{Cacher: &retainingCacherMock{}}
+		},
+		"easer and gob": func() *Config {
+			return &Config{Cacher: NewMemoryCacher(), Easer: true, Serializer: GobSerializer{}, MaxCacheRows: 1}
+		},
+	}
+
+	for name, conf := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var executed int
+			caches := &Caches{Conf: conf()}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				executed++
+				*db.Statement.Dest.(*int64) = int64(40 + executed)
+				db.Statement.RowsAffected = 1
+			}
+			count := func(name string) int64 {
+				var n int64
+				if err := db.Model(&countUserModel{}).Where("name = ?", name).Count(&n).Error; err != nil {
+					t.Fatalf("the count resulted into an unexpected error, %v", err)
+				}
+				return n
+			}
+
+			for i := 0; i < 2; i++ {
+				if n := count("ktsivkov"); n != 41 {
+					t.Errorf("expected the count to be the one of the first run, got %d", n)
+				}
+			}
+			if executed != 1 {
+				t.Errorf("expected the second count to hit the cache, the database was queried %d times", executed)
+			}
+
+			if n := count("other"); n != 42 || executed != 2 {
+				t.Errorf("expected a count with other bind variables not to share the cached value, got %d", n)
+			}
+
+			db.Session(&gorm.Session{DryRun: true}).Model(&countUserModel{}).Where("name = ?", "ktsivkov").Update("name", "renamed")
+			if n := count("ktsivkov"); n != 43 || executed != 3 {
+				t.Errorf("expected an update of the table to invalidate its counts, got %d", n)
+			}
 		})
 	}
 }
+
+func TestCaches_easeCancellation(t *testing.T) {
+	newCaches := func(release <-chan struct{}, executed *int32) (*Caches, *gorm.DB) {
+		caches := &Caches{Conf: &Config{Easer: true}}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			n := atomic.AddInt32(executed, 1)
+			select {
+			case <-release:
+			case <-db.Statement.Context.Done():
+				_ = db.AddError(db.Statement.Context.Err())
+				return
+			}
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: fmt.Sprint(n)}}
+			db.Statement.RowsAffected = 1
+		}
+		return caches, db
+	}
+	find := func(db *gorm.DB, ctx context.Context) ([]mockDest, error) {
+		var rows []mockDest
+		err := db.WithContext(ctx).Table("users").Find(&rows).Error
+		return rows, err
+	}
+
+	t.Run("cancelled waiter", func(t *testing.T) {
+		var executed int32
+		release := make(chan struct{})
+		caches, db := newCaches(release, &executed)
+
+		type result struct {
+			rows []mockDest
+			err  error
+		}
+		leader, waiter := make(chan result, 1), make(chan result, 1)
+		go func() {
+			rows, err := find(db, context.Background())
+			leader <- result{rows, err}
+		}()
+		for i := 0; i < 100 && caches.InFlight() == 0; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+		go func() {
+			rows, err := find(db, context.Background())
+			waiter <- result{rows, err}
+		}()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		if _, err := find(db, ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the cancelled waiter to fail with context.Canceled, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected the cancelled waiter to return promptly, it returned after %s", elapsed)
+		}
+
+		close(release)
+		for name, ch := range map[string]chan result{"leader": leader, "waiter": waiter} {
+			res := <-ch
+			if res.err != nil || len(res.rows) != 1 || res.rows[0].Result != "1" {
+				t.Errorf("expected the %s to be served the result of the query, got %+v", name, res)
+			}
+		}
+		if executed != 1 {
+			t.Errorf("expected the database to be queried once, it was queried %d times", executed)
+		}
+	})
+
+	t.Run("cancelled leader", func(t *testing.T) {
+		var executed int32
+		release := make(chan struct{})
+		caches, db := newCaches(release, &executed)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		leader := make(chan error, 1)
+		go func() {
+			_, err := find(db, ctx)
+			leader <- err
+		}()
+		for i := 0; i < 100 && caches.InFlight() == 0; i++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		waiter := make(chan []mockDest, 1)
+		go func() {
+			rows, err := find(db, context.Background())
+			if err != nil {
+				t.Errorf("expected the waiter not to fail along with the cancelled leader, got %v", err)
+			}
+			waiter <- rows
+		}()
+		time.Sleep(50 * time.Millisecond)
+
+		cancel()
+		if err := <-leader; !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the cancelled leader to fail with context.Canceled, got %v", err)
+		}
+		close(release)
+		if rows := <-waiter; len(rows) != 1 || rows[0].Result != "2" {
+			t.Errorf("expected the waiter to query the database itself, got %+v", rows)
+		}
+	})
+}
+
+// slowCacherMock is a Cacher whose Get takes delay, unless its context gets done first
+type slowCacherMock struct {
+	cacherMock
+	delay time.Duration
+}
+
+func (c *slowCacherMock) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	select {
+	case <-time.After(c.delay):
+		return c.cacherMock.Get(ctx, key, q)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestCaches_CacheGetTimeout(t *testing.T) {
+	newCaches := func(mode CacheErrorMode) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher:          &slowCacherMock{delay: time.Second},
+				CacheErrorMode:  mode,
+				CacheGetTimeout: 20 * time.Millisecond,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					if err := db.Statement.Context.Err(); err != nil {
+						_ = db.AddError(err)
+						return
+					}
+					db.Statement.Dest.(*mockDest).Result = "from-database"
+				},
+			},
+		}
+	}
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.Table = "users"
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	t.Run("ignored timeout falls back on the database", func(t *testing.T) {
+		caches := newCaches(CacheErrorIgnore)
+		db := newDB()
+
+		start := time.Now()
+		caches.query(db)
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("expected the lookup to time out, the query returned after %s", elapsed)
+		}
+		if db.Error != nil {
+			t.Fatalf("expected the timeout not to fail the query, nor to cancel it, got %v", db.Error)
+		}
+		if act := db.Statement.Dest.(*mockDest).Result; act != "from-database" {
+			t.Errorf("expected the query to hit the database, got `%s`", act)
+		}
+		if act := caches.Stats(); act.Misses != 1 || act.GetErrors != 1 {
+			t.Errorf("expected the timeout to be counted as a failed lookup, got %+v", act)
+		}
+	})
+
+	t.Run("timeout fails fast", func(t *testing.T) {
+		caches := newCaches(CacheErrorFailFast)
+		db := newDB()
+
+		caches.query(db)
+		if !errors.Is(db.Error, context.DeadlineExceeded) {
+			t.Errorf("expected the query to fail with the expired lookup, got %v", db.Error)
+		}
+	})
+}
+
+func TestCaches_CachePredicate(t *testing.T) {
+	observer := &observerMock{}
+	caches := &Caches{
+		Conf: &Config{
+			Cacher: &cacherMock{},
+			CachePredicate: func(db *gorm.DB) bool {
+				return db.Statement.Table != "exports" && db.Statement.RowsAffected <= 2
+			},
+			Observer: observer,
+		},
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				rows := make([]mockDest, db.Statement.Vars[0].(int))
+				*db.Statement.Dest.(*[]mockDest) = rows
+				db.Statement.RowsAffected = int64(len(rows))
+			},
+		},
+	}
+	query := func(table string, rows int) *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &[]mockDest{}
+		db.Statement.Table = table
+		db.Statement.SQL.WriteString("demo-query")
+		db.Statement.Vars = []interface{}{rows}
+		caches.query(db)
+		return db
+	}
+
+	testCases := map[string]struct {
+		table  string
+		rows   int
+		stored bool
+	}{
+		"small list":  {table: "users", rows: 2, stored: true},
+		"large list":  {table: "users", rows: 3, stored: false},
+		"other table": {table: "exports", rows: 1, stored: false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			caches.ResetStats()
+			observer.events = nil
+
+			db := query(tc.table, tc.rows)
+			if act := len(*db.Statement.Dest.(*[]mockDest)); act != tc.rows {
+				t.Errorf("expected the result to be returned whatever the predicate, got %d rows", act)
+			}
+
+			expected := Stats{Misses: 1, Stores: 1}
+			events := []string{"miss:" + tc.table, fmt.Sprintf("store:%s:%d", tc.table, tc.rows)}
+			if !tc.stored {
+				expected = Stats{Misses: 1, Skipped: 1}
+				events = []string{"miss:" + tc.table, "skip:" + tc.table + ":predicate"}
+			}
+			if act := caches.Stats(); act != expected {
+				t.Errorf("expected the stats to be %+v, got %+v", expected, act)
+			}
+			if !reflect.DeepEqual(observer.events, events) {
+				t.Errorf("expected the observer events to be %v, got %v", events, observer.events)
+			}
+		})
+	}
+}
+
+func TestCaches_Pluck(t *testing.T) {
+	type pluckUserModel struct {
+		gorm.Model
+		Name  string
+		Email string
+	}
+
+	testCases := map[string]func() *Config{
+		"memory cacher": func() *Config {
+			return &Config{Cacher: NewMemoryCacher()}
+		},
+		"retaining cacher": func() *Config {
+			return &Config{Cacher: &retainingCacherMock{}}
+		},
+		"easer and gob": func() *Config {
+			return &Config{Cacher: NewMemoryCacher(), Easer: true, Serializer: GobSerializer{}}
+		},
+	}
+
+	for name, conf := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var executed int
+			caches := &Caches{Conf: conf()}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				executed++
+				*db.Statement.Dest.(*[]string) = []string{db.Statement.SQL.String(), fmt.Sprint(executed)}
+				db.Statement.RowsAffected = 2
+			}
+			pluck := func(column string) []string {
+				var values []string
+				if err := db.Model(&pluckUserModel{}).Where("name <> ?", "").Pluck(column, &values).Error; err != nil {
+					t.Fatalf("the pluck resulted into an unexpected error, %v", err)
+				}
+				return values
+			}
+
+			first := pluck("email")
+			if len(first) != 2 || !strings.Contains(first[0], "email") || first[1] != "1" {
+				t.Fatalf("expected the plucked values to be the ones of the database, got %v", first)
+			}
+			first[1] = "mutated"
+			if second := pluck("Email"); !reflect.DeepEqual(second, []string{first[0], "1"}) || executed != 1 {
+				t.Errorf("expected the second pluck of the column to hit the cache, got %v after %d queries", second, executed)
+			}
+
+			if names := pluck("name"); len(names) != 2 || !strings.Contains(names[0], "name") || executed != 2 {
+				t.Errorf("expected the pluck of another column not to share the cached value, got %v", names)
+			}
+
+			db.Session(&gorm.Session{DryRun: true}).Model(&pluckUserModel{}).Where("id = ?", 1).Update("email", "updated")
+			if emails := pluck("email"); len(emails) != 2 || emails[1] != "3" {
+				t.Errorf("expected an update of the table to invalidate its plucked values, got %v", emails)
+			}
+		})
+	}
+}
+
+func TestCaches_softDelete(t *testing.T) {
+	type softDeleteModel struct {
+		ID        uint
+		Name      string
+		DeletedAt gorm.DeletedAt
+	}
+
+	testCases := map[string]struct {
+		conf   *Config
+		delete func(db *gorm.DB)
+		verb   string
+	}{
+		"soft delete": {
+			conf: &Config{Cacher: NewMemoryCacher()},
+			delete: func(db *gorm.DB) {
+				db.Delete(&softDeleteModel{ID: 1})
+			},
+			verb: "UPDATE",
+		},
+		"soft delete by condition": {
+			conf: &Config{Cacher: NewMemoryCacher()},
+			delete: func(db *gorm.DB) {
+				db.Where("name = ?", "ktsivkov").Delete(&softDeleteModel{})
+			},
+			verb: "UPDATE",
+		},
+		"soft delete by tag": {
+			conf: &Config{Cacher: NewMemoryCacher(), Tagger: PrimaryKeyTagger},
+			delete: func(db *gorm.DB) {
+				db.Delete(&softDeleteModel{ID: 1})
+			},
+			verb: "UPDATE",
+		},
+		"unscoped delete": {
+			conf: &Config{Cacher: NewMemoryCacher()},
+			delete: func(db *gorm.DB) {
+				db.Unscoped().Delete(&softDeleteModel{ID: 1})
+			},
+			verb: "DELETE",
+		},
+		"unscoped delete by tag": {
+			conf: &Config{Cacher: NewMemoryCacher(), Tagger: PrimaryKeyTagger},
+			delete: func(db *gorm.DB) {
+				db.Unscoped().Delete(&softDeleteModel{ID: 1})
+			},
+			verb: "DELETE",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var executed int
+			caches := &Caches{Conf: tc.conf}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				executed++
+				rows := []softDeleteModel{{ID: 1, Name: "ktsivkov"}}
+				if db.Statement.Unscoped {
+					// The unscoped reads see the soft deleted rows as well
+					rows = append(rows, softDeleteModel{ID: 2, Name: "deleted", DeletedAt: gorm.DeletedAt{Valid: true}})
+				}
+				*db.Statement.Dest.(*[]softDeleteModel) = rows
+				db.Statement.RowsAffected = int64(len(rows))
+			}
+			find := func() {
+				for _, tx := range []*gorm.DB{db, db.Unscoped()} {
+					var rows []softDeleteModel
+					if err := tx.Find(&rows).Error; err != nil {
+						t.Fatalf("the query resulted into an unexpected error, %v", err)
+					}
+					if expected := map[bool]int{false: 1, true: 2}[tx.Statement.Unscoped]; len(rows) != expected {
+						t.Fatalf("expected the unscoped read to be %t to get %d rows, got %v", tx.Statement.Unscoped, expected, rows)
+					}
+				}
+			}
+
+			scoped, err := caches.Identifier(db, func(tx *gorm.DB) *gorm.DB { return tx.Find(&[]softDeleteModel{}) })
+			if err != nil {
+				t.Fatalf("the identifier resulted into an unexpected error, %v", err)
+			}
+			unscoped, _ := caches.Identifier(db, func(tx *gorm.DB) *gorm.DB { return tx.Unscoped().Find(&[]softDeleteModel{}) })
+			if scoped == unscoped {
+				t.Fatalf("expected the scoped and unscoped reads to have identifiers of their own, got %s", scoped)
+			}
+
+			find()
+			find()
+			if executed != 2 {
+				t.Fatalf("expected the second reads to hit the cache, the database was queried %d times", executed)
+			}
+
+			var sql string
+			dryRun := db.Session(&gorm.Session{DryRun: true})
+			if err := dryRun.Callback().Delete().After("gorm:delete").Register("test:capture_sql", func(db *gorm.DB) {
+				sql = db.Statement.SQL.String()
+			}); err != nil {
+				t.Fatalf("registering the capture callback resulted into an unexpected error, %s", err.Error())
+			}
+			tc.delete(dryRun)
+			_ = dryRun.Callback().Delete().Remove("test:capture_sql")
+
+			if !strings.HasPrefix(sql, tc.verb) {
+				t.Fatalf("expected the delete to run an %s, got %q", tc.verb, sql)
+			}
+			find()
+			if executed != 4 {
+				t.Errorf("expected the delete to invalidate the scoped and unscoped reads, the database was queried %d times", executed)
+			}
+		})
+	}
+}
+
+func TestCaches_sharedCacher(t *testing.T) {
+	type sharedUserModel struct {
+		ID   uint
+		Name string
+	}
+
+	testCases := map[string]func() *Config{
+		"table scoped": func() *Config {
+			return &Config{Cacher: NewMemoryCacher()}
+		},
+		"tag scoped": func() *Config {
+			return &Config{Cacher: NewMemoryCacher(), Tagger: PrimaryKeyTagger}
+		},
+	}
+
+	for name, newConf := range testCases {
+		t.Run(name, func(t *testing.T) {
+			shared := newConf()
+			open := func(role string, executed *int) *gorm.DB {
+				// Every instance has a Config of its own, sharing the Cacher and the same rules
+				conf := *shared
+				caches := &Caches{Conf: &conf}
+				db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+				if err != nil {
+					t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+				}
+				if err := db.Use(caches); err != nil {
+					t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+				}
+				caches.callbacks[uponQuery] = func(db *gorm.DB) {
+					*executed++
+					*db.Statement.Dest.(*[]sharedUserModel) = []sharedUserModel{{ID: 1, Name: role}}
+					db.Statement.RowsAffected = 1
+				}
+				return db
+			}
+
+			var primaryQueries, replicaQueries int
+			primary, replica := open("primary", &primaryQueries), open("replica", &replicaQueries)
+			find := func(db *gorm.DB) []sharedUserModel {
+				var rows []sharedUserModel
+				if err := db.Where("id = ?", 1).Find(&rows).Error; err != nil {
+					t.Fatalf("the query resulted into an unexpected error, %v", err)
+				}
+				return rows
+			}
+			update := func(id uint) {
+				primary.Session(&gorm.Session{DryRun: true}).Model(&sharedUserModel{ID: id}).Update("name", "updated")
+			}
+
+			if rows := find(replica); rows[0].Name != "replica" {
+				t.Fatalf("expected the replica to query its database, got %+v", rows)
+			}
+			if rows := find(primary); rows[0].Name != "replica" || primaryQueries != 0 {
+				t.Errorf("expected the primary to be served the value cached by the replica, got %+v", rows)
+			}
+
+			update(1)
+			if rows := find(replica); rows[0].Name != "replica" || replicaQueries != 2 {
+				t.Errorf("expected an update on the primary to invalidate the value cached by the replica, got %d queries", replicaQueries)
+			}
+
+			if name == "tag scoped" {
+				update(2)
+				if find(replica); replicaQueries != 2 {
+					t.Errorf("expected an update of another row to keep the value cached by the replica, got %d queries", replicaQueries)
+				}
+			}
+		})
+	}
+}
+
+func TestCaches_FindInBatches(t *testing.T) {
+	var executed int
+	caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher()}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	// The table holds the rows 1 to 5, every batch after the first one reads the rows past the last one it got
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+		var after uint
+		if vars := db.Statement.Vars; len(vars) > 0 {
+			after = vars[len(vars)-1].(uint)
+		}
+		limit := *db.Statement.Clauses["LIMIT"].Expression.(clause.Limit).Limit
+
+		rows := []tablesUserModel{}
+		for id := after + 1; id <= 5 && len(rows) < limit; id++ {
+			rows = append(rows, tablesUserModel{Model: gorm.Model{ID: id}})
+		}
+		*db.Statement.Dest.(*[]tablesUserModel) = rows
+		db.Statement.RowsAffected = int64(len(rows))
+	}
+
+	findInBatches := func() []uint {
+		var (
+			ids  []uint
+			rows []tablesUserModel
+		)
+		err := db.FindInBatches(&rows, 2, func(tx *gorm.DB, batch int) error {
+			if batch > 3 {
+				return errors.New("the iteration did not end")
+			}
+			for _, row := range rows {
+				ids = append(ids, row.ID)
+			}
+			return nil
+		}).Error
+		if err != nil {
+			t.Fatalf("FindInBatches resulted into an unexpected error, %v", err)
+		}
+		return ids
+	}
+
+	expected := []uint{1, 2, 3, 4, 5}
+	if act := findInBatches(); !reflect.DeepEqual(act, expected) {
+		t.Fatalf("expected the batches to hold the rows %v, got %v", expected, act)
+	}
+	if executed != 3 {
+		t.Fatalf("expected every batch to be queried, the database was queried %d times", executed)
+	}
+
+	if act := findInBatches(); !reflect.DeepEqual(act, expected) {
+		t.Errorf("expected the cached batches to hold the rows %v, got %v", expected, act)
+	}
+	if executed != 3 {
+		t.Errorf("expected every batch to be served from the cache, the database was queried %d times", executed)
+	}
+	if act := caches.Stats().Hits; act != 3 {
+		t.Errorf("expected a hit per batch, got %d", act)
+	}
+}
+
+func TestCaches_associations(t *testing.T) {
+	role := &tablesRoleModel{Model: gorm.Model{ID: 7}, Name: "Admin"}
+	orders := []tablesOrderModel{{Model: gorm.Model{ID: 3}, UserId: 1}, {Model: gorm.Model{ID: 4}, UserId: 1}}
+
+	for _, serializer := range []Serializer{nil, GobSerializer{}} {
+		t.Run(fmt.Sprintf("%T", serializer), func(t *testing.T) {
+			newDB := func(t *testing.T) (*gorm.DB, *int) {
+				var executed int
+				caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher(), Serializer: serializer}}
+				db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+				if err != nil {
+					t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+				}
+				if err := db.Use(caches); err != nil {
+					t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+				}
+				caches.callbacks[uponQuery] = func(db *gorm.DB) {
+					executed++
+					switch dest := db.Statement.Dest.(type) {
+					case *[]tablesUserModel:
+						user := tablesUserModel{Model: gorm.Model{ID: 1}, Name: "ktsivkov", RoleId: role.ID}
+						if from, ok := db.Statement.Clauses["FROM"].Expression.(clause.From); ok && len(from.Joins) > 0 {
+							user.Role = role
+						}
+						*dest = []tablesUserModel{user}
+					case *[]*tablesRoleModel: // The preloads query their association's table with a slice of pointers
+						*dest = []*tablesRoleModel{role}
+					case *[]*tablesOrderModel:
+						*dest = []*tablesOrderModel{&orders[0], &orders[1]}
+					default:
+						t.Fatalf("unexpected destination %T", dest)
+					}
+					db.Statement.RowsAffected = int64(reflect.Indirect(reflect.ValueOf(db.Statement.Dest)).Len())
+				}
+				return db, &executed
+			}
+			assert := func(t *testing.T, users []tablesUserModel, preloaded bool) {
+				if len(users) != 1 || users[0].Role == nil || !reflect.DeepEqual(*users[0].Role, *role) {
+					t.Fatalf("expected the user to hold its role, got %+v", users)
+				}
+				if preloaded && !reflect.DeepEqual(users[0].Orders, orders) {
+					t.Errorf("expected the user to hold its orders %+v, got %+v", orders, users[0].Orders)
+				}
+			}
+
+			t.Run("preload", func(t *testing.T) {
+				db, executed := newDB(t)
+				for i := 0; i < 2; i++ {
+					var users []tablesUserModel
+					if err := db.Preload("Role").Preload("Orders").Find(&users).Error; err != nil {
+						t.Fatalf("the query resulted into an unexpected error, %v", err)
+					}
+					assert(t, users, true)
+				}
+				if *executed != 3 {
+					t.Errorf("expected the second query and its preloads to hit the cache, the database was queried %d times", *executed)
+				}
+			})
+
+			t.Run("joins", func(t *testing.T) {
+				db, executed := newDB(t)
+				for i := 0; i < 2; i++ {
+					var users []tablesUserModel
+					if err := db.Joins("Role").Find(&users).Error; err != nil {
+						t.Fatalf("the query resulted into an unexpected error, %v", err)
+					}
+					assert(t, users, false)
+				}
+				if *executed != 1 {
+					t.Errorf("expected the second query to hit the cache, the database was queried %d times", *executed)
+				}
+			})
+		})
+	}
+}
+
+func TestCaches_associationWrites(t *testing.T) {
+	type tag struct {
+		ID   uint
+		Name string
+	}
+	type user struct {
+		ID     uint
+		Orders []tablesOrderModel `gorm:"foreignKey:UserId"`
+		Tags   []tag              `gorm:"many2many:association_user_tags"`
+	}
+
+	testCases := map[string]struct {
+		write   func(db *gorm.DB)
+		evicted []string
+	}{
+		"create with has many": {
+			write: func(db *gorm.DB) {
+				db.Create(&user{Orders: []tablesOrderModel{{}}})
+			},
+			evicted: []string{"tables_order_models"},
+		},
+		"save with has many": {
+			write: func(db *gorm.DB) {
+				db.Save(&user{ID: 1, Orders: []tablesOrderModel{{}}})
+			},
+			evicted: []string{"tables_order_models"},
+		},
+		"create with many to many": {
+			write: func(db *gorm.DB) {
+				db.Create(&user{Tags: []tag{{Name: "vip"}}})
+			},
+			evicted: []string{"tags", "association_user_tags"},
+		},
+		"append": {
+			write: func(db *gorm.DB) {
+				_ = db.Model(&user{ID: 1}).Association("Orders").Append(&tablesOrderModel{})
+			},
+			evicted: []string{"tables_order_models"},
+		},
+		"replace": {
+			write: func(db *gorm.DB) {
+				_ = db.Model(&user{ID: 1}).Association("Orders").Replace(&tablesOrderModel{})
+			},
+			evicted: []string{"tables_order_models"},
+		},
+		"clear": {
+			write: func(db *gorm.DB) {
+				_ = db.Model(&user{ID: 1}).Association("Orders").Clear()
+			},
+			evicted: []string{"tables_order_models"},
+		},
+		"delete many to many": {
+			write: func(db *gorm.DB) {
+				_ = db.Model(&user{ID: 1}).Association("Tags").Delete(&tag{ID: 3})
+			},
+			evicted: []string{"association_user_tags"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			executed := map[string]int{}
+			caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher()}}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				executed[db.Statement.Table]++
+				db.Statement.RowsAffected = 1
+			}
+			tables := []string{"tables_order_models", "tags", "association_user_tags", "tables_role_models"}
+			query := func() {
+				for _, table := range tables {
+					db.Table(table).Where("id = ?", 1).Find(&[]mockDest{})
+				}
+			}
+
+			query()
+			tc.write(db.Session(&gorm.Session{DryRun: true}))
+			query()
+
+			for _, table := range tables {
+				expected := 1
+				for _, evicted := range tc.evicted {
+					if table == evicted {
+						expected = 2
+					}
+				}
+				if executed[table] != expected {
+					t.Errorf("expected the table %s to be queried %d times, got %d", table, expected, executed[table])
+				}
+			}
+		})
+	}
+}
+
+func TestCaches_associationCount(t *testing.T) {
+	type follower struct {
+		ID        uint
+		Followers []follower `gorm:"many2many:association_followers"`
+	}
+
+	testCases := map[string]func(db *gorm.DB){
+		"append": func(db *gorm.DB) {
+			_ = db.Model(&follower{ID: 1}).Association("Followers").Append(&follower{ID: 4})
+		},
+		"delete": func(db *gorm.DB) {
+			_ = db.Model(&follower{ID: 1}).Association("Followers").Delete(&follower{ID: 2})
+		},
+		"join table": func(db *gorm.DB) {
+			db.Table("association_followers").Where("follower_id = ?", 2).Delete(&mockDest{})
+		},
+	}
+
+	for name, write := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var (
+				executed int
+				sql      string
+			)
+			caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher()}}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				executed++
+				sql = db.Statement.SQL.String()
+				*db.Statement.Dest.(*int64) = int64(len(db.Statement.Vars)) + 2
+				db.Statement.RowsAffected = 1
+			}
+			count := func(id uint) int64 {
+				return db.Model(&follower{ID: id}).Association("Followers").Count()
+			}
+
+			for i := 0; i < 2; i++ {
+				if act := count(1); act != 3 {
+					t.Fatalf("expected the count of the followers, got %d", act)
+				}
+			}
+			if executed != 1 {
+				t.Fatalf("expected the second count to hit the cache, the database was queried %d times", executed)
+			}
+			if !strings.Contains(sql, "JOIN `association_followers`") {
+				t.Fatalf("expected the count to join the join table, got %s", sql)
+			}
+			if count(2); executed != 2 {
+				t.Errorf("expected the count of another user not to be served the first one, got %d queries", executed)
+			}
+
+			write(db.Session(&gorm.Session{DryRun: true}))
+			if count(1); executed != 3 {
+				t.Errorf("expected the writes of the join table to invalidate the count, got %d queries", executed)
+			}
+		})
+	}
+}
+
+func TestCaches_partitionWrites(t *testing.T) {
+	type event struct {
+		ID   uint
+		Name string
+	}
+	partition := func(month string) func(db *gorm.DB) *gorm.DB {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Table("events_" + month)
+		}
+	}
+
+	testCases := map[string]func(db *gorm.DB){
+		"create": func(db *gorm.DB) {
+			db.Scopes(partition("2024_02")).Create(&event{Name: "signup"})
+		},
+		"create in batches": func(db *gorm.DB) {
+			// The batches run within a transaction otherwise, which the dummy dialector cannot begin
+			db.Session(&gorm.Session{SkipDefaultTransaction: true}).Scopes(partition("2024_02")).
+				CreateInBatches(&[]event{{Name: "signup"}, {Name: "login"}}, 1)
+		},
+		"update": func(db *gorm.DB) {
+			db.Scopes(partition("2024_02")).Where("name = ?", "signup").Update("name", "register")
+		},
+		"delete": func(db *gorm.DB) {
+			db.Scopes(partition("2024_02")).Where("name = ?", "signup").Delete(&event{})
+		},
+		"schema qualified": func(db *gorm.DB) {
+			db.Table("analytics.events_2024_02").Create(&event{Name: "signup"})
+		},
+	}
+
+	for cacherName, newCacher := range map[string]func(t *testing.T) Cacher{
+		"memory cacher": func(t *testing.T) Cacher {
+			return NewMemoryCacher()
+		},
+		"redis cacher": func(t *testing.T) Cacher {
+			cacher, _ := newTestRedisCacher(t)
+			return cacher
+		},
+	} {
+		for name, write := range testCases {
+			t.Run(cacherName+"/"+name, func(t *testing.T) {
+				executed := map[string]int{}
+				caches := &Caches{Conf: &Config{Cacher: newCacher(t)}}
+				db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+				if err != nil {
+					t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+				}
+				if err := db.Use(caches); err != nil {
+					t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+				}
+				caches.callbacks[uponQuery] = func(db *gorm.DB) {
+					executed[db.Statement.Table]++
+					db.Statement.RowsAffected = 1
+				}
+				months := []string{"2024_01", "2024_02", "2024_03"}
+				query := func() {
+					for _, month := range months {
+						db.Scopes(partition(month)).Where("name = ?", "signup").Find(&[]event{})
+					}
+				}
+
+				query()
+				write(db.Session(&gorm.Session{DryRun: true}))
+				query()
+
+				for _, month := range months {
+					expected := 1
+					if month == "2024_02" {
+						expected = 2
+					}
+					if act := executed["events_"+month]; act != expected {
+						t.Errorf("expected the partition %s to be queried %d times, got %d", month, expected, act)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCaches_OnCoalesce(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []int
+		ids   = map[string]struct{}{}
+	)
+	unblock := make(chan struct{})
+	caches := &Caches{
+		Conf: &Config{
+			Easer: true,
+			OnCoalesce: func(identifier string, waiters int) {
+				mu.Lock()
+				defer mu.Unlock()
+				calls = append(calls, waiters)
+				ids[identifier] = struct{}{}
+			},
+		},
+		queue: newEaseQueue(),
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				<-unblock
+			},
+		},
+	}
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("TEST-SQL")
+		return db
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			caches.query(newDB())
+			wg.Done()
+		}()
+	}
+
+	identifier := caches.identifierOf(newDB())
+	deadline := time.Now().Add(time.Second)
+	for caches.InFlightWaiters()[identifier] != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(unblock)
+	wg.Wait()
+
+	if !reflect.DeepEqual(calls, []int{3, 3, 3}) {
+		t.Errorf("expected a call per coalesced query with the 3 waiters, got %v", calls)
+	}
+	if _, ok := ids[identifier]; !ok || len(ids) != 1 {
+		t.Errorf("expected the calls to be handed the identifier `%s`, got %v", identifier, ids)
+	}
+}
+
+func TestCaches_nilDest(t *testing.T) {
+	testCases := map[string]any{
+		"nil":         nil,
+		"nil pointer": (*[]mockDest)(nil),
+	}
+
+	for name, dest := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var executed int
+			caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher(), Easer: true}}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				executed++
+				db.Statement.RowsAffected = 1
+			}
+
+			for i := 0; i < 2; i++ {
+				tx := db.Session(&gorm.Session{NewDB: true}).Table("users")
+				tx.Statement.Dest = dest
+				tx.Statement.SQL.WriteString("UPDATE users SET name = 'guest'")
+				caches.query(tx)
+				if tx.Error != nil {
+					t.Fatalf("the query resulted into an unexpected error, %v", tx.Error)
+				}
+			}
+			if executed != 2 {
+				t.Errorf("expected the queries without destination to skip the cache, the database was queried %d times", executed)
+			}
+			if act := caches.Stats(); act != (Stats{}) {
+				t.Errorf("expected the queries without destination not to reach the Cacher, got %+v", act)
+			}
+		})
+	}
+}
+
+func TestCaches_projections(t *testing.T) {
+	var executed int
+	caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher()}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	// The rows only hold the columns the query selects
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+		user := tablesUserModel{Name: "ktsivkov"}
+		if strings.HasPrefix(db.Statement.SQL.String(), "SELECT *") {
+			user.ID, user.RoleId = 1, 2
+		}
+		*db.Statement.Dest.(*[]tablesUserModel) = []tablesUserModel{user}
+		db.Statement.RowsAffected = 1
+	}
+
+	var narrow, wide []tablesUserModel
+	db.Select("name").Where("name = ?", "ktsivkov").Find(&narrow)
+	db.Where("name = ?", "ktsivkov").Find(&wide)
+	if executed != 2 {
+		t.Fatalf("expected the wider projection not to be served the narrower one, the database was queried %d times", executed)
+	}
+	if len(wide) != 1 || wide[0].ID != 1 || wide[0].RoleId != 2 {
+		t.Errorf("expected the wider projection to hold all the columns, got %+v", wide)
+	}
+
+	narrow = nil
+	db.Select("name").Where("name = ?", "ktsivkov").Find(&narrow)
+	if executed != 2 {
+		t.Errorf("expected the narrower projection to hit the cache, the database was queried %d times", executed)
+	}
+	if len(narrow) != 1 || narrow[0].ID != 0 || narrow[0].Name != "ktsivkov" {
+		t.Errorf("expected the narrower projection to only hold its columns, got %+v", narrow)
+	}
+}
+
+func TestCaches_DryRun(t *testing.T) {
+	var executed int
+	cacher, l := &flakyCacherMock{}, &loggerMock{}
+	caches := &Caches{Conf: &Config{
+		Cacher:          cacher,
+		Easer:           true,
+		DryRun:          true,
+		Logger:          l,
+		LogIdentifiers:  true,
+		CanCachedTables: []any{"^users$"},
+	}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+		*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: db.Statement.Table}}
+		db.Statement.RowsAffected = 1
+	}
+
+	var users, orders []mockDest
+	db.Table("users").Find(&users)
+	db.Table("users").Find(&users)
+	db.Table("orders").Find(&orders)
+	db.Session(&gorm.Session{DryRun: true}).Table("users").Where("id = ?", 1).Update("result", "updated")
+	if err := caches.InvalidateAll(context.Background()); err != nil {
+		t.Fatalf("InvalidateAll resulted into an unexpected error, %v", err)
+	}
+
+	if executed != 3 || len(users) != 1 || users[0].Result != "users" {
+		t.Errorf("expected every query to run against the database, it was queried %d times for %+v", executed, users)
+	}
+	if cacher.calls != 0 || cacher.invalidatedTables != nil {
+		t.Errorf("expected the Cacher never to be called, got %d calls and the invalidations %v", cacher.calls, cacher.invalidatedTables)
+	}
+	if act := caches.Stats(); act != (Stats{}) {
+		t.Errorf("expected no cache operation to be counted, got %+v", act)
+	}
+
+	if len(l.events) != 5 {
+		t.Fatalf("expected an event per query, mutation and invalidation, got %+v", l.events)
+	}
+	for i, cacheable := range []bool{true, true, false} {
+		event := l.events[i]
+		if event.Operation != LogDryRun || event.Cacheable != cacheable || !strings.HasPrefix(event.Identifier, IdentifierPrefix) {
+			t.Errorf("expected the query #%d to be reported as cacheable: %t, got %+v", i, cacheable, event)
+		}
+	}
+	if l.events[0].Identifier != l.events[1].Identifier || l.events[0].Identifier == l.events[2].Identifier {
+		t.Errorf("expected the identical queries only to share their identifier, got %+v", l.events[:3])
+	}
+	if event := l.events[3]; event.Operation != LogDryRun || !reflect.DeepEqual(event.Invalidated, []string{"users"}) {
+		t.Errorf("expected the mutation to report the tables it would invalidate, got %+v", event)
+	}
+	if event := l.events[4]; event.Operation != LogDryRun || event.Invalidated == nil || len(event.Invalidated) != 0 {
+		t.Errorf("expected the invalidation to report that it would invalidate everything, got %+v", event)
+	}
+	if act := l.events[2].String(); !strings.HasSuffix(act, "cacheable=false") {
+		t.Errorf("expected the event to print its decision, got %q", act)
+	}
+}
+
+func TestCaches_ExposeMetadata(t *testing.T) {
+	newDB := func(t *testing.T, expose bool) *gorm.DB {
+		caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher(), ExposeMetadata: expose}}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: db.Statement.Table}}
+			db.Statement.RowsAffected = 1
+		}
+		return db
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		db := newDB(t, true)
+		miss := db.Table("users").Find(&[]mockDest{})
+		if source, _ := miss.Get(ServedFromSetting); source != ServedFromDatabase {
+			t.Errorf("expected the miss to be served from the database, got %v", source)
+		}
+		if age, ok := miss.Get(AgeSetting); ok {
+			t.Errorf("expected the miss to have no age, got %v", age)
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		hit := db.Table("users").Find(&[]mockDest{})
+		if source, _ := hit.Get(ServedFromSetting); source != ServedFromCache {
+			t.Errorf("expected the hit to be served from the cache, got %v", source)
+		}
+		age, _ := hit.Get(AgeSetting)
+		if age, _ := age.(time.Duration); age < 20*time.Millisecond || age > time.Minute {
+			t.Errorf("expected the hit to report the age of its value, got %v", age)
+		}
+
+		bypassed := db.Set(BypassSetting, true).Table("users").Find(&[]mockDest{})
+		if source, _ := bypassed.Get(ServedFromSetting); source != ServedFromDatabase {
+			t.Errorf("expected the bypassing query to be served from the database, got %v", source)
+		}
+	})
+
+	t.Run("chained statements do not inherit the age", func(t *testing.T) {
+		db := newDB(t, true)
+		db.Table("users").Find(&[]mockDest{})
+		hit := db.Table("users").Find(&[]mockDest{})
+		miss := hit.Table("orders").Find(&[]mockDest{})
+		if source, _ := miss.Get(ServedFromSetting); source != ServedFromDatabase {
+			t.Errorf("expected the chained miss to be served from the database, got %v", source)
+		}
+		if age, ok := miss.Get(AgeSetting); ok {
+			t.Errorf("expected the chained miss to have no age, got %v", age)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		db := newDB(t, false)
+		db.Table("users").Find(&[]mockDest{})
+		hit := db.Table("users").Find(&[]mockDest{})
+		if source, ok := hit.Get(ServedFromSetting); ok {
+			t.Errorf("expected no metadata by default, got %v", source)
+		}
+	})
+}
+
+func TestCaches_MaxEaseWait(t *testing.T) {
+	var executed int32
+	release := make(chan struct{})
+	caches := &Caches{Conf: &Config{Easer: true, MaxEaseWait: 200 * time.Millisecond}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	// The first query is stuck until released, the following ones return at once
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		n := atomic.AddInt32(&executed, 1)
+		if n == 1 {
+			<-release
+		}
+		*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: fmt.Sprint(n)}}
+		db.Statement.RowsAffected = 1
+	}
+
+	type result struct {
+		rows    []mockDest
+		elapsed time.Duration
+	}
+	find := func(ch chan<- result) {
+		start := time.Now()
+		var rows []mockDest
+		if err := db.Table("users").Find(&rows).Error; err != nil {
+			t.Errorf("the query resulted into an unexpected error, %v", err)
+		}
+		ch <- result{rows, time.Since(start)}
+	}
+
+	leader, detached, waiter := make(chan result, 1), make(chan result, 1), make(chan result, 1)
+	go find(leader)
+	for i := 0; i < 100 && caches.InFlight() == 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	go find(detached)
+	time.Sleep(150 * time.Millisecond)
+	go find(waiter)
+
+	res := <-detached
+	if len(res.rows) != 1 || res.rows[0].Result != "2" || res.elapsed > time.Second {
+		t.Errorf("expected the waiter to query the database itself after MaxEaseWait, got %+v", res)
+	}
+
+	close(release)
+	for name, ch := range map[string]chan result{"leader": leader, "remaining waiter": waiter} {
+		res := <-ch
+		if len(res.rows) != 1 || res.rows[0].Result != "1" {
+			t.Errorf("expected the %s to be served the result of the stuck query, got %+v", name, res)
+		}
+	}
+	if executed != 2 {
+		t.Errorf("expected the database to be queried twice, it was queried %d times", executed)
+	}
+}
+
+func TestCaches_EaseTables(t *testing.T) {
+	var executed sync.Map
+	release := make(chan struct{})
+	caches := &Caches{Conf: &Config{Easer: true, EaseTables: []any{"^analytics$"}}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		n, _ := executed.LoadOrStore(db.Statement.Table, new(int32))
+		atomic.AddInt32(n.(*int32), 1)
+		<-release
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, table := range []string{"analytics", "analytics", "lookups", "lookups"} {
+		wg.Add(1)
+		go func(table string) {
+			defer wg.Done()
+			db.Table(table).Find(&[]mockDest{})
+		}(table)
+	}
+	identifier, err := caches.Identifier(db, func(db *gorm.DB) *gorm.DB {
+		return db.Table("analytics").Find(&[]mockDest{})
+	})
+	if err != nil {
+		t.Fatalf("Identifier resulted into an unexpected error, %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for caches.InFlightWaiters()[identifier] == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	for table, expected := range map[string]int32{"analytics": 1, "lookups": 2} {
+		n, _ := executed.Load(table)
+		if n == nil || *n.(*int32) != expected {
+			t.Errorf("expected the %s table to be queried %d times", table, expected)
+		}
+	}
+}
+
+func TestCaches_registeredTwice(t *testing.T) {
+	var executed int
+	caches := &Caches{Conf: &Config{Cacher: &cacherMock{}}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+		*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: fmt.Sprint(executed)}}
+		db.Statement.RowsAffected = 1
+	}
+	original := caches.callbacks[uponQuery]
+
+	other, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	testCases := map[string]func() error{
+		"use":                       func() error { return db.Use(caches) },
+		"use of another instance":   func() error { return db.Use(&Caches{Conf: &Config{Cacher: &cacherMock{}}}) },
+		"initialize":                func() error { return caches.Initialize(db) },
+		"initialize another":        func() error { return (&Caches{Conf: &Config{Cacher: &cacherMock{}}}).Initialize(db) },
+		"initialize on another db":  func() error { return caches.Initialize(other) },
+		"initialize a session's db": func() error { return (&Caches{}).Initialize(db.Session(&gorm.Session{})) },
+	}
+	for name, register := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if err := register(); !errors.Is(err, gorm.ErrRegistered) {
+				t.Errorf("expected the registration to fail with gorm.ErrRegistered, got %v", err)
+			}
+		})
+	}
+
+	if reflect.ValueOf(caches.callbacks[uponQuery]).Pointer() != reflect.ValueOf(original).Pointer() {
+		t.Fatal("expected the original callbacks to be kept")
+	}
+	for i := 0; i < 2; i++ {
+		var rows []mockDest
+		db.Table("users").Find(&rows)
+		if len(rows) != 1 || rows[0].Result != "1" {
+			t.Errorf("expected the query to be served from the cache, got %+v", rows)
+		}
+	}
+	if executed != 1 {
+		t.Errorf("expected the query to hit the database once, it did %d times", executed)
+	}
+}
+
+func TestCaches_MinQueryDuration(t *testing.T) {
+	newCaches := func(t *testing.T, conf *Config) (*gorm.DB, *int32) {
+		var executed int32
+		caches := &Caches{Conf: conf}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		// The queries of the slow table take 30ms, the other ones return at once
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			n := atomic.AddInt32(&executed, 1)
+			if db.Statement.Table == "slow" {
+				time.Sleep(30 * time.Millisecond)
+			}
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: fmt.Sprint(n)}}
+			db.Statement.RowsAffected = 1
+		}
+		return db, &executed
+	}
+	find := func(db *gorm.DB, table string) {
+		var rows []mockDest
+		if err := db.Table(table).Find(&rows).Error; err != nil || len(rows) != 1 {
+			t.Errorf("the query resulted into unexpected rows %+v or error %v", rows, err)
+		}
+	}
+
+	t.Run("only the slow queries are stored", func(t *testing.T) {
+		observer := &observerMock{}
+		db, executed := newCaches(t, &Config{
+			Cacher:           &cacherMock{},
+			Observer:         observer,
+			MinQueryDuration: 10 * time.Millisecond,
+		})
+		for i := 0; i < 2; i++ {
+			find(db, "slow")
+			find(db, "fast")
+		}
+		if *executed != 3 {
+			t.Errorf("expected the fast queries only to hit the database every time, got %d queries", *executed)
+		}
+		if !reflect.DeepEqual(observer.events, []string{
+			"miss:slow", "store:slow:1", "miss:fast", "skip:fast:fast", "hit:slow", "miss:fast", "skip:fast:fast",
+		}) {
+			t.Errorf("expected the fast results to be skipped, got %v", observer.events)
+		}
+	})
+
+	t.Run("coalesced queries are measured by the query they were served from", func(t *testing.T) {
+		db, executed := newCaches(t, &Config{
+			Easer:            true,
+			Cacher:           &cacherMock{},
+			MinQueryDuration: time.Second,
+		})
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				find(db, "slow")
+			}()
+			time.Sleep(10 * time.Millisecond)
+		}
+		wg.Wait()
+		if *executed != 1 {
+			t.Fatalf("expected the identical queries to be coalesced, got %d queries", *executed)
+		}
+
+		find(db, "slow")
+		if *executed != 2 {
+			t.Errorf("expected neither the leader nor the waiter to store the result, got %d queries", *executed)
+		}
+	})
+}
+
+// fixedCacherMock returns the same query upon every Get
+type fixedCacherMock struct {
+	cacherMock
+	res *Query[any]
+}
+
+func (c *fixedCacherMock) Get(context.Context, string, *Query[any]) (*Query[any], error) {
+	return c.res, nil
+}
+
+func TestCaches_typeMismatch(t *testing.T) {
+	testCases := map[string]struct {
+		dest     any
+		mismatch bool
+	}{
+		"map instead of a struct": {dest: &map[string]interface{}{"result": "cached"}, mismatch: true},
+		"other struct":            {dest: &[]tablesUserModel{{Name: "cached"}}, mismatch: true},
+		"nil":                     {dest: nil, mismatch: true},
+		"pointer":                 {dest: &[]mockDest{{Result: "cached"}}},
+		"value":                   {dest: []mockDest{{Result: "cached"}}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var executed int
+			observer := &observerMock{}
+			caches := &Caches{Conf: &Config{
+				Cacher:   &fixedCacherMock{res: &Query[any]{Dest: tc.dest, RowsAffected: 1}},
+				Observer: observer,
+			}}
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				executed++
+				*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: "database"}}
+				db.Statement.RowsAffected = 1
+			}
+
+			var rows []mockDest
+			if err := db.Table("users").Find(&rows).Error; err != nil {
+				t.Fatalf("expected the query not to fail, got %v", err)
+			}
+
+			expected, queries := "cached", 0
+			if tc.mismatch {
+				expected, queries = "database", 1
+			}
+			if len(rows) != 1 || rows[0].Result != expected || executed != queries {
+				t.Errorf("expected the %s rows after %d queries, got %+v after %d", expected, queries, rows, executed)
+			}
+
+			stats := caches.Stats()
+			if tc.mismatch != (stats.TypeMismatches == 1 && stats.Misses == 1 && stats.GetErrors == 0) {
+				t.Errorf("expected the mismatch to be counted as a miss, got %+v", stats)
+			}
+			var reported bool
+			for _, event := range observer.events {
+				reported = reported || strings.HasPrefix(event, "error:users:caches: get of table users failed: caches: the cached value holds a")
+			}
+			if reported != tc.mismatch {
+				t.Errorf("expected the mismatch to be reported to the ErrorObserver, got %v", observer.events)
+			}
+		})
+	}
+}
+
+func TestCaches_exists(t *testing.T) {
+	type signupUser struct {
+		ID    uint
+		Email string
+	}
+
+	var (
+		executed   int
+		registered bool
+		limited    []bool
+	)
+	caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher()}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+		limited = append(limited, strings.HasSuffix(db.Statement.SQL.String(), "LIMIT 1"))
+		dest := db.Statement.Dest.(*signupUser)
+		if !registered {
+			db.Statement.RowsAffected = 0
+			return
+		}
+		*dest = signupUser{ID: 1, Email: "ktsivkov@example.com"}
+		db.Statement.RowsAffected = 1
+	}
+	exists := func(email string) bool {
+		var user signupUser
+		tx := db.Model(&signupUser{}).Where("email = ?", email).Limit(1).Find(&user)
+		if tx.Error != nil {
+			t.Fatalf("the existence check resulted into an unexpected error, %v", tx.Error)
+		}
+		if (tx.RowsAffected == 1) != (user.ID == 1) {
+			t.Fatalf("expected the RowsAffected to match the result, got %d for %+v", tx.RowsAffected, user)
+		}
+		return tx.RowsAffected == 1
+	}
+	mutate := func() *gorm.DB {
+		return db.Session(&gorm.Session{DryRun: true})
+	}
+
+	if exists("ktsivkov@example.com") || exists("ktsivkov@example.com") {
+		t.Fatal("expected the user not to exist yet")
+	}
+	if executed != 1 || !limited[0] {
+		t.Errorf("expected the missing user to be cached, got %d limited queries %v", executed, limited)
+	}
+
+	limitedID, _ := caches.Identifier(db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&signupUser{}).Where("email = ?", "ktsivkov@example.com").Limit(1).Find(&signupUser{})
+	})
+	unlimitedID, _ := caches.Identifier(db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Model(&signupUser{}).Where("email = ?", "ktsivkov@example.com").Find(&signupUser{})
+	})
+	if limitedID == "" || limitedID == unlimitedID {
+		t.Errorf("expected the limit to be part of the identifier, got `%s` and `%s`", limitedID, unlimitedID)
+	}
+
+	registered = true
+	mutate().Create(&signupUser{Email: "ktsivkov@example.com"})
+	if !exists("ktsivkov@example.com") || !exists("ktsivkov@example.com") {
+		t.Fatal("expected the insert to invalidate the cached missing user")
+	}
+	if executed != 2 {
+		t.Errorf("expected the found user to be cached, the database was queried %d times", executed)
+	}
+
+	registered = false
+	mutate().Where("email = ?", "ktsivkov@example.com").Delete(&signupUser{})
+	if exists("ktsivkov@example.com") || executed != 3 {
+		t.Errorf("expected the delete to invalidate the cached user, the database was queried %d times", executed)
+	}
+}
+
+func TestCaches_storeHooks(t *testing.T) {
+	type account struct {
+		Name   string
+		Secret string
+	}
+
+	var executed int
+	cacher := NewMemoryCacher()
+	caches := &Caches{Conf: &Config{
+		Cacher: cacher,
+		BeforeStore: func(q *Query[any]) {
+			for i := range *q.Dest.(*[]account) {
+				(*q.Dest.(*[]account))[i].Secret = ""
+			}
+		},
+		AfterGet: func(q *Query[any]) {
+			for i, row := range *q.Dest.(*[]account) {
+				(*q.Dest.(*[]account))[i].Secret = "derived:" + row.Name
+			}
+		},
+	}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+		*db.Statement.Dest.(*[]account) = []account{{Name: "ktsivkov", Secret: "live"}}
+		db.Statement.RowsAffected = 1
+	}
+	find := func() []account {
+		var rows []account
+		if err := db.Table("accounts").Find(&rows).Error; err != nil {
+			t.Fatalf("the query resulted into an unexpected error, %v", err)
+		}
+		return rows
+	}
+
+	if rows := find(); len(rows) != 1 || rows[0].Secret != "live" {
+		t.Errorf("expected the caller to be returned the live result, got %+v", rows)
+	}
+
+	identifier, _ := caches.Identifier(db, func(tx *gorm.DB) *gorm.DB {
+		return tx.Table("accounts").Find(&[]account{})
+	})
+	stored, err := cacher.Get(context.Background(), identifier, &Query[any]{Dest: &[]account{}})
+	if err != nil || stored == nil {
+		t.Fatalf("expected the result to be stored, got %v", err)
+	}
+	if rows := *stored.Dest.(*[]account); len(rows) != 1 || rows[0].Name != "ktsivkov" || rows[0].Secret != "" {
+		t.Errorf("expected the stored value to be transformed by BeforeStore, got %+v", rows)
+	}
+
+	if rows := find(); executed != 1 || len(rows) != 1 || rows[0].Secret != "derived:ktsivkov" {
+		t.Errorf("expected the cached value to be transformed by AfterGet, got %+v after %d queries", rows, executed)
+	}
+}
+
+func Test_readOnly(t *testing.T) {
+	testCases := map[string]bool{
+		"SELECT * FROM users":                                                true,
+		"  select name FROM users":                                           true,
+		"(SELECT id FROM users) UNION (SELECT id FROM admins)":               true,
+		"WITH recent AS (SELECT * FROM orders) SELECT * FROM recent":         true,
+		"/* hint */ SELECT * FROM users":                                     true,
+		"INSERT INTO users (name) VALUES ('ktsivkov') RETURNING id":          false,
+		"insert into users (name) values ('ktsivkov') returning id":          false,
+		"-- audit\nUPDATE users SET name = 'guest' RETURNING *":              false,
+		"/* hint */ DELETE FROM users RETURNING id":                          false,
+		"WITH moved AS (DELETE FROM orders RETURNING *) SELECT * FROM moved": false,
+		"CALL refresh_users()":                                               false,
+	}
+
+	for sql, expected := range testCases {
+		t.Run(sql, func(t *testing.T) {
+			db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			db.Statement.SQL.WriteString(sql)
+			if act := readOnly(db); act != expected {
+				t.Errorf("expected readOnly to report %t, got %t", expected, act)
+			}
+		})
+	}
+}
+
+func TestCaches_writesThroughQuery(t *testing.T) {
+	var executed int32
+	caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher(), Easer: true}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		n := atomic.AddInt32(&executed, 1)
+		time.Sleep(20 * time.Millisecond) // Long enough for the concurrent inserts to overlap
+		*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: fmt.Sprint(n)}}
+		db.Statement.RowsAffected = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var rows []mockDest
+			db.Raw("INSERT INTO users (name) VALUES (?) RETURNING id", "ktsivkov").Find(&rows)
+		}()
+	}
+	wg.Wait()
+
+	var rows []mockDest
+	db.Raw("INSERT INTO users (name) VALUES (?) RETURNING id", "ktsivkov").Find(&rows)
+	if executed != 4 {
+		t.Errorf("expected every insert to reach the database, it was queried %d times", executed)
+	}
+	if act := caches.Stats(); act != (Stats{}) {
+		t.Errorf("expected the inserts to neither reach the Cacher nor be coalesced, got %+v", act)
+	}
+}
+
+func TestCaches_easeDestinations(t *testing.T) {
+	var executed int32
+	release := make(chan struct{})
+	caches := &Caches{Conf: &Config{Easer: true}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		atomic.AddInt32(&executed, 1)
+		<-release
+		*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: "a"}, {Result: "b"}, {Result: "c"}}
+		db.Statement.RowsAffected = 3
+	}
+
+	t.Run("separate destinations", func(t *testing.T) {
+		const queries = 4
+		var (
+			wg       sync.WaitGroup
+			dests    [queries][]mockDest
+			affected [queries]int64
+		)
+		for i := 0; i < queries; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				affected[i] = db.Table("users").Find(&dests[i]).RowsAffected
+			}(i)
+			for j := 0; j < 100 && !waitingOn(caches, i); j++ {
+				time.Sleep(5 * time.Millisecond)
+			}
+		}
+		release <- struct{}{}
+		wg.Wait()
+
+		if executed != 1 {
+			t.Fatalf("expected the identical queries to be coalesced, the database was queried %d times", executed)
+		}
+		for i, rows := range dests {
+			if len(rows) != 3 || rows[2].Result != "c" || affected[i] != 3 {
+				t.Errorf("expected the query %d to get the 3 rows, got %+v with %d rows affected", i, rows, affected[i])
+			}
+		}
+
+		dests[1][0].Result = "mutated by the caller"
+		if dests[0][0].Result != "a" || dests[2][0].Result != "a" {
+			t.Error("expected the waiters with their own destination to get a copy of the result")
+		}
+	})
+
+	t.Run("shared destination", func(t *testing.T) {
+		atomic.StoreInt32(&executed, 0)
+		var (
+			rows     []mockDest
+			affected int64
+			done     = make(chan struct{})
+		)
+		leader := db.Table("users")
+		go func() {
+			defer close(done)
+			leader.Find(&rows)
+		}()
+		for j := 0; j < 100 && caches.InFlight() == 0; j++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		// A waiter reading into the leader's destination, which it only reads once the leader is done
+		var identifier string
+		for id := range caches.InFlightWaiters() {
+			identifier = id
+		}
+		waiter := db.Table("users")
+		waiter.Statement.Dest = &rows
+		go func() {
+			for j := 0; j < 100 && !waitingOn(caches, 1); j++ {
+				time.Sleep(5 * time.Millisecond)
+			}
+			release <- struct{}{}
+		}()
+		caches.ease(waiter, identifier, func(db *gorm.DB) {
+			t.Error("expected the waiter to be served by the leader")
+		})
+		affected = waiter.Statement.RowsAffected
+		<-done
+
+		if executed != 1 || len(rows) != 3 || affected != 3 {
+			t.Errorf("expected the waiter to get the rows count with the shared rows, got %d rows affected", affected)
+		}
+	})
+}
+
+// waitingOn reports whether the easer runs a query with the given number of queries waiting on it
+func waitingOn(caches *Caches, waiters int) bool {
+	for _, n := range caches.InFlightWaiters() {
+		if n == waiters {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCaches_easeCount(t *testing.T) {
+	var executed int32
+	release := make(chan struct{})
+	caches := &Caches{Conf: &Config{Easer: true}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		atomic.AddInt32(&executed, 1)
+		<-release
+		*db.Statement.Dest.(*int64) = 42
+		db.Statement.RowsAffected = 1
+	}
+
+	const queries = 3
+	var (
+		wg     sync.WaitGroup
+		counts [queries]int64
+	)
+	for i := 0; i < queries; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			db.Table("users").Count(&counts[i])
+		}(i)
+		for j := 0; j < 100 && !waitingOn(caches, i); j++ {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	close(release)
+	wg.Wait()
+
+	if executed != 1 || counts != [queries]int64{42, 42, 42} {
+		t.Errorf("expected the coalesced counts to be copied, got %v after %d queries", counts, executed)
+	}
+}
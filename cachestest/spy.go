@@ -0,0 +1,182 @@
+// Package cachestest provides a Cacher recording the operations the caches plugin runs against it, so the tests of
+// the code using the plugin can assert on the keys it looks up and stores, and on its sequence of hits and misses,
+// without hand-rolling a fake backend.
+package cachestest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-gorm/caches/v4"
+)
+
+// Call is an operation the SpyCacher received, the Keys are the ones of a Get or Store, several for the batched ones,
+// the Tables and Tags are the ones of an Invalidate, and Hit reports whether the keys of a Get held any value
+type Call struct {
+	Operation caches.CacherOperation
+	Keys      []string
+	Tables    []string
+	Tags      []string
+	Hit       bool
+	TTL       time.Duration
+}
+
+// SpyCacher is an in-memory Cacher recording every call it receives, it implements the optional interfaces
+// of the plugin as well: TagInvalidator, BatchCacher and ExpiryCacher
+// The values are held by a MemoryCacher, so they are stored and invalidated as they would be with a real backend,
+// and the errors set with Fail are returned instead of running the operations. Its methods are safe for concurrent use.
+type SpyCacher struct {
+	mu     sync.Mutex
+	memory *caches.MemoryCacher
+	calls  []Call
+	errs   map[caches.CacherOperation]error
+}
+
+// NewSpyCacher returns an empty SpyCacher
+func NewSpyCacher() *SpyCacher {
+	return &SpyCacher{memory: caches.NewMemoryCacher()}
+}
+
+func (c *SpyCacher) Get(ctx context.Context, key string, q *caches.Query[any]) (*caches.Query[any], error) {
+	res, _, err := c.GetWithExpiry(ctx, key, q)
+	return res, err
+}
+
+func (c *SpyCacher) GetWithExpiry(ctx context.Context, key string, q *caches.Query[any]) (*caches.Query[any], time.Time, error) {
+	if err := c.failure(caches.CacherGet); err != nil {
+		c.record(Call{Operation: caches.CacherGet, Keys: []string{key}})
+		return nil, caches.UnknownExpiry, err
+	}
+
+	res, expiresAt, err := c.memory.GetWithExpiry(ctx, key, q)
+	c.record(Call{Operation: caches.CacherGet, Keys: []string{key}, Hit: res != nil})
+	return res, expiresAt, err
+}
+
+func (c *SpyCacher) Store(ctx context.Context, key string, val *caches.Query[any], ttl time.Duration) error {
+	c.record(Call{Operation: caches.CacherStore, Keys: []string{key}, TTL: ttl})
+	if err := c.failure(caches.CacherStore); err != nil {
+		return err
+	}
+	return c.memory.Store(ctx, key, val, ttl)
+}
+
+func (c *SpyCacher) Invalidate(ctx context.Context, tables ...string) error {
+	c.record(Call{Operation: caches.CacherInvalidate, Tables: tables})
+	if err := c.failure(caches.CacherInvalidate); err != nil {
+		return err
+	}
+	return c.memory.Invalidate(ctx, tables...)
+}
+
+func (c *SpyCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	c.record(Call{Operation: caches.CacherInvalidate, Tags: tags})
+	if err := c.failure(caches.CacherInvalidate); err != nil {
+		return err
+	}
+	return c.memory.InvalidateTags(ctx, tags...)
+}
+
+func (c *SpyCacher) BatchGet(ctx context.Context, keys []string, qs []*caches.Query[any]) ([]*caches.Query[any], error) {
+	if err := c.failure(caches.CacherGet); err != nil {
+		c.record(Call{Operation: caches.CacherGet, Keys: keys})
+		return nil, err
+	}
+
+	res := make([]*caches.Query[any], len(keys))
+	hit := false
+	for i, key := range keys {
+		q, err := c.memory.Get(ctx, key, qs[i])
+		if err != nil {
+			return nil, err
+		}
+		res[i] = q
+		hit = hit || q != nil
+	}
+	c.record(Call{Operation: caches.CacherGet, Keys: keys, Hit: hit})
+	return res, nil
+}
+
+func (c *SpyCacher) BatchStore(ctx context.Context, entries []caches.BatchEntry) error {
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	c.record(Call{Operation: caches.CacherStore, Keys: keys})
+	if err := c.failure(caches.CacherStore); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := c.memory.Store(ctx, entry.Key, entry.Value, entry.TTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fail makes the operations return err, until Fail is called again for them with a nil error
+func (c *SpyCacher) Fail(op caches.CacherOperation, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.errs == nil {
+		c.errs = make(map[caches.CacherOperation]error)
+	}
+	c.errs[op] = err
+}
+
+// Calls returns the calls received so far, in the order they were received
+func (c *SpyCacher) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+// Keys returns the keys of the calls of the operation received so far, in the order they were received
+func (c *SpyCacher) Keys(op caches.CacherOperation) []string {
+	var keys []string
+	for _, call := range c.Calls() {
+		if call.Operation == op {
+			keys = append(keys, call.Keys...)
+		}
+	}
+	return keys
+}
+
+// Hits returns whether every Get received so far was a hit, in the order they were received,
+// e.g. []bool{false, true} for a query cached by its first run and served from the cache by the second one
+func (c *SpyCacher) Hits() []bool {
+	var hits []bool
+	for _, call := range c.Calls() {
+		if call.Operation == caches.CacherGet {
+			hits = append(hits, call.Hit)
+		}
+	}
+	return hits
+}
+
+// Len returns the number of values currently held
+func (c *SpyCacher) Len() int {
+	return c.memory.Len()
+}
+
+// Reset forgets the calls received so far along with the values held and the errors set with Fail
+func (c *SpyCacher) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls, c.errs = nil, nil
+	_ = c.memory.Invalidate(context.Background())
+}
+
+func (c *SpyCacher) record(call Call) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, call)
+}
+
+func (c *SpyCacher) failure(op caches.CacherOperation) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.errs[op]
+}
@@ -0,0 +1,151 @@
+package cachestest_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-gorm/caches/v4"
+	"github.com/go-gorm/caches/v4/cachestest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type user struct {
+	ID   int
+	Name string
+}
+
+// newDB opens a database whose queries return a single user without reaching any database, with the plugin using
+// the SpyCacher, as the tests of the code using the plugin would
+func newDB(t *testing.T, spy *cachestest.SpyCacher) (*gorm.DB, *int) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+
+	var executed int
+	if err := db.Callback().Query().Replace("gorm:query", func(db *gorm.DB) {
+		executed++
+		*db.Statement.Dest.(*[]user) = []user{{ID: 1, Name: "cached"}}
+		db.Statement.RowsAffected = 1
+	}); err != nil {
+		t.Fatalf("the query callback replacement resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(&caches.Caches{Conf: &caches.Config{Cacher: spy, DefaultTTL: time.Minute}}); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	return db, &executed
+}
+
+func TestSpyCacher(t *testing.T) {
+	t.Run("hits and misses", func(t *testing.T) {
+		spy := cachestest.NewSpyCacher()
+		db, executed := newDB(t, spy)
+
+		for i := 0; i < 2; i++ {
+			var users []user
+			if err := db.Find(&users).Error; err != nil || len(users) != 1 {
+				t.Fatalf("the query resulted into %v and %v", users, err)
+			}
+		}
+
+		if *executed != 1 {
+			t.Errorf("expected the second query to be served from the cache, the database was queried %d times", *executed)
+		}
+		if act := spy.Hits(); !reflect.DeepEqual(act, []bool{false, true}) {
+			t.Errorf("expected a miss followed by a hit, got %v", act)
+		}
+		stored := spy.Keys(caches.CacherStore)
+		if len(stored) != 1 || !reflect.DeepEqual(spy.Keys(caches.CacherGet), []string{stored[0], stored[0]}) {
+			t.Errorf("expected the key stored to be the one looked up, got %v and %v", stored, spy.Keys(caches.CacherGet))
+		}
+		if calls := spy.Calls(); calls[1].TTL != time.Minute {
+			t.Errorf("expected the ttl of the store to be recorded, got %+v", calls[1])
+		}
+		if spy.Len() != 1 {
+			t.Errorf("expected a single value to be held, got %d", spy.Len())
+		}
+	})
+
+	t.Run("invalidations", func(t *testing.T) {
+		spy := cachestest.NewSpyCacher()
+		db, executed := newDB(t, spy)
+		db.Find(&[]user{})
+
+		db.Session(&gorm.Session{DryRun: true}).Model(&user{}).Where("id = ?", 1).Update("name", "updated")
+		db.Find(&[]user{})
+
+		var tables []string
+		for _, call := range spy.Calls() {
+			if call.Operation == caches.CacherInvalidate {
+				tables = append(tables, call.Tables...)
+			}
+		}
+		if !reflect.DeepEqual(tables, []string{"users"}) {
+			t.Errorf("expected the table of the update to be invalidated, got %v", tables)
+		}
+		if *executed != 2 || !reflect.DeepEqual(spy.Hits(), []bool{false, false}) {
+			t.Errorf("expected the query following the update to miss, got %v", spy.Hits())
+		}
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		spy := cachestest.NewSpyCacher()
+		db, _ := newDB(t, spy)
+		unreachable := errors.New("unreachable")
+		spy.Fail(caches.CacherGet, unreachable)
+
+		if err := db.Find(&[]user{}).Error; !errors.Is(err, unreachable) {
+			t.Errorf("expected the lookup error to fail the query, got %v", err)
+		}
+
+		spy.Fail(caches.CacherGet, nil)
+		db.Find(&[]user{})
+		db.Find(&[]user{})
+		if act := spy.Hits(); !reflect.DeepEqual(act, []bool{false, false, true}) {
+			t.Errorf("expected the lookups to succeed again, got %v", act)
+		}
+	})
+
+	t.Run("optional interfaces", func(t *testing.T) {
+		var cacher caches.Cacher = cachestest.NewSpyCacher()
+		if _, ok := cacher.(caches.TagInvalidator); !ok {
+			t.Error("expected the SpyCacher to be a TagInvalidator")
+		}
+		if _, ok := cacher.(caches.BatchCacher); !ok {
+			t.Error("expected the SpyCacher to be a BatchCacher")
+		}
+		if _, ok := cacher.(caches.ExpiryCacher); !ok {
+			t.Error("expected the SpyCacher to be an ExpiryCacher")
+		}
+	})
+
+	t.Run("batches", func(t *testing.T) {
+		ctx := context.Background()
+		spy := cachestest.NewSpyCacher()
+		if err := spy.BatchStore(ctx, []caches.BatchEntry{
+			{Key: "first", Value: &caches.Query[any]{Dest: &user{ID: 1}}},
+			{Key: "second", Value: &caches.Query[any]{Dest: &user{ID: 2}}},
+		}); err != nil {
+			t.Fatalf("BatchStore resulted into an unexpected error, %v", err)
+		}
+
+		res, err := spy.BatchGet(ctx, []string{"first", "missing"}, []*caches.Query[any]{
+			{Dest: &user{}}, {Dest: &user{}},
+		})
+		if err != nil || res[0] == nil || res[0].Dest.(*user).ID != 1 || res[1] != nil {
+			t.Fatalf("BatchGet was expected to return the stored value only, got %v and %v", res, err)
+		}
+		if act := spy.Keys(caches.CacherGet); !reflect.DeepEqual(act, []string{"first", "missing"}) {
+			t.Errorf("expected the batched keys to be recorded, got %v", act)
+		}
+
+		spy.Reset()
+		if len(spy.Calls()) != 0 || spy.Len() != 0 {
+			t.Errorf("expected Reset to forget the calls and the values, got %v", spy.Calls())
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCircuitBreakerThreshold = 5
+	defaultCircuitBreakerWindow    = 10 * time.Second
+	defaultCircuitBreakerCooldown  = 5 * time.Second
+)
+
+// CircuitState is the state of a CircuitBreakerCacher
+type CircuitState string
+
+const (
+	// CircuitClosed is the state of a healthy Cacher, every operation reaches it
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen is the state of a failing Cacher, the lookups and stores are skipped until the cooldown elapses
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen is the state of a Cacher past its cooldown, a single operation probes it meanwhile
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+type CircuitBreakerOption func(c *CircuitBreakerCacher)
+
+// WithCircuitBreakerThreshold sets the number of consecutive failures opening the circuit, it defaults to 5
+func WithCircuitBreakerThreshold(failures int) CircuitBreakerOption {
+	return func(c *CircuitBreakerCacher) {
+		if failures > 0 {
+			c.threshold = failures
+		}
+	}
+}
+
+// WithCircuitBreakerWindow sets the window the consecutive failures have to happen within, it defaults to 10s
+// A failure happening after the window of the first one starts a new run of failures.
+func WithCircuitBreakerWindow(window time.Duration) CircuitBreakerOption {
+	return func(c *CircuitBreakerCacher) {
+		if window > 0 {
+			c.window = window
+		}
+	}
+}
+
+// WithCircuitBreakerCooldown sets how long the circuit stays open before it is probed, it defaults to 5s
+func WithCircuitBreakerCooldown(cooldown time.Duration) CircuitBreakerOption {
+	return func(c *CircuitBreakerCacher) {
+		if cooldown > 0 {
+			c.cooldown = cooldown
+		}
+	}
+}
+
+// WithCircuitBreakerObserver sets the observer notified about the state changes of the circuit
+func WithCircuitBreakerObserver(observer CircuitObserver) CircuitBreakerOption {
+	return func(c *CircuitBreakerCacher) {
+		c.observer = observer
+	}
+}
+
+// CircuitBreakerCacher is a Cacher protecting the queries from a flaky Cacher
+//
+// Once Get and Store failed a number of consecutive times within a window (see WithCircuitBreakerThreshold and
+// WithCircuitBreakerWindow), the circuit opens: the lookups are misses and the stores are skipped, without reaching
+// the inner Cacher, so the queries only rely on the database instead of failing or waiting on it.
+// Once the cooldown elapsed (see WithCircuitBreakerCooldown) the circuit is half-open, a single operation probes the
+// inner Cacher, closing the circuit when it succeeds and opening it again otherwise.
+//
+// The invalidations always reach the inner Cacher and do not count as failures, since skipping them would leave
+// stale values behind. The errors of the callers' cancelled contexts, and the values failing to decode,
+// do not count as failures either.
+type CircuitBreakerCacher struct {
+	inner     Cacher
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	observer  CircuitObserver
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	since    time.Time // The first failure of the run when closed, the instant it opened otherwise
+	probing  bool
+}
+
+func NewCircuitBreakerCacher(inner Cacher, opts ...CircuitBreakerOption) *CircuitBreakerCacher {
+	c := &CircuitBreakerCacher{
+		inner:     inner,
+		threshold: defaultCircuitBreakerThreshold,
+		window:    defaultCircuitBreakerWindow,
+		cooldown:  defaultCircuitBreakerCooldown,
+		state:     CircuitClosed,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// State returns the current state of the circuit
+func (c *CircuitBreakerCacher) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+func (c *CircuitBreakerCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	if !c.allow() {
+		return nil, nil
+	}
+
+	res, err := c.inner.Get(ctx, key, q)
+	c.record(err)
+	return res, err
+}
+
+func (c *CircuitBreakerCacher) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	if !c.allow() {
+		return nil
+	}
+
+	err := c.inner.Store(ctx, key, val, ttl)
+	c.record(err)
+	return err
+}
+
+func (c *CircuitBreakerCacher) Invalidate(ctx context.Context, tables ...string) error {
+	return c.inner.Invalidate(ctx, tables...)
+}
+
+// InvalidateTags invalidates the tags of the inner Cacher, it fails when the inner Cacher is not a TagInvalidator,
+// in which case the plugin invalidates the mutations per table instead
+func (c *CircuitBreakerCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	invalidator, ok := tagInvalidatorOf(c.inner)
+	if !ok {
+		return errTagsUnsupported
+	}
+	return invalidator.InvalidateTags(ctx, tags...)
+}
+
+func (c *CircuitBreakerCacher) supportsTags() bool {
+	_, ok := tagInvalidatorOf(c.inner)
+	return ok
+}
+
+// Ping pings the inner Cacher whatever the state of the circuit, and does not count as an operation of the circuit
+func (c *CircuitBreakerCacher) Ping(ctx context.Context) error {
+	return pingOf(ctx, c.inner)
+}
+
+// allow reports whether an operation may reach the inner Cacher, turning an open circuit past its cooldown half-open
+func (c *CircuitBreakerCacher) allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case CircuitOpen:
+		if time.Since(c.since) < c.cooldown {
+			return false
+		}
+		c.transition(CircuitHalfOpen)
+		c.probing = true
+		return true
+	case CircuitHalfOpen:
+		if c.probing {
+			return false
+		}
+		c.probing = true
+		return true
+	}
+	return true
+}
+
+// record updates the circuit with the outcome of an operation which reached the inner Cacher
+func (c *CircuitBreakerCacher) record(err error) {
+	if errors.Is(err, ErrSkipCache) {
+		// The inner Cacher answered, refusing the value
+		err = nil
+	}
+	failed := err != nil && !errors.Is(err, context.Canceled) && !isDecompressError(err) && !isDecodeError(err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == CircuitHalfOpen {
+		c.probing = false
+		switch {
+		case failed:
+			c.open()
+		case err == nil:
+			c.failures = 0
+			c.transition(CircuitClosed)
+		}
+		// An error which is not a failure tells nothing about the inner Cacher, the next operation probes it again
+		return
+	}
+
+	if !failed {
+		c.failures = 0
+		return
+	}
+	if c.failures == 0 || time.Since(c.since) > c.window {
+		c.failures, c.since = 0, time.Now()
+	}
+	c.failures++
+	if c.state == CircuitClosed && c.failures >= c.threshold {
+		c.open()
+	}
+}
+
+func (c *CircuitBreakerCacher) open() {
+	c.failures, c.since = 0, time.Now()
+	c.transition(CircuitOpen)
+}
+
+func (c *CircuitBreakerCacher) transition(state CircuitState) {
+	if c.state == state {
+		return
+	}
+	c.state = state
+	if c.observer != nil {
+		c.observer.OnCircuitStateChange(state)
+	}
+}
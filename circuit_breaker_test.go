@@ -0,0 +1,264 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+var errFlakyCacher = errors.New("flaky cacher")
+
+// flakyCacherMock is a cacherMock failing its Get and Store while failing is set, it counts the calls reaching it
+type flakyCacherMock struct {
+	cacherMock
+	mu      sync.Mutex
+	failing bool
+	calls   int
+}
+
+func (c *flakyCacherMock) fail(failing bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failing = failing
+}
+
+func (c *flakyCacherMock) call() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	if c.failing {
+		return errFlakyCacher
+	}
+	return nil
+}
+
+func (c *flakyCacherMock) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	if err := c.call(); err != nil {
+		return nil, err
+	}
+	return c.cacherMock.Get(ctx, key, q)
+}
+
+func (c *flakyCacherMock) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	if err := c.call(); err != nil {
+		return err
+	}
+	return c.cacherMock.Store(ctx, key, val, ttl)
+}
+
+func TestCircuitBreakerCacher(t *testing.T) {
+	ctx := context.Background()
+	newCacher := func(opts ...CircuitBreakerOption) (*CircuitBreakerCacher, *flakyCacherMock, *observerMock) {
+		inner, observer := &flakyCacherMock{}, &observerMock{}
+		opts = append([]CircuitBreakerOption{
+			WithCircuitBreakerThreshold(3),
+			WithCircuitBreakerCooldown(50 * time.Millisecond),
+			WithCircuitBreakerObserver(observer),
+		}, opts...)
+		return NewCircuitBreakerCacher(inner, opts...), inner, observer
+	}
+	get := func(c Cacher) error {
+		_, err := c.Get(ctx, "key", &Query[any]{Dest: &mockDest{}})
+		return err
+	}
+
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		cacher, inner, observer := newCacher()
+		inner.fail(true)
+
+		for i := 0; i < 3; i++ {
+			if err := get(cacher); !errors.Is(err, errFlakyCacher) {
+				t.Errorf("expected the failures to be returned while the circuit is closed, got %v", err)
+			}
+		}
+		if act := cacher.State(); act != CircuitOpen {
+			t.Fatalf("expected the circuit to open, got %s", act)
+		}
+
+		if err := get(cacher); err != nil {
+			t.Errorf("expected a lookup to be a miss while the circuit is open, got %v", err)
+		}
+		if err := cacher.Store(ctx, "key", &Query[any]{Dest: &mockDest{}}, 0); err != nil {
+			t.Errorf("expected a store to be skipped while the circuit is open, got %v", err)
+		}
+		if inner.calls != 3 {
+			t.Errorf("expected the open circuit not to reach the inner cacher, it was called %d times", inner.calls)
+		}
+		if expected := []string{"circuit:open"}; !reflect.DeepEqual(observer.events, expected) {
+			t.Errorf("expected the observer events to be %v, got %v", expected, observer.events)
+		}
+	})
+
+	t.Run("a success resets the failures", func(t *testing.T) {
+		cacher, inner, _ := newCacher()
+		for i := 0; i < 3; i++ {
+			inner.fail(i != 1)
+			_ = get(cacher)
+		}
+		if act := cacher.State(); act != CircuitClosed {
+			t.Errorf("expected the failures not to be consecutive, got %s", act)
+		}
+	})
+
+	t.Run("failures outside of the window", func(t *testing.T) {
+		cacher, inner, _ := newCacher(WithCircuitBreakerWindow(20 * time.Millisecond))
+		inner.fail(true)
+		_ = get(cacher)
+		_ = get(cacher)
+		time.Sleep(30 * time.Millisecond)
+		_ = get(cacher)
+		if act := cacher.State(); act != CircuitClosed {
+			t.Errorf("expected a failure outside of the window to start a new run, got %s", act)
+		}
+	})
+
+	t.Run("cancellations are not failures", func(t *testing.T) {
+		cacher, _, _ := newCacher()
+		cacher.inner = &slowCacherMock{delay: time.Second}
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		for i := 0; i < 3; i++ {
+			_, _ = cacher.Get(cancelled, "key", &Query[any]{Dest: &mockDest{}})
+		}
+		if act := cacher.State(); act != CircuitClosed {
+			t.Errorf("expected the cancelled lookups not to open the circuit, got %s", act)
+		}
+	})
+
+	t.Run("half-open probe", func(t *testing.T) {
+		cacher, inner, observer := newCacher()
+		inner.fail(true)
+		for i := 0; i < 3; i++ {
+			_ = get(cacher)
+		}
+
+		// A failing probe opens the circuit again
+		time.Sleep(60 * time.Millisecond)
+		if err := get(cacher); !errors.Is(err, errFlakyCacher) {
+			t.Errorf("expected the probe to reach the inner cacher, got %v", err)
+		}
+		if act := cacher.State(); act != CircuitOpen {
+			t.Fatalf("expected the failed probe to open the circuit again, got %s", act)
+		}
+
+		inner.fail(false)
+		time.Sleep(60 * time.Millisecond)
+		if err := get(cacher); err != nil {
+			t.Errorf("expected the probe to succeed, got %v", err)
+		}
+		if act := cacher.State(); act != CircuitClosed {
+			t.Errorf("expected the successful probe to close the circuit, got %s", act)
+		}
+
+		expected := []string{"circuit:open", "circuit:half-open", "circuit:open", "circuit:half-open", "circuit:closed"}
+		if !reflect.DeepEqual(observer.events, expected) {
+			t.Errorf("expected the observer events to be %v, got %v", expected, observer.events)
+		}
+	})
+
+	t.Run("single probe at a time", func(t *testing.T) {
+		cacher, inner, _ := newCacher()
+		inner.fail(true)
+		for i := 0; i < 3; i++ {
+			_ = get(cacher)
+		}
+		time.Sleep(60 * time.Millisecond)
+
+		if !cacher.allow() {
+			t.Fatal("expected the first operation past the cooldown to probe the inner cacher")
+		}
+		if cacher.allow() {
+			t.Error("expected the other operations to be short-circuited while probing")
+		}
+	})
+
+	t.Run("invalidations always pass through", func(t *testing.T) {
+		cacher, inner, _ := newCacher()
+		inner.fail(true)
+		for i := 0; i < 3; i++ {
+			_ = get(cacher)
+		}
+
+		if err := cacher.Invalidate(ctx, "users"); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+		if !reflect.DeepEqual(inner.invalidatedTables, []string{"users"}) {
+			t.Errorf("expected the invalidation to reach the inner cacher, got %v", inner.invalidatedTables)
+		}
+	})
+
+	t.Run("tags of the inner cacher", func(t *testing.T) {
+		cacher, inner, _ := newCacher()
+		_ = cacher.Invalidate(ctx, "users")
+		if _, ok := tagInvalidatorOf(cacher); ok {
+			t.Error("expected the circuit breaker not to support the tags when the inner cacher does not")
+		}
+		if err := cacher.InvalidateTags(ctx, "users:1"); err == nil {
+			t.Error("expected the tag invalidation to fail when the inner cacher does not support the tags")
+		}
+		if !reflect.DeepEqual(inner.invalidatedTables, []string{"users"}) {
+			t.Errorf("expected the inner cacher not to be fully invalidated, got %v", inner.invalidatedTables)
+		}
+
+		memory := NewMemoryCacher()
+		breaker := NewCircuitBreakerCacher(memory)
+		_ = memory.Store(ctx, "key", &Query[any]{Dest: &mockDest{}, Tags: []string{"users:1"}}, time.Minute)
+		if _, ok := tagInvalidatorOf(breaker); !ok {
+			t.Fatal("expected the circuit breaker to support the tags when the inner cacher does")
+		}
+		if err := breaker.InvalidateTags(ctx, "users:1"); err != nil {
+			t.Fatalf("InvalidateTags resulted into an unexpected error, %v", err)
+		}
+		if res, _ := memory.Get(ctx, "key", &Query[any]{Dest: &mockDest{}}); res != nil {
+			t.Error("expected the tag invalidation to reach the inner cacher")
+		}
+	})
+}
+
+func TestCaches_CircuitBreakerCacher(t *testing.T) {
+	inner := &flakyCacherMock{failing: true}
+	caches := &Caches{
+		Conf: &Config{
+			Cacher: NewCircuitBreakerCacher(inner, WithCircuitBreakerThreshold(2)),
+		},
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				db.Statement.Dest.(*mockDest).Result = "from-database"
+			},
+		},
+	}
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.Table = "users"
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	db := newDB()
+	caches.query(db)
+	if !errors.Is(db.Error, errFlakyCacher) {
+		t.Fatalf("expected the failure to fail the query while the circuit is closed, got %v", db.Error)
+	}
+	caches.query(newDB())
+
+	for i := 0; i < 3; i++ {
+		db := newDB()
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("expected the open circuit not to fail the query, got %v", db.Error)
+		}
+		if act := db.Statement.Dest.(*mockDest).Result; act != "from-database" {
+			t.Errorf("expected the query to hit the database, got `%s`", act)
+		}
+	}
+	if inner.calls != 2 {
+		t.Errorf("expected the open circuit not to reach the inner cacher, it was called %d times", inner.calls)
+	}
+}
@@ -0,0 +1,161 @@
+package caches
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	clausePkgPath = reflect.TypeOf(clause.Clause{}).PkgPath()
+	gormPkgPath   = reflect.TypeOf(gorm.Statement{}).PkgPath()
+	gormDBType    = reflect.TypeOf(&gorm.DB{})
+)
+
+// ClausesKeyBuilder is a KeyBuilder identifying the queries by the fingerprint of their clauses rather than by their
+// SQL, so the same clauses rendered to different SQL, e.g. by a dialect quoting or binding them differently, share
+// their key. The key is IdentifierPrefix followed by 32 hex characters, as the default identifiers are
+//
+//	db.Use(&caches.Caches{Conf: &caches.Config{Cacher: cacher, KeyBuilder: caches.ClausesKeyBuilder}})
+//
+// The fingerprint holds the table, the clauses the statement was built from in their build order, their expressions
+// and the values they hold, formatted along with their type as the bind variables are, and the subqueries they hold,
+// fingerprinted the same way. The raw SQL of the expressions, e.g. the one of `Where("id = ?", 1)`, is part of it
+// with its whitespace collapsed. The raw queries, which are not built from clauses, are identified by their SQL.
+func ClausesKeyBuilder(db *gorm.DB) string {
+	if !builtFromClauses(db.Statement) {
+		return buildIdentifier(db, "", nil)
+	}
+
+	var f clausesFingerprint
+	f.writeStatement(db.Statement)
+	return hashKey(f.String())
+}
+
+// builtFromClauses reports whether the SQL of the statement was built from its clauses, which the raw queries are not:
+// gorm runs them with the build clauses of the callbacks as well, but they hold none of them
+func builtFromClauses(stmt *gorm.Statement) bool {
+	for _, name := range stmt.BuildClauses {
+		if _, ok := stmt.Clauses[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// clausesFingerprint formats the clauses of a statement deterministically, see ClausesKeyBuilder
+type clausesFingerprint struct {
+	strings.Builder
+}
+
+func (f *clausesFingerprint) writeStatement(stmt *gorm.Statement) {
+	_, _ = fmt.Fprintf(f, "table(%d:%s)", len(stmt.Table), stmt.Table)
+	if stmt.Model != nil {
+		_, _ = fmt.Fprintf(f, "model(%T)", stmt.Model)
+	}
+	f.writeField("table_expr", reflect.ValueOf(stmt.TableExpr))
+	f.writeField("distinct", reflect.ValueOf(stmt.Distinct))
+	f.writeField("selects", reflect.ValueOf(stmt.Selects))
+	f.writeField("omits", reflect.ValueOf(stmt.Omits))
+	f.writeField("joins", reflect.ValueOf(stmt.Joins))
+
+	// The clauses of a subquery are only ordered once it is built
+	names := stmt.BuildClauses
+	if len(names) == 0 {
+		names = make([]string, 0, len(stmt.Clauses))
+		for name := range stmt.Clauses {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if c, ok := stmt.Clauses[name]; ok {
+			f.writeField("clause:"+name, reflect.ValueOf(c))
+		}
+	}
+}
+
+func (f *clausesFingerprint) writeField(name string, v reflect.Value) {
+	f.WriteString(name)
+	f.WriteByte('(')
+	f.write(v)
+	f.WriteByte(')')
+}
+
+// write formats the value, walking the types of gorm and of its clause package, while the other values are formatted
+// as the bind variables are
+func (f *clausesFingerprint) write(v reflect.Value) {
+	if !v.IsValid() {
+		f.WriteString("nil")
+		return
+	}
+	if v.Type() == gormDBType {
+		if v.IsNil() {
+			f.WriteString("nil")
+			return
+		}
+		f.WriteString("subquery(")
+		f.writeStatement(v.Interface().(*gorm.DB).Statement)
+		f.WriteByte(')')
+		return
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			f.WriteString("nil")
+			return
+		}
+		f.write(v.Elem())
+	case reflect.Func:
+		// The builders of the clauses cannot be told apart, the dialect provides them anyway
+	case reflect.Struct:
+		if pkg := v.Type().PkgPath(); pkg != clausePkgPath && pkg != gormPkgPath {
+			f.writeValue(v)
+			return
+		}
+		f.WriteString(v.Type().String())
+		f.WriteByte('{')
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.Name == "SQL" && field.Type.Kind() == reflect.String {
+				query, _ := canonicalQuery(v.Field(i).String(), nil)
+				_, _ = fmt.Fprintf(f, "SQL:%d:%s,", len(query), query)
+				continue
+			}
+			f.WriteString(field.Name)
+			f.WriteByte(':')
+			f.write(v.Field(i))
+			f.WriteByte(',')
+		}
+		f.WriteByte('}')
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			f.writeValue(v)
+			return
+		}
+		f.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			f.write(v.Index(i))
+			f.WriteByte(',')
+		}
+		f.WriteByte(']')
+	default:
+		f.writeValue(v)
+	}
+}
+
+// writeValue formats the value along with its type, length prefixed, as canonicalQuery formats the bind variables
+func (f *clausesFingerprint) writeValue(v reflect.Value) {
+	var s string
+	if v.CanInterface() {
+		s = fmt.Sprintf("%T(%s)", v.Interface(), valueToString(v.Interface()))
+	} else {
+		s = fmt.Sprintf("%s(%v)", v.Type(), v)
+	}
+	_, _ = fmt.Fprintf(f, "%d:%s", len(s), s)
+}
@@ -0,0 +1,151 @@
+package caches
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
+)
+
+// pgLikeDialector renders the same clauses as the DummyDialector to a different SQL
+type pgLikeDialector struct {
+	tests.DummyDialector
+}
+
+func (pgLikeDialector) Name() string {
+	return "pglike"
+}
+
+func (pgLikeDialector) BindVarTo(writer clause.Writer, stmt *gorm.Statement, v interface{}) {
+	_ = writer.WriteByte('$')
+	_, _ = writer.WriteString(strconv.Itoa(len(stmt.Vars)))
+}
+
+func (pgLikeDialector) QuoteTo(writer clause.Writer, str string) {
+	_ = writer.WriteByte('"')
+	_, _ = writer.WriteString(strings.ReplaceAll(str, ".", `"."`))
+	_ = writer.WriteByte('"')
+}
+
+type clausesKeyUser struct {
+	ID   uint
+	Name string
+	Age  int
+}
+
+func TestClausesKeyBuilder(t *testing.T) {
+	newCaches := func(dialector gorm.Dialector, keyBuilder func(*gorm.DB) string) (*Caches, *gorm.DB) {
+		db, err := gorm.Open(dialector, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher(), KeyBuilder: keyBuilder}}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		return caches, db
+	}
+	identifier := func(caches *Caches, db *gorm.DB, query func(*gorm.DB) *gorm.DB) string {
+		id, err := caches.Identifier(db, query)
+		if err != nil {
+			t.Fatalf("the identifier resulted into an unexpected error, %v", err)
+		}
+		return id
+	}
+
+	find := func(name string, age int) func(*gorm.DB) *gorm.DB {
+		return func(db *gorm.DB) *gorm.DB {
+			return db.Where("name = ? AND age > ?", name, age).Order("id").Limit(10).Find(&[]clausesKeyUser{})
+		}
+	}
+	subquery := func(db *gorm.DB) *gorm.DB {
+		return db.Where("age IN (?)", db.Model(&clausesKeyUser{}).Select("age").Where("name = ?", "adult")).
+			Find(&[]clausesKeyUser{})
+	}
+
+	dummy, dummyDB := newCaches(tests.DummyDialector{}, ClausesKeyBuilder)
+	pgLike, pgLikeDB := newCaches(pgLikeDialector{}, ClausesKeyBuilder)
+
+	t.Run("dialects share the keys", func(t *testing.T) {
+		for name, query := range map[string]func(*gorm.DB) *gorm.DB{"find": find("jinzhu", 18), "subquery": subquery} {
+			a, b := identifier(dummy, dummyDB, query), identifier(pgLike, pgLikeDB, query)
+			if a != b {
+				t.Errorf("%s: expected the dialects to share the key, got %s and %s", name, a, b)
+			}
+			if !strings.HasPrefix(a, IdentifierPrefix) || len(a) != len(IdentifierPrefix)+32 {
+				t.Errorf("%s: expected the prefix followed by a 128-bit hex digest, got %s", name, a)
+			}
+		}
+
+		sqlDummy, sqlDummyDB := newCaches(tests.DummyDialector{}, nil)
+		sqlPgLike, sqlPgLikeDB := newCaches(pgLikeDialector{}, nil)
+		if identifier(sqlDummy, sqlDummyDB, find("jinzhu", 18)) == identifier(sqlPgLike, sqlPgLikeDB, find("jinzhu", 18)) {
+			t.Error("expected the default identifiers of the dialects to differ")
+		}
+	})
+
+	t.Run("different clauses", func(t *testing.T) {
+		expected := identifier(dummy, dummyDB, find("jinzhu", 18))
+		if act := identifier(dummy, dummyDB, find("jinzhu", 18)); act != expected {
+			t.Errorf("expected identical queries to share the key, got %s and %s", act, expected)
+		}
+
+		queries := map[string]func(*gorm.DB) *gorm.DB{
+			"values": find("jinzhu", 21),
+			"types": func(db *gorm.DB) *gorm.DB {
+				return db.Where("name = ? AND age > ?", "jinzhu", "18").Order("id").Limit(10).Find(&[]clausesKeyUser{})
+			},
+			"order": func(db *gorm.DB) *gorm.DB {
+				return db.Where("name = ? AND age > ?", "jinzhu", 18).Order("age").Limit(10).Find(&[]clausesKeyUser{})
+			},
+			"limit": func(db *gorm.DB) *gorm.DB {
+				return db.Where("name = ? AND age > ?", "jinzhu", 18).Order("id").Limit(20).Find(&[]clausesKeyUser{})
+			},
+			"select": func(db *gorm.DB) *gorm.DB {
+				return db.Select("name").Where("name = ? AND age > ?", "jinzhu", 18).Order("id").Limit(10).
+					Find(&[]clausesKeyUser{})
+			},
+			"table": func(db *gorm.DB) *gorm.DB {
+				return db.Table("archived_users").Where("name = ? AND age > ?", "jinzhu", 18).Order("id").Limit(10).
+					Find(&[]clausesKeyUser{})
+			},
+			"subquery values": func(db *gorm.DB) *gorm.DB {
+				return db.Where("age IN (?)", db.Model(&clausesKeyUser{}).Select("age").Where("name = ?", "minor")).
+					Find(&[]clausesKeyUser{})
+			},
+		}
+		seen := map[string]string{identifier(dummy, dummyDB, subquery): "subquery"}
+		for name, query := range queries {
+			act := identifier(dummy, dummyDB, query)
+			if act == expected {
+				t.Errorf("%s: expected a different key, got %s", name, act)
+			}
+			if other, ok := seen[act]; ok {
+				t.Errorf("%s: expected a different key than %s, got %s", name, other, act)
+			}
+			seen[act] = name
+		}
+	})
+
+	t.Run("whitespace", func(t *testing.T) {
+		a := identifier(dummy, dummyDB, func(db *gorm.DB) *gorm.DB {
+			return db.Where("name = ?  AND\n\tage > ?", "jinzhu", 18).Order("id").Limit(10).Find(&[]clausesKeyUser{})
+		})
+		if b := identifier(dummy, dummyDB, find("jinzhu", 18)); a != b {
+			t.Errorf("expected the whitespace of the expressions not to matter, got %s and %s", a, b)
+		}
+	})
+
+	t.Run("raw queries", func(t *testing.T) {
+		raw := func(db *gorm.DB) *gorm.DB {
+			return db.Raw("SELECT * FROM users WHERE id = ?", 1).Find(&[]clausesKeyUser{})
+		}
+		sqlDummy, sqlDummyDB := newCaches(tests.DummyDialector{}, nil)
+		if act, expected := identifier(dummy, dummyDB, raw), identifier(sqlDummy, sqlDummyDB, raw); act != expected {
+			t.Errorf("expected the raw queries to be identified by their SQL, got %s rather than %s", act, expected)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package caches
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// Compressor compresses the marshalled queries before they reach the Cacher, see Config.Compressor
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// GzipCompressor is a gzip based Compressor, Level is one of the compress/gzip levels
+// The zero value uses gzip.DefaultCompression, use gzip.HuffmanOnly or gzip.BestSpeed for cheaper compression
+type GzipCompressor struct {
+	Level int
+}
+
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == gzip.NoCompression {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// decompressError marks the failures to decompress a cached value, which are treated as cache misses
+// since they are usually caused by values stored before the compression was enabled
+type decompressError struct {
+	err error
+}
+
+func (e *decompressError) Error() string {
+	return "caches: failed to decompress the cached value: " + e.err.Error()
+}
+
+func (e *decompressError) Unwrap() error {
+	return e.err
+}
+
+func isDecompressError(err error) bool {
+	var target *decompressError
+	return errors.As(err, &target)
+}
+
+// queryCodec is attached by the plugin to the queries it hands to the Cacher,
+// so that Query.Marshal and Query.Unmarshal apply the configured serialization and compression transparently
+type queryCodec struct {
+	serializer Serializer
+	compressor Compressor
+	// onCompress is optional, it receives the marshalled size along with the compressed one
+	onCompress func(original, compressed int)
+	// onMarshal is optional, it receives the size of the payloads produced by Query.Marshal
+	onMarshal func(size int)
+}
+
+func (c *queryCodec) marshalled(data []byte) {
+	if c != nil && c.onMarshal != nil {
+		c.onMarshal(len(data))
+	}
+}
+
+func (c *queryCodec) serializerOf() Serializer {
+	if c == nil {
+		return nil
+	}
+	return c.serializer
+}
+
+func (c *queryCodec) compress(data []byte) ([]byte, error) {
+	if c == nil || c.compressor == nil {
+		return data, nil
+	}
+
+	res, err := c.compressor.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.onCompress != nil {
+		c.onCompress(len(data), len(res))
+	}
+	return res, nil
+}
+
+func (c *queryCodec) decompress(data []byte) ([]byte, error) {
+	if c == nil || c.compressor == nil {
+		return data, nil
+	}
+
+	res, err := c.compressor.Decompress(data)
+	if err != nil {
+		return nil, &decompressError{err: err}
+	}
+	return res, nil
+}
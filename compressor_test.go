@@ -0,0 +1,120 @@
+package caches
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestGzipCompressor(t *testing.T) {
+	data := []byte(strings.Repeat(`{"Result":"cached"}`, 100))
+
+	t.Run("round trip", func(t *testing.T) {
+		compressor := GzipCompressor{}
+		compressed, err := compressor.Compress(data)
+		if err != nil {
+			t.Fatalf("Compress resulted into an unexpected error, %v", err)
+		}
+		if len(compressed) >= len(data) {
+			t.Errorf("expected repetitive data to shrink, got %d bytes out of %d", len(compressed), len(data))
+		}
+
+		decompressed, err := compressor.Decompress(compressed)
+		if err != nil {
+			t.Fatalf("Decompress resulted into an unexpected error, %v", err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Error("expected Decompress to return the original data")
+		}
+	})
+
+	t.Run("invalid level", func(t *testing.T) {
+		if _, err := (GzipCompressor{Level: 42}).Compress(data); err == nil {
+			t.Error("expected Compress to fail with an invalid level")
+		}
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		if _, err := (GzipCompressor{}).Decompress(data); err == nil {
+			t.Error("expected Decompress to fail with data which is not gzipped")
+		}
+	})
+}
+
+func TestCaches_Compressor(t *testing.T) {
+	newCaches := func(cacher Cacher, observer MetricsObserver) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher:     cacher,
+				Compressor: GzipCompressor{},
+				Observer:   observer,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
+				},
+			},
+		}
+	}
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.Table = "users"
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	t.Run("stores compressed values", func(t *testing.T) {
+		observer := &observerMock{}
+		cacher := NewMemoryCacher()
+		caches := newCaches(cacher, observer)
+
+		caches.query(newDB())
+		db := newDB()
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", db.Error)
+		}
+		if act := db.Statement.Dest.(*mockDest).Result; act != "demo-query" {
+			t.Errorf("expected the compressed value to be served, got `%s`", act)
+		}
+		if act := caches.Stats(); act.Hits != 1 {
+			t.Errorf("expected the second query to be a hit, got %+v", act)
+		}
+
+		raw, _ := cacher.shard(buildIdentifier(newDB(), "", nil)).get(buildIdentifier(newDB(), "", nil))
+		if _, err := (GzipCompressor{}).Decompress(raw); err != nil {
+			t.Errorf("expected the value to be stored gzipped, %v", err)
+		}
+
+		expected := []string{"miss:users", "compress:users:true", "store:users:0", "hit:users"}
+		if !reflect.DeepEqual(observer.events, expected) {
+			t.Errorf("expected the observer events to be %v, got %v", expected, observer.events)
+		}
+	})
+
+	t.Run("decompression failure is a miss", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		// Stored without the compressor, as values cached before the compression was enabled
+		raw := &Query[any]{Dest: &mockDest{Result: "uncompressed"}}
+		_ = cacher.Store(context.Background(), buildIdentifier(newDB(), "", nil), raw, 0)
+
+		caches := newCaches(cacher, nil)
+		db := newDB()
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("expected the decompression failure not to fail the query, got %v", db.Error)
+		}
+		if act := db.Statement.Dest.(*mockDest).Result; act != "demo-query" {
+			t.Errorf("expected the query to hit the database, got `%s`", act)
+		}
+		if act := caches.Stats(); act.Misses != 1 || act.Stores != 1 {
+			t.Errorf("expected a miss followed by a store replacing the value, got %+v", act)
+		}
+	})
+}
@@ -0,0 +1,169 @@
+package caches
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// BypassSetting is the statement setting equivalent to Bypass, for use with gorm's session chaining
+//
+//	db.Set(caches.BypassSetting, true).Find(&users)
+const BypassSetting = "caches:bypass"
+
+// DisabledSetting disables the plugin for the queries of a session, like BypassSetting does, e.g. for an admin panel
+// which must always show live data. The settings are inherited by the sessions and chains derived from a session,
+// including the ones of WithContext, so a single session serves a whole subtree of calls
+//
+//	admin := db.Set(caches.DisabledSetting, true).Session(&gorm.Session{})
+//	admin.Where("active = ?", true).Find(&users)
+//
+// The mutations of such a session still invalidate the cache, since the other sessions would be served stale values otherwise
+const DisabledSetting = "caches:disabled"
+
+// RefreshSetting is the statement setting equivalent to Refresh, for use with gorm's session chaining
+//
+//	db.Set(caches.RefreshSetting, true).Find(&users)
+const RefreshSetting = "caches:refresh"
+
+// SkipInvalidateSetting is the statement setting equivalent to SkipInvalidate, for use with gorm's session chaining
+//
+//	db.Set(caches.SkipInvalidateSetting, true).Model(&user).Update("last_seen", time.Now())
+const SkipInvalidateSetting = "caches:skip_invalidate"
+
+// KeySetting is the statement setting equivalent to WithKey, for use with gorm's session chaining
+//
+//	db.Set(caches.KeySetting, "active-users").Where("active = ?", true).Find(&users)
+const KeySetting = "caches:key"
+
+// ServedFromSetting is the statement setting telling where the result of a query was served from, either
+// ServedFromCache or ServedFromDatabase, it is only set when Config.ExposeMetadata is
+//
+//	tx := db.Find(&users)
+//	source, _ := tx.Get(caches.ServedFromSetting)
+const ServedFromSetting = "caches:served_from"
+
+// AgeSetting is the statement setting holding the time.Duration since the value served from the cache was stored,
+// it is only set along with ServedFromSetting on the cache hits
+const AgeSetting = "caches:age"
+
+const (
+	ServedFromCache    = "cache"
+	ServedFromDatabase = "db"
+)
+
+type contextKey int
+
+const (
+	bypassContextKey contextKey = iota
+	refreshContextKey
+	warmContextKey
+	batchContextKey
+	identifyContextKey
+	skipInvalidateContextKey
+	writeBackFlushContextKey
+	keyContextKey
+)
+
+// Bypass returns a context making the queries run with it skip the plugin entirely,
+// neither the Cacher nor the easer are used so the queries always hit the database
+//
+//	db.WithContext(caches.Bypass(ctx)).Find(&users)
+func Bypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassContextKey, true)
+}
+
+// Refresh returns a context making the queries run with it skip the Cacher lookup, while still storing their results,
+// which replaces a possibly stale cached value with a fresh one
+// Unlike Bypass, the easer is still used, but refreshing queries only coalesce with other refreshing queries,
+// since a regular query already running may have started before the data changed
+//
+//	db.WithContext(caches.Refresh(ctx)).Find(&users)
+func Refresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshContextKey, true)
+}
+
+// SkipInvalidate returns a context making the mutations run with it leave the cache untouched, e.g. for the writes
+// no cached query depends on, such as the update of a last seen timestamp. The mutation itself runs as usual, but it
+// neither invalidates the cached values, nor records the tombstones of Config.ReadYourWritesWindow
+//
+//	db.WithContext(caches.SkipInvalidate(ctx)).Model(&user).Update("last_seen", time.Now())
+//
+// It applies to every statement run with the context, so derive it for the single mutation rather than
+// for a whole session, unless all the mutations of that session are meant to skip the invalidation
+func SkipInvalidate(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipInvalidateContextKey, true)
+}
+
+// WithKey returns a context making the queries run with it identified by the key, instead of their SQL and bind
+// variables, e.g. to share a cached value between two differently written queries known to return the same rows,
+// or to keep apart two identical ones. The key is handled as a key of the KeyBuilder would be: it is scoped to the
+// database and to the KeyContextKeys values, digested when HashKeys is set, and prefixed with the KeyPrefix
+//
+//	db.WithContext(caches.WithKey(ctx, "active-users")).Where("active = ?", true).Find(&users)
+//
+// The plugin cannot tell the queries sharing a key apart, so telling them apart is up to the caller: every statement
+// run with the context is identified by the key, including the preloads of the query, so derive it for a single query
+// and namespace the keys, e.g. "reports:active-users".
+func WithKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, keyContextKey, key)
+}
+
+// queryKey returns the key the query is identified by, through its context or its settings, if any
+func queryKey(db *gorm.DB) (string, bool) {
+	if ctx := db.Statement.Context; ctx != nil {
+		if key, _ := ctx.Value(keyContextKey).(string); key != "" {
+			return key, true
+		}
+	}
+	if v, ok := db.Get(KeySetting); ok {
+		if key, _ := v.(string); key != "" {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// isBypassed reports whether the query asked to skip the plugin, through its context or its settings
+func isBypassed(db *gorm.DB) bool {
+	return flagged(db, bypassContextKey, BypassSetting) || setting(db, DisabledSetting)
+}
+
+// isRefreshed reports whether the query asked to refresh its cached value, through its context or its settings
+func isRefreshed(db *gorm.DB) bool {
+	return flagged(db, refreshContextKey, RefreshSetting)
+}
+
+// skipsInvalidation reports whether the mutation asked to leave the cache untouched, through its context or its settings
+func skipsInvalidation(db *gorm.DB) bool {
+	return flagged(db, skipInvalidateContextKey, SkipInvalidateSetting)
+}
+
+// isWarming reports whether the query is run by Warm
+func isWarming(db *gorm.DB) bool {
+	if ctx := db.Statement.Context; ctx != nil {
+		v, _ := ctx.Value(warmContextKey).(bool)
+		return v
+	}
+	return false
+}
+
+func flagged(db *gorm.DB, key contextKey, name string) bool {
+	if ctx := db.Statement.Context; ctx != nil {
+		if v, ok := ctx.Value(key).(bool); ok && v {
+			return true
+		}
+	}
+
+	return setting(db, name)
+}
+
+// setting reports whether the boolean setting is set on the statement
+func setting(db *gorm.DB, name string) bool {
+	v, ok := db.Get(name)
+	if !ok {
+		return false
+	}
+	flag, _ := v.(bool)
+	return flag
+}
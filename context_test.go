@@ -0,0 +1,304 @@
+package caches
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+// newFlagsTestCaches returns a plugin whose query callback writes the number of times it ran in the destination
+func newFlagsTestCaches(incr *int32) *Caches {
+	return &Caches{
+		Conf: &Config{
+			Cacher: &cacherMock{},
+		},
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				atomic.AddInt32(incr, 1)
+				db.Statement.Dest.(*mockDest).Result = fmt.Sprintf("%d", atomic.LoadInt32(incr))
+			},
+		},
+	}
+}
+
+func runFlagsTestQuery(t *testing.T, caches *Caches, prepare func(db *gorm.DB)) string {
+	db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	db.Statement.Dest = &mockDest{}
+	db.Statement.SQL.WriteString("demo-query")
+	if prepare != nil {
+		prepare(db)
+	}
+
+	caches.query(db)
+	if db.Error != nil {
+		t.Fatalf("an unexpected error has occurred, %v", db.Error)
+	}
+	return db.Statement.Dest.(*mockDest).Result
+}
+
+func TestBypass(t *testing.T) {
+	testCases := map[string]func(db *gorm.DB){
+		"context": func(db *gorm.DB) {
+			db.Statement.Context = Bypass(context.Background())
+		},
+		"setting": func(db *gorm.DB) {
+			db.Statement.Settings.Store(BypassSetting, true)
+		},
+	}
+
+	for name, bypass := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var incr int32
+			caches := newFlagsTestCaches(&incr)
+
+			if res := runFlagsTestQuery(t, caches, nil); res != "1" {
+				t.Fatalf("expected the first query to hit the database, got `%s`", res)
+			}
+			if res := runFlagsTestQuery(t, caches, bypass); res != "2" {
+				t.Errorf("expected the bypassed query to hit the database, got `%s`", res)
+			}
+			if res := runFlagsTestQuery(t, caches, nil); res != "1" {
+				t.Errorf("expected the bypassed query not to replace the cached value, got `%s`", res)
+			}
+		})
+	}
+}
+
+func TestWithKey(t *testing.T) {
+	testCases := map[string]func(key string) func(db *gorm.DB){
+		"context": func(key string) func(db *gorm.DB) {
+			return func(db *gorm.DB) {
+				db.Statement.Context = WithKey(context.Background(), key)
+			}
+		},
+		"setting": func(key string) func(db *gorm.DB) {
+			return func(db *gorm.DB) {
+				db.Statement.Settings.Store(KeySetting, key)
+			}
+		},
+	}
+
+	for name, withKey := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var incr int32
+			caches := newFlagsTestCaches(&incr)
+			rewritten := func(key string) func(db *gorm.DB) {
+				return func(db *gorm.DB) {
+					db.Statement.SQL.Reset()
+					db.Statement.SQL.WriteString("rewritten-demo-query")
+					withKey(key)(db)
+				}
+			}
+
+			if res := runFlagsTestQuery(t, caches, withKey("shared")); res != "1" {
+				t.Fatalf("expected the first query to hit the database, got `%s`", res)
+			}
+			if res := runFlagsTestQuery(t, caches, rewritten("shared")); res != "1" {
+				t.Errorf("expected the queries sharing a key to share their cached value, got `%s`", res)
+			}
+			if res := runFlagsTestQuery(t, caches, withKey("distinct")); res != "2" {
+				t.Errorf("expected the identical queries of different keys not to share their cached value, got `%s`", res)
+			}
+			if res := runFlagsTestQuery(t, caches, nil); res != "3" {
+				t.Errorf("expected the query without key not to be served the values of the keys, got `%s`", res)
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	testCases := map[string]func(db *gorm.DB){
+		"context": func(db *gorm.DB) {
+			db.Statement.Context = Refresh(context.Background())
+		},
+		"setting": func(db *gorm.DB) {
+			db.Statement.Settings.Store(RefreshSetting, true)
+		},
+	}
+
+	for name, refresh := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var incr int32
+			caches := newFlagsTestCaches(&incr)
+
+			if res := runFlagsTestQuery(t, caches, nil); res != "1" {
+				t.Fatalf("expected the first query to hit the database, got `%s`", res)
+			}
+			if res := runFlagsTestQuery(t, caches, refresh); res != "2" {
+				t.Errorf("expected the refreshing query to hit the database, got `%s`", res)
+			}
+			if res := runFlagsTestQuery(t, caches, nil); res != "2" {
+				t.Errorf("expected the refreshing query to replace the cached value, got `%s`", res)
+			}
+		})
+	}
+
+	t.Run("easer", func(t *testing.T) {
+		var (
+			incr    int32
+			started = make(chan struct{})
+			release = make(chan struct{})
+		)
+		caches := newFlagsTestCaches(&incr)
+		caches.Conf.Easer = true
+		caches.queue = newEaseQueue()
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			n := atomic.AddInt32(&incr, 1)
+			db.Statement.Dest.(*mockDest).Result = fmt.Sprintf("%d", n)
+			switch n {
+			case 1:
+				close(started)
+				<-release
+			case 2:
+				time.Sleep(300 * time.Millisecond)
+			}
+		}
+
+		results := make([]string, 3)
+		wg := &sync.WaitGroup{}
+		wg.Add(3)
+		go func() {
+			results[0] = runFlagsTestQuery(t, caches, nil) // The regular leader, blocked until released
+			wg.Done()
+		}()
+		<-started
+		go func() {
+			results[1] = runFlagsTestQuery(t, caches, func(db *gorm.DB) {
+				db.Statement.Context = Refresh(context.Background())
+			})
+			wg.Done()
+		}()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			results[2] = runFlagsTestQuery(t, caches, func(db *gorm.DB) {
+				db.Statement.Context = Refresh(context.Background())
+			})
+			wg.Done()
+		}()
+		time.Sleep(300 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		if results[0] != "1" {
+			t.Errorf("expected the regular query to get its own result, got `%s`", results[0])
+		}
+		if results[1] == "1" || results[1] != results[2] {
+			t.Errorf("expected the refreshing queries to coalesce together only, got `%s` and `%s`", results[1], results[2])
+		}
+		if act := atomic.LoadInt32(&incr); act != 2 {
+			t.Errorf("expected the database to be hit %d times, got %d", 2, act)
+		}
+	})
+}
+
+func TestDisabledSetting(t *testing.T) {
+	var executed int
+	cacher := &cacherMock{}
+	caches := &Caches{Conf: &Config{Cacher: cacher}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+		*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: fmt.Sprint(executed)}}
+		db.Statement.RowsAffected = 1
+	}
+	query := func(db *gorm.DB) string {
+		var rows []mockDest
+		db.Table("users").Where("id = ?", 1).Find(&rows)
+		return rows[0].Result
+	}
+
+	query(db)
+	admin := db.Set(DisabledSetting, true).Session(&gorm.Session{})
+
+	testCases := map[string]func() *gorm.DB{
+		"session": func() *gorm.DB {
+			return admin
+		},
+		"derived session": func() *gorm.DB {
+			return admin.Session(&gorm.Session{})
+		},
+		"context": func() *gorm.DB {
+			return admin.WithContext(context.Background())
+		},
+		"clauses": func() *gorm.DB {
+			return admin.Table("users").Order("id").Limit(10).Offset(0)
+		},
+	}
+	for name, session := range testCases {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 2; i++ {
+				before := executed
+				query(session())
+				if executed != before+1 {
+					t.Error("expected the query of the disabled session to hit the database")
+				}
+			}
+		})
+	}
+
+	if res := query(db); res != "1" {
+		t.Errorf("expected the other sessions to keep being served the cached value, got `%s`", res)
+	}
+
+	admin.Session(&gorm.Session{DryRun: true}).Where("id = ?", 1).Delete(&tablesUserModel{})
+	if len(cacher.invalidatedTables) == 0 {
+		t.Error("expected the mutations of the disabled session to invalidate the cache")
+	}
+}
+
+func TestSkipInvalidate(t *testing.T) {
+	cacher := &cacherMock{}
+	caches := &Caches{Conf: &Config{Cacher: cacher}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	var mutated int
+	caches.callbacks[uponUpdate] = func(db *gorm.DB) {
+		mutated++
+	}
+	update := func(db *gorm.DB) {
+		db.Session(&gorm.Session{DryRun: true}).Table("users").Where("id = ?", 1).Update("last_seen", "now")
+	}
+
+	testCases := map[string]func(db *gorm.DB) *gorm.DB{
+		"context": func(db *gorm.DB) *gorm.DB {
+			return db.WithContext(SkipInvalidate(context.Background()))
+		},
+		"setting": func(db *gorm.DB) *gorm.DB {
+			return db.Set(SkipInvalidateSetting, true)
+		},
+	}
+	for name, skip := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cacher.invalidatedTables, mutated = nil, 0
+			update(skip(db))
+			if mutated != 1 {
+				t.Fatalf("expected the mutation to run, it ran %d times", mutated)
+			}
+			if cacher.invalidatedTables != nil {
+				t.Errorf("expected the mutation not to invalidate the cache, got %v", cacher.invalidatedTables)
+			}
+
+			update(db)
+			if len(cacher.invalidatedTables) == 0 {
+				t.Error("expected the following mutations to invalidate the cache")
+			}
+		})
+	}
+}
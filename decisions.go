@@ -0,0 +1,117 @@
+package caches
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// defaultMaxTableDecisions is the default of Config.MaxTableDecisions
+const defaultMaxTableDecisions = 4096
+
+// decisionCache memoizes the per table decisions, e.g. whether a table may be cached or its ttl, evicting the ones
+// which were not used lately beyond a capacity, so the apps querying many dynamically named tables do not grow it
+// forever. The lookups, which every query runs, take no lock: they only flag the decision as used, and the stores
+// evict with the CLOCK approximation of the LRU, sparing the decisions flagged since the previous sweep.
+// Its zero value is an empty cache, ready to use. The keys are interface values since cacheDecisionKey holds a
+// reflect.Type, which only satisfies comparable from go 1.20.
+type decisionCache[V any] struct {
+	entries sync.Map // of interface{} to *decisionEntry[V]
+
+	mu    sync.Mutex // serializes the stores, it guards clock and hand
+	clock []*decisionEntry[V]
+	hand  int
+}
+
+type decisionEntry[V any] struct {
+	key   interface{}
+	value V
+	index int    // within the clock
+	used  uint32 // accessed atomically, set by the lookups and cleared by the sweeps
+}
+
+// Load returns the decision memoized for the key, flagging it as used
+func (d *decisionCache[V]) Load(key interface{}) (V, bool) {
+	v, ok := d.entries.Load(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	e := v.(*decisionEntry[V])
+	// Only written when cleared, so the lookups of a hot decision do not contend on its cache line
+	if atomic.LoadUint32(&e.used) == 0 {
+		atomic.StoreUint32(&e.used, 1)
+	}
+	return e.value, true
+}
+
+// Store memoizes the decision of the key, evicting the decisions not used lately beyond capacity decisions
+func (d *decisionCache[V]) Store(key interface{}, value V, capacity int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The decisions are replaced rather than updated, since the lookups read them without a lock
+	if v, ok := d.entries.Load(key); ok {
+		old := v.(*decisionEntry[V])
+		e := &decisionEntry[V]{key: key, value: value, index: old.index, used: 1}
+		d.clock[old.index] = e
+		d.entries.Store(key, e)
+		return
+	}
+
+	for len(d.clock) > 0 && len(d.clock) >= capacity {
+		d.evict()
+	}
+	e := &decisionEntry[V]{key: key, value: value, index: len(d.clock)}
+	d.clock = append(d.clock, e)
+	d.entries.Store(key, e)
+}
+
+// evict sweeps the clock from its hand, clearing the flag of the used decisions, until it evicts an unused one
+func (d *decisionCache[V]) evict() {
+	for {
+		e := d.clock[d.hand]
+		if atomic.LoadUint32(&e.used) == 1 {
+			atomic.StoreUint32(&e.used, 0)
+			d.hand = (d.hand + 1) % len(d.clock)
+			continue
+		}
+
+		d.entries.Delete(e.key)
+		// The last decision takes the slot of the evicted one
+		last := len(d.clock) - 1
+		d.clock[d.hand] = d.clock[last]
+		d.clock[d.hand].index = d.hand
+		d.clock[last] = nil
+		d.clock = d.clock[:last]
+		if d.hand >= len(d.clock) {
+			d.hand = 0
+		}
+		return
+	}
+}
+
+// Len returns the number of memoized decisions
+func (d *decisionCache[V]) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.clock)
+}
+
+// Clear forgets all the memoized decisions
+func (d *decisionCache[V]) Clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.clock {
+		d.entries.Delete(e.key)
+	}
+	d.clock = nil
+	d.hand = 0
+}
+
+// maxTableDecisions returns the capacity of the memoized table decisions, see Config.MaxTableDecisions
+func (c *Caches) maxTableDecisions() int {
+	if c.Conf.MaxTableDecisions > 0 {
+		return c.Conf.MaxTableDecisions
+	}
+	return defaultMaxTableDecisions
+}
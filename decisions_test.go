@@ -0,0 +1,126 @@
+package caches
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func Test_decisionCache(t *testing.T) {
+	var d decisionCache[bool]
+	if _, ok := d.Load("users"); ok {
+		t.Fatal("expected the zero value to hold no decision")
+	}
+
+	d.Store("users", true, 2)
+	d.Store("orders", false, 2)
+	if _, ok := d.Load("users"); !ok { // Makes orders the least recently used decision
+		t.Fatal("expected the decision of users to be memoized")
+	}
+	d.Store("items", true, 2)
+
+	if d.Len() != 2 {
+		t.Errorf("expected the decisions to be bounded to 2, got %d", d.Len())
+	}
+	if _, ok := d.Load("orders"); ok {
+		t.Error("expected the least recently used decision to be evicted")
+	}
+	if decision, ok := d.Load("users"); !ok || !decision {
+		t.Error("expected the recently used decision to be kept")
+	}
+
+	d.Store("users", false, 2)
+	if decision, _ := d.Load("users"); decision {
+		t.Error("expected storing a memoized key to overwrite its decision")
+	}
+
+	d.Clear()
+	if _, ok := d.Load("users"); ok || d.Len() != 0 {
+		t.Error("expected Clear to forget all the decisions")
+	}
+}
+
+func Test_decisionCache_concurrency(t *testing.T) {
+	var d decisionCache[int]
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := fmt.Sprint((i + j) % 20)
+				if v, ok := d.Load(key); ok && fmt.Sprint(v) != key {
+					t.Errorf("expected the decision of %s to be its own, got %d", key, v)
+					return
+				}
+				n, _ := strconv.Atoi(key)
+				d.Store(key, n, 10)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if d.Len() != 10 {
+		t.Errorf("expected the decisions to be bounded to 10, got %d", d.Len())
+	}
+	n := 0
+	d.entries.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	if n != d.Len() {
+		t.Errorf("expected the lookups to find the %d decisions of the clock, found %d", d.Len(), n)
+	}
+}
+
+func BenchmarkDecisionCache_Load(b *testing.B) {
+	var d decisionCache[bool]
+	keys := make([]interface{}, 64)
+	for i := range keys {
+		keys[i] = cacheDecisionKey{table: fmt.Sprintf("table_%d", i)}
+		d.Store(keys[i], true, defaultMaxTableDecisions)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, ok := d.Load(keys[i%len(keys)]); !ok {
+				b.Fatal("expected the decision to be memoized")
+			}
+			i++
+		}
+	})
+}
+
+func TestCaches_MaxTableDecisions(t *testing.T) {
+	caches := &Caches{Conf: &Config{
+		CanNotCachedTables: []any{"^audit_"},
+		MaxTableDecisions:  3,
+	}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+
+	for i := 0; i < 10; i++ {
+		stmt := db.Table(fmt.Sprintf("events_%d", i)).Statement
+		caches.canCacheTable(stmt.DB)
+	}
+	if n := caches.cacheDecisions.Len(); n != 3 {
+		t.Fatalf("expected the decisions to be bounded by MaxTableDecisions, got %d", n)
+	}
+	if _, ok := caches.cacheDecisions.Load(cacheDecisionKey{table: "events_0"}); ok {
+		t.Error("expected the oldest decisions to be evicted")
+	}
+
+	caches.Conf.MaxTableDecisions = 0
+	if caches.maxTableDecisions() != defaultMaxTableDecisions {
+		t.Errorf("expected the default capacity to be %d", defaultMaxTableDecisions)
+	}
+}
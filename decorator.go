@@ -0,0 +1,241 @@
+package caches
+
+import (
+	"context"
+	"time"
+)
+
+// WithPrefix returns a Cacher prepending the prefix to the keys it hands to the inner Cacher, so several apps or
+// environments may share a backend without sharing their values. The invalidations are scoped by tables rather than
+// by keys, so they reach the inner Cacher as is: a backend shared this way is invalidated for all of them. The full
+// invalidations have to reach the prefixed keys as well, which the RedisCacher finds through its index sets.
+func WithPrefix(inner Cacher, prefix string) Cacher {
+	return decorate(&prefixCacher{decoratedCacher: decoratedCacher{inner}, prefix: prefix}, inner)
+}
+
+// WithTTLJitter returns a Cacher randomizing the ttl of the values it stores within the fraction around it,
+// as Config.TTLJitter does, e.g. for a Cacher shared by several plugin instances configured without it
+func WithTTLJitter(inner Cacher, fraction float64) Cacher {
+	return decorate(&jitterCacher{decoratedCacher: decoratedCacher{inner}, fraction: fraction}, inner)
+}
+
+// WithMetrics returns a Cacher notifying the observer about every operation of the inner Cacher, along with its
+// latency and error, which tells the health of the backend itself rather than the one of the queries
+func WithMetrics(inner Cacher, observer CacherObserver) Cacher {
+	return decorate(&metricsCacher{decoratedCacher: decoratedCacher{inner}, observer: observer}, inner)
+}
+
+// decorator is the method set of the decorators, they are TagInvalidators as well when the decorated Cacher is one
+type decorator interface {
+	Cacher
+	ExpiryCacher
+	BatchCacher
+	HealthChecker
+	invalidateTags(ctx context.Context, tags []string) error
+}
+
+// decorate returns the decorator, along with the InvalidateTags of the inner Cacher when it supports the tags, so
+// the plugin invalidates the mutations of a Cacher which does not per table, whatever the decorators wrapping it
+func decorate(d decorator, inner Cacher) Cacher {
+	if _, ok := tagInvalidatorOf(inner); ok {
+		return tagDecorator{d}
+	}
+	return d
+}
+
+type tagDecorator struct {
+	decorator
+}
+
+func (c tagDecorator) InvalidateTags(ctx context.Context, tags ...string) error {
+	return c.invalidateTags(ctx, tags)
+}
+
+// decoratedCacher delegates every operation to the inner Cacher, including the ones of the optional BatchCacher,
+// ExpiryCacher and HealthChecker interfaces, which fall back to its single operations when it does not implement them,
+// and the ones of TagInvalidator, which are only exposed when it implements them (see decorate)
+// The decorators embed it and only override the operations they alter, so they stack in any order.
+type decoratedCacher struct {
+	inner Cacher
+}
+
+func (c decoratedCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	return c.inner.Get(ctx, key, q)
+}
+
+func (c decoratedCacher) GetWithExpiry(ctx context.Context, key string, q *Query[any]) (*Query[any], time.Time, error) {
+	return getWithExpiryOf(ctx, c.inner, key, q)
+}
+
+func (c decoratedCacher) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	return c.inner.Store(ctx, key, val, ttl)
+}
+
+func (c decoratedCacher) Invalidate(ctx context.Context, tables ...string) error {
+	return c.inner.Invalidate(ctx, tables...)
+}
+
+func (c decoratedCacher) invalidateTags(ctx context.Context, tags []string) error {
+	return c.inner.(TagInvalidator).InvalidateTags(ctx, tags...)
+}
+
+func (c decoratedCacher) BatchGet(ctx context.Context, keys []string, qs []*Query[any]) ([]*Query[any], error) {
+	return batchGetOf(ctx, c.inner, keys, qs)
+}
+
+func (c decoratedCacher) BatchStore(ctx context.Context, entries []BatchEntry) error {
+	return batchStoreIn(ctx, c.inner, entries)
+}
+
+func (c decoratedCacher) Ping(ctx context.Context) error {
+	return pingOf(ctx, c.inner)
+}
+
+// batchGetOf gets the keys with a single BatchGet when the Cacher is a BatchCacher, and one by one otherwise
+func batchGetOf(ctx context.Context, cacher Cacher, keys []string, qs []*Query[any]) ([]*Query[any], error) {
+	if batcher, ok := cacher.(BatchCacher); ok {
+		return batcher.BatchGet(ctx, keys, qs)
+	}
+
+	res := make([]*Query[any], len(keys))
+	for i, key := range keys {
+		q, err := cacher.Get(ctx, key, qs[i])
+		if err != nil {
+			return nil, err
+		}
+		res[i] = q
+	}
+	return res, nil
+}
+
+// batchStoreIn stores the entries with a single BatchStore when the Cacher is a BatchCacher, and one by one otherwise
+func batchStoreIn(ctx context.Context, cacher Cacher, entries []BatchEntry) error {
+	if batcher, ok := cacher.(BatchCacher); ok {
+		return batcher.BatchStore(ctx, entries)
+	}
+
+	for _, entry := range entries {
+		if err := cacher.Store(ctx, entry.Key, entry.Value, entry.TTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type prefixCacher struct {
+	decoratedCacher
+	prefix string
+}
+
+func (c *prefixCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	return c.inner.Get(ctx, c.prefix+key, q)
+}
+
+func (c *prefixCacher) GetWithExpiry(ctx context.Context, key string, q *Query[any]) (*Query[any], time.Time, error) {
+	return getWithExpiryOf(ctx, c.inner, c.prefix+key, q)
+}
+
+func (c *prefixCacher) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	return c.inner.Store(ctx, c.prefix+key, val, ttl)
+}
+
+func (c *prefixCacher) BatchGet(ctx context.Context, keys []string, qs []*Query[any]) ([]*Query[any], error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+	return batchGetOf(ctx, c.inner, prefixed, qs)
+}
+
+func (c *prefixCacher) BatchStore(ctx context.Context, entries []BatchEntry) error {
+	prefixed := make([]BatchEntry, len(entries))
+	for i, entry := range entries {
+		entry.Key = c.prefix + entry.Key
+		prefixed[i] = entry
+	}
+	return batchStoreIn(ctx, c.inner, prefixed)
+}
+
+type jitterCacher struct {
+	decoratedCacher
+	fraction float64
+}
+
+func (c *jitterCacher) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	return c.inner.Store(ctx, key, val, jitterTTL(ttl, c.fraction))
+}
+
+func (c *jitterCacher) BatchStore(ctx context.Context, entries []BatchEntry) error {
+	jittered := make([]BatchEntry, len(entries))
+	for i, entry := range entries {
+		entry.TTL = jitterTTL(entry.TTL, c.fraction)
+		jittered[i] = entry
+	}
+	return batchStoreIn(ctx, c.inner, jittered)
+}
+
+type metricsCacher struct {
+	decoratedCacher
+	observer CacherObserver
+}
+
+func (c *metricsCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	start := time.Now()
+	res, err := c.inner.Get(ctx, key, q)
+	c.observer.OnCacherCall(CacherCall{
+		Operation: CacherGet, Keys: []string{key}, Hit: res != nil, Latency: time.Since(start), Err: err,
+	})
+	return res, err
+}
+
+func (c *metricsCacher) GetWithExpiry(ctx context.Context, key string, q *Query[any]) (*Query[any], time.Time, error) {
+	start := time.Now()
+	res, expiresAt, err := getWithExpiryOf(ctx, c.inner, key, q)
+	c.observer.OnCacherCall(CacherCall{
+		Operation: CacherGet, Keys: []string{key}, Hit: res != nil, Latency: time.Since(start), Err: err,
+	})
+	return res, expiresAt, err
+}
+
+func (c *metricsCacher) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	start := time.Now()
+	err := c.inner.Store(ctx, key, val, ttl)
+	c.observer.OnCacherCall(CacherCall{Operation: CacherStore, Keys: []string{key}, Latency: time.Since(start), Err: err})
+	return err
+}
+
+func (c *metricsCacher) Invalidate(ctx context.Context, tables ...string) error {
+	start := time.Now()
+	err := c.inner.Invalidate(ctx, tables...)
+	c.observer.OnCacherCall(CacherCall{Operation: CacherInvalidate, Tables: tables, Latency: time.Since(start), Err: err})
+	return err
+}
+
+func (c *metricsCacher) invalidateTags(ctx context.Context, tags []string) error {
+	start := time.Now()
+	err := c.decoratedCacher.invalidateTags(ctx, tags)
+	c.observer.OnCacherCall(CacherCall{Operation: CacherInvalidate, Tags: tags, Latency: time.Since(start), Err: err})
+	return err
+}
+
+func (c *metricsCacher) BatchGet(ctx context.Context, keys []string, qs []*Query[any]) ([]*Query[any], error) {
+	start := time.Now()
+	res, err := batchGetOf(ctx, c.inner, keys, qs)
+	hit := false
+	for _, q := range res {
+		hit = hit || q != nil
+	}
+	c.observer.OnCacherCall(CacherCall{Operation: CacherGet, Keys: keys, Hit: hit, Latency: time.Since(start), Err: err})
+	return res, err
+}
+
+func (c *metricsCacher) BatchStore(ctx context.Context, entries []BatchEntry) error {
+	start := time.Now()
+	err := batchStoreIn(ctx, c.inner, entries)
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	c.observer.OnCacherCall(CacherCall{Operation: CacherStore, Keys: keys, Latency: time.Since(start), Err: err})
+	return err
+}
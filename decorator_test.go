@@ -0,0 +1,238 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type decoratorCtxKey struct{}
+
+// recordingCacher records the ttls and contexts it is handed, batchRecordingCacher makes it a BatchCacher
+type recordingCacher struct {
+	cacherMock
+	ttls    map[string]time.Duration
+	ctxs    []context.Context
+	batches int
+}
+
+func (c *recordingCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	c.ctxs = append(c.ctxs, ctx)
+	return c.cacherMock.Get(ctx, key, q)
+}
+
+func (c *recordingCacher) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	c.ctxs = append(c.ctxs, ctx)
+	if c.ttls == nil {
+		c.ttls = map[string]time.Duration{}
+	}
+	c.ttls[key] = ttl
+	return c.cacherMock.Store(ctx, key, val, ttl)
+}
+
+type batchRecordingCacher struct {
+	recordingCacher
+}
+
+func (c *batchRecordingCacher) BatchGet(ctx context.Context, keys []string, qs []*Query[any]) ([]*Query[any], error) {
+	c.batches++
+	res := make([]*Query[any], len(keys))
+	for i, key := range keys {
+		res[i], _ = c.recordingCacher.Get(ctx, key, qs[i])
+	}
+	return res, nil
+}
+
+func (c *batchRecordingCacher) BatchStore(ctx context.Context, entries []BatchEntry) error {
+	c.batches++
+	for _, entry := range entries {
+		_ = c.recordingCacher.Store(ctx, entry.Key, entry.Value, entry.TTL)
+	}
+	return nil
+}
+
+type cacherObserverMock struct {
+	mu    sync.Mutex
+	calls []CacherCall
+}
+
+func (o *cacherObserverMock) OnCacherCall(call CacherCall) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.calls = append(o.calls, call)
+}
+
+func TestWithPrefix(t *testing.T) {
+	inner := &recordingCacher{}
+	cacher := WithPrefix(inner, "app:")
+	ctx := context.WithValue(context.Background(), decoratorCtxKey{}, "threaded")
+
+	if err := cacher.Store(ctx, "key", &Query[any]{Dest: "value"}, time.Minute); err != nil {
+		t.Fatalf("the store resulted into an unexpected error, %v", err)
+	}
+	if _, ok := inner.ttls["app:key"]; !ok {
+		t.Fatalf("expected the key to be prefixed, got %v", inner.ttls)
+	}
+	if res, _ := cacher.Get(ctx, "key", &Query[any]{}); res == nil || res.Dest != "value" {
+		t.Errorf("expected the prefixed key to be looked up, got %+v", res)
+	}
+	if res, _ := inner.Get(ctx, "key", &Query[any]{}); res != nil {
+		t.Error("expected the unprefixed key to hold no value")
+	}
+	for _, got := range inner.ctxs {
+		if got.Value(decoratorCtxKey{}) != "threaded" {
+			t.Fatal("expected the context to be threaded through to the inner Cacher")
+		}
+	}
+
+	if err := cacher.Invalidate(ctx, "users"); err != nil || len(inner.invalidatedTables) != 1 {
+		t.Errorf("expected the invalidation to reach the inner Cacher, got %v", inner.invalidatedTables)
+	}
+}
+
+func TestDecorators_expiry(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemoryCacher()
+	cacher := WithMetrics(WithPrefix(memory, "app:"), &cacherObserverMock{})
+	_ = cacher.Store(ctx, "key", &Query[any]{Dest: &mockDest{}}, time.Minute)
+
+	res, expiresAt, err := cacher.(ExpiryCacher).GetWithExpiry(ctx, "key", &Query[any]{Dest: &mockDest{}})
+	if err != nil || res == nil || expiresAt.IsZero() {
+		t.Fatalf("expected the expiry of the prefixed key to be reported, got %+v, %s and %v", res, expiresAt, err)
+	}
+
+	_, expiresAt, _ = WithPrefix(&recordingCacher{}, "app:").(ExpiryCacher).GetWithExpiry(ctx, "key", &Query[any]{})
+	if expiresAt != UnknownExpiry {
+		t.Errorf("expected the expiry to be unknown when the inner Cacher is no ExpiryCacher, got %s", expiresAt)
+	}
+}
+
+func TestWithTTLJitter(t *testing.T) {
+	inner := &recordingCacher{}
+	cacher := WithTTLJitter(inner, 0.2)
+
+	for i := 0; i < 20; i++ {
+		key := string(rune('a' + i))
+		_ = cacher.Store(context.Background(), key, &Query[any]{}, time.Minute)
+		if ttl := inner.ttls[key]; ttl < 48*time.Second || ttl > 72*time.Second {
+			t.Fatalf("expected the ttl to be jittered within 20%%, got %s", ttl)
+		}
+	}
+
+	_ = cacher.Store(context.Background(), "forever", &Query[any]{}, 0)
+	if ttl := inner.ttls["forever"]; ttl != 0 {
+		t.Errorf("expected a zero ttl to be kept, got %s", ttl)
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	inner := &recordingCacher{}
+	observer := &cacherObserverMock{}
+	cacher := WithMetrics(inner, observer)
+	ctx := context.Background()
+
+	_, _ = cacher.Get(ctx, "key", &Query[any]{})
+	_ = cacher.Store(ctx, "key", &Query[any]{}, time.Minute)
+	_, _ = cacher.Get(ctx, "key", &Query[any]{})
+	_ = cacher.Invalidate(ctx, "users")
+
+	expected := []CacherCall{
+		{Operation: CacherGet, Keys: []string{"key"}},
+		{Operation: CacherStore, Keys: []string{"key"}},
+		{Operation: CacherGet, Keys: []string{"key"}, Hit: true},
+		{Operation: CacherInvalidate, Tables: []string{"users"}},
+	}
+	if len(observer.calls) != len(expected) {
+		t.Fatalf("expected %d calls to be observed, got %+v", len(expected), observer.calls)
+	}
+	for i, call := range observer.calls {
+		if call.Operation != expected[i].Operation || call.Hit != expected[i].Hit ||
+			len(call.Keys) != len(expected[i].Keys) || len(call.Tables) != len(expected[i].Tables) {
+			t.Errorf("expected the call %d to be %+v, got %+v", i, expected[i], call)
+		}
+	}
+
+	failing := WithMetrics(&failingCacher{}, observer)
+	if _, err := failing.Get(ctx, "key", &Query[any]{}); err == nil {
+		t.Fatal("expected the error of the inner Cacher to be returned")
+	}
+	if last := observer.calls[len(observer.calls)-1]; last.Err == nil {
+		t.Error("expected the error of the inner Cacher to be observed")
+	}
+}
+
+func TestDecorators_stacked(t *testing.T) {
+	inner := &batchRecordingCacher{}
+	observer := &cacherObserverMock{}
+	cacher := WithMetrics(WithTTLJitter(WithPrefix(inner, "app:"), 0.1), observer)
+	ctx := context.Background()
+
+	batcher, ok := cacher.(BatchCacher)
+	if !ok {
+		t.Fatal("expected the decorated Cacher to be a BatchCacher")
+	}
+	err := batcher.BatchStore(ctx, []BatchEntry{
+		{Key: "a", Value: &Query[any]{Dest: "a"}, TTL: time.Minute},
+		{Key: "b", Value: &Query[any]{Dest: "b"}, TTL: time.Minute},
+	})
+	if err != nil {
+		t.Fatalf("the batch store resulted into an unexpected error, %v", err)
+	}
+	if inner.batches != 1 {
+		t.Errorf("expected the batch to reach the inner BatchCacher at once, got %d batches", inner.batches)
+	}
+	for _, key := range []string{"app:a", "app:b"} {
+		if ttl, ok := inner.ttls[key]; !ok || ttl < 54*time.Second || ttl > 66*time.Second {
+			t.Errorf("expected %s to be stored with a jittered ttl, got %v", key, inner.ttls)
+		}
+	}
+
+	res, _ := batcher.BatchGet(ctx, []string{"a", "missing"}, []*Query[any]{{}, {}})
+	if len(res) != 2 || res[0] == nil || res[0].Dest != "a" || res[1] != nil {
+		t.Errorf("expected the batch lookup to go through the decorators, got %+v", res)
+	}
+	if len(observer.calls) != 2 || !observer.calls[1].Hit {
+		t.Errorf("expected the batches to be observed, got %+v", observer.calls)
+	}
+
+	if err := WithPrefix(&recordingCacher{}, "app:").(BatchCacher).BatchStore(ctx, []BatchEntry{{Key: "a", Value: &Query[any]{}}}); err != nil {
+		t.Errorf("expected the batches to fall back to the single operations, got %v", err)
+	}
+}
+
+func TestDecorators_tags(t *testing.T) {
+	if _, ok := WithMetrics(WithPrefix(&recordingCacher{}, "app:"), &cacherObserverMock{}).(TagInvalidator); ok {
+		t.Error("expected the decorators of a Cacher without tags not to be a TagInvalidator")
+	}
+
+	memory := NewMemoryCacher()
+	observer := &cacherObserverMock{}
+	cacher := WithMetrics(WithTTLJitter(WithPrefix(memory, "app:"), 0.1), observer)
+	ctx := context.Background()
+	if err := memory.Store(ctx, "app:orders", &Query[any]{Dest: "orders", Tags: []string{"orders:1"}}, time.Minute); err != nil {
+		t.Fatalf("the store resulted into an unexpected error, %v", err)
+	}
+	invalidator, ok := cacher.(TagInvalidator)
+	if !ok {
+		t.Fatal("expected the decorators of a TagInvalidator to be a TagInvalidator")
+	}
+	if err := invalidator.InvalidateTags(ctx, "orders:1"); err != nil {
+		t.Fatalf("the tag invalidation resulted into an unexpected error, %v", err)
+	}
+	if res, _ := memory.Get(ctx, "app:orders", &Query[any]{}); res != nil {
+		t.Error("expected the tag invalidation to reach the inner Cacher")
+	}
+	if len(observer.calls) != 1 || observer.calls[0].Operation != CacherInvalidate || len(observer.calls[0].Tags) != 1 {
+		t.Errorf("expected the tag invalidation to be observed, got %+v", observer.calls)
+	}
+}
+
+type failingCacher struct {
+	cacherMock
+}
+
+func (c *failingCacher) Get(context.Context, string, *Query[any]) (*Query[any], error) {
+	return nil, errors.New("unreachable")
+}
@@ -1,31 +1,207 @@
 package caches
 
 import (
+	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
-func ease(t task, queue *sync.Map) task {
+const easeQueueShards = 256
+
+// easeQueue holds the tasks run by the easer, keyed by their id, and spread across independently locked shards
+// by the FNV-1a hash of their id, so that the cold queries do not all contend on a single lock
+type easeQueue struct {
+	shards [easeQueueShards]easeQueueShard
+}
+
+type easeQueueShard struct {
+	mu    sync.Mutex
+	tasks map[string]*eased
+}
+
+func newEaseQueue() *easeQueue {
+	q := &easeQueue{}
+	for i := range q.shards {
+		q.shards[i].tasks = make(map[string]*eased)
+	}
+	return q
+}
+
+func (q *easeQueue) shard(id string) *easeQueueShard {
+	// FNV-1a, inlined so that hashing the id does not allocate
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return &q.shards[h%easeQueueShards]
+}
+
+// loadOrStore returns the task running under the id if any, storing eq under it otherwise
+func (q *easeQueue) loadOrStore(id string, eq *eased) (*eased, bool) {
+	s := q.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if running, ok := s.tasks[id]; ok {
+		return running, true
+	}
+	s.tasks[id] = eq
+	return eq, false
+}
+
+// delete removes eq from the queue, unless another task took its id meanwhile, see clear
+func (q *easeQueue) delete(id string, eq *eased) {
+	s := q.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tasks[id] == eq {
+		delete(s.tasks, id)
+	}
+}
+
+// rangeTasks calls fn for every running task, each shard being locked while its tasks are visited
+func (q *easeQueue) rangeTasks(fn func(id string, eq *eased)) {
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		for id, eq := range s.tasks {
+			fn(id, eq)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// clear removes every task from the queue, the running tasks carry on for their waiters
+func (q *easeQueue) clear() {
+	for i := range q.shards {
+		s := &q.shards[i]
+		s.mu.Lock()
+		s.tasks = make(map[string]*eased)
+		s.mu.Unlock()
+	}
+}
+
+// ease runs the task unless a task with the same id is already running, in which case it waits for it
+// and returns it instead. inFlight is optional, it tracks the number of distinct ids in the queue
+//
+// A waiting task gives up once done is closed, e.g. the Done channel of its context, in which case nil is returned
+// while the running task carries on for its other waiters. A nil done channel waits for the running task.
+// A waiting task is also handed the number of tasks which waited for the running one, itself included.
+// Once maxWaiters tasks wait for the running one, the later ones run on their own instead and are returned as is,
+// which bounds the tasks failing along with a failing one. A zero maxWaiters does not bound them.
+func ease(t task, queue *easeQueue, inFlight *int64, done <-chan struct{}, maxWaiters int) (task, int) {
 	eq := &eased{
-		task: t,
-		wg:   &sync.WaitGroup{},
+		task:     t,
+		finished: make(chan struct{}),
 	}
-	eq.wg.Add(1)
-	defer eq.wg.Done()
+	defer close(eq.finished)
 
-	runner, ok := queue.LoadOrStore(t.GetId(), eq)
+	et, ok := queue.loadOrStore(t.GetId(), eq)
 	if ok {
-		et := runner.(*eased)
-		et.wg.Wait()
+		if waiters := atomic.AddInt64(&et.waiters, 1); maxWaiters > 0 && waiters > int64(maxWaiters) {
+			atomic.AddInt64(&et.waiters, -1)
+			t.Run()
+			return t, 0
+		}
+		atomic.AddInt64(&et.joined, 1)
+		defer atomic.AddInt64(&et.waiters, -1)
 
-		return et.task
+		select {
+		case <-et.finished:
+			// No task joins once it finished, since it is removed from the queue beforehand
+			return et.task, int(atomic.LoadInt64(&et.joined))
+		case <-done:
+			return nil, 0
+		}
 	}
 
+	if inFlight != nil {
+		atomic.AddInt64(inFlight, 1)
+	}
+	// Deferred, so a panicking task does not leave its id in the queue, which would hand its result to every later task
+	defer func() {
+		queue.delete(t.GetId(), eq)
+		if inFlight != nil {
+			atomic.AddInt64(inFlight, -1)
+		}
+	}()
 	eq.task.Run()
-	queue.Delete(t.GetId())
-	return eq.task
+	return eq.task, 0
+}
+
+// easeAsync runs the task in the background unless a task with the same id is already running,
+// in which case it does not wait for it. It reports whether the task was started
+func easeAsync(t task, queue *easeQueue) bool {
+	eq := &eased{
+		task:     t,
+		finished: make(chan struct{}),
+	}
+
+	if _, ok := queue.loadOrStore(t.GetId(), eq); ok {
+		return false
+	}
+
+	go func() {
+		defer close(eq.finished)
+		eq.task.Run()
+		queue.delete(t.GetId(), eq)
+	}()
+	return true
 }
 
 type eased struct {
-	task task
-	wg   *sync.WaitGroup
+	waiters  int64 // Kept first so it is 64-bit aligned for atomic access on 32-bit platforms
+	joined   int64 // The number of tasks which waited for it, including the ones which gave up or are done waiting
+	task     task
+	finished chan struct{} // Closed once the task ran
+}
+
+// defaultEaseQueue holds the functions run by Ease, it is distinct from the queues of the plugin instances
+var defaultEaseQueue = newEaseQueue()
+
+// Ease runs fn unless a call with the same key is already running, in which case it waits for it and returns
+// its result instead, with the same logic the plugin coalesces the identical queries with, e.g. to coalesce
+// the calls of an expensive function which does not go through gorm
+//
+// The keys are shared by all the callers of Ease within the process, so they are best namespaced, e.g. `report:42`.
+// The waiters are handed the very value fn returned, which they must not mutate when it holds pointers, slices or maps.
+// A panic of fn is propagated to the call which ran it, while its waiters return an error. A call waiting for one
+// of another T, which the same key does not make identical, runs its own fn once that one is done.
+func Ease[T any](key string, fn func() (T, error)) (T, error) {
+	t := &funcTask[T]{id: key, fn: fn}
+	leader, _ := ease(t, defaultEaseQueue, nil, nil, 0)
+	res, ok := leader.(*funcTask[T])
+	if !ok {
+		t.Run()
+		res = t
+	}
+	if res == t && res.panicked != nil {
+		panic(res.panicked)
+	}
+	return res.res, res.err
+}
+
+// funcTask is the task of a function run by Ease
+type funcTask[T any] struct {
+	id       string
+	fn       func() (T, error)
+	res      T
+	err      error
+	panicked interface{}
+}
+
+func (f *funcTask[T]) GetId() string {
+	return f.id
+}
+
+func (f *funcTask[T]) Run() {
+	defer func() {
+		if r := recover(); r != nil {
+			f.panicked = r
+			f.err = fmt.Errorf("caches: the eased function panicked: %v", r)
+		}
+	}()
+	f.res, f.err = f.fn()
 }
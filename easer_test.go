@@ -1,14 +1,18 @@
 package caches
 
 import (
+	"errors"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestEase(t *testing.T) {
 	t.Run("same queries", func(t *testing.T) {
-		queue := &sync.Map{}
+		queue := newEaseQueue()
 
 		myTask := &mockTask{
 			delay:  1 * time.Second,
@@ -31,12 +35,17 @@ func TestEase(t *testing.T) {
 
 		// Both queries will run at the same time, the second one will run half a second later
 		go func() {
-			myTaskRes = ease(myTask, queue).(*mockTask)
+			res, _ := ease(myTask, queue, nil, nil, 0)
+			myTaskRes = res.(*mockTask)
 			wg.Done()
 		}()
 		go func() {
 			time.Sleep(500 * time.Millisecond)
-			myDupTaskRes = ease(myDupTask, queue).(*mockTask)
+			res, waiters := ease(myDupTask, queue, nil, nil, 0)
+			myDupTaskRes = res.(*mockTask)
+			if waiters != 1 {
+				t.Errorf("expected the coalesced task to be handed a single waiter, got %d", waiters)
+			}
 			wg.Done()
 		}()
 		wg.Wait()
@@ -52,7 +61,7 @@ func TestEase(t *testing.T) {
 	})
 
 	t.Run("different queries", func(t *testing.T) {
-		queue := &sync.Map{}
+		queue := newEaseQueue()
 
 		myTask := &mockTask{
 			delay:  1 * time.Second,
@@ -75,12 +84,14 @@ func TestEase(t *testing.T) {
 
 		// Both queries will run at the same time, the second one will run half a second later
 		go func() {
-			myTaskRes = ease(myTask, queue).(*mockTask)
+			res, _ := ease(myTask, queue, nil, nil, 0)
+			myTaskRes = res.(*mockTask)
 			wg.Done()
 		}()
 		go func() {
 			time.Sleep(500 * time.Millisecond)
-			myDupTaskRes = ease(myDupTask, queue).(*mockTask)
+			res, _ := ease(myDupTask, queue, nil, nil, 0)
+			myDupTaskRes = res.(*mockTask)
 			wg.Done()
 		}()
 		wg.Wait()
@@ -99,4 +110,325 @@ func TestEase(t *testing.T) {
 			t.Error("expected second query to be executed")
 		}
 	})
+	t.Run("cancelled waiter", func(t *testing.T) {
+		queue := newEaseQueue()
+
+		myTask := &mockTask{
+			delay:  500 * time.Millisecond,
+			expRes: "expect-this",
+			id:     "unique-id",
+		}
+		myDupTask := &mockTask{
+			delay:  500 * time.Millisecond,
+			expRes: "not-this",
+			id:     "unique-id",
+		}
+
+		var myTaskRes *mockTask
+		finished := make(chan struct{})
+		go func() {
+			res, _ := ease(myTask, queue, nil, nil, 0)
+			myTaskRes = res.(*mockTask)
+			close(finished)
+		}()
+		time.Sleep(100 * time.Millisecond)
+
+		done := make(chan struct{})
+		time.AfterFunc(100*time.Millisecond, func() { close(done) })
+		start := time.Now()
+		if res, _ := ease(myDupTask, queue, nil, done, 0); res != nil {
+			t.Errorf("expected the cancelled waiter to give up, got %+v", res)
+		}
+		if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+			t.Errorf("expected the cancelled waiter to return promptly, it returned after %s", elapsed)
+		}
+		if myDupTask.actRes != "" {
+			t.Error("expected the cancelled waiter not to run its task")
+		}
+
+		<-finished
+		if myTaskRes.actRes != myTaskRes.expRes {
+			t.Error("expected the running task to complete regardless of the cancelled waiter")
+		}
+	})
+
+	t.Run("max waiters", func(t *testing.T) {
+		queue := newEaseQueue()
+		leader := &mockTask{delay: 500 * time.Millisecond, expRes: "leader", id: "unique-id"}
+		go ease(leader, queue, nil, nil, 2)
+		time.Sleep(50 * time.Millisecond)
+
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if res, _ := ease(&mockTask{expRes: "waiter", id: "unique-id"}, queue, nil, nil, 2); res != leader {
+					t.Error("expected the waiters within the bound to be handed the running task")
+				}
+			}()
+		}
+		deadline := time.Now().Add(time.Second)
+		for waitersOf(queue, "unique-id") < 2 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		overflow := &mockTask{expRes: "overflow", id: "unique-id"}
+		start := time.Now()
+		if res, waiters := ease(overflow, queue, nil, nil, 2); res != overflow || waiters != 0 || overflow.actRes != "overflow" {
+			t.Errorf("expected the task exceeding the bound to run on its own, got %+v and %d waiters", res, waiters)
+		}
+		if elapsed := time.Since(start); elapsed > 300*time.Millisecond {
+			t.Errorf("expected the task exceeding the bound not to wait, it returned after %s", elapsed)
+		}
+		if act := waitersOf(queue, "unique-id"); act != 2 {
+			t.Errorf("expected the task exceeding the bound not to count as a waiter, got %d", act)
+		}
+		wg.Wait()
+	})
+
+	t.Run("panicking task", func(t *testing.T) {
+		queue := newEaseQueue()
+		var inFlight int64
+		func() {
+			defer func() {
+				_ = recover()
+			}()
+			ease(panickingTask{}, queue, &inFlight, nil, 0)
+		}()
+
+		next := &mockTask{expRes: "ran", id: panickingTask{}.GetId()}
+		if res, _ := ease(next, queue, &inFlight, nil, 0); res != next || next.actRes != "ran" {
+			t.Error("expected the id of the panicking task to be released")
+		}
+		if act := atomic.LoadInt64(&inFlight); act != 0 {
+			t.Errorf("expected no task to be left in flight, got %d", act)
+		}
+	})
+}
+
+type panickingTask struct{}
+
+func (panickingTask) GetId() string {
+	return "panicking-id"
+}
+
+func (panickingTask) Run() {
+	panic("boom")
+}
+
+func TestEase_standalone(t *testing.T) {
+	// started runs a call of Ease in the background, once the previous call of the key is running
+	started := func(key string, fn func() (string, error)) (<-chan string, <-chan error) {
+		results, errs := make(chan string, 1), make(chan error, 1)
+		go func() {
+			res, err := Ease(key, fn)
+			results <- res
+			errs <- err
+		}()
+		return results, errs
+	}
+	waitFor := func(key string, waiters int64) {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			s := defaultEaseQueue.shard(key)
+			s.mu.Lock()
+			eq, ok := s.tasks[key]
+			s.mu.Unlock()
+			if ok && atomic.LoadInt64(&eq.waiters) >= waiters {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("expected %d calls to wait for the key %s", waiters, key)
+	}
+
+	t.Run("identical calls are coalesced", func(t *testing.T) {
+		var executed int64
+		release := make(chan struct{})
+		fn := func() (string, error) {
+			atomic.AddInt64(&executed, 1)
+			<-release
+			return "computed", nil
+		}
+
+		first, _ := started("standalone:coalesced", fn)
+		waitFor("standalone:coalesced", 0)
+		second, secondErr := started("standalone:coalesced", fn)
+		waitFor("standalone:coalesced", 1)
+		close(release)
+
+		if res := <-first; res != "computed" {
+			t.Errorf("expected the call to return the result of fn, got %q", res)
+		}
+		if res, err := <-second, <-secondErr; res != "computed" || err != nil {
+			t.Errorf("expected the waiting call to be handed the same result, got %q and %v", res, err)
+		}
+		if act := atomic.LoadInt64(&executed); act != 1 {
+			t.Errorf("expected fn to run once, it ran %d times", act)
+		}
+
+		if res, _ := Ease("standalone:coalesced", func() (string, error) { return "again", nil }); res != "again" {
+			t.Errorf("expected a later call to run fn again, got %q", res)
+		}
+	})
+
+	t.Run("errors are shared", func(t *testing.T) {
+		failure := errors.New("unavailable")
+		release := make(chan struct{})
+		fn := func() (int, error) {
+			<-release
+			return 0, failure
+		}
+
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				_, err := Ease("standalone:errors", fn)
+				errs <- err
+			}()
+			waitFor("standalone:errors", int64(i))
+		}
+		close(release)
+		for i := 0; i < 2; i++ {
+			if err := <-errs; !errors.Is(err, failure) {
+				t.Errorf("expected the error of fn to be returned to every call, got %v", err)
+			}
+		}
+	})
+
+	t.Run("distinct keys", func(t *testing.T) {
+		first, _ := Ease("standalone:first", func() (string, error) { return "first", nil })
+		second, _ := Ease("standalone:second", func() (string, error) { return "second", nil })
+		if first != "first" || second != "second" {
+			t.Errorf("expected the calls of distinct keys to run their own fn, got %q and %q", first, second)
+		}
+	})
+
+	t.Run("distinct types", func(t *testing.T) {
+		release := make(chan struct{})
+		first, _ := started("standalone:types", func() (string, error) {
+			<-release
+			return "first", nil
+		})
+		waitFor("standalone:types", 0)
+		second := make(chan int, 1)
+		go func() {
+			res, _ := Ease("standalone:types", func() (int, error) { return 2, nil })
+			second <- res
+		}()
+		waitFor("standalone:types", 1)
+		close(release)
+
+		if res := <-first; res != "first" {
+			t.Errorf("expected the call to return the result of its fn, got %q", res)
+		}
+		if res := <-second; res != 2 {
+			t.Errorf("expected the call of another type to run its own fn, got %d", res)
+		}
+	})
+
+	t.Run("panics", func(t *testing.T) {
+		release := make(chan struct{})
+		recovered := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				recovered <- recover()
+			}()
+			_, _ = Ease("standalone:panics", func() (string, error) {
+				<-release
+				panic("boom")
+			})
+		}()
+		waitFor("standalone:panics", 0)
+		_, waiterErr := started("standalone:panics", func() (string, error) { return "unused", nil })
+		waitFor("standalone:panics", 1)
+		close(release)
+
+		if r := <-recovered; r != "boom" {
+			t.Errorf("expected the panic to be propagated to the call which ran fn, got %v", r)
+		}
+		if err := <-waiterErr; err == nil || !strings.Contains(err.Error(), "boom") {
+			t.Errorf("expected the waiting call to return an error, got %v", err)
+		}
+		if res, err := Ease("standalone:panics", func() (string, error) { return "recovered", nil }); res != "recovered" || err != nil {
+			t.Errorf("expected the key to be released after the panic, got %q and %v", res, err)
+		}
+	})
+}
+
+func TestEaseQueue(t *testing.T) {
+	queue := newEaseQueue()
+	first, second := &eased{}, &eased{}
+
+	if act, ok := queue.loadOrStore("id", first); ok || act != first {
+		t.Fatal("expected the first task to be stored")
+	}
+	if act, ok := queue.loadOrStore("id", second); !ok || act != first {
+		t.Fatal("expected the running task to be returned")
+	}
+
+	// A task cleared from the queue must not remove the one which took its id afterwards
+	queue.clear()
+	if _, ok := queue.loadOrStore("id", second); ok {
+		t.Fatal("expected the cleared queue to be empty")
+	}
+	queue.delete("id", first)
+	if act, ok := queue.loadOrStore("id", first); !ok || act != second {
+		t.Error("expected the task which took the id to be kept")
+	}
+
+	queue.delete("id", second)
+	var ids []string
+	queue.rangeTasks(func(id string, _ *eased) {
+		ids = append(ids, id)
+	})
+	if len(ids) != 0 {
+		t.Errorf("expected the deleted task not to be running anymore, got %v", ids)
+	}
+}
+
+// BenchmarkEaseQueue compares the sharded queue of the easer with a single sync.Map, under many goroutines storing
+// and deleting distinct ids as the cold queries do
+func BenchmarkEaseQueue(b *testing.B) {
+	ids := make([]string, 4096)
+	for i := range ids {
+		ids[i] = IdentifierPrefix + strconv.Itoa(i)
+	}
+
+	syncMap, sharded := &sync.Map{}, newEaseQueue()
+	queues := map[string]func(id string, eq *eased){
+		"sync.Map": func(id string, eq *eased) {
+			syncMap.LoadOrStore(id, eq)
+			syncMap.Delete(id)
+		},
+		"sharded": func(id string, eq *eased) {
+			sharded.loadOrStore(id, eq)
+			sharded.delete(id, eq)
+		},
+	}
+
+	for name, run := range queues {
+		b.Run(name, func(b *testing.B) {
+			var next uint64
+			b.SetParallelism(64)
+			b.RunParallel(func(pb *testing.PB) {
+				eq := &eased{}
+				for pb.Next() {
+					run(ids[atomic.AddUint64(&next, 1)%uint64(len(ids))], eq)
+				}
+			})
+		})
+	}
+}
+
+// waitersOf returns the number of tasks waiting for the task running under the id
+func waitersOf(queue *easeQueue, id string) int64 {
+	s := queue.shard(id)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if eq, ok := s.tasks[id]; ok {
+		return atomic.LoadInt64(&eq.waiters)
+	}
+	return 0
 }
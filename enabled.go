@@ -0,0 +1,21 @@
+package caches
+
+import "sync/atomic"
+
+// Disable turns the plugin off at runtime, e.g. as the lever of a stale data incident, until Enable is called
+// The queries then run against the database, skipping both the Cacher and the easer as the bypassed queries do,
+// while the mutations still invalidate the cache unless Config.SkipInvalidateWhenDisabled is set.
+// It is safe to call concurrently with the queries, which only pay an atomic load to check it.
+func (c *Caches) Disable() {
+	atomic.StoreInt32(&c.disabled, 1)
+}
+
+// Enable turns the plugin back on after Disable, the plugins are enabled upon registration
+func (c *Caches) Enable() {
+	atomic.StoreInt32(&c.disabled, 0)
+}
+
+// Enabled reports whether the plugin is enabled, see Disable
+func (c *Caches) Enabled() bool {
+	return atomic.LoadInt32(&c.disabled) == 0
+}
@@ -0,0 +1,104 @@
+package caches
+
+import (
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_Disable(t *testing.T) {
+	newDB := func(t *testing.T, conf *Config) (*gorm.DB, *Caches, *int) {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		conf.Cacher = NewMemoryCacher()
+		caches := &Caches{Conf: conf}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+
+		var executed int
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			executed++
+			db.Statement.RowsAffected = 1
+		}
+		return db, caches, &executed
+	}
+	query := func(db *gorm.DB) {
+		db.Where("id = ?", 1).Find(&[]tablesUserModel{})
+	}
+	update := func(db *gorm.DB) {
+		db.Session(&gorm.Session{DryRun: true}).Model(&tablesUserModel{}).Where("id = ?", 1).Update("name", "ktsivkov")
+	}
+
+	t.Run("queries", func(t *testing.T) {
+		db, caches, executed := newDB(t, &Config{})
+		if !caches.Enabled() {
+			t.Fatal("expected the plugin to be enabled upon registration")
+		}
+
+		caches.Disable()
+		query(db)
+		query(db)
+		if *executed != 2 || caches.Enabled() {
+			t.Errorf("expected the queries to run against the database while disabled, got %d queries", *executed)
+		}
+
+		caches.Enable()
+		query(db)
+		query(db)
+		if *executed != 3 {
+			t.Errorf("expected the queries to be cached once enabled again, got %d queries", *executed)
+		}
+	})
+
+	t.Run("mutations invalidate", func(t *testing.T) {
+		db, caches, executed := newDB(t, &Config{})
+		query(db)
+
+		caches.Disable()
+		update(db)
+		caches.Enable()
+		query(db)
+		if *executed != 2 {
+			t.Errorf("expected the mutation run while disabled to invalidate, got %d queries", *executed)
+		}
+	})
+
+	t.Run("mutations skip invalidation", func(t *testing.T) {
+		db, caches, executed := newDB(t, &Config{SkipInvalidateWhenDisabled: true})
+		query(db)
+
+		caches.Disable()
+		update(db)
+		caches.Enable()
+		query(db)
+		if *executed != 1 {
+			t.Errorf("expected the mutation run while disabled to leave the cache untouched, got %d queries", *executed)
+		}
+	})
+
+	t.Run("concurrent toggles", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{}}
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				caches.Disable()
+				caches.Enable()
+			}()
+			go func() {
+				defer wg.Done()
+				_ = caches.Enabled()
+			}()
+		}
+		wg.Wait()
+		if !caches.Enabled() {
+			t.Error("expected the plugin to be enabled once every Disable was followed by an Enable")
+		}
+	})
+}
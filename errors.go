@@ -0,0 +1,78 @@
+package caches
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrCache is the sentinel of the errors of the cache operations, every CacheOpError matches it with errors.Is
+var ErrCache = errors.New("caches: cache operation failed")
+
+// ErrSkipCache is returned by a Cacher's Store, possibly wrapped, to refuse a value without failing, e.g. one too large
+// for the backend or matching a policy of its own. The plugin counts the result as skipped, in Stats().Skipped, and
+// reports it to a SkipObserver as SkipCacher, the query returning its result as usual whatever the CacheErrorMode.
+var ErrSkipCache = errors.New("caches: the cacher skipped the value")
+
+// errTagsUnsupported is returned by the InvalidateTags of the Cachers wrapping one which is not a TagInvalidator,
+// which the plugin invalidates per table instead
+var errTagsUnsupported = errors.New("caches: the wrapped cacher is not a TagInvalidator")
+
+// CacheOp is the cache operation a CacheOpError is about
+type CacheOp string
+
+const (
+	CacheOpGet        CacheOp = "get"
+	CacheOpStore      CacheOp = "store"
+	CacheOpInvalidate CacheOp = "invalidate"
+)
+
+// CacheOpError is an error of a cache operation, wrapping the error of the Cacher
+//
+// The queries fail with it in the CacheErrorFailFast mode, and the mutations whatever the CacheErrorMode when their
+// invalidation fails, so callers can tell the cache failures from the database ones:
+//
+//	var cacheErr *caches.CacheOpError
+//	if errors.As(db.Error, &cacheErr) { ... }
+//
+// It is reported to the ErrorObserver and to the Logger, as a LogError event, in both modes.
+type CacheOpError struct {
+	Op CacheOp
+	// Key is the identifier of the query, it is empty for invalidations and holds the bind variables of the query
+	// when built by a KeyBuilder without HashKeys, so the message of the error leaves it out
+	Key string
+	// Table is the one of the parsed statement, it may be empty for raw queries
+	Table string
+	Err   error
+}
+
+func (e *CacheOpError) Error() string {
+	var sb strings.Builder
+	sb.WriteString("caches: ")
+	sb.WriteString(string(e.Op))
+	if e.Table != "" {
+		sb.WriteString(" of table ")
+		sb.WriteString(e.Table)
+	}
+	sb.WriteString(" failed: ")
+	if e.Err != nil {
+		sb.WriteString(e.Err.Error())
+	}
+	return sb.String()
+}
+
+func (e *CacheOpError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is ErrCache, the wrapped error is matched by errors.Is through Unwrap
+func (e *CacheOpError) Is(target error) bool {
+	return target == ErrCache
+}
+
+// cacheOpError wraps the error into a CacheOpError, unless it is one already
+func cacheOpError(op CacheOp, key, table string, err error) *CacheOpError {
+	if opErr, ok := err.(*CacheOpError); ok {
+		return opErr
+	}
+	return &CacheOpError{Op: op, Key: key, Table: table, Err: err}
+}
@@ -0,0 +1,172 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+// cacherInvalidateErrorMock fails every invalidation
+type cacherInvalidateErrorMock struct {
+	cacherMock
+}
+
+func (c *cacherInvalidateErrorMock) Invalidate(context.Context, ...string) error {
+	return errors.New("invalidate-error")
+}
+
+func TestCacheOpError(t *testing.T) {
+	newCaches := func(cacher Cacher, mode CacheErrorMode, logger Logger) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher:         cacher,
+				CacheErrorMode: mode,
+				Logger:         logger,
+				LogIdentifiers: true,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery:  func(db *gorm.DB) {},
+				uponUpdate: func(db *gorm.DB) {},
+			},
+		}
+	}
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.Table = "users"
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+	loggedError := func(t *testing.T, logger *loggerMock) *CacheOpError {
+		for _, event := range logger.events {
+			if event.Operation == LogError {
+				var opErr *CacheOpError
+				if !errors.As(event.Err, &opErr) {
+					t.Fatalf("expected the LogError event to carry a CacheOpError, got %v", event.Err)
+				}
+				return opErr
+			}
+		}
+		t.Fatalf("expected a LogError event, got %v", logger.events)
+		return nil
+	}
+
+	t.Run("fail fast", func(t *testing.T) {
+		logger := &loggerMock{}
+		caches := newCaches(&cacherGetErrorMock{}, CacheErrorFailFast, logger)
+		db := newDB()
+		caches.query(db)
+
+		var opErr *CacheOpError
+		if !errors.As(db.Error, &opErr) {
+			t.Fatalf("expected the query to fail with a CacheOpError, got %v", db.Error)
+		}
+		if opErr.Op != CacheOpGet || opErr.Table != "users" || opErr.Key != caches.identifierOf(newDB()) {
+			t.Errorf("expected the get of the users query to fail, got %+v", opErr)
+		}
+		if !errors.Is(db.Error, ErrCache) || errors.Unwrap(opErr).Error() != "get-error" {
+			t.Errorf("expected the error to match ErrCache and to wrap the error of the Cacher, got %v", db.Error)
+		}
+		if act := opErr.Error(); act != "caches: get of table users failed: get-error" {
+			t.Errorf("expected the message to leave the key out, got %q", act)
+		}
+		if loggedError(t, logger) != opErr {
+			t.Error("expected the error the query failed with to be logged")
+		}
+	})
+
+	t.Run("ignore", func(t *testing.T) {
+		logger := &loggerMock{}
+		caches := newCaches(&cacherStoreErrorMock{}, CacheErrorIgnore, logger)
+		db := newDB()
+		caches.query(db)
+
+		if db.Error != nil {
+			t.Fatalf("expected the ignored error not to fail the query, got %v", db.Error)
+		}
+		if opErr := loggedError(t, logger); opErr.Op != CacheOpStore || !errors.Is(opErr, ErrCache) {
+			t.Errorf("expected the store error to be logged, got %+v", opErr)
+		}
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		logger := &loggerMock{}
+		caches := newCaches(&cacherInvalidateErrorMock{}, CacheErrorIgnore, logger)
+		db := newDB()
+		caches.getMutatorCb(uponUpdate)(db)
+
+		var opErr *CacheOpError
+		if !errors.As(db.Error, &opErr) || opErr.Op != CacheOpInvalidate || opErr.Key != "" {
+			t.Fatalf("expected the mutation to fail with the invalidation error whatever the mode, got %v", db.Error)
+		}
+		if loggedError(t, logger) != opErr {
+			t.Error("expected the invalidation error to be logged")
+		}
+	})
+
+	t.Run("database errors", func(t *testing.T) {
+		caches := newCaches(&cacherMock{}, CacheErrorFailFast, nil)
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			_ = db.AddError(errors.New("database-error"))
+		}
+		db := newDB()
+		caches.query(db)
+
+		if db.Error == nil || errors.Is(db.Error, ErrCache) {
+			t.Errorf("expected the database error not to be a cache one, got %v", db.Error)
+		}
+	})
+}
+
+// cacherSkipMock refuses to store the values with ErrSkipCache
+type cacherSkipMock struct {
+	cacherMock
+}
+
+func (c *cacherSkipMock) Store(context.Context, string, *Query[any], time.Duration) error {
+	return fmt.Errorf("%w: the value is too large", ErrSkipCache)
+}
+
+func TestErrSkipCache(t *testing.T) {
+	for _, mode := range []CacheErrorMode{CacheErrorFailFast, CacheErrorIgnore} {
+		t.Run(fmt.Sprint(mode), func(t *testing.T) {
+			observer := &observerMock{}
+			caches := &Caches{
+				Conf: &Config{
+					Cacher:         NewCircuitBreakerCacher(&cacherSkipMock{}, WithCircuitBreakerThreshold(1)),
+					CacheErrorMode: mode,
+					Observer:       observer,
+				},
+				callbacks: map[queryType]func(db *gorm.DB){
+					uponQuery: func(db *gorm.DB) {
+						db.Statement.Dest.(*mockDest).Result = "from-database"
+					},
+				},
+			}
+			db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			db.Statement.Dest = &mockDest{}
+			db.Statement.Table = "users"
+			db.Statement.SQL.WriteString("demo-query")
+			caches.query(db)
+
+			if db.Error != nil || db.Statement.Dest.(*mockDest).Result != "from-database" {
+				t.Fatalf("expected the skip not to fail the query, got %v", db.Error)
+			}
+			if act := caches.Stats(); act.Skipped != 1 || act.StoreErrors != 0 || act.Stores != 0 {
+				t.Errorf("expected the result to be counted as skipped, got %+v", act)
+			}
+			if !reflect.DeepEqual(observer.events, []string{"miss:users", "skip:users:cacher"}) {
+				t.Errorf("expected the skip to be reported to the SkipObserver, got %v", observer.events)
+			}
+			if act := caches.Conf.Cacher.(*CircuitBreakerCacher).State(); act != CircuitClosed {
+				t.Errorf("expected the skip not to count as a failure of the circuit, got %s", act)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package caches
+
+import "context"
+
+// HealthChecker is an optional extension of Cacher, for backends able to tell whether they are reachable
+type HealthChecker interface {
+	// Ping impl should return an error when the backend cannot be reached, e.g. to fail a readiness probe
+	Ping(ctx context.Context) error
+}
+
+// Ping checks the health of the Cacher, it returns nil when the Cacher is not set or is not a HealthChecker
+func (c *Caches) Ping(ctx context.Context) error {
+	if c.Conf == nil || c.Conf.Cacher == nil {
+		return nil
+	}
+	return pingOf(ctx, c.Conf.Cacher)
+}
+
+// pingOf pings the Cacher when it is a HealthChecker, the other ones are assumed to be healthy
+func pingOf(ctx context.Context, cacher Cacher) error {
+	if checker, ok := cacher.(HealthChecker); ok {
+		return checker.Ping(ctx)
+	}
+	return nil
+}
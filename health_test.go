@@ -0,0 +1,65 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// pingableCacherMock is a cacherMock whose Ping returns err, it counts the pings reaching it
+type pingableCacherMock struct {
+	cacherMock
+	err   error
+	pings int
+}
+
+func (c *pingableCacherMock) Ping(context.Context) error {
+	c.pings++
+	return c.err
+}
+
+func TestCaches_Ping(t *testing.T) {
+	ctx := context.Background()
+	unreachable := errors.New("unreachable")
+
+	t.Run("without cacher", func(t *testing.T) {
+		if err := (&Caches{Conf: &Config{}}).Ping(ctx); err != nil {
+			t.Errorf("expected a plugin without Cacher to be healthy, got %v", err)
+		}
+	})
+
+	t.Run("cacher without health check", func(t *testing.T) {
+		if err := (&Caches{Conf: &Config{Cacher: &cacherMock{}}}).Ping(ctx); err != nil {
+			t.Errorf("expected a Cacher which is not a HealthChecker to be healthy, got %v", err)
+		}
+	})
+
+	t.Run("health checker", func(t *testing.T) {
+		cacher := &pingableCacherMock{err: unreachable}
+		caches := &Caches{Conf: &Config{Cacher: cacher}}
+		if err := caches.Ping(ctx); !errors.Is(err, unreachable) {
+			t.Errorf("expected the error of the Cacher to be returned, got %v", err)
+		}
+
+		cacher.err = nil
+		if err := caches.Ping(ctx); err != nil || cacher.pings != 2 {
+			t.Errorf("expected the Cacher to be pinged again, got %v after %d pings", err, cacher.pings)
+		}
+	})
+
+	t.Run("wrapping cachers", func(t *testing.T) {
+		inner := &pingableCacherMock{err: unreachable}
+		for name, cacher := range map[string]Cacher{
+			"prefix":          WithPrefix(inner, "app:"),
+			"jitter":          WithTTLJitter(inner, 0.1),
+			"metrics":         WithMetrics(inner, &cacherObserverMock{}),
+			"circuit breaker": NewCircuitBreakerCacher(inner),
+			"tiered l1":       NewTieredCacher(inner, &cacherMock{}),
+			"tiered l2":       NewTieredCacher(&cacherMock{}, inner),
+		} {
+			if err := (&Caches{Conf: &Config{Cacher: cacher}}).Ping(ctx); !errors.Is(err, unreachable) {
+				t.Errorf("expected the %s cacher to ping the inner one, got %v", name, err)
+			}
+		}
+	})
+}
@@ -1,8 +1,14 @@
 package caches
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 
 	"gorm.io/gorm/callbacks"
@@ -12,15 +18,195 @@ import (
 
 const IdentifierPrefix = "gorm-caches::"
 
-func buildIdentifier(db *gorm.DB) string {
-	// Build query identifier,
-	//	for that reason we need to compile all arguments into a string
-	//	and concat them with the SQL query itself
+// buildIdentifier builds the identifier of the query from its canonical SQL and arguments (see canonicalQuery),
+// digested with the 128-bit FNV-1a hash so that every identifier is IdentifierPrefix followed by 32 hex characters.
+// FNV is not collision resistant against crafted inputs, but the odds of an accidental collision are about 1 in 2^64
+// with 2^32 distinct cached queries, which is negligible in practice.
+//
+// The SQL is the whole statement, its select list included, so two projections of the same rows never share a value.
+// The database, when known, is part of the digest, so the same SQL run against two databases gets two identifiers.
+// So are the bind variables, which gorm keeps in the statement's Vars in PrepareStmt mode as well, the prepared
+// statements only being cached by its ConnPool, so two prepared queries differing by their parameters never collide.
+// The context values, see Config.KeyContextKeys, are digested last, when any of them is set.
+func buildIdentifier(db *gorm.DB, database string, contextValues []string) string {
 	callbacks.BuildQuerySQL(db)
-	query := db.Statement.SQL.String()
-	queryArgs := valueToString(db.Statement.Vars)
-	identifier := fmt.Sprintf("%s%s-%s", IdentifierPrefix, query, queryArgs)
-	return identifier
+	query, args := canonicalQuery(db.Statement.SQL.String(), db.Statement.Vars)
+
+	h := fnv.New128a()
+	if database != "" {
+		// Length prefixed, so that no database name followed by a query digests like another one
+		_, _ = fmt.Fprintf(h, "%d:%s\x00", len(database), database)
+	}
+	_, _ = h.Write([]byte(query))
+	for _, arg := range args {
+		// Length prefixed, so that the boundaries of the arguments are part of the digest
+		_, _ = fmt.Fprintf(h, "\x00%d:%s", len(arg), arg)
+	}
+	if len(contextValues) > 0 {
+		_, _ = h.Write([]byte("\x01"))
+		for _, value := range contextValues {
+			_, _ = fmt.Fprintf(h, "\x00%d:%s", len(value), value)
+		}
+	}
+	return IdentifierPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// keyContextValues returns the formatted values of the KeyContextKeys, along with their type as the arguments are,
+// an unset key standing as an empty string, or nil when none of them is set in the context
+// The values are formatted as the bind variables are, so maps are formatted deterministically, and types implementing
+// fmt.Stringer, e.g. a language tag, are formatted by their String method.
+func (c *Caches) keyContextValues(ctx context.Context) []string {
+	if len(c.Conf.KeyContextKeys) == 0 || ctx == nil {
+		return nil
+	}
+
+	var (
+		values = make([]string, len(c.Conf.KeyContextKeys))
+		set    bool
+	)
+	for i, key := range c.Conf.KeyContextKeys {
+		if v := ctx.Value(key); v != nil {
+			values[i] = fmt.Sprintf("%T(%s)", v, valueToString(v))
+			set = true
+		}
+	}
+	if !set {
+		return nil
+	}
+	return values
+}
+
+// scopeKey appends the context values to a key of the KeyBuilder, length prefixed as the arguments are digested
+func scopeKey(key string, contextValues []string) string {
+	if len(contextValues) == 0 {
+		return key
+	}
+
+	var sb strings.Builder
+	sb.WriteString(key)
+	sb.WriteString(":ctx")
+	for _, value := range contextValues {
+		_, _ = fmt.Fprintf(&sb, ":%d:%s", len(value), value)
+	}
+	return sb.String()
+}
+
+// hashKey digests a key with the 128-bit FNV-1a hash, as buildIdentifier does, see Config.HashKeys
+func hashKey(key string) string {
+	h := fnv.New128a()
+	_, _ = h.Write([]byte(key))
+	return IdentifierPrefix + hex.EncodeToString(h.Sum(nil))
+}
+
+// Identifier returns the key the plugin caches the result of a query under, KeyPrefix, KeyBuilder and database included,
+// e.g. to Store a value for it, or to look it up, from application code
+//
+// The query func receives a session of db and should run a single finisher on it, as the ones of Warm do, e.g.
+//
+//	func(db *gorm.DB) *gorm.DB { return db.Where("active = ?", true).Find(&[]User{}) }
+//
+// The query is only built, it neither reaches the database nor the Cacher. Queries whose finisher does not go
+// through the query callbacks, e.g. Scan or Row, have no identifier, in which case an error is returned.
+func (c *Caches) Identifier(db *gorm.DB, query func(*gorm.DB) *gorm.DB) (string, error) {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	capture := new(string)
+	tx := query(db.Session(&gorm.Session{DryRun: true}).WithContext(context.WithValue(ctx, identifyContextKey, capture)))
+	if tx != nil && tx.Error != nil {
+		return "", tx.Error
+	}
+	if *capture == "" {
+		return "", errors.New("caches: the query did not reach the query callbacks")
+	}
+	return *capture, nil
+}
+
+// identifying returns where to write the identifier of a query run by Identifier, nil for the other queries
+func identifying(db *gorm.DB) *string {
+	if ctx := db.Statement.Context; ctx != nil {
+		capture, _ := ctx.Value(identifyContextKey).(*string)
+		return capture
+	}
+	return nil
+}
+
+var (
+	placeholderPattern = regexp.MustCompile(`^(\?|\$\d+|@p\d+)`)
+	inListPattern      = regexp.MustCompile(`(?i)\bIN ?\(((?:\?|\$\d+|@p\d+)(?: ?, ?(?:\?|\$\d+|@p\d+))*)\)`)
+)
+
+// canonicalQuery returns the forms of the SQL and of its arguments semantically equal queries share:
+//   - runs of whitespace outside of quoted strings and identifiers are collapsed into a single space
+//   - the arguments of the IN lists made only of placeholders are sorted, so `IN (1,2,3)` equals `IN (3,2,1)`
+//
+// Every argument is formatted along with its type, so that `1` and `"1"` are told apart.
+func canonicalQuery(query string, vars []interface{}) (string, []string) {
+	var (
+		sb           strings.Builder
+		placeholders []int // offsets of the placeholders in the canonical SQL
+		quote        byte
+		space        bool
+	)
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			space = true
+			continue
+		default:
+			if m := placeholderPattern.FindString(query[i:]); m != "" {
+				if space && sb.Len() > 0 {
+					sb.WriteByte(' ')
+				}
+				space = false
+				placeholders = append(placeholders, sb.Len())
+				sb.WriteString(m)
+				i += len(m) - 1
+				continue
+			}
+		}
+
+		if space && sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteByte(ch)
+	}
+	canonical := sb.String()
+
+	args := make([]string, len(vars))
+	for i, v := range vars {
+		args[i] = fmt.Sprintf("%T(%s)", v, valueToString(v))
+	}
+
+	// The placeholders can only be mapped onto the arguments when there is one per argument
+	if len(placeholders) != len(vars) {
+		return canonical, args
+	}
+
+	for _, m := range inListPattern.FindAllStringSubmatchIndex(canonical, -1) {
+		first := sort.SearchInts(placeholders, m[2])
+		if first == len(placeholders) || placeholders[first] != m[2] {
+			continue // The list is within a quoted string
+		}
+
+		last := first
+		for last < len(placeholders) && placeholders[last] < m[3] {
+			last++
+		}
+		sort.Strings(args[first:last])
+	}
+
+	return canonical, args
 }
 
 func valueToString(value interface{}) string {
@@ -32,16 +218,13 @@ func valueToString(value interface{}) string {
 		}
 		return valueToString(valueOf.Elem().Interface())
 	case reflect.Map:
-		var sb strings.Builder
-		sb.WriteString("{")
-		for i, key := range valueOf.MapKeys() {
-			if i > 0 {
-				sb.WriteString(", ")
-			}
-			sb.WriteString(fmt.Sprintf("%s: %s", valueToString(key.Interface()), valueToString(valueOf.MapIndex(key).Interface())))
+		entries := make([]string, 0, valueOf.Len())
+		for _, key := range valueOf.MapKeys() {
+			entries = append(entries, fmt.Sprintf("%s: %s", valueToString(key.Interface()), valueToString(valueOf.MapIndex(key).Interface())))
 		}
-		sb.WriteString("}")
-		return sb.String()
+		// Map iteration order is random, while identical maps must produce identical strings
+		sort.Strings(entries)
+		return "{" + strings.Join(entries, ", ") + "}"
 	case reflect.Slice:
 		valueSlice := make([]interface{}, valueOf.Len())
 		for i := range valueSlice {
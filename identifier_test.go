@@ -1,22 +1,150 @@
 package caches
 
 import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
 )
 
 func Test_buildIdentifier(t *testing.T) {
-	db := &gorm.DB{}
-	db.Statement = &gorm.Statement{}
-	db.Statement.SQL.WriteString("TEST-SQL")
-	db.Statement.Vars = append(db.Statement.Vars, "test", 123, 12.3, true, false, []string{"test", "me"})
+	newDB := func(sql string, vars ...interface{}) *gorm.DB {
+		db := &gorm.DB{}
+		db.Statement = &gorm.Statement{}
+		db.Statement.SQL.WriteString(sql)
+		db.Statement.Vars = vars
+		return db
+	}
+
+	t.Run("bounded length", func(t *testing.T) {
+		actual := buildIdentifier(newDB("TEST-SQL "+strings.Repeat("AND x = ? ", 100), make([]interface{}, 100)...), "", nil)
+		if !strings.HasPrefix(actual, IdentifierPrefix) || len(actual) != len(IdentifierPrefix)+32 {
+			t.Errorf("buildIdentifier expected to return the prefix followed by a 128-bit hex digest, got `%s`", actual)
+		}
+	})
+
+	testCases := map[string]struct {
+		first, second *gorm.DB
+		equal         bool
+	}{
+		"identical": {
+			first:  newDB("TEST-SQL", "test", 123, 12.3, true, false, []string{"test", "me"}),
+			second: newDB("TEST-SQL", "test", 123, 12.3, true, false, []string{"test", "me"}),
+			equal:  true,
+		},
+		"whitespace": {
+			first:  newDB("SELECT *  FROM users\n\tWHERE id = ? "),
+			second: newDB("SELECT * FROM users WHERE id = ?"),
+			equal:  true,
+		},
+		"quoted whitespace": {
+			first:  newDB("SELECT * FROM users WHERE name = 'a  b'"),
+			second: newDB("SELECT * FROM users WHERE name = 'a b'"),
+			equal:  false,
+		},
+		"in list order": {
+			first:  newDB("SELECT * FROM users WHERE id IN (?,?,?) AND role = ?", 1, 2, 3, "admin"),
+			second: newDB("SELECT * FROM users WHERE id IN (?,?,?) AND role = ?", 3, 2, 1, "admin"),
+			equal:  true,
+		},
+		"numbered in list order": {
+			first:  newDB(`SELECT * FROM "users" WHERE "id" IN ($1, $2) AND "role" = $3`, 1, 2, "admin"),
+			second: newDB(`SELECT * FROM "users" WHERE "id" IN ($1, $2) AND "role" = $3`, 2, 1, "admin"),
+			equal:  true,
+		},
+		"order outside of in lists": {
+			first:  newDB("SELECT * FROM users WHERE id = ? AND role = ?", 1, 2),
+			second: newDB("SELECT * FROM users WHERE id = ? AND role = ?", 2, 1),
+			equal:  false,
+		},
+		"quoted in list": {
+			first:  newDB("SELECT * FROM users WHERE name = 'IN (?,?)' AND id IN (?,?)", 1, 2),
+			second: newDB("SELECT * FROM users WHERE name = 'IN (?,?)' AND id IN (?,?)", 2, 1),
+			equal:  true,
+		},
+		"different vars": {
+			first:  newDB("TEST-SQL", 1),
+			second: newDB("TEST-SQL", 2),
+			equal:  false,
+		},
+		"var boundaries": {
+			first:  newDB("TEST-SQL", "a b"),
+			second: newDB("TEST-SQL", "a", "b"),
+			equal:  false,
+		},
+		"var types": {
+			first:  newDB("TEST-SQL", 1),
+			second: newDB("TEST-SQL", "1"),
+			equal:  false,
+		},
+		"maps": {
+			first:  newDB("TEST-SQL", map[string]int{"a": 1, "b": 2, "c": 3, "d": 4}),
+			second: newDB("TEST-SQL", map[string]int{"d": 4, "c": 3, "b": 2, "a": 1}),
+			equal:  true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			first, second := buildIdentifier(tc.first, "", nil), buildIdentifier(tc.second, "", nil)
+			if (first == second) != tc.equal {
+				t.Errorf("expected the equality of the identifiers to be %t, got `%s` and `%s`", tc.equal, first, second)
+			}
+		})
+	}
+
+	t.Run("gorm in list", func(t *testing.T) {
+		captured := captureIdentifiers(t, func(db *gorm.DB) {
+			db.Where("id IN ?", []int{1, 2, 3}).Find(&[]tablesUserModel{})
+			db.Where("id IN ?", []int{3, 2, 1}).Find(&[]tablesUserModel{})
+			db.Where("id IN ?", []int{3, 2, 4}).Find(&[]tablesUserModel{})
+		})
+		if len(captured) != 3 || captured[0] != captured[1] || captured[0] == captured[2] {
+			t.Errorf("expected only the queries with the same IN list values to share their identifier, got %v", captured)
+		}
+	})
 
-	actual := buildIdentifier(db)
-	expected := "gorm-caches::TEST-SQL-[test 123 12.3 true false [test me]]"
-	if actual != expected {
-		t.Errorf("buildIdentifier expected to return `%s` but got `%s`", expected, actual)
+	t.Run("select list", func(t *testing.T) {
+		captured := captureIdentifiers(t, func(db *gorm.DB) {
+			db.Where("name = ?", "ktsivkov").Find(&[]tablesUserModel{})
+			db.Select("id", "name").Where("name = ?", "ktsivkov").Find(&[]tablesUserModel{})
+			db.Select("name", "id").Where("name = ?", "ktsivkov").Find(&[]tablesUserModel{})
+			db.Omit("role_id").Where("name = ?", "ktsivkov").Find(&[]tablesUserModel{})
+			db.Distinct("name").Where("name = ?", "ktsivkov").Find(&[]tablesUserModel{})
+		})
+		seen := make(map[string]int, len(captured))
+		for i, identifier := range captured {
+			if j, ok := seen[identifier]; ok {
+				t.Errorf("expected the queries selecting different columns to get different identifiers, #%d and #%d share `%s`", j, i, identifier)
+			}
+			seen[identifier] = i
+		}
+		if len(captured) != 5 {
+			t.Errorf("expected 5 queries to be executed, got %d", len(captured))
+		}
+	})
+}
+
+// captureIdentifiers runs fn in dry run mode and returns the identifiers of the executed queries
+func captureIdentifiers(t *testing.T, fn func(db *gorm.DB)) []string {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+
+	var captured []string
+	if err := db.Callback().Query().After("gorm:query").Register("test:capture_identifiers", func(db *gorm.DB) {
+		captured = append(captured, buildIdentifier(db, "", nil))
+	}); err != nil {
+		t.Fatalf("registering the capture callback resulted into an unexpected error, %s", err.Error())
 	}
+
+	fn(db)
+	return captured
 }
 
 func Test_sliceToString(t *testing.T) {
@@ -53,3 +181,287 @@ func Test_sliceToString(t *testing.T) {
 		t.Errorf("sliceToString expected to return `%s` but got `%s`", expected, actual)
 	}
 }
+
+func TestCaches_identifierOf(t *testing.T) {
+	newDB := func(ctx context.Context) *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Context = ctx
+		db.Statement.SQL.WriteString("TEST-SQL")
+		db.Statement.Vars = append(db.Statement.Vars, 1)
+		return db
+	}
+
+	t.Run("default", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{}}
+		db := newDB(context.Background())
+		if act, expected := caches.identifierOf(db), buildIdentifier(db, "", nil); act != expected {
+			t.Errorf("identifierOf expected to return `%s` but got `%s`", expected, act)
+		}
+	})
+
+	t.Run("key builder", func(t *testing.T) {
+		type tenantKey struct{}
+		caches := &Caches{Conf: &Config{
+			KeyBuilder: func(db *gorm.DB) string {
+				return fmt.Sprintf("%v::%s", db.Statement.Context.Value(tenantKey{}), buildIdentifier(db, "", nil))
+			},
+		}}
+
+		first := caches.identifierOf(newDB(context.WithValue(context.Background(), tenantKey{}, "first")))
+		second := caches.identifierOf(newDB(context.WithValue(context.Background(), tenantKey{}, "second")))
+		expected := IdentifierPrefix + "first::" + buildIdentifier(newDB(context.Background()), "", nil)
+		if first != expected {
+			t.Errorf("identifierOf expected to return `%s` but got `%s`", expected, first)
+		}
+		if first == second {
+			t.Errorf("expected the keys of different tenants to differ, both are `%s`", first)
+		}
+	})
+	t.Run("key prefix", func(t *testing.T) {
+		db := newDB(context.Background())
+		caches := &Caches{Conf: &Config{KeyPrefix: "app::"}}
+		if act, expected := caches.identifierOf(db), "app::"+buildIdentifier(db, "", nil); act != expected {
+			t.Errorf("identifierOf expected to return `%s` but got `%s`", expected, act)
+		}
+
+		caches.Conf.KeyBuilder = func(db *gorm.DB) string {
+			return "custom"
+		}
+		if act := caches.identifierOf(db); act != "app::"+IdentifierPrefix+"custom" {
+			t.Errorf("identifierOf expected to prefix the custom key as well, got `%s`", act)
+		}
+	})
+	t.Run("database", func(t *testing.T) {
+		db := newDB(context.Background())
+		tenantA := &Caches{Conf: &Config{}, database: "tenant_a"}
+		tenantB := &Caches{Conf: &Config{}, database: "tenant_b"}
+		if a, b := tenantA.identifierOf(db), tenantB.identifierOf(db); a == b || a == buildIdentifier(db, "", nil) {
+			t.Errorf("expected the identifiers of the same query to differ per database, got `%s` and `%s`", a, b)
+		}
+
+		tenantA.Conf.KeyBuilder = func(db *gorm.DB) string {
+			return "custom"
+		}
+		if act := tenantA.identifierOf(db); act != IdentifierPrefix+"tenant_a:custom" {
+			t.Errorf("identifierOf expected to scope the custom key to the database, got `%s`", act)
+		}
+	})
+
+	t.Run("hashed keys", func(t *testing.T) {
+		db := newDB(context.Background())
+		long := strings.Repeat("tenant:", 100)
+		log := &loggerMock{}
+		caches := &Caches{Conf: &Config{
+			KeyPrefix: "app::",
+			KeyBuilder: func(db *gorm.DB) string {
+				return long + fmt.Sprint(db.Statement.Vars...)
+			},
+			HashKeys:       true,
+			Logger:         log,
+			LogIdentifiers: true,
+		}, database: "tenant_a"}
+
+		act := caches.identifierOf(db)
+		if !strings.HasPrefix(act, "app::"+IdentifierPrefix) || len(act) != len("app::"+IdentifierPrefix)+32 {
+			t.Errorf("identifierOf expected to digest the custom key into a bounded one, got `%s`", act)
+		}
+		if act != caches.identifierOf(newDB(context.Background())) {
+			t.Error("expected the digests of identical keys to be equal")
+		}
+		other := newDB(context.Background())
+		other.Statement.Vars[0] = 2
+		if act == caches.identifierOf(other) {
+			t.Error("expected the digests of different keys to differ")
+		}
+
+		if len(log.events) == 0 || log.events[0].Operation != LogKey ||
+			log.events[0].Key != "tenant_a:"+long+"1" || log.events[0].Identifier != act {
+			t.Errorf("expected the raw key to be logged along with its digest, got %+v", log.events)
+		}
+		caches.Conf.LogIdentifiers = false
+		log.events = nil
+		caches.identifierOf(db)
+		if len(log.events) != 0 {
+			t.Errorf("expected the raw keys to only be logged with LogIdentifiers, got %+v", log.events)
+		}
+	})
+
+	t.Run("context values", func(t *testing.T) {
+		type localeKey struct{}
+		type currencyKey struct{}
+		caches := &Caches{Conf: &Config{KeyContextKeys: []any{localeKey{}, currencyKey{}}}}
+		with := func(values ...any) *gorm.DB {
+			ctx := context.Background()
+			for i := 0; i < len(values); i += 2 {
+				ctx = context.WithValue(ctx, values[i], values[i+1])
+			}
+			return newDB(ctx)
+		}
+
+		if act := caches.identifierOf(with()); act != buildIdentifier(newDB(context.Background()), "", nil) {
+			t.Errorf("expected the queries without context values to be identified as by default, got `%s`", act)
+		}
+		en, fr := caches.identifierOf(with(localeKey{}, "en-US")), caches.identifierOf(with(localeKey{}, "fr-FR"))
+		if en == fr || en != caches.identifierOf(with(localeKey{}, "en-US")) {
+			t.Errorf("expected the identifiers to differ per locale only, got `%s` and `%s`", en, fr)
+		}
+		if en == caches.identifierOf(with(currencyKey{}, "en-US")) {
+			t.Error("expected the values of different keys to be told apart")
+		}
+		if len(en) != len(IdentifierPrefix)+32 {
+			t.Errorf("expected the context values to be digested, got `%s`", en)
+		}
+		first := caches.identifierOf(with(localeKey{}, map[string]int{"a": 1, "b": 2, "c": 3}))
+		for i := 0; i < 10; i++ {
+			if act := caches.identifierOf(with(localeKey{}, map[string]int{"c": 3, "b": 2, "a": 1})); act != first {
+				t.Fatalf("expected the context values to be formatted deterministically, got `%s` and `%s`", first, act)
+			}
+		}
+
+		caches.Conf.KeyBuilder = func(db *gorm.DB) string {
+			return "custom"
+		}
+		if act := caches.identifierOf(with(localeKey{}, "en-US")); act != IdentifierPrefix+"custom:ctx:13:string(en-US):0:" {
+			t.Errorf("identifierOf expected to suffix the custom key with the context values, got `%s`", act)
+		}
+		if act := caches.identifierOf(with()); act != IdentifierPrefix+"custom" {
+			t.Errorf("identifierOf expected to keep the custom key without context values, got `%s`", act)
+		}
+	})
+
+	t.Run("query key", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{KeyPrefix: "app::"}, database: "tenant_a"}
+		db := newDB(WithKey(context.Background(), "active-users"))
+		if act := caches.identifierOf(db); act != "app::"+IdentifierPrefix+"tenant_a:active-users" {
+			t.Errorf("identifierOf expected to return the key of the context, got `%s`", act)
+		}
+
+		caches.Conf.KeyBuilder = func(db *gorm.DB) string {
+			return "custom"
+		}
+		db = newDB(context.Background())
+		db.Statement.Settings.Store(KeySetting, "active-users")
+		if act := caches.identifierOf(db); act != "app::"+IdentifierPrefix+"tenant_a:active-users" {
+			t.Errorf("identifierOf expected the key of the setting to take precedence over the KeyBuilder, got `%s`", act)
+		}
+		if act := caches.identifierOf(newDB(WithKey(context.Background(), ""))); act != "app::"+IdentifierPrefix+"tenant_a:custom" {
+			t.Errorf("identifierOf expected to ignore an empty key, got `%s`", act)
+		}
+
+		caches.Conf.HashKeys = true
+		if act := caches.identifierOf(newDB(WithKey(context.Background(), "active-users"))); act != "app::"+hashKey("tenant_a:active-users") {
+			t.Errorf("identifierOf expected to digest the key of the context, got `%s`", act)
+		}
+	})
+
+	t.Run("database upon initialization", func(t *testing.T) {
+		for conf, expected := range map[*Config]string{
+			{}:                     "", // The dummy dialector has no migrator
+			{Database: "tenant_a"}: "tenant_a",
+		} {
+			db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			caches := &Caches{Conf: conf}
+			if err := db.Use(caches); err != nil {
+				t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+			}
+			if caches.database != expected {
+				t.Errorf("expected the identifiers to be scoped to the database %q, got %q", expected, caches.database)
+			}
+		}
+	})
+}
+
+func TestCaches_Identifier(t *testing.T) {
+	cacher := &cacherMock{store: &sync.Map{}}
+	caches := &Caches{Conf: &Config{Cacher: cacher, KeyPrefix: "app::"}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	var executed int
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+	}
+
+	query := func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Orders").Where("name = ?", "ktsivkov").Find(&[]tablesUserModel{})
+	}
+	identifier, err := caches.Identifier(db, query)
+	if err != nil {
+		t.Fatalf("Identifier resulted into an unexpected error, %v", err)
+	}
+	if !strings.HasPrefix(identifier, "app::"+IdentifierPrefix) {
+		t.Errorf("expected the identifier to be prefixed, got `%s`", identifier)
+	}
+	if executed != 0 {
+		t.Errorf("expected Identifier not to run the query, the database was queried %d times", executed)
+	}
+	if _, ok := cacher.store.Load(identifier); ok {
+		t.Error("expected Identifier not to store the query")
+	}
+
+	query(db)
+	if _, ok := cacher.store.Load(identifier); !ok {
+		t.Errorf("expected the query to be stored under `%s`", identifier)
+	}
+
+	other, _ := caches.Identifier(db, func(db *gorm.DB) *gorm.DB {
+		return db.Where("name = ?", "guest").Find(&[]tablesUserModel{})
+	})
+	if other == identifier {
+		t.Error("expected the queries with other bind variables to get another identifier")
+	}
+
+	if _, err := caches.Identifier(db, func(db *gorm.DB) *gorm.DB {
+		return db.Exec("UPDATE users SET name = ?", "guest")
+	}); err == nil {
+		t.Error("expected a query skipping the query callbacks to have no identifier")
+	}
+}
+
+func TestCaches_Identifier_prepareStmt(t *testing.T) {
+	var executed int
+	caches := &Caches{Conf: &Config{Cacher: &cacherMock{}}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{PrepareStmt: true})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		executed++
+		*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: fmt.Sprint(db.Statement.Vars...)}}
+		db.Statement.RowsAffected = 1
+	}
+
+	identifierOf := func(id int) string {
+		identifier, err := caches.Identifier(db, func(tx *gorm.DB) *gorm.DB {
+			return tx.Table("users").Where("id = ?", id).Find(&[]mockDest{})
+		})
+		if err != nil {
+			t.Fatalf("the identifier resulted into an unexpected error, %v", err)
+		}
+		return identifier
+	}
+	if first, second := identifierOf(1), identifierOf(2); first == second {
+		t.Errorf("expected the bind variables to be part of the prepared statements' identifiers, both are `%s`", first)
+	}
+	if identifierOf(1) != identifierOf(1) {
+		t.Error("expected the identifiers of identical prepared statements to be equal")
+	}
+
+	for _, id := range []int{1, 2, 1, 2} {
+		var rows []mockDest
+		db.Table("users").Where("id = ?", id).Find(&rows)
+		if len(rows) != 1 || rows[0].Result != fmt.Sprint(id) {
+			t.Errorf("expected the result of the id %d, got %+v", id, rows)
+		}
+	}
+	if executed != 2 {
+		t.Errorf("expected each bind variable to be cached on its own, the database was queried %d times", executed)
+	}
+}
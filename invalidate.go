@@ -0,0 +1,56 @@
+package caches
+
+import (
+	"context"
+)
+
+// InvalidateTable invalidates the cached values reading from any of the tables, along with the values whose tables
+// are unknown, e.g. after a bulk import or a mutation run by another service which did not go through gorm.
+// It delegates to the Cacher's Invalidate, the tables being the ones of the statements (see Query.Tables),
+// and does nothing when no tables are given, see InvalidateAll.
+//
+// It is safe to call concurrently with the queries, with the same guarantees as the invalidations of the mutations:
+// a query which read the database before the out-of-band mutation, and stores its result after the invalidation,
+// may still cache a stale value, so the invalidation is best issued once the mutation committed.
+func (c *Caches) InvalidateTable(ctx context.Context, tables ...string) error {
+	if len(tables) == 0 || c.Conf == nil || c.Conf.Cacher == nil {
+		return nil
+	}
+	if c.Conf.DryRun {
+		c.logEvent(ctx, LogEvent{Operation: LogDryRun, Invalidated: tables})
+		return nil
+	}
+
+	c.log(ctx, LogInvalidate, "", "", tables)
+	return c.Conf.Cacher.Invalidate(ctx, tables...)
+}
+
+// InvalidateAll invalidates all the cached values, by calling the Cacher's Invalidate without tables
+// It is safe to call concurrently with the queries, see InvalidateTable.
+func (c *Caches) InvalidateAll(ctx context.Context) error {
+	if c.Conf == nil || c.Conf.Cacher == nil {
+		return nil
+	}
+	if c.Conf.DryRun {
+		c.logEvent(ctx, LogEvent{Operation: LogDryRun, Invalidated: []string{}})
+		return nil
+	}
+
+	c.log(ctx, LogInvalidate, "", "", nil)
+	return c.Conf.Cacher.Invalidate(ctx)
+}
+
+// Flush invalidates all the cached values (see InvalidateAll), and forgets the memoized table decisions and ttls,
+// so that a reconfigured CanCachedTables, CanNotCachedTables or TableTTL applies to the tables already queried,
+// e.g. between the cases of integration tests. It is safe to call without a Cacher, and leaves Stats untouched.
+//
+// The easer's queue is cleared as well: the queries it runs carry on for their waiters, while the identical queries
+// started afterwards run on their own rather than being served a result which may predate the flush.
+func (c *Caches) Flush(ctx context.Context) error {
+	c.cacheDecisions.Clear()
+	c.tableTTLs.Clear()
+	if c.queue != nil {
+		c.queue.clear()
+	}
+	return c.InvalidateAll(ctx)
+}
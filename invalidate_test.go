@@ -0,0 +1,140 @@
+package caches
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_manualInvalidation(t *testing.T) {
+	ctx := context.Background()
+	newCaches := func(t *testing.T) (*Caches, *gorm.DB, *int) {
+		var executed int
+		caches := &Caches{Conf: &Config{Cacher: NewMemoryCacher()}}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			executed++
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: db.Statement.Table}}
+			db.Statement.RowsAffected = 1
+		}
+		return caches, db, &executed
+	}
+	find := func(db *gorm.DB, table string) {
+		db.Table(table).Find(&[]mockDest{})
+	}
+
+	t.Run("InvalidateTable", func(t *testing.T) {
+		caches, db, executed := newCaches(t)
+		find(db, "users")
+		find(db, "orders")
+
+		if err := caches.InvalidateTable(ctx, "users"); err != nil {
+			t.Fatalf("InvalidateTable resulted into an unexpected error, %v", err)
+		}
+		find(db, "users")
+		find(db, "orders")
+		if *executed != 3 {
+			t.Errorf("expected only the values of the invalidated table to be queried again, got %d queries", *executed)
+		}
+
+		if err := caches.InvalidateTable(ctx); err != nil {
+			t.Fatalf("InvalidateTable resulted into an unexpected error, %v", err)
+		}
+		find(db, "users")
+		find(db, "orders")
+		if *executed != 3 {
+			t.Errorf("expected an invalidation without tables to do nothing, got %d queries", *executed)
+		}
+	})
+
+	t.Run("InvalidateAll", func(t *testing.T) {
+		caches, db, executed := newCaches(t)
+		find(db, "users")
+		find(db, "orders")
+
+		if err := caches.InvalidateAll(ctx); err != nil {
+			t.Fatalf("InvalidateAll resulted into an unexpected error, %v", err)
+		}
+		find(db, "users")
+		find(db, "orders")
+		if *executed != 4 {
+			t.Errorf("expected all the values to be queried again, got %d queries", *executed)
+		}
+	})
+
+	t.Run("concurrently with the queries", func(t *testing.T) {
+		caches, db, _ := newCaches(t)
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: db.Statement.Table}}
+		}
+
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					find(db, "users")
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					_ = caches.InvalidateTable(ctx, "users")
+					_ = caches.InvalidateAll(ctx)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("without cacher", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{Easer: true}}
+		if err := caches.InvalidateTable(ctx, "users"); err != nil {
+			t.Errorf("expected InvalidateTable to do nothing without a Cacher, got %v", err)
+		}
+		if err := caches.InvalidateAll(ctx); err != nil {
+			t.Errorf("expected InvalidateAll to do nothing without a Cacher, got %v", err)
+		}
+	})
+	t.Run("Flush", func(t *testing.T) {
+		caches, db, executed := newCaches(t)
+		caches.Conf.CanCachedTables = []any{"^users$"}
+		find(db, "users")
+		find(db, "users")
+		if *executed != 1 {
+			t.Fatalf("expected the second query to hit the cache, got %d queries", *executed)
+		}
+
+		caches.Conf.CanCachedTables = []any{"^orders$"}
+		find(db, "users")
+		if *executed != 1 {
+			t.Fatalf("expected the memoized decision to apply until the flush, got %d queries", *executed)
+		}
+
+		if err := caches.Flush(ctx); err != nil {
+			t.Fatalf("Flush resulted into an unexpected error, %v", err)
+		}
+		find(db, "users")
+		find(db, "users")
+		if *executed != 3 {
+			t.Errorf("expected the flushed table not to be cached anymore, got %d queries", *executed)
+		}
+	})
+
+	t.Run("Flush without cacher", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{}}
+		if err := caches.Flush(ctx); err != nil {
+			t.Errorf("expected Flush to do nothing without a Cacher, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package caches
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultLockWait         = time.Second
+	defaultLockPollInterval = 50 * time.Millisecond
+)
+
+// Locker serializes the fetches of a query across processes, see Config.Locker
+//
+// Within a process, identical queries are coalesced by the easer first, so only one of them acquires the lock.
+// The process holding it queries the database and stores the result, while the other ones poll the Cacher
+// for it until LockWait elapses, and then query the database themselves, so a dead process never blocks them.
+// They query it as soon as the lock is released without a stored result as well, e.g. a result too large to cache.
+type Locker interface {
+	// Acquire impl should try to take the lock of the key without blocking, and report whether it did
+	// The lock should expire on its own, so that a process dying while holding it does not hold it forever,
+	// while release is called once the result is stored. The ctx is canceled after LockWait.
+	// An impl unable to tell whether the lock is held, e.g. when its backend is unreachable, should report it as
+	// taken with a no-op release, so that the query hits the database rather than waiting for nothing.
+	Acquire(ctx context.Context, key string) (release func(), ok bool)
+}
+
+// fetch runs the query, holding the Locker's lock of the identifier when lock is set and a Locker is configured
+// It reports whether the result was either served from the Cacher or stored in it already
+func (c *Caches) fetch(db *gorm.DB, identifier string, lock bool) bool {
+	if !lock || c.Conf.Locker == nil || c.Conf.Cacher == nil {
+		c.runQuery(db)
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(db.Statement.Context, c.lockWait())
+	defer cancel()
+
+	release, ok := c.Conf.Locker.Acquire(ctx, identifier)
+	if !ok {
+		return c.awaitResult(ctx, db, identifier)
+	}
+	defer release()
+
+	// Another process may have stored the result in between the cache miss and the acquisition of the lock
+	if res := c.lookup(db, identifier); res != nil {
+		c.serve(db, identifier, res)
+		return true
+	}
+
+	c.runQuery(db)
+	if db.Error != nil && !c.cachesNotFound(db) {
+		return true
+	}
+
+	c.storeInCache(db, identifier)
+	return true
+}
+
+// awaitResult polls the Cacher for the result of the process holding the lock until ctx is done,
+// and then falls back on querying the database. It falls back as soon as the lock is released without a result,
+// e.g. when the holder did not store it (MaxCacheRows, CachePredicate, MinQueryDuration, ErrSkipCache or a not found
+// row without NegativeTTL) or failed, rather than waiting for a result which is not coming.
+func (c *Caches) awaitResult(ctx context.Context, db *gorm.DB, identifier string) bool {
+	interval := c.Conf.LockPollInterval
+	if interval <= 0 {
+		interval = defaultLockPollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.runQuery(db)
+			return false
+		case <-ticker.C:
+			if res := c.lookup(db, identifier); res != nil {
+				c.serve(db, identifier, res)
+				return true
+			}
+			// The lock is only taken to tell whether it is still held, the waiters querying the database at once
+			release, ok := c.Conf.Locker.Acquire(ctx, identifier)
+			if !ok {
+				continue
+			}
+			release()
+			// The holder may have stored the result in between the lookup and the acquisition
+			if res := c.lookup(db, identifier); res != nil {
+				c.serve(db, identifier, res)
+				return true
+			}
+			c.runQuery(db)
+			return false
+		}
+	}
+}
+
+func (c *Caches) lockWait() time.Duration {
+	if c.Conf.LockWait <= 0 {
+		return defaultLockWait
+	}
+	return c.Conf.LockWait
+}
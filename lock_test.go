@@ -0,0 +1,183 @@
+package caches
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type lockerMock struct {
+	mu       sync.Mutex
+	held     map[string]bool
+	acquired int
+	released int
+}
+
+func (l *lockerMock) Acquire(_ context.Context, key string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		l.held = make(map[string]bool)
+	}
+	if l.held[key] {
+		return nil, false
+	}
+
+	l.held[key] = true
+	l.acquired++
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.held, key)
+		l.released++
+	}, true
+}
+
+func TestCaches_Locker(t *testing.T) {
+	newCaches := func(cacher Cacher, locker Locker, executed *int) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher:           cacher,
+				Locker:           locker,
+				LockWait:         200 * time.Millisecond,
+				LockPollInterval: 10 * time.Millisecond,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					*executed++
+					db.Statement.Dest.(*mockDest).Result = "from-database"
+				},
+			},
+		}
+	}
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	t.Run("lock holder", func(t *testing.T) {
+		var executed int
+		cacher := NewMemoryCacher()
+		locker := &lockerMock{}
+		caches := newCaches(cacher, locker, &executed)
+
+		db := newDB()
+		caches.query(db)
+		if executed != 1 || db.Statement.Dest.(*mockDest).Result != "from-database" {
+			t.Errorf("expected the lock holder to query the database, it ran %d times", executed)
+		}
+		if locker.acquired != 1 || locker.released != 1 {
+			t.Errorf("expected the lock to be acquired and released once, got %d and %d", locker.acquired, locker.released)
+		}
+		if act := caches.Stats(); act.Stores != 1 {
+			t.Errorf("expected the lock holder to store the result once, got %+v", act)
+		}
+	})
+
+	t.Run("lock waiter", func(t *testing.T) {
+		var executed int
+		cacher := NewMemoryCacher()
+		locker := &lockerMock{}
+		caches := newCaches(cacher, locker, &executed)
+
+		identifier := caches.identifierOf(newDB())
+		release, _ := locker.Acquire(context.Background(), identifier) // Held by another process
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = cacher.Store(context.Background(), identifier, &Query[any]{Dest: &mockDest{Result: "from-cache"}, RowsAffected: 1}, 0)
+			release()
+		}()
+
+		db := newDB()
+		caches.query(db)
+		if executed != 0 {
+			t.Errorf("expected the waiter not to query the database, it ran %d times", executed)
+		}
+		if act := db.Statement.Dest.(*mockDest).Result; act != "from-cache" {
+			t.Errorf("expected the waiter to be served the result of the lock holder, got `%s`", act)
+		}
+		if act := caches.Stats(); act.Misses != 1 || act.Hits != 1 || act.Stores != 0 {
+			t.Errorf("expected a miss followed by a hit without store, got %+v", act)
+		}
+	})
+
+	t.Run("lock wait timeout", func(t *testing.T) {
+		var executed int
+		locker := &lockerMock{}
+		caches := newCaches(NewMemoryCacher(), locker, &executed)
+		_, _ = locker.Acquire(context.Background(), caches.identifierOf(newDB())) // Held by a dead process
+
+		start := time.Now()
+		db := newDB()
+		caches.query(db)
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+			t.Errorf("expected the waiter to give up after the lock wait, it took %s", elapsed)
+		}
+		if executed != 1 || db.Statement.Dest.(*mockDest).Result != "from-database" {
+			t.Errorf("expected the waiter to fall back on the database, it ran %d times", executed)
+		}
+		if act := caches.Stats(); act.Stores != 1 {
+			t.Errorf("expected the fallback result to be stored, got %+v", act)
+		}
+	})
+
+	t.Run("lock released without result", func(t *testing.T) {
+		var executed int
+		locker := &lockerMock{}
+		caches := newCaches(NewMemoryCacher(), locker, &executed)
+		// The process holding the lock does not store its result, e.g. one exceeding MaxCacheRows
+		release, _ := locker.Acquire(context.Background(), caches.identifierOf(newDB()))
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			release()
+		}()
+
+		start := time.Now()
+		db := newDB()
+		caches.query(db)
+		if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+			t.Errorf("expected the waiter to query the database once the lock is released, it took %s", elapsed)
+		}
+		if executed != 1 || db.Statement.Dest.(*mockDest).Result != "from-database" {
+			t.Errorf("expected the waiter to fall back on the database, it ran %d times", executed)
+		}
+		if locker.held[caches.identifierOf(newDB())] {
+			t.Error("expected the waiter not to keep the lock it probed")
+		}
+	})
+
+	t.Run("local coalescing first", func(t *testing.T) {
+		var executed int
+		locker := &lockerMock{}
+		caches := newCaches(NewMemoryCacher(), locker, &executed)
+		caches.Conf.Easer = true
+		caches.queue = newEaseQueue()
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			time.Sleep(300 * time.Millisecond)
+			db.Statement.Dest.(*mockDest).Result = "from-database"
+		}
+
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			caches.query(newDB())
+			wg.Done()
+		}()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			caches.query(newDB())
+			wg.Done()
+		}()
+		wg.Wait()
+
+		if locker.acquired != 1 {
+			t.Errorf("expected the coalesced query not to acquire the lock, it was acquired %d times", locker.acquired)
+		}
+	})
+}
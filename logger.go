@@ -0,0 +1,121 @@
+package caches
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"gorm.io/gorm/logger"
+)
+
+// LogOperation is the cache operation a LogEvent is about
+type LogOperation string
+
+const (
+	LogHit        LogOperation = "hit"
+	LogMiss       LogOperation = "miss"
+	LogStore      LogOperation = "store"
+	LogCoalesced  LogOperation = "coalesced"
+	LogInvalidate LogOperation = "invalidate"
+	// LogDryRun reports what the plugin would do in Config.DryRun mode, either the decision about a query,
+	// or the invalidation of a mutation when Invalidated is set
+	LogDryRun LogOperation = "dry-run"
+	// LogKey reports the raw key of a query along with its digest, when Config.HashKeys and Config.LogIdentifiers are set
+	LogKey LogOperation = "key"
+	// LogError reports the CacheOpError of a failed cache operation, whatever the Config.CacheErrorMode
+	LogError LogOperation = "error"
+)
+
+// LogEvent describes a cache operation
+type LogEvent struct {
+	Operation LogOperation
+	// Table is the one of the parsed statement, it may be empty for raw queries
+	Table string
+	// Identifier is the key of the query, redacted unless Config.LogIdentifiers is set, it is empty for invalidations
+	Identifier string
+	// Invalidated lists the invalidated tables, or tags (see Config.Tagger), a LogInvalidate event without any
+	// means that every cached value was invalidated
+	Invalidated []string
+	// Key is the raw key of a LogKey event, which is digested into its Identifier
+	Key string
+	// Cacheable tells whether the query of a LogDryRun event would be cached, see Config.CanCachedTables
+	Cacheable bool
+	// Err is the *CacheOpError of a LogError event
+	Err error
+}
+
+func (e LogEvent) String() string {
+	var sb strings.Builder
+	sb.WriteString("caches: ")
+	sb.WriteString(string(e.Operation))
+	if e.Table != "" {
+		sb.WriteString(" table=")
+		sb.WriteString(e.Table)
+	}
+	if e.Identifier != "" {
+		sb.WriteString(" identifier=")
+		sb.WriteString(e.Identifier)
+	}
+	if e.Key != "" {
+		sb.WriteString(" key=")
+		sb.WriteString(e.Key)
+	}
+	switch {
+	case e.Operation == LogInvalidate, e.Operation == LogDryRun && e.Invalidated != nil:
+		sb.WriteString(fmt.Sprintf(" invalidated=%v", e.Invalidated))
+	case e.Operation == LogDryRun:
+		sb.WriteString(fmt.Sprintf(" cacheable=%t", e.Cacheable))
+	case e.Operation == LogError && e.Err != nil:
+		sb.WriteString(fmt.Sprintf(" error=%q", e.Err.Error()))
+	}
+	return sb.String()
+}
+
+// Logger receives debug level events about the cache operations, see Config.Logger
+// Its methods are called from the concurrently running queries, so implementations must be safe for concurrent use
+type Logger interface {
+	Debug(ctx context.Context, event LogEvent)
+}
+
+// NewGormLogger adapts a gorm logger into a Logger, the events are logged at its Info level since it has no Debug one
+func NewGormLogger(l logger.Interface) Logger {
+	return gormLogger{l}
+}
+
+type gormLogger struct {
+	logger logger.Interface
+}
+
+func (l gormLogger) Debug(ctx context.Context, event LogEvent) {
+	l.logger.Info(ctx, "%s", event)
+}
+
+// log emits an event about a cache operation to the Logger, see logEvent
+func (c *Caches) log(ctx context.Context, op LogOperation, table, identifier string, invalidated []string) {
+	c.logEvent(ctx, LogEvent{Operation: op, Table: table, Identifier: identifier, Invalidated: invalidated})
+}
+
+// logEvent emits the event to the Logger, the identifier is only redacted when a Logger is configured
+func (c *Caches) logEvent(ctx context.Context, event LogEvent) {
+	if c.Conf.Logger == nil {
+		return
+	}
+
+	if !c.Conf.LogIdentifiers && event.Identifier != "" {
+		event.Identifier = redactIdentifier(event.Identifier)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c.Conf.Logger.Debug(ctx, event)
+}
+
+// redactIdentifier digests the identifier, which may hold the bind variables of the query when built by a KeyBuilder,
+// the digests of identical identifiers are equal so the operations of a query can still be correlated
+func redactIdentifier(identifier string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(identifier))
+	return "fnv64:" + hex.EncodeToString(h.Sum(nil))
+}
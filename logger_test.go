@@ -0,0 +1,107 @@
+package caches
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+type loggerMock struct {
+	mu     sync.Mutex
+	events []LogEvent
+}
+
+func (l *loggerMock) Debug(_ context.Context, event LogEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func TestCaches_Logger(t *testing.T) {
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Table = "users"
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+	newCaches := func(l Logger, raw bool) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher:         &cacherMock{},
+				Logger:         l,
+				LogIdentifiers: raw,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {},
+			},
+		}
+	}
+
+	t.Run("events", func(t *testing.T) {
+		l := &loggerMock{}
+		caches := newCaches(l, false)
+
+		caches.query(newDB())
+		caches.query(newDB())
+		if err := caches.invalidate(newDB(), uponUpdate); err != nil {
+			t.Fatalf("invalidating resulted into an unexpected error, %v", err)
+		}
+
+		ops := make([]LogOperation, len(l.events))
+		for i, event := range l.events {
+			ops[i] = event.Operation
+		}
+		if expected := []LogOperation{LogMiss, LogStore, LogHit, LogInvalidate}; !reflect.DeepEqual(ops, expected) {
+			t.Fatalf("expected the logged operations to be %v, got %v", expected, ops)
+		}
+
+		identifier := l.events[0].Identifier
+		if !strings.HasPrefix(identifier, "fnv64:") {
+			t.Errorf("expected the identifier to be redacted, got %s", identifier)
+		}
+		for _, event := range l.events[:3] {
+			if event.Identifier != identifier || event.Table != "users" {
+				t.Errorf("expected the events of the query to share its table and identifier, got %+v", event)
+			}
+		}
+		if !reflect.DeepEqual(l.events[3].Invalidated, []string{"users"}) {
+			t.Errorf("expected the users table to be invalidated, got %v", l.events[3].Invalidated)
+		}
+	})
+
+	t.Run("raw identifiers", func(t *testing.T) {
+		l := &loggerMock{}
+		caches := newCaches(l, true)
+
+		caches.query(newDB())
+		if len(l.events) == 0 || !strings.HasPrefix(l.events[0].Identifier, IdentifierPrefix) {
+			t.Errorf("expected the identifier to be logged as is, got %+v", l.events)
+		}
+	})
+
+	t.Run("gorm logger", func(t *testing.T) {
+		var sb strings.Builder
+		l := NewGormLogger(logger.New(&writerMock{&sb}, logger.Config{LogLevel: logger.Info}))
+		l.Debug(context.Background(), LogEvent{Operation: LogInvalidate, Table: "users", Invalidated: []string{"users"}})
+
+		if act := sb.String(); !strings.Contains(act, "caches: invalidate table=users invalidated=[users]") {
+			t.Errorf("expected the event to be logged, got %q", act)
+		}
+	})
+}
+
+type writerMock struct {
+	sb *strings.Builder
+}
+
+func (w *writerMock) Printf(format string, args ...interface{}) {
+	_, _ = fmt.Fprintf(w.sb, format, args...)
+}
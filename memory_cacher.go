@@ -0,0 +1,338 @@
+package caches
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const defaultMemoryShards = 32
+
+type MemoryCacherOption func(c *MemoryCacher)
+
+// WithMemoryShards sets the number of independently locked shards the keys are spread across
+func WithMemoryShards(shards int) MemoryCacherOption {
+	return func(c *MemoryCacher) {
+		if shards > 0 {
+			c.shardsCount = shards
+		}
+	}
+}
+
+// WithMemoryMaxEntries caps the number of cached entries, the least recently used ones get evicted first unless
+// another policy is set with WithMemoryEvictionPolicy
+// The cap is split evenly across the shards, so when keys are not evenly distributed it is approximate
+func WithMemoryMaxEntries(maxEntries int) MemoryCacherOption {
+	return func(c *MemoryCacher) {
+		if maxEntries > 0 {
+			c.maxEntries = maxEntries
+		}
+	}
+}
+
+// WithMemoryEvictionPolicy sets which entries are evicted first once the cap of WithMemoryMaxEntries is reached,
+// the expired entries being removed upon their next read whatever the policy, see EvictionPolicy
+func WithMemoryEvictionPolicy(policy EvictionPolicy) MemoryCacherOption {
+	return func(c *MemoryCacher) {
+		c.policy = policy
+	}
+}
+
+// MemoryCacher is an in-process Cacher implementation
+// Values are kept in their marshalled form, so neither the stored nor the returned destinations are shared with the cache
+type MemoryCacher struct {
+	shardsCount int
+	maxEntries  int
+	policy      EvictionPolicy
+
+	shards []*memoryShard
+}
+
+func NewMemoryCacher(opts ...MemoryCacherOption) *MemoryCacher {
+	c := &MemoryCacher{
+		shardsCount: defaultMemoryShards,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	var shardMaxEntries int
+	if c.maxEntries > 0 {
+		shardMaxEntries = (c.maxEntries + c.shardsCount - 1) / c.shardsCount
+	}
+
+	c.shards = make([]*memoryShard, c.shardsCount)
+	for i := range c.shards {
+		c.shards[i] = newMemoryShard(shardMaxEntries, c.policy)
+	}
+
+	return c
+}
+
+func (c *MemoryCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	res, _, err := c.GetWithExpiry(ctx, key, q)
+	return res, err
+}
+
+// GetWithExpiry gets the key along with the instant it expires, it makes MemoryCacher an ExpiryCacher
+func (c *MemoryCacher) GetWithExpiry(_ context.Context, key string, q *Query[any]) (*Query[any], time.Time, error) {
+	entry, ok := c.shard(key).entry(key)
+	if !ok {
+		return nil, UnknownExpiry, nil
+	}
+
+	if err := q.Unmarshal(entry.value); err != nil {
+		return nil, UnknownExpiry, err
+	}
+
+	return q, entry.expiresAt, nil
+}
+
+func (c *MemoryCacher) Store(_ context.Context, key string, val *Query[any], ttl time.Duration) error {
+	res, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+
+	var expiresAt time.Time
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+
+	c.shard(key).set(key, res, expiresAt, val.Tables, val.Tags)
+	return nil
+}
+
+func (c *MemoryCacher) Invalidate(_ context.Context, tables ...string) error {
+	for _, s := range c.shards {
+		if len(tables) == 0 {
+			s.clear()
+		} else {
+			s.clearTables(tables)
+		}
+	}
+	return nil
+}
+
+// InvalidateTags deletes the keys tagged with any of the tags, it makes MemoryCacher a TagInvalidator
+func (c *MemoryCacher) InvalidateTags(_ context.Context, tags ...string) error {
+	for _, s := range c.shards {
+		s.clearTags(tags)
+	}
+	return nil
+}
+
+// Len returns the number of entries currently held, including expired ones which were not yet evicted
+func (c *MemoryCacher) Len() int {
+	var n int
+	for _, s := range c.shards {
+		s.mu.RLock()
+		n += len(s.entries)
+		s.mu.RUnlock()
+	}
+	return n
+}
+
+func (c *MemoryCacher) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return c.shards[h.Sum32()%uint32(len(c.shards))]
+}
+
+type memoryEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+	tables    []string
+	tags      []string
+
+	// The bookkeeping of the evictor, which is the only mutation of an entry, under the lock of its shard
+	element *list.Element // the place of the entry in the list of the lruEvictor
+	index   int           // the place of the entry in the heap of the heapEvictor
+	uses    uint64
+	usedAt  uint64
+}
+
+func (e *memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// indexedTables returns the tables the entry is indexed under, "" standing for the unknown tables
+func (e *memoryEntry) indexedTables() []string {
+	if len(e.tables) == 0 {
+		return []string{""}
+	}
+	return e.tables
+}
+
+type memoryShard struct {
+	mu         sync.RWMutex
+	maxEntries int
+	entries    map[string]*memoryEntry
+	evictor    evictor                        // nil without maxEntries
+	tables     map[string]map[string]struct{} // keys per table, the ones reading from unknown tables are under ""
+	tags       map[string]map[string]struct{} // keys per tag
+}
+
+func newMemoryShard(maxEntries int, policy EvictionPolicy) *memoryShard {
+	s := &memoryShard{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*memoryEntry),
+		tables:     make(map[string]map[string]struct{}),
+		tags:       make(map[string]map[string]struct{}),
+	}
+	if maxEntries > 0 {
+		s.evictor = newEvictor(policy)
+	}
+	return s
+}
+
+func (s *memoryShard) get(key string) ([]byte, bool) {
+	entry, ok := s.entry(key)
+	if !ok {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// entry returns the live entry of the key, entries are never mutated in place so it is safe to read outside the lock
+func (s *memoryShard) entry(key string) (*memoryEntry, bool) {
+	if s.evictor == nil || !s.evictor.tracksReads() {
+		// Without eviction, or with a policy ignoring the reads, there is nothing to track, so readers do not have
+		// to be exclusive
+		s.mu.RLock()
+		entry, ok := s.entries[key]
+		s.mu.RUnlock()
+		if !ok {
+			return nil, false
+		}
+
+		if entry.expired(time.Now()) {
+			s.mu.Lock()
+			if cur, ok := s.entries[key]; ok && cur == entry {
+				s.remove(entry)
+			}
+			s.mu.Unlock()
+			return nil, false
+		}
+		return entry, true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if entry.expired(time.Now()) {
+		s.remove(entry)
+		return nil, false
+	}
+
+	s.evictor.touch(entry)
+	return entry, true
+}
+
+func (s *memoryShard) set(key string, value []byte, expiresAt time.Time, tables, tags []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	replaced, ok := s.entries[key]
+	if ok {
+		// Entries are never mutated in place, since readers may still hold them outside the lock
+		s.remove(replaced)
+	}
+
+	if s.evictor != nil {
+		// The entries are evicted before the new one is tracked, which would otherwise be the first to go with LFU
+		for len(s.entries) >= s.maxEntries {
+			s.remove(s.evictor.victim())
+		}
+	}
+
+	entry := &memoryEntry{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+		tables:    tables,
+		tags:      tags,
+	}
+	s.entries[key] = entry
+	indexKey(s.tables, entry.indexedTables(), key)
+	indexKey(s.tags, entry.tags, key)
+	if s.evictor != nil {
+		s.evictor.add(entry, replaced)
+	}
+}
+
+func (s *memoryShard) remove(entry *memoryEntry) {
+	if s.evictor != nil {
+		s.evictor.remove(entry)
+	}
+	delete(s.entries, entry.key)
+	unindexKey(s.tables, entry.indexedTables(), entry.key)
+	unindexKey(s.tags, entry.tags, entry.key)
+}
+
+func (s *memoryShard) clear() {
+	s.mu.Lock()
+	s.entries = make(map[string]*memoryEntry)
+	if s.evictor != nil {
+		s.evictor.reset()
+	}
+	s.tables = make(map[string]map[string]struct{})
+	s.tags = make(map[string]map[string]struct{})
+	s.mu.Unlock()
+}
+
+func (s *memoryShard) clearTables(tables []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, table := range append([]string{""}, tables...) {
+		for key := range s.tables[table] {
+			if entry, ok := s.entries[key]; ok {
+				s.remove(entry)
+			}
+		}
+	}
+}
+
+func (s *memoryShard) clearTags(tags []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tag := range tags {
+		for key := range s.tags[tag] {
+			if entry, ok := s.entries[key]; ok {
+				s.remove(entry)
+			}
+		}
+	}
+}
+
+func indexKey(index map[string]map[string]struct{}, names []string, key string) {
+	for _, name := range names {
+		keys, ok := index[name]
+		if !ok {
+			keys = make(map[string]struct{})
+			index[name] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func unindexKey(index map[string]map[string]struct{}, names []string, key string) {
+	for _, name := range names {
+		if keys, ok := index[name]; ok {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(index, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package caches
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestMemoryCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("miss", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		res, err := cacher.Get(ctx, "missing", &Query[any]{Dest: &mockDest{}})
+		if err != nil {
+			t.Fatalf("Get resulted into an unexpected error, %v", err)
+		}
+		if res != nil {
+			t.Errorf("Get was expected to return no result for a missing key, got %+v", res)
+		}
+	})
+
+	t.Run("store and get", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		if err := cacher.Store(ctx, "key", &Query[any]{
+			Dest:         &mockDest{Result: "cached"},
+			RowsAffected: 1,
+		}, 0); err != nil {
+			t.Fatalf("Store resulted into an unexpected error, %v", err)
+		}
+
+		res, err := cacher.Get(ctx, "key", &Query[any]{Dest: &mockDest{}})
+		if err != nil {
+			t.Fatalf("Get resulted into an unexpected error, %v", err)
+		}
+		if res == nil {
+			t.Fatal("Get was expected to return the stored value, got none")
+		}
+		if act := res.Dest.(*mockDest).Result; act != "cached" {
+			t.Errorf("Get was expected to return `%s`, got `%s`", "cached", act)
+		}
+		if res.RowsAffected != 1 {
+			t.Errorf("Get was expected to return %d affected rows, got %d", 1, res.RowsAffected)
+		}
+	})
+
+	t.Run("isolation", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		stored := &mockDest{Result: "cached"}
+		_ = cacher.Store(ctx, "key", &Query[any]{Dest: stored}, 0)
+		stored.Result = "mutated after store"
+
+		res, _ := cacher.Get(ctx, "key", &Query[any]{Dest: &mockDest{}})
+		if act := res.Dest.(*mockDest).Result; act != "cached" {
+			t.Errorf("mutating the stored destination was expected not to affect the cache, got `%s`", act)
+		}
+		res.Dest.(*mockDest).Result = "mutated after get"
+
+		res, _ = cacher.Get(ctx, "key", &Query[any]{Dest: &mockDest{}})
+		if act := res.Dest.(*mockDest).Result; act != "cached" {
+			t.Errorf("mutating the returned destination was expected not to affect the cache, got `%s`", act)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		_ = cacher.Store(ctx, "key", &Query[any]{Dest: &mockDest{}}, 100*time.Millisecond)
+		if res, _ := cacher.Get(ctx, "key", &Query[any]{Dest: &mockDest{}}); res == nil {
+			t.Fatal("Get was expected to return the value before it expired")
+		}
+
+		time.Sleep(200 * time.Millisecond)
+		if res, _ := cacher.Get(ctx, "key", &Query[any]{Dest: &mockDest{}}); res != nil {
+			t.Error("Get was expected to return no value after it expired")
+		}
+		if cacher.Len() != 0 {
+			t.Errorf("expired entries were expected to be dropped on read, %d left", cacher.Len())
+		}
+	})
+
+	t.Run("get with expiry", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		_ = cacher.Store(ctx, "key", &Query[any]{Dest: &mockDest{}}, time.Minute)
+		_ = cacher.Store(ctx, "forever", &Query[any]{Dest: &mockDest{}}, 0)
+
+		res, expiresAt, err := cacher.GetWithExpiry(ctx, "key", &Query[any]{Dest: &mockDest{}})
+		if err != nil || res == nil {
+			t.Fatalf("GetWithExpiry was expected to return the stored value, got %+v and %v", res, err)
+		}
+		if until := time.Until(expiresAt); until > time.Minute || until < time.Minute-time.Second {
+			t.Errorf("GetWithExpiry was expected to report the value to expire in %s, got %s", time.Minute, until)
+		}
+		if _, expiresAt, _ := cacher.GetWithExpiry(ctx, "forever", &Query[any]{Dest: &mockDest{}}); expiresAt != UnknownExpiry {
+			t.Errorf("GetWithExpiry was expected to report no expiry for a value stored without ttl, got %s", expiresAt)
+		}
+		if res, _, _ := cacher.GetWithExpiry(ctx, "missing", &Query[any]{Dest: &mockDest{}}); res != nil {
+			t.Errorf("GetWithExpiry was expected to return no result for a missing key, got %+v", res)
+		}
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		for i := 0; i < 10; i++ {
+			_ = cacher.Store(ctx, fmt.Sprintf("key-%d", i), &Query[any]{Dest: &mockDest{}}, 0)
+		}
+
+		if err := cacher.Invalidate(ctx); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+		if cacher.Len() != 0 {
+			t.Errorf("Invalidate was expected to clear all entries, %d left", cacher.Len())
+		}
+	})
+
+	t.Run("invalidate tables", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		_ = cacher.Store(ctx, "users", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}}, 0)
+		_ = cacher.Store(ctx, "users-roles", &Query[any]{Dest: &mockDest{}, Tables: []string{"users", "roles"}}, 0)
+		_ = cacher.Store(ctx, "orders", &Query[any]{Dest: &mockDest{}, Tables: []string{"orders"}}, 0)
+		_ = cacher.Store(ctx, "raw", &Query[any]{Dest: &mockDest{}}, 0)
+
+		if err := cacher.Invalidate(ctx, "roles"); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+
+		for key, exists := range map[string]bool{
+			"users":       true,
+			"users-roles": false,
+			"orders":      true,
+			"raw":         false,
+		} {
+			if res, _ := cacher.Get(ctx, key, &Query[any]{Dest: &mockDest{}}); (res != nil) != exists {
+				t.Errorf("after invalidating the roles table, expected the existence of `%s` to be %t", key, exists)
+			}
+		}
+	})
+
+	t.Run("lru eviction", func(t *testing.T) {
+		cacher := NewMemoryCacher(WithMemoryShards(1), WithMemoryMaxEntries(2))
+		_ = cacher.Store(ctx, "first", &Query[any]{Dest: &mockDest{}}, 0)
+		_ = cacher.Store(ctx, "second", &Query[any]{Dest: &mockDest{}}, 0)
+		_, _ = cacher.Get(ctx, "first", &Query[any]{Dest: &mockDest{}}) // "second" becomes the least recently used
+		_ = cacher.Store(ctx, "third", &Query[any]{Dest: &mockDest{}}, 0)
+
+		if cacher.Len() != 2 {
+			t.Errorf("expected the cacher to hold at most %d entries, got %d", 2, cacher.Len())
+		}
+		if res, _ := cacher.Get(ctx, "second", &Query[any]{Dest: &mockDest{}}); res != nil {
+			t.Error("expected the least recently used entry to be evicted")
+		}
+		for _, key := range []string{"first", "third"} {
+			if res, _ := cacher.Get(ctx, key, &Query[any]{Dest: &mockDest{}}); res == nil {
+				t.Errorf("expected the entry `%s` to be kept", key)
+			}
+		}
+	})
+
+	kept := func(t *testing.T, cacher *MemoryCacher, evicted string, keys ...string) {
+		if cacher.Len() != len(keys) {
+			t.Errorf("expected the cacher to hold %d entries, got %d", len(keys), cacher.Len())
+		}
+		if res, _ := cacher.Get(ctx, evicted, &Query[any]{Dest: &mockDest{}}); res != nil {
+			t.Errorf("expected the entry `%s` to be evicted", evicted)
+		}
+		for _, key := range keys {
+			if res, _ := cacher.Get(ctx, key, &Query[any]{Dest: &mockDest{}}); res == nil {
+				t.Errorf("expected the entry `%s` to be kept", key)
+			}
+		}
+	}
+
+	t.Run("lfu eviction", func(t *testing.T) {
+		cacher := NewMemoryCacher(WithMemoryShards(1), WithMemoryMaxEntries(3), WithMemoryEvictionPolicy(EvictLFU))
+		for _, key := range []string{"hot", "warm", "cold"} {
+			_ = cacher.Store(ctx, key, &Query[any]{Dest: &mockDest{}}, 0)
+		}
+		for i := 0; i < 3; i++ {
+			_, _ = cacher.Get(ctx, "hot", &Query[any]{Dest: &mockDest{}})
+		}
+		// The replaced value keeps the uses of the previous one
+		_ = cacher.Store(ctx, "hot", &Query[any]{Dest: &mockDest{}}, 0)
+		_, _ = cacher.Get(ctx, "warm", &Query[any]{Dest: &mockDest{}})
+		_, _ = cacher.Get(ctx, "cold", &Query[any]{Dest: &mockDest{}})
+		_, _ = cacher.Get(ctx, "warm", &Query[any]{Dest: &mockDest{}})
+
+		// "cold" is the least frequently used entry, even though it was read after "hot"
+		_ = cacher.Store(ctx, "scan", &Query[any]{Dest: &mockDest{}}, 0)
+		kept(t, cacher, "cold", "hot", "warm", "scan")
+
+		// The entries used as often are evicted by recency, "scan" is now used as often as "warm", but more recently
+		_, _ = cacher.Get(ctx, "scan", &Query[any]{Dest: &mockDest{}})
+		_, _ = cacher.Get(ctx, "scan", &Query[any]{Dest: &mockDest{}})
+		_ = cacher.Store(ctx, "next", &Query[any]{Dest: &mockDest{}}, 0)
+		kept(t, cacher, "warm", "hot", "scan", "next")
+	})
+
+	t.Run("ttl eviction", func(t *testing.T) {
+		cacher := NewMemoryCacher(WithMemoryShards(1), WithMemoryMaxEntries(3), WithMemoryEvictionPolicy(EvictTTL))
+		_ = cacher.Store(ctx, "forever", &Query[any]{Dest: &mockDest{}}, 0)
+		_ = cacher.Store(ctx, "hour", &Query[any]{Dest: &mockDest{}}, time.Hour)
+		_ = cacher.Store(ctx, "minute", &Query[any]{Dest: &mockDest{}}, time.Minute)
+		// The reads do not matter
+		for i := 0; i < 3; i++ {
+			_, _ = cacher.Get(ctx, "minute", &Query[any]{Dest: &mockDest{}})
+		}
+
+		_ = cacher.Store(ctx, "day", &Query[any]{Dest: &mockDest{}}, 24*time.Hour)
+		kept(t, cacher, "minute", "forever", "hour", "day")
+
+		// The entries without a ttl are evicted last, in the order they were stored
+		_ = cacher.Store(ctx, "forever too", &Query[any]{Dest: &mockDest{}}, 0)
+		_ = cacher.Store(ctx, "forever again", &Query[any]{Dest: &mockDest{}}, 0)
+		_ = cacher.Store(ctx, "forever at last", &Query[any]{Dest: &mockDest{}}, 0)
+		kept(t, cacher, "forever", "forever too", "forever again", "forever at last")
+	})
+}
+
+// naiveMapCacher guards a single map with a single mutex, it is used as a baseline for the benchmarks
+type naiveMapCacher struct {
+	mu    sync.Mutex
+	store map[string][]byte
+}
+
+func (c *naiveMapCacher) Get(_ context.Context, key string, q *Query[any]) (*Query[any], error) {
+	c.mu.Lock()
+	val, ok := c.store[key]
+	c.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	if err := q.Unmarshal(val); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (c *naiveMapCacher) Store(_ context.Context, key string, val *Query[any], _ time.Duration) error {
+	res, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.store[key] = res
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *naiveMapCacher) Invalidate(context.Context, ...string) error {
+	c.mu.Lock()
+	c.store = make(map[string][]byte)
+	c.mu.Unlock()
+	return nil
+}
+
+func benchmarkCacherQuery(b *testing.B, cacher Cacher) {
+	caches := &Caches{
+		Conf: &Config{
+			Cacher: cacher,
+		},
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
+			},
+		},
+	}
+
+	var seq int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		for pb.Next() {
+			db.Statement.Dest = &mockDest{}
+			db.Statement.SQL.Reset()
+			db.Statement.SQL.WriteString(fmt.Sprintf("demo-query-%d", atomic.AddInt64(&seq, 1)%1024))
+			caches.query(db)
+		}
+	})
+}
+
+func BenchmarkMemoryCacher_query(b *testing.B) {
+	b.Run("naive map", func(b *testing.B) {
+		benchmarkCacherQuery(b, &naiveMapCacher{store: make(map[string][]byte)})
+	})
+	b.Run("memory cacher", func(b *testing.B) {
+		benchmarkCacherQuery(b, NewMemoryCacher())
+	})
+	b.Run("memory cacher with lru", func(b *testing.B) {
+		benchmarkCacherQuery(b, NewMemoryCacher(WithMemoryMaxEntries(512)))
+	})
+	b.Run("memory cacher with lfu", func(b *testing.B) {
+		benchmarkCacherQuery(b, NewMemoryCacher(WithMemoryMaxEntries(512), WithMemoryEvictionPolicy(EvictLFU)))
+	})
+	b.Run("memory cacher with ttl", func(b *testing.B) {
+		benchmarkCacherQuery(b, NewMemoryCacher(WithMemoryMaxEntries(512), WithMemoryEvictionPolicy(EvictTTL)))
+	})
+}
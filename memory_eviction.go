@@ -0,0 +1,173 @@
+package caches
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// EvictionPolicy tells which entries a MemoryCacher capped by WithMemoryMaxEntries evicts first
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used entries first, it is the default
+	EvictLRU EvictionPolicy = iota
+	// EvictLFU evicts the least frequently used entries first, and the least recently used ones among the entries
+	// used as often, so a set of hot keys survives the scans of many keys read once. A replaced value keeps the count
+	// of the value it replaces
+	EvictLFU
+	// EvictTTL evicts the entries closest to their expiry first, and the ones without a ttl last, in the order they
+	// were stored. The reads are not tracked, so they do not lock the shard exclusively
+	EvictTTL
+)
+
+// evictor tracks the entries of a shard to pick the one to evict, it is called under the shard's lock
+type evictor interface {
+	// add tracks the entry, replaced being the entry of the same key it replaces, if any
+	add(entry, replaced *memoryEntry)
+	// touch records a read of the entry
+	touch(entry *memoryEntry)
+	remove(entry *memoryEntry)
+	// victim returns the entry to evict first, nil when none is tracked
+	victim() *memoryEntry
+	reset()
+	// tracksReads tells whether touch has to be called, the reads of the other evictors are not exclusive
+	tracksReads() bool
+}
+
+func newEvictor(policy EvictionPolicy) evictor {
+	switch policy {
+	case EvictLFU:
+		return &heapEvictor{less: lessFrequentlyUsed, countsReads: true}
+	case EvictTTL:
+		return &heapEvictor{less: expiresSooner}
+	default:
+		return &lruEvictor{recency: list.New()}
+	}
+}
+
+// lruEvictor keeps the entries by recency, the most recently used ones at the front
+type lruEvictor struct {
+	recency *list.List
+}
+
+func (e *lruEvictor) add(entry, _ *memoryEntry) {
+	entry.element = e.recency.PushFront(entry)
+}
+
+func (e *lruEvictor) touch(entry *memoryEntry) {
+	e.recency.MoveToFront(entry.element)
+}
+
+func (e *lruEvictor) remove(entry *memoryEntry) {
+	e.recency.Remove(entry.element)
+}
+
+func (e *lruEvictor) victim() *memoryEntry {
+	if back := e.recency.Back(); back != nil {
+		return back.Value.(*memoryEntry)
+	}
+	return nil
+}
+
+func (e *lruEvictor) reset() {
+	e.recency.Init()
+}
+
+func (e *lruEvictor) tracksReads() bool {
+	return true
+}
+
+// heapEvictor keeps the entries in a min heap ordered by less, the entry to evict first at its root
+type heapEvictor struct {
+	entries     []*memoryEntry
+	less        func(a, b *memoryEntry) bool
+	countsReads bool
+	clock       uint64 // orders the uses of the entries
+}
+
+func lessFrequentlyUsed(a, b *memoryEntry) bool {
+	if a.uses != b.uses {
+		return a.uses < b.uses
+	}
+	return a.usedAt < b.usedAt
+}
+
+func expiresSooner(a, b *memoryEntry) bool {
+	switch {
+	case a.expiresAt.IsZero() != b.expiresAt.IsZero():
+		return b.expiresAt.IsZero()
+	case !a.expiresAt.Equal(b.expiresAt):
+		return a.expiresAt.Before(b.expiresAt)
+	default:
+		return a.usedAt < b.usedAt
+	}
+}
+
+func (e *heapEvictor) add(entry, replaced *memoryEntry) {
+	e.clock++
+	entry.usedAt = e.clock
+	entry.uses = 1
+	if replaced != nil && e.countsReads {
+		entry.uses += replaced.uses
+	}
+	heap.Push(e, entry)
+}
+
+func (e *heapEvictor) touch(entry *memoryEntry) {
+	if !e.countsReads {
+		return
+	}
+	e.clock++
+	entry.usedAt = e.clock
+	entry.uses++
+	heap.Fix(e, entry.index)
+}
+
+func (e *heapEvictor) remove(entry *memoryEntry) {
+	heap.Remove(e, entry.index)
+}
+
+func (e *heapEvictor) victim() *memoryEntry {
+	if len(e.entries) == 0 {
+		return nil
+	}
+	return e.entries[0]
+}
+
+func (e *heapEvictor) reset() {
+	e.entries = nil
+}
+
+func (e *heapEvictor) tracksReads() bool {
+	return e.countsReads
+}
+
+// Len, Less, Swap, Push and Pop implement heap.Interface, they are only called by the heap package
+
+func (e *heapEvictor) Len() int {
+	return len(e.entries)
+}
+
+func (e *heapEvictor) Less(i, j int) bool {
+	return e.less(e.entries[i], e.entries[j])
+}
+
+func (e *heapEvictor) Swap(i, j int) {
+	e.entries[i], e.entries[j] = e.entries[j], e.entries[i]
+	e.entries[i].index = i
+	e.entries[j].index = j
+}
+
+func (e *heapEvictor) Push(x any) {
+	entry := x.(*memoryEntry)
+	entry.index = len(e.entries)
+	e.entries = append(e.entries, entry)
+}
+
+func (e *heapEvictor) Pop() any {
+	n := len(e.entries) - 1
+	entry := e.entries[n]
+	e.entries[n] = nil
+	e.entries = e.entries[:n]
+	return entry
+}
@@ -0,0 +1,100 @@
+package caches
+
+import "time"
+
+// MetricsObserver is notified about the cache operations as they happen,
+// the table is the one of the parsed statement and may be empty for raw queries
+// Its methods are called from the concurrently running queries, so implementations must be safe for concurrent use
+type MetricsObserver interface {
+	// OnHit is called when a query is served from the Cacher
+	OnHit(table string)
+	// OnMiss is called when a query was looked up in the Cacher without finding a valid value
+	OnMiss(table string)
+	// OnStore is called when a query result was stored in the Cacher, size is the number of rows it affected
+	OnStore(table string, size int)
+	// OnEaseCoalesced is called when the easer served a query from an identical query running at the same time
+	OnEaseCoalesced(table string)
+}
+
+// SkipReason tells why a query result was not stored
+type SkipReason string
+
+const (
+	// SkipOversized is reported for the results exceeding MaxCacheRows or MaxCacheBytes
+	SkipOversized SkipReason = "oversized"
+	// SkipDropped is reported for the results dropped since the AsyncStore queue was full
+	SkipDropped SkipReason = "dropped"
+	// SkipPredicate is reported for the results the CachePredicate returned false for
+	SkipPredicate SkipReason = "predicate"
+	// SkipFast is reported for the results of the queries faster than MinQueryDuration
+	SkipFast SkipReason = "fast"
+	// SkipCacher is reported for the results the Cacher's Store refused with ErrSkipCache
+	SkipCacher SkipReason = "cacher"
+)
+
+// SkipObserver is an optional extension of MetricsObserver, notified when a query result is not stored
+type SkipObserver interface {
+	OnSkipStore(table string, reason SkipReason)
+}
+
+// SavedDurationObserver is an optional extension of MetricsObserver, notified on every hit with how long the query
+// it served took to run against the database when it was stored, see Config.RecordQueryDuration
+type SavedDurationObserver interface {
+	OnHitSaved(table string, saved time.Duration)
+}
+
+// CompressionObserver is an optional extension of MetricsObserver, notified when a query result is compressed
+// with the configured Compressor, the sizes are in bytes
+type CompressionObserver interface {
+	OnCompress(table string, original, compressed int)
+}
+
+// PayloadObserver is an optional extension of MetricsObserver, notified with the size in bytes of the payloads
+// of the query results stored, as produced by Query.Marshal once compressed, so only the Cachers relying on it
+// report them, once per Marshal, e.g. twice for a TieredCacher whose cachers both serialize the values
+type PayloadObserver interface {
+	OnPayloadSize(table string, bytes int)
+}
+
+// ErrorObserver is an optional extension of MetricsObserver, notified about the errors of the Cacher's Get, Store and
+// Invalidate along with the statement's table, including the ones of the asynchronous stores. The errors of the
+// Cacher are wrapped into a *CacheOpError, the ones of the write-back flushes are the database's.
+type ErrorObserver interface {
+	OnCacheError(table string, err error)
+}
+
+// CircuitObserver is an optional extension of MetricsObserver, notified about the state changes of a
+// CircuitBreakerCacher when it is passed to WithCircuitBreakerObserver
+// It is called while the state of the circuit is locked, so it must not call the CircuitBreakerCacher
+type CircuitObserver interface {
+	OnCircuitStateChange(state CircuitState)
+}
+
+// CacherOperation is the operation of a CacherCall
+type CacherOperation string
+
+const (
+	CacherGet        CacherOperation = "get"
+	CacherStore      CacherOperation = "store"
+	CacherInvalidate CacherOperation = "invalidate"
+)
+
+// CacherCall describes an operation of a Cacher decorated by WithMetrics
+type CacherCall struct {
+	Operation CacherOperation
+	// Keys are the keys which were looked up or stored, several ones for the operations of a BatchCacher
+	Keys []string
+	// Tables and Tags are the ones which were invalidated, neither is set when the whole Cacher was
+	Tables []string
+	Tags   []string
+	// Hit reports whether a lookup found a value, for any of its keys
+	Hit     bool
+	Latency time.Duration
+	Err     error
+}
+
+// CacherObserver is notified about the operations of a Cacher decorated by WithMetrics, once they returned
+// Its method is called from the concurrently running queries, so implementations must be safe for concurrent use
+type CacherObserver interface {
+	OnCacherCall(call CacherCall)
+}
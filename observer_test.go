@@ -0,0 +1,257 @@
+package caches
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type observerMock struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (o *observerMock) record(event string) {
+	o.mu.Lock()
+	o.events = append(o.events, event)
+	o.mu.Unlock()
+}
+
+func (o *observerMock) OnHit(table string) {
+	o.record("hit:" + table)
+}
+
+func (o *observerMock) OnMiss(table string) {
+	o.record("miss:" + table)
+}
+
+func (o *observerMock) OnStore(table string, size int) {
+	o.record(fmt.Sprintf("store:%s:%d", table, size))
+}
+
+func (o *observerMock) OnEaseCoalesced(table string) {
+	o.record("coalesced:" + table)
+}
+
+func (o *observerMock) OnSkipStore(table string, reason SkipReason) {
+	o.record(fmt.Sprintf("skip:%s:%s", table, reason))
+}
+
+func TestCaches_Observer(t *testing.T) {
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.Table = "users"
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	t.Run("cacher", func(t *testing.T) {
+		observer := &observerMock{}
+		caches := &Caches{
+			Conf: &Config{
+				Cacher:   &cacherMock{},
+				Observer: observer,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					db.Statement.RowsAffected = 3
+				},
+			},
+		}
+
+		caches.query(newDB())
+		caches.query(newDB())
+
+		expected := []string{"miss:users", "store:users:3", "hit:users"}
+		if !reflect.DeepEqual(observer.events, expected) {
+			t.Errorf("expected the observer to be notified with %v, got %v", expected, observer.events)
+		}
+	})
+
+	t.Run("easer", func(t *testing.T) {
+		observer := &observerMock{}
+		caches := &Caches{
+			Conf: &Config{
+				Easer:    true,
+				Observer: observer,
+			},
+			queue: newEaseQueue(),
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					time.Sleep(500 * time.Millisecond)
+				},
+			},
+		}
+
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			caches.query(newDB())
+			wg.Done()
+		}()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			caches.query(newDB())
+			wg.Done()
+		}()
+		wg.Wait()
+
+		expected := []string{"coalesced:users"}
+		if !reflect.DeepEqual(observer.events, expected) {
+			t.Errorf("expected the observer to be notified with %v, got %v", expected, observer.events)
+		}
+	})
+}
+
+// payloadObserverMock is an observerMock which is a PayloadObserver, and a CompressionObserver recording the sizes
+type payloadObserverMock struct {
+	observerMock
+	payloads   []int
+	compressed []int
+}
+
+func (o *payloadObserverMock) OnPayloadSize(table string, bytes int) {
+	o.record("payload:" + table)
+	o.mu.Lock()
+	o.payloads = append(o.payloads, bytes)
+	o.mu.Unlock()
+}
+
+func (o *payloadObserverMock) OnCompress(_ string, _, compressed int) {
+	o.mu.Lock()
+	o.compressed = append(o.compressed, compressed)
+	o.mu.Unlock()
+}
+
+func TestCaches_PayloadObserver(t *testing.T) {
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &[]mockDest{{Result: strings.Repeat("a", 1000)}}
+		db.Statement.Table = "users"
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+	newCaches := func(observer MetricsObserver, compressor Compressor) *Caches {
+		return &Caches{
+			Conf: &Config{Cacher: NewMemoryCacher(), Observer: observer, Compressor: compressor},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					db.Statement.RowsAffected = 1
+				},
+			},
+		}
+	}
+
+	t.Run("serialized sizes", func(t *testing.T) {
+		observer := &payloadObserverMock{}
+		caches := newCaches(observer, nil)
+		caches.query(newDB())
+		caches.query(newDB())
+
+		expected := []string{"miss:users", "payload:users", "store:users:1", "hit:users"}
+		if !reflect.DeepEqual(observer.events, expected) {
+			t.Errorf("expected the observer to be notified with %v, got %v", expected, observer.events)
+		}
+		if len(observer.payloads) != 1 || observer.payloads[0] < 1000 {
+			t.Errorf("expected the size of the serialized result to be reported, got %v", observer.payloads)
+		}
+	})
+
+	t.Run("compressed sizes", func(t *testing.T) {
+		observer := &payloadObserverMock{}
+		caches := newCaches(observer, GzipCompressor{})
+		caches.query(newDB())
+
+		if !reflect.DeepEqual(observer.payloads, observer.compressed) || len(observer.payloads) != 1 {
+			t.Errorf("expected the size of the compressed result to be reported, got %v rather than %v",
+				observer.payloads, observer.compressed)
+		}
+	})
+
+	t.Run("without payload observer", func(t *testing.T) {
+		caches := newCaches(&observerMock{}, nil)
+		if codec := caches.storeCodecOf(newDB()); codec != nil {
+			t.Errorf("expected no codec to be attached to the stored queries, got %+v", codec)
+		}
+	})
+}
+
+func (o *observerMock) OnCompress(table string, original, compressed int) {
+	o.record(fmt.Sprintf("compress:%s:%t", table, compressed > 0 && original > 0))
+}
+
+func (o *observerMock) OnCacheError(table string, err error) {
+	o.record(fmt.Sprintf("error:%s:%v", table, err))
+}
+
+func (o *observerMock) OnCircuitStateChange(state CircuitState) {
+	o.record(fmt.Sprintf("circuit:%s", state))
+}
+
+func (o *observerMock) OnHitSaved(table string, saved time.Duration) {
+	o.record(fmt.Sprintf("saved:%s:%t", table, saved >= 5*time.Millisecond))
+}
+
+func TestCaches_RecordQueryDuration(t *testing.T) {
+	newDB := func(t *testing.T, conf *Config) *gorm.DB {
+		caches := &Caches{Conf: conf}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			time.Sleep(5 * time.Millisecond)
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: "from-database"}}
+			db.Statement.RowsAffected = 1
+		}
+		return db
+	}
+	find := func(t *testing.T, db *gorm.DB) {
+		var rows []mockDest
+		if err := db.Table("users").Find(&rows).Error; err != nil || len(rows) != 1 || rows[0].Result != "from-database" {
+			t.Fatalf("the query resulted into unexpected rows %+v or error %v", rows, err)
+		}
+	}
+
+	for name, serializer := range map[string]Serializer{"json": nil, "gob": GobSerializer{}} {
+		t.Run(name, func(t *testing.T) {
+			// Both processes share the backend, the hit of the second one is served the value the first one stored
+			cacher, _ := newTestRedisCacher(t)
+			find(t, newDB(t, &Config{Cacher: cacher, Serializer: serializer, RecordQueryDuration: true}))
+
+			observer := &observerMock{}
+			find(t, newDB(t, &Config{Cacher: cacher, Serializer: serializer, Observer: observer, RecordQueryDuration: true}))
+			if expected := []string{"hit:users", "saved:users:true"}; !reflect.DeepEqual(observer.events, expected) {
+				t.Errorf("expected the hit to report the duration of the query, got %v rather than %v", observer.events, expected)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		cacher := &cacherMock{}
+		observer := &observerMock{}
+		db := newDB(t, &Config{Cacher: cacher, Observer: observer})
+		find(t, db)
+		find(t, db)
+
+		cacher.store.Range(func(_, value any) bool {
+			if q := value.(*Query[any]); q.QueryDuration != 0 {
+				t.Errorf("expected the duration not to be stored, got %s", q.QueryDuration)
+			}
+			return true
+		})
+		if expected := []string{"miss:users", "store:users:1", "hit:users"}; !reflect.DeepEqual(observer.events, expected) {
+			t.Errorf("expected no saved duration to be reported, got %v rather than %v", observer.events, expected)
+		}
+	})
+}
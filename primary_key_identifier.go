@@ -0,0 +1,134 @@
+package caches
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// primaryKeyIdentifier returns the key of a lookup of a single row by its primary key, see
+// Config.PrimaryKeyIdentifiers, it is not one when the second result is false
+//
+// The lookups are the queries of the model's own type, e.g. `First(&user, 1)`, `Take(&user, "id = ?", 1)` or
+// `Where("id = ?", 1).Find(&user)`, whose only other clauses are the LIMIT and the primary key ORDER BY of
+// First, Take and Last, which cannot change the row they read. The key is built from the clauses
+// before gorm renders them, so the SQL of a hit is never built.
+func primaryKeyIdentifier(db *gorm.DB) (string, bool) {
+	stmt := db.Statement
+	if stmt.Schema == nil || len(stmt.Schema.PrimaryFields) != 1 || stmt.SQL.Len() > 0 || stmt.Distinct ||
+		len(stmt.Selects) > 0 || len(stmt.Omits) > 0 || len(stmt.Joins) > 0 {
+		return "", false
+	}
+	// db.Table sets the expression of a plain table name as well, the other expressions, e.g. aliases, are not keyed
+	if e := stmt.TableExpr; e != nil && (len(e.Vars) > 0 || e.SQL != stmt.Quote(stmt.Table)) {
+		return "", false
+	}
+	// A single row of the model is read, rather than a slice or the columns of another type
+	if reflect.TypeOf(stmt.Dest) != reflect.PtrTo(stmt.Schema.ModelType) {
+		return "", false
+	}
+
+	field := stmt.Schema.PrimaryFields[0]
+	// gorm adds the primary key of a model holding one to the conditions
+	if v := stmt.ReflectValue; v.Kind() == reflect.Struct && v.Type() == stmt.Schema.ModelType {
+		if _, isZero := field.ValueOf(stmt.Context, v); !isZero {
+			return "", false
+		}
+	}
+
+	// The soft delete condition is the only query clause of a model known not to depend on the statement
+	for _, c := range stmt.Schema.QueryClauses {
+		if _, ok := c.(gorm.SoftDeleteQueryClause); !ok {
+			return "", false
+		}
+	}
+
+	var (
+		value any
+		found bool
+	)
+	for name, c := range stmt.Clauses {
+		switch name {
+		case "WHERE":
+			where, ok := c.Expression.(clause.Where)
+			if !ok || len(where.Exprs) != 1 {
+				return "", false
+			}
+			if value, found = pinnedPrimaryKey(stmt, where.Exprs[0]); !found {
+				return "", false
+			}
+		case "LIMIT":
+			limit, ok := c.Expression.(clause.Limit)
+			if !ok || limit.Offset != 0 || (limit.Limit != nil && *limit.Limit <= 0) {
+				return "", false
+			}
+		case "ORDER BY":
+			orderBy, ok := c.Expression.(clause.OrderBy)
+			if !ok || orderBy.Expression != nil {
+				return "", false
+			}
+			for _, column := range orderBy.Columns {
+				if !isPrimaryColumn(stmt, field, column.Column, "") {
+					return "", false
+				}
+			}
+		default:
+			return "", false
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(stmt.Table) + 16)
+	sb.WriteString(stmt.Table)
+	sb.WriteString(":pk:")
+	_, _ = fmt.Fprint(&sb, value)
+	if stmt.Unscoped && len(stmt.Schema.QueryClauses) > 0 {
+		// The soft deleted rows are only read by the Unscoped lookups
+		sb.WriteString(":unscoped")
+	}
+	return sb.String(), true
+}
+
+// pinnedPrimaryKey returns the single value the condition pins the primary key of the statement to, when it is
+// an integer or a string
+func pinnedPrimaryKey(stmt *gorm.Statement, expr clause.Expression) (any, bool) {
+	field := stmt.Schema.PrimaryFields[0]
+	var value any
+	switch e := expr.(type) {
+	case clause.Eq:
+		if !isPrimaryColumn(stmt, field, e.Column, "") {
+			return nil, false
+		}
+		value = e.Value
+	case clause.IN:
+		if !isPrimaryColumn(stmt, field, e.Column, "") || len(e.Values) != 1 {
+			return nil, false
+		}
+		value = e.Values[0]
+	case clause.Expr:
+		m := pkConditionPattern.FindStringSubmatch(e.SQL)
+		if m == nil || len(e.Vars) != 1 || !isPrimaryColumn(stmt, field, m[2], m[1]) {
+			return nil, false
+		}
+		if !(m[3] == "=" && m[4] == "?" || m[4] != "?") {
+			return nil, false
+		}
+		value = e.Vars[0]
+	default:
+		return nil, false
+	}
+
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.String:
+		return value, true
+	default:
+		return nil, false
+	}
+}
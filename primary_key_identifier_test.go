@@ -0,0 +1,257 @@
+package caches
+
+import (
+	"context"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
+)
+
+type pkIdentifierUser struct {
+	ID   uint
+	Name string
+}
+
+func (pkIdentifierUser) TableName() string {
+	return "users"
+}
+
+type pkIdentifierPost struct {
+	ID        string
+	Title     string
+	DeletedAt gorm.DeletedAt
+}
+
+func (pkIdentifierPost) TableName() string {
+	return "posts"
+}
+
+type pkIdentifierMembership struct {
+	TeamID uint `gorm:"primaryKey"`
+	UserID uint `gorm:"primaryKey"`
+}
+
+func newPrimaryKeyIdentifierCaches(t testing.TB, conf *Config) (*Caches, *gorm.DB) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if conf.Cacher == nil {
+		conf.Cacher = NewMemoryCacher()
+	}
+	conf.PrimaryKeyIdentifiers = true
+	caches := &Caches{Conf: conf}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	return caches, db
+}
+
+func TestCaches_PrimaryKeyIdentifiers(t *testing.T) {
+	caches, db := newPrimaryKeyIdentifierCaches(t, &Config{})
+	identifier := func(t *testing.T, query func(*gorm.DB) *gorm.DB) string {
+		id, err := caches.Identifier(db, query)
+		if err != nil {
+			t.Fatalf("the identifier resulted into an unexpected error, %v", err)
+		}
+		return id
+	}
+
+	t.Run("lookups", func(t *testing.T) {
+		testCases := map[string]struct {
+			query    func(*gorm.DB) *gorm.DB
+			expected string
+		}{
+			"first": {
+				query:    func(db *gorm.DB) *gorm.DB { return db.First(&pkIdentifierUser{}, 1) },
+				expected: "users:pk:1",
+			},
+			"take": {
+				query:    func(db *gorm.DB) *gorm.DB { return db.Take(&pkIdentifierUser{}, "id = ?", 2) },
+				expected: "users:pk:2",
+			},
+			"last": {
+				query:    func(db *gorm.DB) *gorm.DB { return db.Last(&pkIdentifierUser{}, 3) },
+				expected: "users:pk:3",
+			},
+			"where": {
+				query:    func(db *gorm.DB) *gorm.DB { return db.Where("`users`.`id` = ?", 4).Find(&pkIdentifierUser{}) },
+				expected: "users:pk:4",
+			},
+			"struct conditions": {
+				query:    func(db *gorm.DB) *gorm.DB { return db.Where(&pkIdentifierUser{ID: 5}).First(&pkIdentifierUser{}) },
+				expected: "users:pk:5",
+			},
+			"table": {
+				query: func(db *gorm.DB) *gorm.DB {
+					return db.Table("archived_users").First(&pkIdentifierUser{}, 6)
+				},
+				expected: "archived_users:pk:6",
+			},
+			"soft delete": {
+				query:    func(db *gorm.DB) *gorm.DB { return db.First(&pkIdentifierPost{}, "id = ?", "a:b") },
+				expected: "posts:pk:a:b",
+			},
+			"unscoped": {
+				query:    func(db *gorm.DB) *gorm.DB { return db.Unscoped().First(&pkIdentifierPost{}, "id = ?", "a:b") },
+				expected: "posts:pk:a:b:unscoped",
+			},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				if act := identifier(t, tc.query); act != IdentifierPrefix+tc.expected {
+					t.Errorf("expected the lookup to be identified by %s, got %s", IdentifierPrefix+tc.expected, act)
+				}
+			})
+		}
+	})
+
+	t.Run("other queries", func(t *testing.T) {
+		one := 1
+		testCases := map[string]func(*gorm.DB) *gorm.DB{
+			"slice":    func(db *gorm.DB) *gorm.DB { return db.Find(&[]pkIdentifierUser{}, 1) },
+			"no where": func(db *gorm.DB) *gorm.DB { return db.First(&pkIdentifierUser{}) },
+			"other column": func(db *gorm.DB) *gorm.DB {
+				return db.First(&pkIdentifierUser{}, "name = ?", "jinzhu")
+			},
+			"several conditions": func(db *gorm.DB) *gorm.DB {
+				return db.Where("id = ?", 1).Where("name = ?", "jinzhu").First(&pkIdentifierUser{})
+			},
+			"and":     func(db *gorm.DB) *gorm.DB { return db.First(&pkIdentifierUser{}, "id = ? AND name = ?", 1, "jinzhu") },
+			"in list": func(db *gorm.DB) *gorm.DB { return db.First(&pkIdentifierUser{}, []int{1, 2}) },
+			"offset":  func(db *gorm.DB) *gorm.DB { return db.Offset(1).First(&pkIdentifierUser{}, 1) },
+			"zero limit": func(db *gorm.DB) *gorm.DB {
+				return db.Limit(0).Find(&pkIdentifierUser{}, 1)
+			},
+			"order":  func(db *gorm.DB) *gorm.DB { return db.Order("name").First(&pkIdentifierUser{}, 1) },
+			"select": func(db *gorm.DB) *gorm.DB { return db.Select("name").First(&pkIdentifierUser{}, 1) },
+			"joins": func(db *gorm.DB) *gorm.DB {
+				return db.Joins("JOIN teams ON teams.id = users.id").First(&pkIdentifierUser{}, 1)
+			},
+			"clauses": func(db *gorm.DB) *gorm.DB {
+				return db.Clauses(clause.GroupBy{Columns: []clause.Column{{Name: "id"}}}).First(&pkIdentifierUser{}, 1)
+			},
+			"other type": func(db *gorm.DB) *gorm.DB {
+				return db.Model(&pkIdentifierUser{}).Where("id = ?", 1).Take(&struct{ Name string }{})
+			},
+			"primary key of the model": func(db *gorm.DB) *gorm.DB {
+				return db.First(&pkIdentifierUser{ID: 2}, 1)
+			},
+			"composite key": func(db *gorm.DB) *gorm.DB {
+				return db.First(&pkIdentifierMembership{}, "team_id = ?", 1)
+			},
+			"alias": func(db *gorm.DB) *gorm.DB {
+				return db.Table("users u").First(&pkIdentifierUser{}, 1)
+			},
+			"raw": func(db *gorm.DB) *gorm.DB {
+				return db.Raw("SELECT * FROM users WHERE id = ?", 1).Find(&pkIdentifierUser{})
+			},
+			"limit": func(db *gorm.DB) *gorm.DB {
+				return db.Limit(one).Find(&pkIdentifierUser{}, "id > ?", 1)
+			},
+		}
+
+		for name, query := range testCases {
+			t.Run(name, func(t *testing.T) {
+				if act := identifier(t, query); len(act) != len(IdentifierPrefix)+32 {
+					t.Errorf("expected the query to keep its default identifier, got %s", act)
+				}
+			})
+		}
+	})
+
+	t.Run("scoped", func(t *testing.T) {
+		type tenantKey struct{}
+		caches, db := newPrimaryKeyIdentifierCaches(t, &Config{
+			KeyPrefix:      "app:",
+			Database:       "main",
+			KeyContextKeys: []any{tenantKey{}},
+		})
+		act, err := caches.Identifier(db.WithContext(context.WithValue(context.Background(), tenantKey{}, "acme")),
+			func(db *gorm.DB) *gorm.DB { return db.First(&pkIdentifierUser{}, 1) })
+		if err != nil {
+			t.Fatalf("the identifier resulted into an unexpected error, %v", err)
+		}
+		if expected := "app:" + IdentifierPrefix + "main:" + scopeKey("users:pk:1", []string{"string(acme)"}); act != expected {
+			t.Errorf("expected the key to be scoped as the custom keys are, got %s rather than %s", act, expected)
+		}
+
+		hashed, db := newPrimaryKeyIdentifierCaches(t, &Config{HashKeys: true})
+		if act, _ := hashed.Identifier(db, func(db *gorm.DB) *gorm.DB { return db.First(&pkIdentifierUser{}, 1) }); act != hashKey("users:pk:1") {
+			t.Errorf("expected the key to be digested with HashKeys, got %s", act)
+		}
+
+		custom, db := newPrimaryKeyIdentifierCaches(t, &Config{KeyBuilder: func(db *gorm.DB) string { return "custom" }})
+		if act, _ := custom.Identifier(db, func(db *gorm.DB) *gorm.DB { return db.First(&pkIdentifierUser{}, 1) }); act != IdentifierPrefix+"custom" {
+			t.Errorf("expected the KeyBuilder to take precedence, got %s", act)
+		}
+	})
+
+	t.Run("hits", func(t *testing.T) {
+		caches, db := newPrimaryKeyIdentifierCaches(t, &Config{})
+		var executed int
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			executed++
+			db.Statement.Dest.(*pkIdentifierUser).Name = "jinzhu"
+			db.Statement.RowsAffected = 1
+		}
+
+		for i := 0; i < 2; i++ {
+			var user pkIdentifierUser
+			tx := db.First(&user, 1)
+			if tx.Error != nil || user.Name != "jinzhu" {
+				t.Fatalf("expected the lookup to return the user, got %+v and %v", user, tx.Error)
+			}
+			if i == 1 && tx.Statement.SQL.Len() != 0 {
+				t.Errorf("expected the SQL of the hit not to be built, got %s", tx.Statement.SQL.String())
+			}
+		}
+		if executed != 1 {
+			t.Errorf("expected the second lookup to hit the cache, the database was queried %d times", executed)
+		}
+		if ok, _ := caches.Conf.Cacher.Get(context.Background(), IdentifierPrefix+"users:pk:1", &Query[any]{Dest: &pkIdentifierUser{}}); ok == nil {
+			t.Error("expected the lookup to be cached under its primary key identifier")
+		}
+	})
+
+	t.Run("invalidate all", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t)
+		caches, db := newPrimaryKeyIdentifierCaches(t, &Config{Cacher: cacher})
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			db.Statement.RowsAffected = 1
+		}
+		db.First(&pkIdentifierUser{}, 1)
+		if !srv.Exists(IdentifierPrefix + "users:pk:1") {
+			t.Fatalf("expected the lookup to be stored, got %v", srv.Keys())
+		}
+
+		if err := cacher.Invalidate(context.Background()); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+		if keys := srv.Keys(); len(keys) != 0 {
+			t.Errorf("expected the full invalidation to delete the primary key identifiers, left %v", keys)
+		}
+	})
+}
+
+func BenchmarkCaches_PrimaryKeyIdentifiers(b *testing.B) {
+	for name, enabled := range map[string]bool{"default": false, "primary key": true} {
+		b.Run(name, func(b *testing.B) {
+			caches, db := newPrimaryKeyIdentifierCaches(b, &Config{})
+			caches.Conf.PrimaryKeyIdentifiers = enabled
+			caches.callbacks[uponQuery] = func(db *gorm.DB) {
+				db.Statement.RowsAffected = 1
+			}
+			db.First(&pkIdentifierUser{}, 1)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				db.First(&pkIdentifierUser{}, 1)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package caches
 
 import (
 	"encoding/json"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -9,14 +10,101 @@ import (
 type Query[T any] struct {
 	Dest         T
 	RowsAffected int64
+	// ExpiresAt is the absolute expiry of the cached entry, the zero value means it never expires
+	// Backends without native expiry can rely on it, since expired entries are treated as misses
+	ExpiresAt time.Time
+	// StaleAt is the instant the cached entry goes stale, it is only set when Config.StaleWhileRevalidate is,
+	// in which case ExpiresAt is extended by it and a stale entry is still served while it is revalidated
+	StaleAt time.Time `json:",omitempty"`
+	// RefreshAt is the instant a hit on the cached entry refreshes it in the background, it is only set when
+	// Config.RefreshAheadFactor is, so a hot entry is refreshed before it expires
+	RefreshAt time.Time `json:",omitempty"`
+	// StoredAt is the instant the entry was stored, it is only set when Config.ExposeMetadata is,
+	// so that the age of the values served from the cache can be reported to the caller
+	StoredAt time.Time `json:",omitempty"`
+	// QueryDuration is how long the query took to run against the database, it is only set when
+	// Config.RecordQueryDuration is, so that the hits can report the time they saved
+	QueryDuration time.Duration `json:",omitempty"`
+	// Tables lists the tables the query reads from, it is only set on Store so backends can scope invalidation
+	// A nil value means the tables are unknown, and the value should be invalidated upon any table change
+	Tables []string `json:"-"`
+	// Tags are the tags of the query, they are only set on Store when a Tagger is configured,
+	// so backends implementing TagInvalidator can scope invalidation further, see Config.Tagger
+	Tags []string `json:"-"`
+
+	codec *queryCodec
 }
 
+// Marshal encodes the query to JSON, or with the Serializer the plugin is configured with,
+// compressing it when the plugin is configured with a Compressor
 func (q *Query[T]) Marshal() ([]byte, error) {
-	return json.Marshal(q)
+	var (
+		bytes []byte
+		err   error
+	)
+	if s := q.codec.serializerOf(); s != nil {
+		bytes, err = s.Marshal(q.header())
+	} else {
+		bytes, err = json.Marshal(q)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes, err = q.codec.compress(bytes); err != nil {
+		return nil, err
+	}
+	q.codec.marshalled(bytes)
+	return bytes, nil
 }
 
+// Unmarshal decodes a value returned by Marshal, decompressing it when the plugin is configured with a Compressor
 func (q *Query[T]) Unmarshal(bytes []byte) error {
-	return json.Unmarshal(bytes, q)
+	bytes, err := q.codec.decompress(bytes)
+	if err != nil {
+		return err
+	}
+
+	s := q.codec.serializerOf()
+	if s == nil {
+		return json.Unmarshal(bytes, q)
+	}
+
+	res := q.header()
+	if err := s.Unmarshal(bytes, res); err != nil {
+		return &decodeError{err: err}
+	}
+	if dest, ok := res.Dest.(T); ok {
+		q.Dest = dest
+	}
+	q.RowsAffected, q.ExpiresAt, q.StaleAt, q.RefreshAt = res.RowsAffected, res.ExpiresAt, res.StaleAt, res.RefreshAt
+	q.StoredAt, q.QueryDuration = res.StoredAt, res.QueryDuration
+	return nil
+}
+
+// header returns the serialized fields of the query as a Query[any] a Serializer is handed, without its codec
+func (q *Query[T]) header() *Query[any] {
+	return &Query[any]{
+		Dest:          q.Dest,
+		RowsAffected:  q.RowsAffected,
+		ExpiresAt:     q.ExpiresAt,
+		StaleAt:       q.StaleAt,
+		RefreshAt:     q.RefreshAt,
+		StoredAt:      q.StoredAt,
+		QueryDuration: q.QueryDuration,
+	}
+}
+
+func (q *Query[T]) expired() bool {
+	return !q.ExpiresAt.IsZero() && !time.Now().Before(q.ExpiresAt)
+}
+
+func (q *Query[T]) stale() bool {
+	return !q.StaleAt.IsZero() && !time.Now().Before(q.StaleAt)
+}
+
+func (q *Query[T]) refreshDue() bool {
+	return !q.RefreshAt.IsZero() && !time.Now().Before(q.RefreshAt)
 }
 
 func (q *Query[T]) copyTo(dst *Query[any]) error {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -83,4 +84,15 @@ func TestQuery(t *testing.T) {
 			t.Fatalf("Unmarshal was expected to shape the query into the expected, but failed.")
 		}
 	})
+	t.Run("expired", func(t *testing.T) {
+		if (&Query[any]{}).expired() {
+			t.Error("a query without an expiry was not expected to be expired")
+		}
+		if (&Query[any]{ExpiresAt: time.Now().Add(time.Minute)}).expired() {
+			t.Error("a query expiring in the future was not expected to be expired")
+		}
+		if !(&Query[any]{ExpiresAt: time.Now().Add(-time.Minute)}).expired() {
+			t.Error("a query expiring in the past was expected to be expired")
+		}
+	})
 }
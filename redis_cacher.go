@@ -0,0 +1,362 @@
+package caches
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisTablesIndex  = "tables::"
+	redisTagsIndex    = "tags::"
+	redisUnknownTable = "*"
+	redisLocksPrefix  = "locks::"
+
+	defaultRedisLockTTL = 5 * time.Second
+)
+
+// redisReleaseScript deletes a lock only when it is still held with the given token,
+// so that a lock which expired and was acquired by another process is left alone
+var redisReleaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// redisIndexScript adds a key to an index set, keeping the set at least as long as the key it holds which expires
+// last: a key without a ttl makes the set persistent, the others only extend its ttl. The sets are then deleted by
+// Redis once all their keys expired, rather than growing with the keys of the tables which are rarely written.
+var redisIndexScript = redis.NewScript(`
+local existed = redis.call("EXISTS", KEYS[1])
+redis.call("SADD", KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+	redis.call("PERSIST", KEYS[1])
+	return 1
+end
+local current = redis.call("PTTL", KEYS[1])
+if existed == 0 or (current >= 0 and current < ttl) then
+	redis.call("PEXPIRE", KEYS[1], ttl)
+end
+return 1
+`)
+
+type RedisCacherOption func(c *RedisCacher)
+
+// WithRedisSerializer replaces the default JSONSerializer
+func WithRedisSerializer(serializer Serializer) RedisCacherOption {
+	return func(c *RedisCacher) {
+		if serializer != nil {
+			c.serializer = serializer
+		}
+	}
+}
+
+// WithRedisPrefix sets the prefix shared by all the keys the cacher deletes upon Invalidate,
+// it defaults to IdentifierPrefix
+func WithRedisPrefix(prefix string) RedisCacherOption {
+	return func(c *RedisCacher) {
+		c.prefix = prefix
+	}
+}
+
+// WithRedisLockTTL sets how long a lock taken with Acquire is held at most, it defaults to 5s
+// It should be longer than the slowest cached query, since the lock is released once its result is stored
+func WithRedisLockTTL(ttl time.Duration) RedisCacherOption {
+	return func(c *RedisCacher) {
+		if ttl > 0 {
+			c.lockTTL = ttl
+		}
+	}
+}
+
+// RedisCacher is a Cacher implementation storing every query in its own Redis string key
+//
+// Get hands the serializer a Query whose Dest points to a new value of the statement's destination type,
+// so a JSON array decodes into a slice of structs while a JSON object decodes into a single struct,
+// and the value can be set on the statement's destination as is.
+//
+// Every stored key is indexed in a set per table it reads from, which allows Invalidate to scope deletion.
+// Keys reading from unknown tables are indexed in a dedicated set which is cleared upon the invalidation of any table.
+// Keys are indexed in a set per tag as well, which allows InvalidateTags to scope deletion further.
+// The sets expire along with the last of their keys, the ones holding a key without a ttl do not expire.
+//
+// It is a BatchCacher as well, pipelining every batch in a single round-trip.
+//
+// It is a Locker as well, its locks are keys made of "locks::" followed by the locked key,
+// so they are not deleted by Invalidate.
+//
+// It takes a single Redis server, Redis Cluster is not supported: storing a key in its index sets and deleting the
+// keys those hold run multi-key commands and transactions across keys mapped to different slots of a cluster.
+type RedisCacher struct {
+	client     *redis.Client
+	serializer Serializer
+	prefix     string
+	lockTTL    time.Duration
+}
+
+func NewRedisCacher(client *redis.Client, opts ...RedisCacherOption) *RedisCacher {
+	c := &RedisCacher{
+		client:     client,
+		serializer: JSONSerializer{},
+		prefix:     IdentifierPrefix,
+		lockTTL:    defaultRedisLockTTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *RedisCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	res, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.serializer.Unmarshal(res, q); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// GetWithExpiry gets the key along with its remaining ttl in a single round-trip, it makes RedisCacher an ExpiryCacher
+// The keys stored without a ttl report UnknownExpiry.
+func (c *RedisCacher) GetWithExpiry(ctx context.Context, key string, q *Query[any]) (*Query[any], time.Time, error) {
+	pipe := c.client.Pipeline()
+	get := pipe.Get(ctx, key)
+	pttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, UnknownExpiry, err
+	}
+
+	res, err := get.Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, UnknownExpiry, nil
+	}
+	if err != nil {
+		return nil, UnknownExpiry, err
+	}
+
+	if err := c.serializer.Unmarshal(res, q); err != nil {
+		return nil, UnknownExpiry, err
+	}
+
+	// PTTL is negative for the keys without a ttl
+	expiresAt := UnknownExpiry
+	if ttl := pttl.Val(); ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
+	return q, expiresAt, nil
+}
+
+func (c *RedisCacher) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	pipe := c.client.TxPipeline()
+	if err := c.pipeStore(ctx, pipe, key, val, ttl); err != nil {
+		return err
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// BatchGet gets all the keys with a single MGET, it makes RedisCacher a BatchCacher
+func (c *RedisCacher) BatchGet(ctx context.Context, keys []string, qs []*Query[any]) ([]*Query[any], error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	values, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*Query[any], len(keys))
+	for i, value := range values {
+		s, ok := value.(string)
+		if !ok {
+			continue // A missing key
+		}
+		if err := c.serializer.Unmarshal([]byte(s), qs[i]); err != nil {
+			return nil, err
+		}
+		res[i] = qs[i]
+	}
+	return res, nil
+}
+
+// BatchStore stores all the entries within a single transaction pipeline
+func (c *RedisCacher) BatchStore(ctx context.Context, entries []BatchEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	pipe := c.client.TxPipeline()
+	for _, entry := range entries {
+		if err := c.pipeStore(ctx, pipe, entry.Key, entry.Value, entry.TTL); err != nil {
+			return err
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// pipeStore queues the commands storing the value and indexing its key on the pipeline
+func (c *RedisCacher) pipeStore(ctx context.Context, pipe redis.Pipeliner, key string, val *Query[any], ttl time.Duration) error {
+	res, err := c.serializer.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	tables := val.Tables
+	if len(tables) == 0 {
+		tables = []string{redisUnknownTable}
+	}
+
+	// A ttl below the millisecond is still one
+	indexTTL := ttl.Milliseconds()
+	if ttl > 0 && indexTTL == 0 {
+		indexTTL = 1
+	}
+
+	pipe.Set(ctx, key, res, ttl)
+	for _, table := range tables {
+		redisIndexScript.Eval(ctx, pipe, []string{c.tableIndex(table)}, key, indexTTL)
+	}
+	for _, tag := range val.Tags {
+		redisIndexScript.Eval(ctx, pipe, []string{c.prefix + redisTagsIndex + tag}, key, indexTTL)
+	}
+	return nil
+}
+
+// Ping pings the Redis server, it makes RedisCacher a HealthChecker
+func (c *RedisCacher) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// Invalidate deletes only the keys reading from the given tables, or from unknown tables,
+// when no tables are given it deletes all the keys sharing the cacher's prefix, along with all the keys its index
+// sets hold, e.g. the ones a WithPrefix decorator stored outside of it
+func (c *RedisCacher) Invalidate(ctx context.Context, tables ...string) error {
+	if len(tables) > 0 {
+		return c.invalidateTables(ctx, tables)
+	}
+
+	var cursor uint64
+	for {
+		keys, next, err := c.client.Scan(ctx, cursor, fmt.Sprintf("%s*", c.prefix), 0).Result()
+		if err != nil {
+			return err
+		}
+
+		var indexes, values []string
+		for _, key := range keys {
+			if strings.HasPrefix(key, c.prefix+redisTablesIndex) || strings.HasPrefix(key, c.prefix+redisTagsIndex) {
+				indexes = append(indexes, key)
+			} else {
+				values = append(values, key)
+			}
+		}
+		if len(indexes) > 0 {
+			if err := c.deleteIndexed(ctx, indexes); err != nil {
+				return err
+			}
+		}
+		if len(values) > 0 {
+			if err := c.client.Del(ctx, values...).Err(); err != nil {
+				return err
+			}
+		}
+
+		if cursor = next; cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (c *RedisCacher) invalidateTables(ctx context.Context, tables []string) error {
+	indexes := make([]string, 0, len(tables)+1)
+	for _, table := range tables {
+		indexes = append(indexes, c.tableIndex(table))
+	}
+	indexes = append(indexes, c.tableIndex(redisUnknownTable))
+
+	return c.deleteIndexed(ctx, indexes)
+}
+
+// InvalidateTags deletes the keys tagged with any of the tags, it makes RedisCacher a TagInvalidator
+func (c *RedisCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	indexes := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		indexes = append(indexes, c.prefix+redisTagsIndex+tag)
+	}
+
+	return c.deleteIndexed(ctx, indexes)
+}
+
+// deleteIndexed deletes the keys held by the index sets, and removes them from the sets
+// Only the keys which were read are removed, in the same transaction as their deletion, so a key stored and indexed
+// in between, which the deletion misses, is still indexed for the next invalidation. The emptied sets are deleted
+// by Redis itself.
+func (c *RedisCacher) deleteIndexed(ctx context.Context, indexes []string) error {
+	keys, err := c.client.SUnion(ctx, indexes...).Result()
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	members := make([]interface{}, len(keys))
+	for i, key := range keys {
+		members[i] = key
+	}
+	pipe := c.client.TxPipeline()
+	pipe.Del(ctx, keys...)
+	for _, index := range indexes {
+		pipe.SRem(ctx, index, members...)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Acquire takes the lock of the key with SET NX, the lock expires after the lock ttl (see WithRedisLockTTL)
+// When Redis cannot be reached, the lock is reported as taken, so the query runs rather than waiting for nothing
+func (c *RedisCacher) Acquire(ctx context.Context, key string) (func(), bool) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return func() {}, true
+	}
+
+	lockKey := redisLocksPrefix + key
+	value := hex.EncodeToString(token)
+	ok, err := c.client.SetNX(ctx, lockKey, value, c.lockTTL).Result()
+	if err != nil {
+		return func() {}, true
+	}
+
+	if !ok {
+		return nil, false
+	}
+
+	return func() {
+		// The acquisition ctx may be canceled by the time the lock is released
+		_ = redisReleaseScript.Run(context.Background(), c.client, []string{lockKey}, value).Err()
+	}, true
+}
+
+func (c *RedisCacher) tableIndex(table string) string {
+	return c.prefix + redisTablesIndex + table
+}
@@ -0,0 +1,344 @@
+package caches
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type countingSerializer struct {
+	JSONSerializer
+	marshalled   int
+	unmarshalled int
+}
+
+func (s *countingSerializer) Marshal(q *Query[any]) ([]byte, error) {
+	s.marshalled++
+	return s.JSONSerializer.Marshal(q)
+}
+
+func (s *countingSerializer) Unmarshal(data []byte, q *Query[any]) error {
+	s.unmarshalled++
+	return s.JSONSerializer.Unmarshal(data, q)
+}
+
+func newTestRedisCacher(t *testing.T, opts ...RedisCacherOption) (*RedisCacher, *miniredis.Miniredis) {
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+	return NewRedisCacher(client, opts...), srv
+}
+
+func TestRedisCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("miss", func(t *testing.T) {
+		cacher, _ := newTestRedisCacher(t)
+		res, err := cacher.Get(ctx, IdentifierPrefix+"missing", &Query[any]{Dest: &mockDest{}})
+		if err != nil {
+			t.Fatalf("Get resulted into an unexpected error, %v", err)
+		}
+		if res != nil {
+			t.Errorf("Get was expected to return no result for a missing key, got %+v", res)
+		}
+	})
+
+	t.Run("single struct destination", func(t *testing.T) {
+		cacher, _ := newTestRedisCacher(t)
+		if err := cacher.Store(ctx, IdentifierPrefix+"key", &Query[any]{
+			Dest:         &mockDest{Result: "cached"},
+			RowsAffected: 1,
+		}, 0); err != nil {
+			t.Fatalf("Store resulted into an unexpected error, %v", err)
+		}
+
+		res, err := cacher.Get(ctx, IdentifierPrefix+"key", &Query[any]{Dest: &mockDest{}})
+		if err != nil {
+			t.Fatalf("Get resulted into an unexpected error, %v", err)
+		}
+		if act := res.Dest.(*mockDest).Result; act != "cached" {
+			t.Errorf("Get was expected to return `%s`, got `%s`", "cached", act)
+		}
+		if res.RowsAffected != 1 {
+			t.Errorf("Get was expected to return %d affected rows, got %d", 1, res.RowsAffected)
+		}
+	})
+
+	t.Run("slice of structs destination", func(t *testing.T) {
+		cacher, _ := newTestRedisCacher(t)
+		_ = cacher.Store(ctx, IdentifierPrefix+"key", &Query[any]{
+			Dest:         &[]mockDest{{Result: "first"}, {Result: "second"}},
+			RowsAffected: 2,
+		}, 0)
+
+		res, err := cacher.Get(ctx, IdentifierPrefix+"key", &Query[any]{Dest: &[]mockDest{}})
+		if err != nil {
+			t.Fatalf("Get resulted into an unexpected error, %v", err)
+		}
+		dest := *res.Dest.(*[]mockDest)
+		if len(dest) != 2 || dest[0].Result != "first" || dest[1].Result != "second" {
+			t.Errorf("Get was expected to reconstruct the slice destination, got %+v", dest)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t)
+		_ = cacher.Store(ctx, IdentifierPrefix+"key", &Query[any]{Dest: &mockDest{}}, time.Minute)
+		if ttl := srv.TTL(IdentifierPrefix + "key"); ttl != time.Minute {
+			t.Errorf("Store was expected to set a ttl of %s, got %s", time.Minute, ttl)
+		}
+
+		srv.FastForward(2 * time.Minute)
+		if res, _ := cacher.Get(ctx, IdentifierPrefix+"key", &Query[any]{Dest: &mockDest{}}); res != nil {
+			t.Error("Get was expected to return no value after it expired")
+		}
+	})
+
+	t.Run("get with expiry", func(t *testing.T) {
+		cacher, _ := newTestRedisCacher(t)
+		_ = cacher.Store(ctx, IdentifierPrefix+"key", &Query[any]{Dest: &mockDest{Result: "cached"}}, time.Minute)
+		_ = cacher.Store(ctx, IdentifierPrefix+"forever", &Query[any]{Dest: &mockDest{}}, 0)
+
+		res, expiresAt, err := cacher.GetWithExpiry(ctx, IdentifierPrefix+"key", &Query[any]{Dest: &mockDest{}})
+		if err != nil || res == nil || res.Dest.(*mockDest).Result != "cached" {
+			t.Fatalf("GetWithExpiry was expected to return the stored value, got %+v and %v", res, err)
+		}
+		if until := time.Until(expiresAt); until > time.Minute || until < time.Minute-time.Second {
+			t.Errorf("GetWithExpiry was expected to report the value to expire in %s, got %s", time.Minute, until)
+		}
+		if _, expiresAt, _ := cacher.GetWithExpiry(ctx, IdentifierPrefix+"forever", &Query[any]{Dest: &mockDest{}}); expiresAt != UnknownExpiry {
+			t.Errorf("GetWithExpiry was expected to report no expiry for a key without ttl, got %s", expiresAt)
+		}
+		res, _, err = cacher.GetWithExpiry(ctx, IdentifierPrefix+"missing", &Query[any]{Dest: &mockDest{}})
+		if err != nil || res != nil {
+			t.Errorf("GetWithExpiry was expected to return no result for a missing key, got %+v and %v", res, err)
+		}
+	})
+
+	t.Run("custom serializer", func(t *testing.T) {
+		serializer := &countingSerializer{}
+		cacher, _ := newTestRedisCacher(t, WithRedisSerializer(serializer))
+		_ = cacher.Store(ctx, IdentifierPrefix+"key", &Query[any]{Dest: &mockDest{}}, 0)
+		_, _ = cacher.Get(ctx, IdentifierPrefix+"key", &Query[any]{Dest: &mockDest{}})
+
+		if serializer.marshalled != 1 || serializer.unmarshalled != 1 {
+			t.Errorf("expected the custom serializer to be used once in each direction, got %d marshals and %d unmarshals",
+				serializer.marshalled, serializer.unmarshalled)
+		}
+	})
+
+	t.Run("invalidate", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t)
+		_ = cacher.Store(ctx, IdentifierPrefix+"users", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}}, 0)
+		_ = cacher.Store(ctx, IdentifierPrefix+"raw", &Query[any]{Dest: &mockDest{}}, 0)
+		_ = srv.Set("unrelated", "value")
+
+		if err := cacher.Invalidate(ctx); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+		if keys := srv.Keys(); len(keys) != 1 || keys[0] != "unrelated" {
+			t.Errorf("Invalidate was expected to delete only the prefixed keys, left %v", keys)
+		}
+	})
+
+	t.Run("invalidate prefixed keys", func(t *testing.T) {
+		redisCacher, srv := newTestRedisCacher(t)
+		cacher := WithPrefix(redisCacher, "app:")
+		key := IdentifierPrefix + "users"
+		_ = cacher.Store(ctx, key, &Query[any]{Dest: &mockDest{Result: "cached"}, Tables: []string{"users"}}, 0)
+		_ = cacher.Store(ctx, IdentifierPrefix+"raw", &Query[any]{Dest: &mockDest{}}, 0)
+
+		if err := cacher.Invalidate(ctx); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+		if keys := srv.Keys(); len(keys) != 0 {
+			t.Errorf("Invalidate was expected to delete the prefixed keys as well, left %v", keys)
+		}
+		if res, _ := cacher.Get(ctx, key, &Query[any]{Dest: &mockDest{}}); res != nil {
+			t.Errorf("expected the prefixed key to be a miss once invalidated, got %+v", res)
+		}
+	})
+
+	t.Run("invalidate tables", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t)
+		_ = cacher.Store(ctx, IdentifierPrefix+"users", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}}, 0)
+		_ = cacher.Store(ctx, IdentifierPrefix+"users-roles", &Query[any]{Dest: &mockDest{}, Tables: []string{"users", "roles"}}, 0)
+		_ = cacher.Store(ctx, IdentifierPrefix+"orders", &Query[any]{Dest: &mockDest{}, Tables: []string{"orders"}}, 0)
+		_ = cacher.Store(ctx, IdentifierPrefix+"raw", &Query[any]{Dest: &mockDest{}}, 0)
+
+		if err := cacher.Invalidate(ctx, "roles"); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+
+		for key, exists := range map[string]bool{
+			IdentifierPrefix + "users":       true,
+			IdentifierPrefix + "users-roles": false,
+			IdentifierPrefix + "orders":      true,
+			IdentifierPrefix + "raw":         false,
+		} {
+			if srv.Exists(key) != exists {
+				t.Errorf("after invalidating the roles table, expected the existence of `%s` to be %t", key, exists)
+			}
+		}
+	})
+
+	t.Run("invalidate while storing", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t)
+		_ = cacher.Store(ctx, IdentifierPrefix+"stale", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}}, 0)
+		// A query stores its result between the read of the index and the deletion of its keys
+		cacher.client.AddHook(&redisStoreHook{onSUnion: func() {
+			_ = cacher.Store(ctx, IdentifierPrefix+"fresh", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}}, 0)
+		}})
+
+		if err := cacher.Invalidate(ctx, "users"); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+		if srv.Exists(IdentifierPrefix+"stale") || !srv.Exists(IdentifierPrefix+"fresh") {
+			t.Fatalf("expected only the key read from the index to be deleted, left %v", srv.Keys())
+		}
+		if err := cacher.Invalidate(ctx, "users"); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+		if srv.Exists(IdentifierPrefix + "fresh") {
+			t.Error("expected the key stored during the invalidation to be still indexed for the next one")
+		}
+	})
+
+	t.Run("index expiry", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t)
+		index := IdentifierPrefix + redisTablesIndex + "users"
+		_ = cacher.Store(ctx, IdentifierPrefix+"a", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}}, time.Minute)
+		_ = cacher.Store(ctx, IdentifierPrefix+"b", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}}, time.Second)
+		if ttl := srv.TTL(index); ttl != time.Minute {
+			t.Errorf("expected the index to expire along with the key which expires last, got %s", ttl)
+		}
+
+		srv.FastForward(time.Minute)
+		if srv.Exists(index) {
+			t.Error("expected the index to expire once all its keys did")
+		}
+
+		_ = cacher.Store(ctx, IdentifierPrefix+"c", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}, Tags: []string{"t"}}, time.Second)
+		_ = cacher.Store(ctx, IdentifierPrefix+"d", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}, Tags: []string{"t"}}, 0)
+		_ = cacher.Store(ctx, IdentifierPrefix+"e", &Query[any]{Dest: &mockDest{}, Tables: []string{"users"}}, time.Second)
+		if ttl := srv.TTL(index); ttl != 0 {
+			t.Errorf("expected the index holding a key without a ttl not to expire, got %s", ttl)
+		}
+		if ttl := srv.TTL(IdentifierPrefix + redisTagsIndex + "t"); ttl != 0 {
+			t.Errorf("expected the tag index holding a key without a ttl not to expire, got %s", ttl)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t)
+		err := cacher.BatchStore(ctx, []BatchEntry{
+			{Key: IdentifierPrefix + "a", Value: &Query[any]{Dest: &mockDest{Result: "a"}, Tables: []string{"users"}}, TTL: time.Minute},
+			{Key: IdentifierPrefix + "b", Value: &Query[any]{Dest: &mockDest{Result: "b"}}},
+		})
+		if err != nil {
+			t.Fatalf("BatchStore resulted into an unexpected error, %v", err)
+		}
+		if ttl := srv.TTL(IdentifierPrefix + "a"); ttl != time.Minute {
+			t.Errorf("BatchStore was expected to set the ttl of the entry, got %s", ttl)
+		}
+
+		keys := []string{IdentifierPrefix + "a", IdentifierPrefix + "missing", IdentifierPrefix + "b"}
+		qs := []*Query[any]{{Dest: &mockDest{}}, {Dest: &mockDest{}}, {Dest: &mockDest{}}}
+		res, err := cacher.BatchGet(ctx, keys, qs)
+		if err != nil {
+			t.Fatalf("BatchGet resulted into an unexpected error, %v", err)
+		}
+		if len(res) != 3 || res[1] != nil || res[0].Dest.(*mockDest).Result != "a" || res[2].Dest.(*mockDest).Result != "b" {
+			t.Errorf("BatchGet was expected to return a value per key, got %+v", res)
+		}
+
+		if err := cacher.Invalidate(ctx, "users"); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+		if srv.Exists(IdentifierPrefix+"a") || srv.Exists(IdentifierPrefix+"b") {
+			t.Error("BatchStore was expected to index the keys like Store")
+		}
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t, WithRedisLockTTL(time.Minute))
+		release, ok := cacher.Acquire(ctx, IdentifierPrefix+"key")
+		if !ok {
+			t.Fatal("Acquire was expected to take the free lock")
+		}
+		if ttl := srv.TTL("locks::" + IdentifierPrefix + "key"); ttl != time.Minute {
+			t.Errorf("Acquire was expected to set a ttl of %s on the lock, got %s", time.Minute, ttl)
+		}
+		if _, ok := cacher.Acquire(ctx, IdentifierPrefix+"key"); ok {
+			t.Error("Acquire was expected not to take the held lock")
+		}
+
+		release()
+		if _, ok := cacher.Acquire(ctx, IdentifierPrefix+"key"); !ok {
+			t.Error("Acquire was expected to take the released lock")
+		}
+	})
+
+	t.Run("lock expiry", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t, WithRedisLockTTL(time.Second))
+		release, _ := cacher.Acquire(ctx, IdentifierPrefix+"key")
+		srv.FastForward(2 * time.Second)
+
+		if _, ok := cacher.Acquire(ctx, IdentifierPrefix+"key"); !ok {
+			t.Fatal("Acquire was expected to take the expired lock")
+		}
+		release()
+		if !srv.Exists("locks::" + IdentifierPrefix + "key") {
+			t.Error("releasing an expired lock was expected to leave the lock of another holder alone")
+		}
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t)
+		if err := cacher.Ping(ctx); err != nil {
+			t.Fatalf("Ping resulted into an unexpected error, %v", err)
+		}
+		srv.Close()
+		if err := cacher.Ping(ctx); err == nil {
+			t.Error("Ping was expected to fail when redis cannot be reached")
+		}
+	})
+
+	t.Run("lock without redis", func(t *testing.T) {
+		cacher, srv := newTestRedisCacher(t)
+		srv.Close()
+		if release, ok := cacher.Acquire(ctx, IdentifierPrefix+"key"); !ok || release == nil {
+			t.Error("Acquire was expected to report the lock as taken when redis cannot be reached")
+		}
+	})
+}
+
+// redisStoreHook calls onSUnion once, right after the first SUNION
+type redisStoreHook struct {
+	onSUnion func()
+	done     bool
+}
+
+func (h *redisStoreHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *redisStoreHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if cmd.Name() == "sunion" && !h.done {
+			h.done = true
+			h.onSUnion()
+		}
+		return err
+	}
+}
+
+func (h *redisStoreHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
@@ -1,6 +1,7 @@
 package caches
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -27,9 +28,46 @@ func deepCopy(src, dst interface{}) error {
 	return copyValue(srcVal, dstVal.Elem())
 }
 
+// copyScalar copies src into dst when both point to a value of the same scalar type, e.g. the *int64 of a Count,
+// which shares no memory with src once assigned, so it needs no deep copy. It reports whether it copied the value
+func copyScalar(src, dst interface{}) bool {
+	if s, ok := src.(*int64); ok { // The destination of Count
+		d, ok := dst.(*int64)
+		if !ok || s == nil || d == nil {
+			return false
+		}
+		*d = *s
+		return true
+	}
+
+	srcVal, dstVal := reflect.ValueOf(src), reflect.ValueOf(dst)
+	if srcVal.Kind() != reflect.Ptr || srcVal.IsNil() || !dstVal.IsValid() || dstVal.Type() != srcVal.Type() || dstVal.IsNil() {
+		return false
+	}
+	switch srcVal.Elem().Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128, reflect.String:
+		dstVal.Elem().Set(srcVal.Elem())
+		return true
+	}
+	return false
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// copyValue recursively clones src into dst, so that they share no pointer, slice, map nor interface value
+//
+// The structs with unexported fields cannot be cloned field by field, they are only supported when they marshal
+// themselves to JSON (e.g. time.Time), which is what the values cached by the built-in cachers go through anyway,
+// in which case they are copied by value.
 func copyValue(src, dst reflect.Value) error {
 	switch src.Kind() {
 	case reflect.Ptr:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
 		src = src.Elem()
 		dst.Set(reflect.New(src.Type()))
 		err := copyValue(src, dst.Elem())
@@ -37,7 +75,22 @@ func copyValue(src, dst reflect.Value) error {
 			return err
 		}
 
+	case reflect.Interface:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		elem := reflect.New(src.Elem().Type()).Elem()
+		if err := copyValue(src.Elem(), elem); err != nil {
+			return err
+		}
+		dst.Set(elem)
+
 	case reflect.Struct:
+		if marshalsJSON(src.Type()) && hasUnexportedFields(src.Type()) {
+			dst.Set(src)
+			return nil
+		}
 		for i := 0; i < src.NumField(); i++ {
 			if src.Type().Field(i).PkgPath != "" {
 				return fmt.Errorf("%w: %+v", schema.ErrUnsupportedDataType, src.Type().Field(i).Name)
@@ -49,6 +102,10 @@ func copyValue(src, dst reflect.Value) error {
 		}
 
 	case reflect.Slice:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
 		newSlice := reflect.MakeSlice(src.Type(), src.Len(), src.Cap())
 		for i := 0; i < src.Len(); i++ {
 			err := copyValue(src.Index(i), newSlice.Index(i))
@@ -58,7 +115,18 @@ func copyValue(src, dst reflect.Value) error {
 		}
 		dst.Set(newSlice)
 
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			if err := copyValue(src.Index(i), dst.Index(i)); err != nil {
+				return err
+			}
+		}
+
 	case reflect.Map:
+		if src.IsNil() {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
 		newMap := reflect.MakeMapWithSize(src.Type(), src.Len())
 		for _, key := range src.MapKeys() {
 			value := src.MapIndex(key)
@@ -77,3 +145,16 @@ func copyValue(src, dst reflect.Value) error {
 
 	return nil
 }
+
+func marshalsJSON(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType)
+}
+
+func hasUnexportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package caches
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type unsupportedMockStruct struct {
@@ -213,3 +214,129 @@ func Test_deepCopy(t *testing.T) {
 		}
 	})
 }
+
+type nestedMockChild struct {
+	Name string
+	Tags []string
+}
+
+type nestedMockStruct struct {
+	Child      *nestedMockChild
+	Children   []*nestedMockChild
+	Attributes map[string]interface{}
+	Lookup     map[string]*nestedMockChild
+	Fixed      [2]*nestedMockChild
+	CreatedAt  time.Time
+	Missing    *nestedMockChild
+	Empty      []string
+}
+
+func newNestedMockStruct() nestedMockStruct {
+	return nestedMockStruct{
+		Child:    &nestedMockChild{Name: "child", Tags: []string{"a"}},
+		Children: []*nestedMockChild{{Name: "first", Tags: []string{"b"}}},
+		Attributes: map[string]interface{}{
+			"nested": map[string]interface{}{"key": "value"},
+			"list":   []interface{}{"x"},
+		},
+		Lookup:    map[string]*nestedMockChild{"key": {Name: "looked up"}},
+		Fixed:     [2]*nestedMockChild{{Name: "fixed"}},
+		CreatedAt: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func Test_deepCopy_nested(t *testing.T) {
+	testCases := map[string]func(v *nestedMockStruct){
+		"pointer": func(v *nestedMockStruct) {
+			v.Child.Name = "mutated"
+		},
+		"slice within a pointer": func(v *nestedMockStruct) {
+			v.Child.Tags[0] = "mutated"
+		},
+		"slice of pointers": func(v *nestedMockStruct) {
+			v.Children[0].Name = "mutated"
+		},
+		"map within an interface": func(v *nestedMockStruct) {
+			v.Attributes["nested"].(map[string]interface{})["key"] = "mutated"
+		},
+		"slice within an interface": func(v *nestedMockStruct) {
+			v.Attributes["list"].([]interface{})[0] = "mutated"
+		},
+		"map of pointers": func(v *nestedMockStruct) {
+			v.Lookup["key"].Name = "mutated"
+		},
+		"array of pointers": func(v *nestedMockStruct) {
+			v.Fixed[0].Name = "mutated"
+		},
+	}
+
+	for name, mutate := range testCases {
+		t.Run(name, func(t *testing.T) {
+			src := newNestedMockStruct()
+			var dst nestedMockStruct
+			if err := deepCopy(&src, &dst); err != nil {
+				t.Fatalf("deepCopy returned an unexpected error %+v", err)
+			}
+			if !reflect.DeepEqual(src, dst) {
+				t.Fatalf("deepCopy failed to copy structure: got %+v, want %+v", dst, src)
+			}
+
+			mutate(&dst)
+			if !reflect.DeepEqual(src, newNestedMockStruct()) {
+				t.Errorf("mutating the copy was expected to leave the source alone, got %+v", src)
+			}
+		})
+	}
+
+	t.Run("nil values", func(t *testing.T) {
+		src := newNestedMockStruct()
+		var dst nestedMockStruct
+		_ = deepCopy(&src, &dst)
+		if dst.Missing != nil || dst.Empty != nil {
+			t.Errorf("deepCopy was expected to keep the nil values nil, got %+v", dst)
+		}
+	})
+}
+
+func Test_copyScalar(t *testing.T) {
+	count, countCopy := int64(42), int64(0)
+	if !copyScalar(&count, &countCopy) || countCopy != 42 {
+		t.Errorf("expected the count to be copied, got %d", countCopy)
+	}
+
+	name, nameCopy := "ktsivkov", ""
+	if !copyScalar(&name, &nameCopy) || nameCopy != "ktsivkov" {
+		t.Errorf("expected the string to be copied, got %q", nameCopy)
+	}
+
+	var nilCount *int64
+	for name, tc := range map[string][2]interface{}{
+		"slice":          {&[]int64{1}, &[]int64{}},
+		"struct":         {&mockDest{Result: "a"}, &mockDest{}},
+		"other type":     {&count, new(int32)},
+		"nil source":     {nilCount, &countCopy},
+		"non pointer":    {count, &countCopy},
+		"nil interface":  {nil, &countCopy},
+		"nil dest count": {&count, nilCount},
+	} {
+		if copyScalar(tc[0], tc[1]) {
+			t.Errorf("expected the %s not to be copied as a scalar", name)
+		}
+	}
+}
+
+func Benchmark_easedCopy(b *testing.B) {
+	count := int64(42)
+	b.Run("scalar", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var dest int64
+			copyScalar(&count, &dest)
+		}
+	})
+	b.Run("marshalled", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var dest int64
+			_ = (&Query[any]{Dest: &count}).copyTo(&Query[any]{Dest: &dest})
+		}
+	})
+}
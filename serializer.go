@@ -0,0 +1,180 @@
+package caches
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Serializer converts a Query to and from its cached representation
+// Unmarshal receives a Query whose Dest already points to a value of the concrete destination type,
+// so implementations should decode into it rather than allocating a new destination
+//
+// Since Query.Dest is an interface, its concrete type cannot be told from the decoded bytes alone. The plugin hands
+// Unmarshal a Query whose Dest is a new value of the statement's destination type, e.g. a *[]User, which is the type
+// hint the payload lacks: decoding into it reconstructs the concrete type without registering it anywhere.
+type Serializer interface {
+	Marshal(q *Query[any]) ([]byte, error)
+	Unmarshal(data []byte, q *Query[any]) error
+}
+
+// JSONSerializer is the default Serializer, it relies on Query.Marshal and Query.Unmarshal
+type JSONSerializer struct{}
+
+func (JSONSerializer) Marshal(q *Query[any]) ([]byte, error) {
+	return q.Marshal()
+}
+
+func (JSONSerializer) Unmarshal(data []byte, q *Query[any]) error {
+	return q.Unmarshal(data)
+}
+
+// queryHeader holds the fields of a Query which are serialized along with its Dest
+type queryHeader struct {
+	RowsAffected  int64
+	ExpiresAt     time.Time
+	StaleAt       time.Time
+	RefreshAt     time.Time
+	StoredAt      time.Time
+	QueryDuration time.Duration
+}
+
+func headerOf(q *Query[any]) queryHeader {
+	return queryHeader{
+		RowsAffected:  q.RowsAffected,
+		ExpiresAt:     q.ExpiresAt,
+		StaleAt:       q.StaleAt,
+		RefreshAt:     q.RefreshAt,
+		StoredAt:      q.StoredAt,
+		QueryDuration: q.QueryDuration,
+	}
+}
+
+func (h queryHeader) applyTo(q *Query[any]) {
+	q.RowsAffected, q.ExpiresAt, q.StaleAt, q.RefreshAt = h.RowsAffected, h.ExpiresAt, h.StaleAt, h.RefreshAt
+	q.StoredAt, q.QueryDuration = h.StoredAt, h.QueryDuration
+}
+
+// GobSerializer is an encoding/gob based Serializer, it keeps the full precision of time.Time
+// and encodes []byte as is rather than in base64
+//
+// The Dest is encoded as its concrete type, after a header holding the other fields of the Query, and decoded into
+// the Dest of the Query handed to Unmarshal, so it needs no gob.Register. Only the concrete types held by interfaces
+// within the Dest do, e.g. a time.Time in the map[string]interface{} rows of a raw query.
+type GobSerializer struct{}
+
+func (GobSerializer) Marshal(q *Query[any]) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(headerOf(q)); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(q.Dest); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobSerializer) Unmarshal(data []byte, q *Query[any]) error {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+
+	var header queryHeader
+	if err := dec.Decode(&header); err != nil {
+		return err
+	}
+	if err := dec.Decode(q.Dest); err != nil {
+		return err
+	}
+	header.applyTo(q)
+	return nil
+}
+
+// MsgpackSerializer is a github.com/vmihailenco/msgpack based Serializer, it keeps the full precision of time.Time,
+// encodes []byte as is and usually yields the smallest payloads
+//
+// It is the codec Serializer (see NewCodecSerializer) built on msgpack.Marshal and msgpack.Unmarshal, so the Dest
+// is decoded into the Dest of the Query handed to Unmarshal and its types need no registration. Since msgpack does
+// not encode the location of a time.Time, they are decoded in the local one.
+type MsgpackSerializer struct{}
+
+var msgpackSerializer = NewCodecSerializer(msgpack.Marshal, msgpack.Unmarshal)
+
+func (MsgpackSerializer) Marshal(q *Query[any]) ([]byte, error) {
+	return msgpackSerializer.Marshal(q)
+}
+
+func (MsgpackSerializer) Unmarshal(data []byte, q *Query[any]) error {
+	return msgpackSerializer.Unmarshal(data, q)
+}
+
+// NewCodecSerializer builds a Serializer out of a pair of funcs sharing the signatures of json.Marshal and
+// json.Unmarshal, e.g. `caches.NewCodecSerializer(json.Marshal, json.Unmarshal)` or the ones of another codec
+//
+// The Dest is encoded on its own, after a length prefixed header holding the other fields of the Query,
+// and decoded into the Dest of the Query handed to Unmarshal, so the codec never has to encode the type of the Dest.
+func NewCodecSerializer(marshal func(v any) ([]byte, error), unmarshal func(data []byte, v any) error) Serializer {
+	return &codecSerializer{marshal: marshal, unmarshal: unmarshal}
+}
+
+type codecSerializer struct {
+	marshal   func(v any) ([]byte, error)
+	unmarshal func(data []byte, v any) error
+}
+
+func (s *codecSerializer) Marshal(q *Query[any]) ([]byte, error) {
+	header, err := s.marshal(headerOf(q))
+	if err != nil {
+		return nil, err
+	}
+	dest, err := s.marshal(q.Dest)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(header)+len(dest))
+	data = data[:binary.PutUvarint(data, uint64(len(header)))]
+	data = append(data, header...)
+	return append(data, dest...), nil
+}
+
+func (s *codecSerializer) Unmarshal(data []byte, q *Query[any]) error {
+	size, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < size {
+		return io.ErrUnexpectedEOF
+	}
+	data = data[n:]
+
+	var header queryHeader
+	if err := s.unmarshal(data[:size], &header); err != nil {
+		return err
+	}
+	if err := s.unmarshal(data[size:], q.Dest); err != nil {
+		return err
+	}
+	header.applyTo(q)
+	return nil
+}
+
+// decodeError marks the failures of the configured Serializer to decode a cached value, which are treated as
+// cache misses since they are usually caused by values stored with another Serializer
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string {
+	return "caches: failed to decode the cached value: " + e.err.Error()
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+func isDecodeError(err error) bool {
+	var target *decodeError
+	return errors.As(err, &target)
+}
@@ -0,0 +1,217 @@
+package caches
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type serializerRow struct {
+	ID        uint
+	Name      string
+	Payload   []byte
+	CreatedAt time.Time
+	Parent    *serializerRow
+}
+
+func newSerializerRows(n int) []serializerRow {
+	rows := make([]serializerRow, n)
+	for i := range rows {
+		rows[i] = serializerRow{
+			ID:        uint(i + 1),
+			Name:      "row-" + strconv.Itoa(i),
+			Payload:   []byte("payload-" + strconv.Itoa(i)),
+			CreatedAt: time.Unix(int64(1700000000+i), 123456789).UTC(),
+		}
+	}
+	return rows
+}
+
+func TestSerializers(t *testing.T) {
+	serializers := map[string]Serializer{
+		"json":    JSONSerializer{},
+		"gob":     GobSerializer{},
+		"codec":   NewCodecSerializer(json.Marshal, json.Unmarshal),
+		"msgpack": MsgpackSerializer{},
+	}
+
+	for name, serializer := range serializers {
+		t.Run(name, func(t *testing.T) {
+			rows := newSerializerRows(3)
+			rows[1].Parent = &serializerRow{ID: 42, Name: "parent"}
+			expected := &Query[any]{
+				Dest:         &rows,
+				RowsAffected: 3,
+				ExpiresAt:    time.Unix(1800000000, 0).UTC(),
+				StaleAt:      time.Unix(1700000000, 0).UTC(),
+				RefreshAt:    time.Unix(1750000000, 0).UTC(),
+				StoredAt:     time.Unix(1690000000, 0).UTC(),
+			}
+
+			data, err := serializer.Marshal(expected)
+			if err != nil {
+				t.Fatalf("Marshal resulted into an unexpected error, %v", err)
+			}
+			act := &Query[any]{Dest: &[]serializerRow{}}
+			if err := serializer.Unmarshal(data, act); err != nil {
+				t.Fatalf("Unmarshal resulted into an unexpected error, %v", err)
+			}
+			if name == "msgpack" {
+				// msgpack decodes the times in the local location
+				expected.ExpiresAt, expected.StaleAt = expected.ExpiresAt.Local(), expected.StaleAt.Local()
+				expected.RefreshAt, expected.StoredAt = expected.RefreshAt.Local(), expected.StoredAt.Local()
+				for i := range rows {
+					rows[i].CreatedAt = rows[i].CreatedAt.Local()
+				}
+				rows[1].Parent.CreatedAt = rows[1].Parent.CreatedAt.Local()
+			}
+			if !reflect.DeepEqual(act, expected) {
+				t.Errorf("expected the query to round trip, got %+v instead of %+v", act, expected)
+			}
+		})
+	}
+
+	destinations := map[string]struct {
+		dest, empty any
+	}{
+		"struct": {dest: &mockDest{Result: "cached"}, empty: &mockDest{}},
+		"slice":  {dest: &[]string{"a", "b"}, empty: &[]string{}},
+		"map":    {dest: &map[string]int{"a": 1, "b": 2}, empty: &map[string]int{}},
+	}
+	for name, serializer := range serializers {
+		for destName, dest := range destinations {
+			t.Run(name+" "+destName, func(t *testing.T) {
+				data, err := serializer.Marshal(&Query[any]{Dest: dest.dest, RowsAffected: 1})
+				if err != nil {
+					t.Fatalf("Marshal resulted into an unexpected error, %v", err)
+				}
+				act := &Query[any]{Dest: reflect.New(reflect.TypeOf(dest.empty).Elem()).Interface()}
+				if err := serializer.Unmarshal(data, act); err != nil {
+					t.Fatalf("Unmarshal resulted into an unexpected error, %v", err)
+				}
+				if !reflect.DeepEqual(act.Dest, dest.dest) || act.RowsAffected != 1 {
+					t.Errorf("expected the %s destination to round trip, got %+v instead of %+v", destName, act.Dest, dest.dest)
+				}
+			})
+		}
+	}
+
+	t.Run("codec truncated data", func(t *testing.T) {
+		serializer := NewCodecSerializer(json.Marshal, json.Unmarshal)
+		data, _ := serializer.Marshal(&Query[any]{Dest: &mockDest{Result: "cached"}, RowsAffected: 1})
+		for _, truncated := range [][]byte{nil, data[:3]} {
+			if err := serializer.Unmarshal(truncated, &Query[any]{Dest: &mockDest{}}); err == nil {
+				t.Errorf("expected Unmarshal to fail with %d bytes out of %d", len(truncated), len(data))
+			}
+		}
+	})
+}
+
+func TestCaches_Serializer(t *testing.T) {
+	newCaches := func(cacher Cacher) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher:     cacher,
+				Serializer: GobSerializer{},
+				Compressor: GzipCompressor{},
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
+				},
+			},
+		}
+	}
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.Table = "users"
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	t.Run("stores serialized values", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		caches := newCaches(cacher)
+
+		caches.query(newDB())
+		db := newDB()
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", db.Error)
+		}
+		if act := db.Statement.Dest.(*mockDest).Result; act != "demo-query" {
+			t.Errorf("expected the serialized value to be served, got `%s`", act)
+		}
+		if act := caches.Stats(); act.Hits != 1 {
+			t.Errorf("expected the second query to be a hit, got %+v", act)
+		}
+
+		raw, _ := cacher.shard(buildIdentifier(newDB(), "", nil)).get(buildIdentifier(newDB(), "", nil))
+		data, err := (GzipCompressor{}).Decompress(raw)
+		if err != nil {
+			t.Fatalf("expected the value to be stored gzipped, %v", err)
+		}
+		q := &Query[any]{Dest: &mockDest{}}
+		if err := (GobSerializer{}).Unmarshal(data, q); err != nil {
+			t.Errorf("expected the value to be stored gob encoded, %v", err)
+		}
+	})
+
+	t.Run("decoding failure is a miss", func(t *testing.T) {
+		cacher := NewMemoryCacher()
+		// Stored as JSON, as values cached before the Serializer was configured
+		raw := &Query[any]{Dest: &mockDest{Result: "json"}, codec: &queryCodec{compressor: GzipCompressor{}}}
+		_ = cacher.Store(context.Background(), buildIdentifier(newDB(), "", nil), raw, 0)
+
+		caches := newCaches(cacher)
+		db := newDB()
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("expected the decoding failure not to fail the query, got %v", db.Error)
+		}
+		if act := db.Statement.Dest.(*mockDest).Result; act != "demo-query" {
+			t.Errorf("expected the query to hit the database, got `%s`", act)
+		}
+		if act := caches.Stats(); act.Misses != 1 || act.GetErrors != 0 || act.Stores != 1 {
+			t.Errorf("expected a miss followed by a store replacing the value, got %+v", act)
+		}
+	})
+}
+
+// BenchmarkSerializers round trips a 10k rows result through every Serializer, reporting the size of its payload
+// The codec one is built on encoding/json, so it measures the cost of its header and of encoding the Dest on its own
+// against the JSONSerializer
+func BenchmarkSerializers(b *testing.B) {
+	serializers := map[string]Serializer{
+		"json":    JSONSerializer{},
+		"gob":     GobSerializer{},
+		"codec":   NewCodecSerializer(json.Marshal, json.Unmarshal),
+		"msgpack": MsgpackSerializer{},
+	}
+
+	rows := newSerializerRows(10000)
+	for name, serializer := range serializers {
+		b.Run(name, func(b *testing.B) {
+			var size int
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				data, err := serializer.Marshal(&Query[any]{Dest: &rows, RowsAffected: int64(len(rows))})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := serializer.Unmarshal(data, &Query[any]{Dest: &[]serializerRow{}}); err != nil {
+					b.Fatal(err)
+				}
+				size = len(data)
+			}
+			b.ReportMetric(float64(size), "bytes/payload")
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package caches
+
+import (
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// oversized reports whether the query result exceeds MaxCacheRows or MaxCacheBytes
+// Rows are counted first, since for slice destinations that is a lot cheaper than estimating their size in bytes
+func (c *Caches) oversized(db *gorm.DB) bool {
+	if c.Conf.MaxCacheRows <= 0 && c.Conf.MaxCacheBytes <= 0 {
+		return false
+	}
+
+	dest := reflect.Indirect(reflect.ValueOf(db.Statement.Dest))
+	if c.Conf.MaxCacheRows > 0 {
+		rows := int(db.Statement.RowsAffected)
+		if dest.Kind() == reflect.Slice || dest.Kind() == reflect.Array {
+			rows = dest.Len()
+		}
+		if rows > c.Conf.MaxCacheRows {
+			return true
+		}
+	}
+
+	return c.Conf.MaxCacheBytes > 0 && estimateSize(dest, c.Conf.MaxCacheBytes) > c.Conf.MaxCacheBytes
+}
+
+// estimateSize roughly estimates the memory held by v, which approximates the size of its serialized form
+// The estimation stops as soon as it goes past limit, so that large results are not walked in full
+func estimateSize(v reflect.Value, limit int) int {
+	if !v.IsValid() {
+		return 0
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		return estimateSize(v.Elem(), limit)
+	case reflect.String:
+		return v.Len()
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Len()
+		}
+
+		var size int
+		for i := 0; i < v.Len() && size <= limit; i++ {
+			size += estimateSize(v.Index(i), limit-size)
+		}
+		return size
+	case reflect.Map:
+		var size int
+		iter := v.MapRange()
+		for iter.Next() && size <= limit {
+			size += estimateSize(iter.Key(), limit-size)
+			size += estimateSize(iter.Value(), limit-size)
+		}
+		return size
+	case reflect.Struct:
+		var size int
+		for i := 0; i < v.NumField() && size <= limit; i++ {
+			size += estimateSize(v.Field(i), limit-size)
+		}
+		return size
+	default:
+		return int(v.Type().Size())
+	}
+}
@@ -0,0 +1,114 @@
+package caches
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func Test_estimateSize(t *testing.T) {
+	type row struct {
+		Name  string
+		Count int64
+		Data  []byte
+	}
+
+	testCases := map[string]struct {
+		value    any
+		expected int
+	}{
+		"nil":              {value: nil, expected: 0},
+		"string":           {value: "test", expected: 4},
+		"int64":            {value: int64(1), expected: 8},
+		"bytes":            {value: []byte("test"), expected: 4},
+		"struct":           {value: row{Name: "test", Data: []byte("data")}, expected: 16},
+		"pointer":          {value: &row{Name: "test"}, expected: 12},
+		"slice of structs": {value: []row{{Name: "a"}, {Name: "b"}}, expected: 18},
+		"map":              {value: map[string]string{"key": "value"}, expected: 8},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if act := estimateSize(reflect.ValueOf(tc.value), 1<<20); act != tc.expected {
+				t.Errorf("expected the estimated size to be %d, got %d", tc.expected, act)
+			}
+		})
+	}
+
+	t.Run("stops past the limit", func(t *testing.T) {
+		rows := make([]string, 1000)
+		for i := range rows {
+			rows[i] = "0123456789"
+		}
+		if act := estimateSize(reflect.ValueOf(rows), 25); act != 30 {
+			t.Errorf("expected the estimation to stop right past the limit, got %d", act)
+		}
+	})
+}
+
+func TestCaches_oversized(t *testing.T) {
+	newDB := func(dest any, rowsAffected int64) *gorm.DB {
+		return &gorm.DB{Statement: &gorm.Statement{DB: &gorm.DB{RowsAffected: rowsAffected}, Dest: dest}}
+	}
+	rows := []mockDest{{Result: strings.Repeat("a", 10)}, {Result: strings.Repeat("b", 10)}}
+
+	testCases := map[string]struct {
+		conf     *Config
+		db       *gorm.DB
+		expected bool
+	}{
+		"no limits":           {conf: &Config{}, db: newDB(&rows, 2), expected: false},
+		"within rows":         {conf: &Config{MaxCacheRows: 2}, db: newDB(&rows, 2), expected: false},
+		"exceeding rows":      {conf: &Config{MaxCacheRows: 1}, db: newDB(&rows, 2), expected: true},
+		"single struct rows":  {conf: &Config{MaxCacheRows: 1}, db: newDB(&rows[0], 1), expected: false},
+		"within bytes":        {conf: &Config{MaxCacheBytes: 20}, db: newDB(&rows, 2), expected: false},
+		"exceeding bytes":     {conf: &Config{MaxCacheBytes: 19}, db: newDB(&rows, 2), expected: true},
+		"rows checked first":  {conf: &Config{MaxCacheRows: 1, MaxCacheBytes: 1 << 20}, db: newDB(&rows, 2), expected: true},
+		"bytes after rows ok": {conf: &Config{MaxCacheRows: 10, MaxCacheBytes: 10}, db: newDB(&rows, 2), expected: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			caches := &Caches{Conf: tc.conf}
+			if act := caches.oversized(tc.db); act != tc.expected {
+				t.Errorf("expected oversized to return %t, got %t", tc.expected, act)
+			}
+		})
+	}
+
+	t.Run("skipped store", func(t *testing.T) {
+		observer := &observerMock{}
+		cacher := &cacherMock{}
+		caches := &Caches{
+			Conf: &Config{
+				Cacher:       cacher,
+				MaxCacheRows: 1,
+				Observer:     observer,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					*db.Statement.Dest.(*[]mockDest) = rows
+				},
+			},
+		}
+
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &[]mockDest{}
+		db.Statement.Table = "users"
+		db.Statement.SQL.WriteString("demo-query")
+		caches.query(db)
+
+		if len(*db.Statement.Dest.(*[]mockDest)) != 2 {
+			t.Error("expected the oversized result to still be returned")
+		}
+		if act := caches.Stats(); act.Stores != 0 || act.Skipped != 1 {
+			t.Errorf("expected the oversized result to be counted as skipped rather than stored, got %+v", act)
+		}
+		if !reflect.DeepEqual(observer.events, []string{"miss:users", "skip:users:oversized"}) {
+			t.Errorf("expected the observer to be notified about the skipped store, got %v", observer.events)
+		}
+	})
+}
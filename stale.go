@@ -0,0 +1,98 @@
+package caches
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// revalidateEasePrefix separates the revalidations from the other queries in the easer queue
+const revalidateEasePrefix = "revalidate::"
+
+// UnknownExpiry is returned by the ExpiryCacher implementations which cannot tell when a value expires,
+// the values which never expire report it as well since neither can go stale nor be refreshed ahead
+var UnknownExpiry = time.Time{}
+
+// ExpiryCacher is an optional extension of Cacher, for backends able to report when the values they return expire
+// The expiry drives StaleWhileRevalidate and RefreshAheadFactor for the values whose payload does not tell it, e.g.
+// the ones stored by a Cacher which does not keep the whole Query or whose ttl was altered in the backend.
+type ExpiryCacher interface {
+	// GetWithExpiry impl should behave as Get, and return the instant the value expires along with it,
+	// or UnknownExpiry when it cannot tell
+	GetWithExpiry(ctx context.Context, key string, q *Query[any]) (*Query[any], time.Time, error)
+}
+
+// getWithExpiryOf gets the key along with its expiry when the Cacher is an ExpiryCacher, which is unknown otherwise
+func getWithExpiryOf(ctx context.Context, cacher Cacher, key string, q *Query[any]) (*Query[any], time.Time, error) {
+	if expirer, ok := cacher.(ExpiryCacher); ok {
+		return expirer.GetWithExpiry(ctx, key, q)
+	}
+	res, err := cacher.Get(ctx, key, q)
+	return res, UnknownExpiry, err
+}
+
+// withExpiry fills the instants the payload of a cached result does not tell from the expiry reported by the backend,
+// the ones the payload tells are kept as is
+// The result is assumed to have been stored for the ttl of its table, since the jitter it was stored with is unknown.
+func (c *Caches) withExpiry(db *gorm.DB, q *Query[any], expiresAt time.Time) {
+	if expiresAt.IsZero() || !q.ExpiresAt.IsZero() {
+		return
+	}
+
+	q.ExpiresAt = expiresAt
+	freshUntil := expiresAt
+	if c.Conf.StaleWhileRevalidate > 0 && q.StaleAt.IsZero() {
+		freshUntil = expiresAt.Add(-c.Conf.StaleWhileRevalidate)
+		q.StaleAt = freshUntil
+	}
+	if factor := c.Conf.RefreshAheadFactor; factor > 0 && factor < 1 && q.RefreshAt.IsZero() {
+		if freshTTL := c.ttlOf(db); freshTTL > 0 {
+			q.RefreshAt = freshUntil.Add(-time.Duration(float64(freshTTL) * factor))
+		}
+	}
+}
+
+// staleTTLs returns the instant a result stored now for ttl goes stale, along with the ttl it is stored for,
+// which is extended by StaleWhileRevalidate so the stale value can still be served in the meantime
+func (c *Caches) staleTTLs(ttl time.Duration) (time.Time, time.Duration) {
+	if ttl <= 0 || c.Conf.StaleWhileRevalidate <= 0 {
+		return time.Time{}, ttl
+	}
+	return time.Now().Add(ttl), ttl + c.Conf.StaleWhileRevalidate
+}
+
+// refreshAt returns the instant a hit on a result stored now for ttl refreshes it ahead of its expiry,
+// the zero value when RefreshAheadFactor is not set or the ttl never expires
+func (c *Caches) refreshAt(ttl time.Duration) time.Time {
+	factor := c.Conf.RefreshAheadFactor
+	if ttl <= 0 || factor <= 0 || factor >= 1 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl - time.Duration(float64(ttl)*factor))
+}
+
+// revalidate refreshes the stale value of the query, or the one due for a refresh ahead of its expiry, in the background,
+// unless a revalidation of it is already running
+// The revalidation runs a copy of the statement with the Refresh semantics, so the caller is free to reuse it, and
+// its errors are dropped since the stale value keeps being served until it expires
+func (c *Caches) revalidate(db *gorm.DB, identifier string) {
+	if c.queue == nil {
+		return
+	}
+
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tx := db.Session(&gorm.Session{Context: Refresh(detachedContext{ctx})})
+	dest := newDestOf(db.Statement.Dest)
+
+	easeAsync(&queryTask{
+		id: revalidateEasePrefix + identifier,
+		db: tx,
+		queryCb: func(tx *gorm.DB) {
+			tx.Find(dest)
+		},
+	}, c.queue)
+}
@@ -0,0 +1,343 @@
+package caches
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_StaleWhileRevalidate(t *testing.T) {
+	var (
+		executed int64
+		result   atomic.Value
+		release  = make(chan struct{})
+	)
+	result.Store("v1")
+
+	cacher := NewMemoryCacher()
+	caches := &Caches{Conf: &Config{
+		Cacher:               cacher,
+		DefaultTTL:           50 * time.Millisecond,
+		StaleWhileRevalidate: time.Minute,
+	}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		if atomic.AddInt64(&executed, 1) > 1 {
+			<-release
+		}
+		*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: result.Load().(string)}}
+		db.Statement.RowsAffected = 1
+	}
+	query := func() string {
+		var users []mockDest
+		if err := db.Table("users").Find(&users).Error; err != nil {
+			t.Fatalf("the query resulted into an unexpected error, %v", err)
+		}
+		return users[0].Result
+	}
+
+	query()
+	result.Store("v2")
+	time.Sleep(60 * time.Millisecond)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if act := query(); act != "v1" {
+				t.Errorf("expected the stale value to be served, got %s", act)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for caches.Stats().Stores < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if act := atomic.LoadInt64(&executed); act != 2 {
+		t.Errorf("expected a single revalidation of the stale value, the database was queried %d times", act)
+	}
+	if act := query(); act != "v2" {
+		t.Errorf("expected the revalidated value to be served, got %s", act)
+	}
+	if act := caches.Stats(); act.Hits != 6 || act.Misses != 1 {
+		t.Errorf("expected every query but the first one to hit, got %+v", act)
+	}
+}
+
+func TestCaches_staleTTLs(t *testing.T) {
+	caches := &Caches{Conf: &Config{StaleWhileRevalidate: time.Minute}}
+
+	staleAt, ttl := caches.staleTTLs(time.Second)
+	if ttl != time.Minute+time.Second || staleAt.IsZero() {
+		t.Errorf("expected the ttl to be extended, got %s and %s", ttl, staleAt)
+	}
+
+	if staleAt, ttl := caches.staleTTLs(0); ttl != 0 || !staleAt.IsZero() {
+		t.Errorf("expected values without a ttl not to go stale, got %s and %s", ttl, staleAt)
+	}
+}
+
+func TestCaches_RefreshAhead(t *testing.T) {
+	var (
+		executed int64
+		result   atomic.Value
+		release  = make(chan struct{})
+	)
+	result.Store("v1")
+
+	caches := &Caches{Conf: &Config{
+		Cacher:             NewMemoryCacher(),
+		DefaultTTL:         200 * time.Millisecond,
+		RefreshAheadFactor: 0.5,
+	}}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	if err := db.Use(caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		if atomic.AddInt64(&executed, 1) > 1 {
+			<-release
+		}
+		*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: result.Load().(string)}}
+		db.Statement.RowsAffected = 1
+	}
+	query := func() string {
+		var users []mockDest
+		if err := db.Table("users").Find(&users).Error; err != nil {
+			t.Fatalf("the query resulted into an unexpected error, %v", err)
+		}
+		return users[0].Result
+	}
+
+	query()
+	result.Store("v2")
+	if act := query(); act != "v1" || atomic.LoadInt64(&executed) != 1 {
+		t.Fatalf("expected a hit before the refresh threshold not to refresh the value, got %s", act)
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if act := query(); act != "v1" {
+				t.Errorf("expected the value to be served while it is refreshed, got %s", act)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for caches.Stats().Stores < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if act := atomic.LoadInt64(&executed); act != 2 {
+		t.Errorf("expected a single refresh of the value, the database was queried %d times", act)
+	}
+	if act := query(); act != "v2" {
+		t.Errorf("expected the refreshed value to be served, got %s", act)
+	}
+	if act := caches.Stats(); act.Hits != 7 || act.Misses != 1 {
+		t.Errorf("expected every query but the first one to hit, got %+v", act)
+	}
+}
+
+func TestCaches_refreshAt(t *testing.T) {
+	testCases := map[string]struct {
+		factor   float64
+		ttl      time.Duration
+		expected time.Duration
+	}{
+		"disabled":        {factor: 0, ttl: time.Minute},
+		"without ttl":     {factor: 0.2, ttl: 0},
+		"invalid factor":  {factor: 1, ttl: time.Minute},
+		"last 20 percent": {factor: 0.2, ttl: time.Minute, expected: 48 * time.Second},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			caches := &Caches{Conf: &Config{RefreshAheadFactor: tc.factor}}
+			act := caches.refreshAt(tc.ttl)
+			if tc.expected == 0 {
+				if !act.IsZero() {
+					t.Errorf("expected no refresh, got %s", act)
+				}
+				return
+			}
+			if until := time.Until(act); until > tc.expected || until < tc.expected-time.Second {
+				t.Errorf("expected the refresh to happen in %s, got %s", tc.expected, until)
+			}
+		})
+	}
+}
+
+func TestCaches_withExpiry(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	testCases := map[string]struct {
+		conf      Config
+		q         Query[any]
+		expiresAt time.Time
+		expected  Query[any]
+	}{
+		"unknown expiry": {
+			conf: Config{StaleWhileRevalidate: time.Minute},
+		},
+		"expiry only": {
+			expiresAt: expiresAt,
+			expected:  Query[any]{ExpiresAt: expiresAt},
+		},
+		"stale while revalidate": {
+			conf:      Config{StaleWhileRevalidate: time.Minute},
+			expiresAt: expiresAt,
+			expected:  Query[any]{ExpiresAt: expiresAt, StaleAt: expiresAt.Add(-time.Minute)},
+		},
+		"refresh ahead of the stale instant": {
+			conf:      Config{DefaultTTL: 10 * time.Minute, StaleWhileRevalidate: time.Minute, RefreshAheadFactor: 0.5},
+			expiresAt: expiresAt,
+			expected: Query[any]{
+				ExpiresAt: expiresAt, StaleAt: expiresAt.Add(-time.Minute), RefreshAt: expiresAt.Add(-6 * time.Minute),
+			},
+		},
+		"refresh ahead without ttl": {
+			conf:      Config{RefreshAheadFactor: 0.5},
+			expiresAt: expiresAt,
+			expected:  Query[any]{ExpiresAt: expiresAt},
+		},
+		"payload instants are kept": {
+			conf:      Config{StaleWhileRevalidate: time.Minute},
+			q:         Query[any]{ExpiresAt: expiresAt.Add(time.Hour)},
+			expiresAt: expiresAt,
+			expected:  Query[any]{ExpiresAt: expiresAt.Add(time.Hour)},
+		},
+	}
+
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			conf := tc.conf
+			caches := &Caches{Conf: &conf}
+			q := tc.q
+			caches.withExpiry(db.Table("users"), &q, tc.expiresAt)
+			if !q.ExpiresAt.Equal(tc.expected.ExpiresAt) || !q.StaleAt.Equal(tc.expected.StaleAt) ||
+				!q.RefreshAt.Equal(tc.expected.RefreshAt) {
+				t.Errorf("expected %+v, got %+v", tc.expected, q)
+			}
+		})
+	}
+}
+
+func TestCaches_backendExpiry(t *testing.T) {
+	run := func(t *testing.T, cacher Cacher) string {
+		var executed int64
+		caches := &Caches{Conf: &Config{
+			Cacher:               cacher,
+			DefaultTTL:           50 * time.Millisecond,
+			StaleWhileRevalidate: time.Minute,
+		}}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			result := "v1"
+			if atomic.AddInt64(&executed, 1) > 1 {
+				result = "v2"
+			}
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: result}}
+			db.Statement.RowsAffected = 1
+		}
+		query := func() string {
+			var users []mockDest
+			if err := db.Table("users").Find(&users).Error; err != nil {
+				t.Fatalf("the query resulted into an unexpected error, %v", err)
+			}
+			return users[0].Result
+		}
+
+		query()
+		time.Sleep(60 * time.Millisecond)
+		if act := query(); act != "v1" {
+			t.Fatalf("expected the cached value to be served, got %s", act)
+		}
+		deadline := time.Now().Add(200 * time.Millisecond)
+		for caches.Stats().Stores < 2 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		return query()
+	}
+
+	t.Run("the backend expiry drives the revalidation", func(t *testing.T) {
+		if act := run(t, &instantlessExpiryCacher{instantlessCacher{NewMemoryCacher()}}); act != "v2" {
+			t.Errorf("expected the value gone stale by the backend expiry to be revalidated, got %s", act)
+		}
+	})
+
+	t.Run("an unknown expiry degrades to serving the value", func(t *testing.T) {
+		if act := run(t, &instantlessCacher{NewMemoryCacher()}); act != "v1" {
+			t.Errorf("expected the value to be served until the backend expires it, got %s", act)
+		}
+	})
+}
+
+// instantlessCacher drops the instants of the payloads it returns, as the Cacher implementations which do not keep the
+// whole Query would, and instantlessExpiryCacher reports the expiry of the values along with them
+type instantlessCacher struct {
+	memory *MemoryCacher
+}
+
+func (c *instantlessCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	res, err := c.memory.Get(ctx, key, q)
+	return stripInstants(res), err
+}
+
+func (c *instantlessCacher) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	return c.memory.Store(ctx, key, val, ttl)
+}
+
+func (c *instantlessCacher) Invalidate(ctx context.Context, tables ...string) error {
+	return c.memory.Invalidate(ctx, tables...)
+}
+
+type instantlessExpiryCacher struct {
+	instantlessCacher
+}
+
+func (c *instantlessExpiryCacher) GetWithExpiry(ctx context.Context, key string, q *Query[any]) (*Query[any], time.Time, error) {
+	res, expiresAt, err := c.memory.GetWithExpiry(ctx, key, q)
+	return stripInstants(res), expiresAt, err
+}
+
+func stripInstants(res *Query[any]) *Query[any] {
+	if res != nil {
+		res.ExpiresAt, res.StaleAt, res.RefreshAt = time.Time{}, time.Time{}, time.Time{}
+	}
+	return res
+}
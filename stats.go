@@ -0,0 +1,92 @@
+package caches
+
+import (
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the plugin counters
+type Stats struct {
+	// Hits counts the queries served from the Cacher
+	Hits uint64
+	// Misses counts the queries which were looked up in the Cacher without finding a valid value
+	Misses uint64
+	// GetErrors counts the lookups the Cacher failed, which are counted as misses as well
+	GetErrors uint64
+	// Stores counts the query results successfully stored in the Cacher
+	Stores uint64
+	// StoreErrors counts the query results the Cacher failed to store
+	StoreErrors uint64
+	// Skipped counts the query results which were deliberately not stored, e.g. for being oversized
+	Skipped uint64
+	// Dropped counts the query results which were not stored since the AsyncStore queue was full
+	Dropped uint64
+	// Coalesced counts the queries served by the easer from an identical query running at the same time
+	Coalesced uint64
+	// TypeMismatches counts the values the Cacher returned with a destination of another type than the query's,
+	// which are counted as misses as well
+	TypeMismatches uint64
+}
+
+// cacheStats holds the counters updated by the concurrently running queries,
+// its fields are only accessed atomically
+type cacheStats struct {
+	hits        uint64
+	misses      uint64
+	getErrors   uint64
+	stores      uint64
+	storeErrors uint64
+	skipped     uint64
+	dropped     uint64
+	coalesced   uint64
+	mismatches  uint64
+
+	// inFlight is a gauge rather than a counter, so it is not reset
+	inFlight int64
+}
+
+// Stats returns a snapshot of the counters, each counter is read atomically but not all of them at once
+func (c *Caches) Stats() Stats {
+	return Stats{
+		Hits:           atomic.LoadUint64(&c.stats.hits),
+		Misses:         atomic.LoadUint64(&c.stats.misses),
+		GetErrors:      atomic.LoadUint64(&c.stats.getErrors),
+		Stores:         atomic.LoadUint64(&c.stats.stores),
+		StoreErrors:    atomic.LoadUint64(&c.stats.storeErrors),
+		Skipped:        atomic.LoadUint64(&c.stats.skipped),
+		Dropped:        atomic.LoadUint64(&c.stats.dropped),
+		Coalesced:      atomic.LoadUint64(&c.stats.coalesced),
+		TypeMismatches: atomic.LoadUint64(&c.stats.mismatches),
+	}
+}
+
+// ResetStats sets all the counters back to zero
+func (c *Caches) ResetStats() {
+	atomic.StoreUint64(&c.stats.hits, 0)
+	atomic.StoreUint64(&c.stats.misses, 0)
+	atomic.StoreUint64(&c.stats.getErrors, 0)
+	atomic.StoreUint64(&c.stats.stores, 0)
+	atomic.StoreUint64(&c.stats.storeErrors, 0)
+	atomic.StoreUint64(&c.stats.skipped, 0)
+	atomic.StoreUint64(&c.stats.dropped, 0)
+	atomic.StoreUint64(&c.stats.coalesced, 0)
+	atomic.StoreUint64(&c.stats.mismatches, 0)
+}
+
+// InFlight returns the number of distinct queries currently run by the easer, with other queries possibly waiting on them
+func (c *Caches) InFlight() int {
+	return int(atomic.LoadInt64(&c.stats.inFlight))
+}
+
+// InFlightWaiters returns the number of queries waiting on each of the queries currently run by the easer,
+// keyed by their identifier, the queries nobody waits on are included with a zero count
+func (c *Caches) InFlightWaiters() map[string]int {
+	waiters := make(map[string]int)
+	if c.queue == nil {
+		return waiters
+	}
+
+	c.queue.rangeTasks(func(id string, eq *eased) {
+		waiters[id] = int(atomic.LoadInt64(&eq.waiters))
+	})
+	return waiters
+}
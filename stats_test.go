@@ -0,0 +1,148 @@
+package caches
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_Stats(t *testing.T) {
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	t.Run("cacher", func(t *testing.T) {
+		caches := &Caches{
+			Conf: &Config{
+				Cacher: &cacherMock{},
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {},
+			},
+		}
+
+		caches.query(newDB())
+		caches.query(newDB())
+
+		expected := Stats{Hits: 1, Misses: 1, Stores: 1}
+		if act := caches.Stats(); act != expected {
+			t.Errorf("expected the stats to be %+v, got %+v", expected, act)
+		}
+
+		caches.ResetStats()
+		if act := caches.Stats(); act != (Stats{}) {
+			t.Errorf("expected the stats to be reset, got %+v", act)
+		}
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		caches := &Caches{
+			Conf: &Config{
+				Cacher: &cacherStoreErrorMock{},
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {},
+			},
+		}
+
+		caches.query(newDB())
+
+		expected := Stats{Misses: 1, StoreErrors: 1}
+		if act := caches.Stats(); act != expected {
+			t.Errorf("expected the stats to be %+v, got %+v", expected, act)
+		}
+	})
+
+	t.Run("easer", func(t *testing.T) {
+		caches := &Caches{
+			Conf: &Config{
+				Easer: true,
+			},
+			queue: newEaseQueue(),
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					time.Sleep(500 * time.Millisecond)
+				},
+			},
+		}
+
+		wg := &sync.WaitGroup{}
+		wg.Add(2)
+		go func() {
+			caches.query(newDB())
+			wg.Done()
+		}()
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			caches.query(newDB())
+			wg.Done()
+		}()
+		wg.Wait()
+
+		expected := Stats{Coalesced: 1}
+		if act := caches.Stats(); act != expected {
+			t.Errorf("expected the stats to be %+v, got %+v", expected, act)
+		}
+	})
+}
+
+func TestCaches_InFlight(t *testing.T) {
+	unblock := make(chan struct{})
+	caches := &Caches{
+		Conf: &Config{
+			Easer: true,
+		},
+		queue: newEaseQueue(),
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				<-unblock
+			},
+		},
+	}
+	newDB := func(sql string) *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString(sql)
+		return db
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, sql := range []string{"first", "first", "first", "second"} {
+		wg.Add(1)
+		go func(sql string) {
+			caches.query(newDB(sql))
+			wg.Done()
+		}(sql)
+	}
+
+	first := caches.identifierOf(newDB("first"))
+	second := caches.identifierOf(newDB("second"))
+	expected := map[string]int{first: 2, second: 0}
+	deadline := time.Now().Add(time.Second)
+	for !reflect.DeepEqual(caches.InFlightWaiters(), expected) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if act := caches.InFlightWaiters(); !reflect.DeepEqual(act, expected) {
+		t.Errorf("expected the waiters to be %v, got %v", expected, act)
+	}
+	if act := caches.InFlight(); act != 2 {
+		t.Errorf("expected %d distinct queries in flight, got %d", 2, act)
+	}
+
+	close(unblock)
+	wg.Wait()
+	if act := caches.InFlight(); act != 0 {
+		t.Errorf("expected no query in flight once completed, got %d", act)
+	}
+	if act := caches.InFlightWaiters(); len(act) != 0 {
+		t.Errorf("expected no waiters once completed, got %v", act)
+	}
+}
@@ -0,0 +1,315 @@
+package caches
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// queryTables returns the tables a built query reads from,
+// a nil result means they could not be determined, e.g. for raw SQL or raw joins
+func queryTables(db *gorm.DB) []string {
+	stmt := db.Statement
+	if stmt.Table == "" {
+		return nil
+	}
+
+	// Aliased tables and subqueries only expose an alias as the statement table
+	if expr := stmt.TableExpr; expr != nil && (len(expr.Vars) > 0 || strings.Contains(expr.SQL, " ")) {
+		return nil
+	}
+
+	// Raw SQL is not built from clauses, so the table of the model does not tell which tables are read
+	c, ok := stmt.Clauses["FROM"]
+	if !ok {
+		return nil
+	}
+
+	from, ok := c.Expression.(clause.From)
+	if !ok {
+		return []string{stmt.Table}
+	}
+
+	tables := []string{stmt.Table}
+	for _, table := range from.Tables {
+		if table.Raw {
+			return nil
+		}
+		if table.Name == clause.CurrentTable {
+			continue
+		}
+		tables = append(tables, table.Name)
+	}
+
+	for _, join := range from.Joins {
+		if join.Expression != nil || join.Table.Raw {
+			return nil
+		}
+		tables = append(tables, join.Table.Name)
+	}
+
+	return tables
+}
+
+// mutationTables returns the tables a mutating statement writes to, its own table along with the tables
+// of the associations it saves, a nil result means they could not be determined
+func mutationTables(db *gorm.DB) []string {
+	stmt := db.Statement
+	if stmt.Table == "" {
+		return nil
+	}
+
+	tables := []string{stmt.Table}
+	if stmt.Schema == nil || !stmt.ReflectValue.IsValid() {
+		return tables
+	}
+
+	for _, rel := range stmt.Schema.Relationships.Relations {
+		if !savesAssociation(stmt, rel) {
+			continue
+		}
+
+		tables = appendTable(tables, rel.FieldSchema.Table)
+		if rel.JoinTable != nil {
+			tables = appendTable(tables, rel.JoinTable.Table)
+		}
+	}
+
+	sort.Strings(tables[1:])
+	return tables
+}
+
+// savesAssociation reports whether the statement holds a value for the association which is not omitted
+func savesAssociation(stmt *gorm.Statement, rel *schema.Relationship) bool {
+	// Relationships implied by other schemas have no field on this one
+	if rel.Field.Schema != stmt.Schema {
+		return false
+	}
+
+	for _, omit := range stmt.Omits {
+		if omit == rel.Name || omit == clause.Associations {
+			return false
+		}
+	}
+
+	holdsValue := func(v reflect.Value) bool {
+		v = reflect.Indirect(v)
+		if v.Kind() != reflect.Struct || v.Type() != stmt.Schema.ModelType {
+			return false
+		}
+		_, isZero := rel.Field.ValueOf(stmt.Context, v)
+		return !isZero
+	}
+
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			if holdsValue(stmt.ReflectValue.Index(i)) {
+				return true
+			}
+		}
+		return false
+	default:
+		return holdsValue(stmt.ReflectValue)
+	}
+}
+
+func appendTable(tables []string, table string) []string {
+	for _, t := range tables {
+		if t == table {
+			return tables
+		}
+	}
+	return append(tables, table)
+}
+
+// cacheDecisionKey identifies a memoized canCacheTable decision,
+// both the table and the model take part in it since the rules can match either of them
+type cacheDecisionKey struct {
+	table string
+	model reflect.Type
+	ease  bool // set for the decisions of canEaseTable
+}
+
+// canCacheTable reports whether the query results may be cached, according to CanNotCachedTables and CanCachedTables
+// The exclusion list is consulted first, then the whitelist, which allows every table when empty
+func (c *Caches) canCacheTable(db *gorm.DB) bool {
+	if len(c.Conf.CanCachedTables) == 0 && len(c.Conf.CanNotCachedTables) == 0 {
+		return true
+	}
+
+	key := cacheDecisionKey{table: ruleTable(db.Statement)}
+	if !c.tableRulesByName {
+		// The rules are only matched against the model when there are models among them
+		key.model = modelTypeOf(db.Statement)
+	}
+	if decision, ok := c.cacheDecisions.Load(key); ok {
+		return decision
+	}
+
+	decision := !c.matchTable(c.Conf.CanNotCachedTables, key) &&
+		(len(c.Conf.CanCachedTables) == 0 || c.matchTable(c.Conf.CanCachedTables, key))
+	c.cacheDecisions.Store(key, decision, c.maxTableDecisions())
+	return decision
+}
+
+// canEaseTable reports whether the identical queries may be coalesced by the easer, according to EaseTables,
+// which allows every table when empty. The decisions are memoized along with the ones of canCacheTable
+func (c *Caches) canEaseTable(db *gorm.DB) bool {
+	if len(c.Conf.EaseTables) == 0 {
+		return true
+	}
+
+	key := cacheDecisionKey{table: ruleTable(db.Statement), ease: true}
+	if !c.tableRulesByName {
+		key.model = modelTypeOf(db.Statement)
+	}
+	if decision, ok := c.cacheDecisions.Load(key); ok {
+		return decision
+	}
+
+	decision := c.matchTable(c.Conf.EaseTables, key)
+	c.cacheDecisions.Store(key, decision, c.maxTableDecisions())
+	return decision
+}
+
+// ruleTable returns the table name the table rules are matched against, which is the statement table except for
+// the tables given to db.Table as expressions: gorm sets the alias of `report AS r` as the statement table,
+// and no table at all for a quoted name, so the table named by such an expression is used instead
+// The schema of a qualified name is dropped, as gorm does for the unquoted ones, and the subqueries keep their alias.
+func ruleTable(stmt *gorm.Statement) string {
+	expr := stmt.TableExpr
+	if expr == nil || len(expr.Vars) > 0 {
+		return stmt.Table
+	}
+
+	fields := strings.Fields(expr.SQL)
+	switch {
+	case len(fields) == 1, len(fields) == 2, len(fields) == 3 && strings.EqualFold(fields[1], "AS"):
+	default:
+		return stmt.Table
+	}
+	if strings.HasPrefix(fields[0], "(") {
+		return stmt.Table
+	}
+
+	name := fields[0]
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	if name = strings.Trim(name, "`\"[]"); name == "" {
+		return stmt.Table
+	}
+	return name
+}
+
+// matchTable reports whether any of the rules matches, a string rule is a regex matched against the table name,
+// any other rule is a model, or its reflect.Type, matched against the model of the statement
+// An invalid regex never matches, Initialize rejects the ones configured before it runs
+func (c *Caches) matchTable(rules []any, key cacheDecisionKey) bool {
+	for _, rule := range rules {
+		switch r := rule.(type) {
+		case string:
+			if key.table == "" {
+				continue
+			}
+			if pattern, err := c.tablePattern(r); err == nil && pattern.MatchString(key.table) {
+				return true
+			}
+		case reflect.Type:
+			if key.model != nil && indirectType(r) == key.model {
+				return true
+			}
+		default:
+			if key.model != nil && indirectType(reflect.TypeOf(rule)) == key.model {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tablePattern returns the compiled regex of a string rule, anchored when AnchorTablePatterns is set
+func (c *Caches) tablePattern(rule string) (*regexp.Regexp, error) {
+	if c.Conf.AnchorTablePatterns {
+		return c.compilePattern("^(?:" + rule + ")$")
+	}
+	return c.compilePattern(rule)
+}
+
+// compilePattern returns the compiled regex of a table pattern, the patterns are compiled once, along with their errors
+func (c *Caches) compilePattern(expr string) (*regexp.Regexp, error) {
+	if compiled, ok := c.tablePatterns.Load(expr); ok {
+		res := compiled.(compiledPattern)
+		return res.pattern, res.err
+	}
+
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		err = fmt.Errorf("caches: invalid table pattern %q: %w", expr, err)
+	}
+	c.tablePatterns.Store(expr, compiledPattern{pattern: pattern, err: err})
+	return pattern, err
+}
+
+type compiledPattern struct {
+	pattern *regexp.Regexp
+	err     error
+}
+
+// compileTablePatterns compiles the string rules of CanCachedTables, CanNotCachedTables, EaseTables and
+// WriteBackTables, and the keys
+// of TableTTL, returning the error of the first invalid one. It records whether all the rules are strings along the way.
+func (c *Caches) compileTablePatterns() error {
+	byName := true
+	for _, rules := range [][]any{c.Conf.CanCachedTables, c.Conf.CanNotCachedTables, c.Conf.EaseTables, c.Conf.WriteBackTables} {
+		for _, rule := range rules {
+			r, ok := rule.(string)
+			if !ok {
+				byName = false
+				continue
+			}
+			if _, err := c.tablePattern(r); err != nil {
+				return err
+			}
+		}
+	}
+	c.tableRulesByName = byName
+
+	for pattern := range c.Conf.TableTTL {
+		if _, err := c.compilePattern(pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// modelTypeOf returns the struct type of the statement model, falling back to its destination,
+// without parsing or otherwise modifying the statement
+func modelTypeOf(stmt *gorm.Statement) reflect.Type {
+	model := stmt.Model
+	if model == nil {
+		model = stmt.Dest
+	}
+	if model == nil {
+		return nil
+	}
+
+	if t := indirectType(reflect.TypeOf(model)); t != nil && t.Kind() == reflect.Struct {
+		return t
+	}
+	return nil
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t != nil && (t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,569 @@
+package caches
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type tablesRoleModel struct {
+	gorm.Model
+	Name string
+}
+
+type tablesUserModel struct {
+	gorm.Model
+	Name   string
+	RoleId uint
+	Role   *tablesRoleModel   `gorm:"foreignKey:role_id;references:id"`
+	Orders []tablesOrderModel `gorm:"foreignKey:UserId"`
+}
+
+type tablesOrderModel struct {
+	gorm.Model
+	UserId uint
+}
+
+// captureTables runs fn in dry run mode and returns what queryTables reports for each of the executed queries
+func captureTables(t *testing.T, fn func(db *gorm.DB)) [][]string {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+
+	var captured [][]string
+	if err := db.Callback().Query().After("gorm:query").Register("test:capture_tables", func(db *gorm.DB) {
+		captured = append(captured, queryTables(db))
+	}); err != nil {
+		t.Fatalf("registering the capture callback resulted into an unexpected error, %s", err.Error())
+	}
+
+	fn(db)
+	return captured
+}
+
+func Test_queryTables(t *testing.T) {
+	testCases := map[string]struct {
+		query    func(db *gorm.DB)
+		expected []string
+	}{
+		"model": {
+			query: func(db *gorm.DB) {
+				db.Find(&[]tablesUserModel{})
+			},
+			expected: []string{"tables_user_models"},
+		},
+		"table": {
+			query: func(db *gorm.DB) {
+				db.Table("reports").Find(&[]map[string]interface{}{})
+			},
+			expected: []string{"reports"},
+		},
+		"aliased table": {
+			query: func(db *gorm.DB) {
+				db.Table("reports AS r").Find(&[]map[string]interface{}{})
+			},
+			expected: nil,
+		},
+		"association join": {
+			query: func(db *gorm.DB) {
+				db.Joins("Role").Find(&[]tablesUserModel{})
+			},
+			expected: []string{"tables_user_models", "tables_role_models"},
+		},
+		"raw join": {
+			query: func(db *gorm.DB) {
+				db.Joins("JOIN tables_role_models ON tables_role_models.id = role_id").Find(&[]tablesUserModel{})
+			},
+			expected: nil,
+		},
+		"raw sql": {
+			query: func(db *gorm.DB) {
+				db.Raw("SELECT * FROM tables_role_models").Find(&[]tablesUserModel{})
+			},
+			expected: nil,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			captured := captureTables(t, tc.query)
+			if len(captured) != 1 {
+				t.Fatalf("expected a single query to be executed, got %d", len(captured))
+			}
+			if !reflect.DeepEqual(captured[0], tc.expected) {
+				t.Errorf("queryTables expected to return %v, got %v", tc.expected, captured[0])
+			}
+		})
+	}
+}
+
+func TestCaches_canCacheTable(t *testing.T) {
+	newDB := func(table string, model any) *gorm.DB {
+		return &gorm.DB{Statement: &gorm.Statement{Table: table, Model: model, Dest: model}}
+	}
+
+	testCases := map[string]struct {
+		conf     *Config
+		db       *gorm.DB
+		expected bool
+	}{
+		"no rules": {
+			conf:     &Config{},
+			db:       newDB("users", &tablesUserModel{}),
+			expected: true,
+		},
+		"whitelisted by regex": {
+			conf:     &Config{CanCachedTables: []any{"^tables_user"}},
+			db:       newDB("tables_user_models", &tablesUserModel{}),
+			expected: true,
+		},
+		"whitelisted by model": {
+			conf:     &Config{CanCachedTables: []any{&tablesUserModel{}}},
+			db:       newDB("tables_user_models", &[]tablesUserModel{}),
+			expected: true,
+		},
+		"whitelisted by model type": {
+			conf:     &Config{CanCachedTables: []any{reflect.TypeOf(tablesUserModel{})}},
+			db:       newDB("tables_user_models", &tablesUserModel{}),
+			expected: true,
+		},
+		"not whitelisted": {
+			conf:     &Config{CanCachedTables: []any{"^orders$", &tablesRoleModel{}}},
+			db:       newDB("tables_user_models", &tablesUserModel{}),
+			expected: false,
+		},
+		"excluded by regex": {
+			conf:     &Config{CanNotCachedTables: []any{"^tables_user"}},
+			db:       newDB("tables_user_models", &tablesUserModel{}),
+			expected: false,
+		},
+		"excluded by model": {
+			conf:     &Config{CanNotCachedTables: []any{tablesUserModel{}}},
+			db:       newDB("tables_user_models", &tablesUserModel{}),
+			expected: false,
+		},
+		"not excluded": {
+			conf:     &Config{CanNotCachedTables: []any{"^orders$"}},
+			db:       newDB("tables_user_models", &tablesUserModel{}),
+			expected: true,
+		},
+		"substring match": {
+			conf:     &Config{CanCachedTables: []any{"user"}},
+			db:       newDB("user_audit_log", nil),
+			expected: true,
+		},
+		"anchored exact match": {
+			conf:     &Config{CanCachedTables: []any{"user"}, AnchorTablePatterns: true},
+			db:       newDB("user", nil),
+			expected: true,
+		},
+		"anchored substring": {
+			conf:     &Config{CanCachedTables: []any{"user"}, AnchorTablePatterns: true},
+			db:       newDB("user_audit_log", nil),
+			expected: false,
+		},
+		"anchored alternation": {
+			conf:     &Config{CanNotCachedTables: []any{"users|orders"}, AnchorTablePatterns: true},
+			db:       newDB("orders", nil),
+			expected: false,
+		},
+		"anchored exclusion": {
+			conf:     &Config{CanNotCachedTables: []any{"user"}, AnchorTablePatterns: true},
+			db:       newDB("user_audit_log", nil),
+			expected: true,
+		},
+		"invalid regex never matches": {
+			conf:     &Config{CanCachedTables: []any{"users("}},
+			db:       newDB("users(", nil),
+			expected: false,
+		},
+		"exclusion takes precedence": {
+			conf: &Config{
+				CanCachedTables:    []any{"^tables_"},
+				CanNotCachedTables: []any{&tablesUserModel{}},
+			},
+			db:       newDB("tables_user_models", &tablesUserModel{}),
+			expected: false,
+		},
+		"whitelist applies after exclusion": {
+			conf: &Config{
+				CanCachedTables:    []any{"^tables_"},
+				CanNotCachedTables: []any{&tablesUserModel{}},
+			},
+			db:       newDB("tables_role_models", &tablesRoleModel{}),
+			expected: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			caches := &Caches{Conf: tc.conf}
+			for i := 0; i < 2; i++ { // The second decision is served by the memo
+				if act := caches.canCacheTable(tc.db); act != tc.expected {
+					t.Errorf("expected canCacheTable to return %t, got %t", tc.expected, act)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid regexes fail the initialization", func(t *testing.T) {
+		for _, conf := range []*Config{
+			{CanCachedTables: []any{"^users$", "users("}},
+			{CanNotCachedTables: []any{"[orders"}},
+			{TableTTL: map[string]time.Duration{"users(": time.Minute}},
+		} {
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+			if err := db.Use(&Caches{Conf: conf}); err == nil || !strings.Contains(err.Error(), "invalid table pattern") {
+				t.Errorf("expected the invalid table pattern to fail the initialization, got %v", err)
+			}
+		}
+	})
+
+	t.Run("patterns are compiled upon initialization", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{
+			CanCachedTables:    []any{"^tables_", &tablesUserModel{}},
+			CanNotCachedTables: []any{"_audit$"},
+			TableTTL:           map[string]time.Duration{"^tables_": time.Minute, "^orders$": time.Second},
+		}}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+
+		count := func() (n int) {
+			caches.tablePatterns.Range(func(_, _ interface{}) bool {
+				n++
+				return true
+			})
+			return n
+		}
+		if act := count(); act != 3 {
+			t.Fatalf("expected the 3 distinct patterns to be compiled, got %d", act)
+		}
+		for i := 0; i < 10; i++ {
+			tableDB := newDB(fmt.Sprintf("tables_%d", i), nil)
+			caches.canCacheTable(tableDB)
+			caches.ttlOf(tableDB)
+		}
+		if act := count(); act != 3 {
+			t.Errorf("expected the compiled patterns to be reused for the new tables, got %d patterns", act)
+		}
+	})
+
+	t.Run("regex rules are matched by table name alone", func(t *testing.T) {
+		for conf, byName := range map[*Config]bool{
+			{CanCachedTables: []any{"^tables_"}, CanNotCachedTables: []any{"_audit$"}}: true,
+			{CanCachedTables: []any{"^tables_", &tablesUserModel{}}}:                   false,
+		} {
+			caches := &Caches{Conf: conf}
+			if err := caches.compileTablePatterns(); err != nil {
+				t.Fatalf("compiling the table patterns resulted into an unexpected error, %v", err)
+			}
+			if caches.tableRulesByName != byName {
+				t.Fatalf("expected the rules %v to be matched by name alone: %t", conf.CanCachedTables, byName)
+			}
+
+			if !caches.canCacheTable(newDB("tables_user_models", &tablesUserModel{})) {
+				t.Error("expected the whitelisted table to be cacheable")
+			}
+			_, memoized := caches.cacheDecisions.Load(cacheDecisionKey{table: "tables_user_models"})
+			if memoized != byName {
+				t.Errorf("expected the decision to be memoized without model: %t, got %t", byName, memoized)
+			}
+		}
+	})
+
+	t.Run("the statement is left untouched", func(t *testing.T) {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		parsed := db.Model(&tablesUserModel{})
+		if err := parsed.Statement.Parse(parsed.Statement.Model); err != nil {
+			t.Fatalf("parsing the model resulted into an unexpected error, %v", err)
+		}
+
+		for name, db := range map[string]*gorm.DB{
+			"destination only": {Statement: &gorm.Statement{Table: "tables_user_models", Dest: &[]tablesUserModel{}}},
+			"model only":       {Statement: &gorm.Statement{Model: &tablesRoleModel{}}},
+			"parsed schema":    parsed,
+			"map destination":  {Statement: &gorm.Statement{Table: "report_v2", Dest: &[]map[string]interface{}{}}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				stmt := db.Statement
+				table, model, dest, schema := stmt.Table, stmt.Model, stmt.Dest, stmt.Schema
+				caches := &Caches{Conf: &Config{
+					CanCachedTables: []any{"^tables_", &tablesRoleModel{}},
+					EaseTables:      []any{&tablesUserModel{}},
+					TableTTL:        map[string]time.Duration{"^tables_": time.Minute},
+				}}
+				caches.canCacheTable(db)
+				caches.canEaseTable(db)
+				caches.ttlOf(db)
+
+				if stmt.Table != table || stmt.Model != model || stmt.Dest != dest || stmt.Schema != schema {
+					t.Errorf("expected the table decisions not to alter the statement, got %q, %v, %v and %v",
+						stmt.Table, stmt.Model, stmt.Dest, stmt.Schema)
+				}
+			})
+		}
+	})
+
+	t.Run("decisions are memoized per table and model", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{CanNotCachedTables: []any{&tablesUserModel{}}}}
+
+		if caches.canCacheTable(newDB("shared", &tablesUserModel{})) {
+			t.Error("expected the excluded model not to be cacheable")
+		}
+		if !caches.canCacheTable(newDB("shared", &tablesRoleModel{})) {
+			t.Error("expected another model of the same table not to share the memoized decision")
+		}
+	})
+}
+
+func TestCaches_canCacheTable_withoutModel(t *testing.T) {
+	type reportRow struct {
+		Total int
+	}
+	newCaches := func(t *testing.T, conf *Config) (*gorm.DB, *int) {
+		var executed int
+		conf.Cacher = NewMemoryCacher()
+		caches := &Caches{Conf: conf}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			executed++
+			db.Statement.RowsAffected = 1
+		}
+		return db, &executed
+	}
+
+	tables := []string{"report_v2", "report_v2 AS r", "report_v2 r", "`report_v2`", "public.report_v2"}
+	for _, table := range tables {
+		t.Run(table, func(t *testing.T) {
+			db, executed := newCaches(t, &Config{CanCachedTables: []any{"^report_v2$"}})
+			db.Table(table).Where("total > ?", 1).Find(&[]reportRow{})
+			db.Table(table).Where("total > ?", 1).Find(&[]reportRow{})
+			if *executed != 1 {
+				t.Errorf("expected the whitelisted table to be cached, got %d queries", *executed)
+			}
+
+			db, executed = newCaches(t, &Config{CanNotCachedTables: []any{"^report_v2$"}})
+			db.Table(table).Where("total > ?", 1).Find(&[]reportRow{})
+			db.Table(table).Where("total > ?", 1).Find(&[]reportRow{})
+			if *executed != 2 {
+				t.Errorf("expected the excluded table not to be cached, got %d queries", *executed)
+			}
+		})
+	}
+
+	t.Run("other tables", func(t *testing.T) {
+		db, executed := newCaches(t, &Config{CanCachedTables: []any{"^report_v2$"}})
+		db.Table("report_v1").Find(&[]reportRow{})
+		db.Table("report_v1").Find(&[]reportRow{})
+		if *executed != 2 {
+			t.Errorf("expected the table out of the whitelist not to be cached, got %d queries", *executed)
+		}
+	})
+}
+
+func Test_ruleTable(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+
+	testCases := map[string]struct {
+		table    func(db *gorm.DB) *gorm.DB
+		expected string
+	}{
+		"model":      {table: func(db *gorm.DB) *gorm.DB { return db.Model(&tablesUserModel{}) }, expected: "tables_user_models"},
+		"plain":      {table: func(db *gorm.DB) *gorm.DB { return db.Table("report_v2") }, expected: "report_v2"},
+		"alias":      {table: func(db *gorm.DB) *gorm.DB { return db.Table("report_v2 AS r") }, expected: "report_v2"},
+		"bare alias": {table: func(db *gorm.DB) *gorm.DB { return db.Table("report_v2 r") }, expected: "report_v2"},
+		"quoted":     {table: func(db *gorm.DB) *gorm.DB { return db.Table("`report_v2`") }, expected: "report_v2"},
+		"qualified":  {table: func(db *gorm.DB) *gorm.DB { return db.Table(`"public"."report_v2" AS r`) }, expected: "report_v2"},
+		"subquery": {
+			table:    func(db *gorm.DB) *gorm.DB { return db.Table("(?) AS sub", db.Table("report_v2")) },
+			expected: "sub",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			tx := tc.table(db.Session(&gorm.Session{DryRun: true})).Find(&[]map[string]interface{}{})
+			if act := ruleTable(tx.Statement); act != tc.expected {
+				t.Errorf("expected the rules to be matched against %q, got %q", tc.expected, act)
+			}
+		})
+	}
+}
+
+func TestCaches_canEaseTable(t *testing.T) {
+	newDB := func(table string, model any) *gorm.DB {
+		return &gorm.DB{Statement: &gorm.Statement{Table: table, Model: model, Dest: model}}
+	}
+
+	testCases := map[string]struct {
+		conf     *Config
+		db       *gorm.DB
+		expected bool
+	}{
+		"no rules": {
+			conf:     &Config{},
+			db:       newDB("analytics", nil),
+			expected: true,
+		},
+		"matched by regex": {
+			conf:     &Config{EaseTables: []any{"^analytics"}},
+			db:       newDB("analytics_events", nil),
+			expected: true,
+		},
+		"matched by model": {
+			conf:     &Config{EaseTables: []any{&tablesUserModel{}}},
+			db:       newDB("tables_user_models", &[]tablesUserModel{}),
+			expected: true,
+		},
+		"not matched": {
+			conf:     &Config{EaseTables: []any{"^analytics"}},
+			db:       newDB("lookups", nil),
+			expected: false,
+		},
+		"independent from the caching rules": {
+			conf:     &Config{EaseTables: []any{"^analytics$"}, CanNotCachedTables: []any{"^analytics$"}},
+			db:       newDB("analytics", nil),
+			expected: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			caches := &Caches{Conf: tc.conf}
+			if err := caches.compileTablePatterns(); err != nil {
+				t.Fatalf("compiling the table patterns resulted into an unexpected error, %v", err)
+			}
+			for i := 0; i < 2; i++ { // The second decision is served by the memo
+				if act := caches.canEaseTable(tc.db); act != tc.expected {
+					t.Errorf("expected canEaseTable to return %t, got %t", tc.expected, act)
+				}
+			}
+			if expected := len(tc.conf.CanNotCachedTables) == 0; caches.canCacheTable(tc.db) != expected {
+				t.Errorf("expected the memoized ease decision not to be taken for the cache decision %t", expected)
+			}
+		})
+	}
+}
+
+func BenchmarkCaches_canCacheTable(b *testing.B) {
+	for name, rules := range map[string][]any{
+		"regexes": {"^tables_user", "^tables_role"},
+		"models":  {"^tables_user", &tablesRoleModel{}},
+	} {
+		b.Run(name, func(b *testing.B) {
+			caches := &Caches{Conf: &Config{CanCachedTables: rules}}
+			if err := caches.compileTablePatterns(); err != nil {
+				b.Fatalf("compiling the table patterns resulted into an unexpected error, %v", err)
+			}
+			db := &gorm.DB{Statement: &gorm.Statement{Table: "tables_user_models", Dest: &[]tablesUserModel{}}}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				caches.canCacheTable(db)
+			}
+		})
+	}
+}
+
+func Test_mutationTables(t *testing.T) {
+	testCases := map[string]struct {
+		mutate   func(db *gorm.DB)
+		expected []string
+	}{
+		"create without associations": {
+			mutate: func(db *gorm.DB) {
+				db.Create(&tablesUserModel{Name: "ktsivkov"})
+			},
+			expected: []string{"tables_user_models"},
+		},
+		"create with associations": {
+			mutate: func(db *gorm.DB) {
+				db.Create(&tablesUserModel{
+					Name:   "ktsivkov",
+					Role:   &tablesRoleModel{Name: "Admin"},
+					Orders: []tablesOrderModel{{}},
+				})
+			},
+			expected: []string{"tables_user_models", "tables_order_models", "tables_role_models"},
+		},
+		"create with omitted associations": {
+			mutate: func(db *gorm.DB) {
+				db.Omit("Role").Create(&[]tablesUserModel{{
+					Name:   "ktsivkov",
+					Role:   &tablesRoleModel{Name: "Admin"},
+					Orders: []tablesOrderModel{{}},
+				}})
+			},
+			expected: []string{"tables_user_models", "tables_order_models"},
+		},
+		"update": {
+			mutate: func(db *gorm.DB) {
+				db.Model(&tablesRoleModel{}).Where("id = ?", 1).Update("name", "Guest")
+			},
+			expected: []string{"tables_role_models"},
+		},
+		"delete": {
+			mutate: func(db *gorm.DB) {
+				db.Where("id = ?", 1).Delete(&tablesOrderModel{})
+			},
+			expected: []string{"tables_order_models"},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+
+			var captured [][]string
+			capture := func(db *gorm.DB) {
+				captured = append(captured, mutationTables(db))
+			}
+			_ = db.Callback().Create().Before("gorm:create").Register("test:capture_tables", capture)
+			_ = db.Callback().Update().Before("gorm:update").Register("test:capture_tables", capture)
+			_ = db.Callback().Delete().Before("gorm:delete").Register("test:capture_tables", capture)
+
+			tc.mutate(db)
+
+			// Saved associations run nested mutations of their own, only the top level one is checked
+			for _, tables := range captured {
+				if len(tables) > 0 && tables[0] == tc.expected[0] {
+					if !reflect.DeepEqual(tables, tc.expected) {
+						t.Errorf("mutationTables expected to return %v, got %v", tc.expected, tables)
+					}
+					return
+				}
+			}
+			t.Errorf("expected a mutation of `%s` to be executed, got %v", tc.expected[0], captured)
+		})
+	}
+}
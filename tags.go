@@ -0,0 +1,483 @@
+package caches
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Tagger derives the tags of a query, e.g. "users:42" for every row it returned, see Config.Tagger
+//
+// It is called with the query about to be stored, and with a query whose Dest is the statement's Model
+// upon the updates and deletes, so the tags of the mutated rows have to match the ones of the cached rows.
+// Returning no tags keeps the table scoped invalidation.
+type Tagger func(db *gorm.DB, q *Query[any]) []string
+
+// TagInvalidator is an optional extension of Cacher, required for the Config.Tagger to be used
+type TagInvalidator interface {
+	// InvalidateTags impl should invalidate the cached values tagged with any of the tags (see Query.Tags)
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// untaggedTagPrefix prefixes the tags the plugin gives to the values the Tagger returned no tags for,
+// one per table they read from, so a tag scoped invalidation still invalidates them along with the tagged values
+const untaggedTagPrefix = "caches:untagged:"
+
+// unknownTableTag is the untagged tag of the values reading from unknown tables
+const unknownTableTag = untaggedTagPrefix + "*"
+
+// PrimaryKeyTagger is a Tagger producing a "<table>:<primary key>" tag per row of the statement's model type,
+// the primary key values of composite keys are joined with a comma
+//
+// When any of the rows is of another type or has a zero primary key, or when there are no rows, the tags are taken
+// from the primary keys the statement's WHERE clause pins instead (see whereTags), e.g. `Where("id = ?", 42)`,
+// so the updates of a model without primary key, and the empty results, are tagged as well.
+// It returns no tags otherwise.
+func PrimaryKeyTagger(db *gorm.DB, q *Query[any]) []string {
+	stmt := db.Statement
+	if stmt.Schema == nil || len(stmt.Schema.PrimaryFields) == 0 {
+		return nil
+	}
+
+	if tags := rowTags(stmt, q.Dest); len(tags) > 0 {
+		return tags
+	}
+	return whereTags(stmt)
+}
+
+// rowTags returns the primary key tags of the rows of dest, nil when any of them cannot be tagged
+func rowTags(stmt *gorm.Statement, dest any) []string {
+	value := reflect.Indirect(reflect.ValueOf(dest))
+	rows := []reflect.Value{value}
+	if value.Kind() == reflect.Slice || value.Kind() == reflect.Array {
+		rows = make([]reflect.Value, value.Len())
+		for i := range rows {
+			rows[i] = reflect.Indirect(value.Index(i))
+		}
+	}
+
+	tags := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if !row.IsValid() || row.Type() != stmt.Schema.ModelType {
+			return nil
+		}
+
+		values := make([]string, len(stmt.Schema.PrimaryFields))
+		for i, field := range stmt.Schema.PrimaryFields {
+			value, zero := field.ValueOf(stmt.Context, row)
+			if zero {
+				return nil
+			}
+			values[i] = fmt.Sprint(value)
+		}
+		tags = append(tags, stmt.Table+":"+strings.Join(values, ","))
+	}
+	return tags
+}
+
+var pkConditionPattern = regexp.MustCompile(`(?i)^\s*(?:[` + "`" + `"]?(\w+)[` + "`" + `"]?\.)?[` + "`" + `"]?(\w+)[` + "`" + `"]?\s*(=|IN)\s*(\?|\(\s*\?\s*\))\s*$`)
+
+// andPattern splits the conditions of a raw SQL condition joined with AND, e.g. `tenant_id = ? AND id = ?`
+var andPattern = regexp.MustCompile(`(?i)\s+AND\s+`)
+
+// orPattern matches the raw SQL conditions holding an OR, which takes precedence over their ANDs
+var orPattern = regexp.MustCompile(`(?i)\bOR\b`)
+
+// maxWhereTags bounds the primary keys a WHERE clause may pin, since the values pinned for each field of a composite
+// key combine with each other, the statements pinning more of them are table scoped
+const maxWhereTags = 1000
+
+// whereTags returns the primary key tags of the rows the statement's WHERE clause restricts it to, if any
+//
+// The conditions of a WHERE clause are joined with AND, so any of them pinning a primary field to a set of values,
+// e.g. `id = ?`, `id IN ?` or a clause.Eq built by gorm from a primary key, restricts the statement to the rows of
+// those values, whatever the other conditions are. The fields of a composite primary key are pinned either each by
+// its own condition, e.g. `tenant_id = ? AND id = ?`, the tags being every combination of their values, or all
+// together by the IN clause of their tuples gorm builds from the primary keys of a slice of models.
+// A WHERE clause holding an OR condition restricts nothing, since it may match the rows of any other value.
+func whereTags(stmt *gorm.Statement) []string {
+	if stmt.Schema == nil || len(stmt.Schema.PrimaryFields) == 0 {
+		return nil
+	}
+
+	c, ok := stmt.Clauses["WHERE"]
+	if !ok {
+		return nil
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return nil
+	}
+
+	for _, expr := range where.Exprs {
+		if _, ok := expr.(clause.OrConditions); ok {
+			return nil
+		}
+	}
+
+	keys, ok := pinnedTuples(stmt, where.Exprs)
+	if !ok {
+		keys = pinnedCombinations(stmt, where.Exprs)
+	}
+	if len(keys) == 0 || len(keys) > maxWhereTags {
+		return nil
+	}
+
+	tags := make([]string, len(keys))
+	for i, key := range keys {
+		values := make([]string, len(key))
+		for j, value := range key {
+			values[j] = fmt.Sprint(value)
+		}
+		tags[i] = stmt.Table + ":" + strings.Join(values, ",")
+	}
+	return tags
+}
+
+// pinnedCombinations returns every combination of the values pinned for each primary field, in the order of the
+// primary fields, nil when any of them is not pinned
+func pinnedCombinations(stmt *gorm.Statement, exprs []clause.Expression) [][]any {
+	keys := [][]any{nil}
+	for _, field := range stmt.Schema.PrimaryFields {
+		values, ok := pinnedValues(stmt, field, exprs)
+		if !ok || len(values) == 0 || len(keys)*len(values) > maxWhereTags {
+			return nil
+		}
+
+		combined := make([][]any, 0, len(keys)*len(values))
+		for _, key := range keys {
+			for _, value := range values {
+				combined = append(combined, append(key[:len(key):len(key)], value))
+			}
+		}
+		keys = combined
+	}
+	return keys
+}
+
+// pinnedValues returns the values of the primary field the first condition pinning it allows, the conditions being ANDed
+func pinnedValues(stmt *gorm.Statement, field *schema.Field, exprs []clause.Expression) ([]any, bool) {
+	for _, expr := range exprs {
+		switch e := expr.(type) {
+		case clause.AndConditions:
+			if values, ok := pinnedValues(stmt, field, e.Exprs); ok {
+				return values, true
+			}
+		case clause.Eq:
+			if isPrimaryColumn(stmt, field, e.Column, "") && isScalar(e.Value) {
+				return []any{e.Value}, true
+			}
+		case clause.IN:
+			if isPrimaryColumn(stmt, field, e.Column, "") && len(e.Values) == 1 && isList(e.Values[0]) {
+				return listValues(e.Values[0]), true
+			}
+			if isPrimaryColumn(stmt, field, e.Column, "") && allScalars(e.Values) {
+				return e.Values, true
+			}
+		case clause.Expr:
+			for _, cond := range andConditions(e) {
+				m := pkConditionPattern.FindStringSubmatch(cond.SQL)
+				if m == nil || len(cond.Vars) != 1 || !isPrimaryColumn(stmt, field, m[2], m[1]) {
+					continue
+				}
+				if strings.EqualFold(m[3], "IN") && isList(cond.Vars[0]) {
+					return listValues(cond.Vars[0]), true
+				}
+				if (m[3] == "=" && m[4] == "?" || m[4] != "?") && isScalar(cond.Vars[0]) {
+					return []any{cond.Vars[0]}, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// pinnedTuples returns the primary keys the first IN clause of the tuples of every primary field allows,
+// e.g. `(tenant_id, id) IN ((1, 2), (1, 3))`, their values being in the order of the primary fields
+func pinnedTuples(stmt *gorm.Statement, exprs []clause.Expression) ([][]any, bool) {
+	for _, expr := range exprs {
+		switch e := expr.(type) {
+		case clause.AndConditions:
+			if keys, ok := pinnedTuples(stmt, e.Exprs); ok {
+				return keys, true
+			}
+		case clause.IN:
+			if keys, ok := inTuples(stmt, e); ok {
+				return keys, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func inTuples(stmt *gorm.Statement, in clause.IN) ([][]any, bool) {
+	columns, ok := in.Column.([]clause.Column)
+	if !ok || len(columns) != len(stmt.Schema.PrimaryFields) {
+		return nil, false
+	}
+
+	// The position of every primary field within the tuples
+	positions := make([]int, len(stmt.Schema.PrimaryFields))
+	for i, field := range stmt.Schema.PrimaryFields {
+		positions[i] = -1
+		for j, column := range columns {
+			if isPrimaryColumn(stmt, field, column, "") {
+				positions[i] = j
+			}
+		}
+		if positions[i] < 0 {
+			return nil, false
+		}
+	}
+
+	keys := make([][]any, len(in.Values))
+	for i, value := range in.Values {
+		tuple, ok := value.([]interface{})
+		if !ok || len(tuple) != len(columns) || !allScalars(tuple) {
+			return nil, false
+		}
+		keys[i] = make([]any, len(positions))
+		for j, position := range positions {
+			keys[i][j] = tuple[position]
+		}
+	}
+	return keys, true
+}
+
+// andConditions splits a raw SQL condition into the conditions it joins with AND, along with their own vars,
+// the condition is returned as is when it holds an OR, or when its vars cannot be told apart
+func andConditions(e clause.Expr) []clause.Expr {
+	if orPattern.MatchString(e.SQL) {
+		return []clause.Expr{e}
+	}
+	parts := andPattern.Split(e.SQL, -1)
+	if len(parts) == 1 {
+		return []clause.Expr{e}
+	}
+
+	conds := make([]clause.Expr, len(parts))
+	vars := e.Vars
+	for i, part := range parts {
+		n := strings.Count(part, "?")
+		if n > len(vars) {
+			return []clause.Expr{e}
+		}
+		conds[i], vars = clause.Expr{SQL: part, Vars: vars[:n]}, vars[n:]
+	}
+	if len(vars) > 0 {
+		return []clause.Expr{e}
+	}
+	return conds
+}
+
+// isPrimaryColumn reports whether the column, given either as a clause.Column or as a name qualified by table,
+// is the given primary field of the statement's schema, the primary key placeholder of gorm only stands for a single one
+func isPrimaryColumn(stmt *gorm.Statement, field *schema.Field, column any, table string) bool {
+	name, ok := column.(string)
+	if col, isColumn := column.(clause.Column); isColumn {
+		if col.Raw || col.Alias != "" {
+			return false
+		}
+		name, table, ok = col.Name, col.Table, true
+	}
+	if !ok || (table != "" && table != clause.CurrentTable && table != stmt.Table) {
+		return false
+	}
+
+	return name == field.DBName || (name == clause.PrimaryKey && len(stmt.Schema.PrimaryFields) == 1)
+}
+
+func isList(value any) bool {
+	if _, ok := value.(driver.Valuer); ok {
+		return false
+	}
+	kind := reflect.ValueOf(value).Kind()
+	return kind == reflect.Slice || kind == reflect.Array
+}
+
+func isScalar(value any) bool {
+	if value == nil || isList(value) {
+		return false
+	}
+	switch value.(type) {
+	case clause.Expression, *gorm.DB:
+		return false
+	}
+	return true
+}
+
+func allScalars(values []any) bool {
+	for _, value := range values {
+		if !isScalar(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func listValues(list any) []any {
+	value := reflect.ValueOf(list)
+	values := make([]any, value.Len())
+	for i := range values {
+		values[i] = value.Index(i).Interface()
+	}
+	return values
+}
+
+// updatesPrimaryKey reports whether the update may change the primary key of its rows, in which case the rows may
+// end up matching cached values tagged with their new primary key, so the invalidation has to be table scoped
+func updatesPrimaryKey(stmt *gorm.Statement) bool {
+	if stmt.Schema == nil {
+		return true
+	}
+	isPrimary := func(name string) bool {
+		for _, field := range stmt.Schema.PrimaryFields {
+			if name == field.DBName || name == field.Name {
+				return true
+			}
+		}
+		return false
+	}
+
+	if c, ok := stmt.Clauses["SET"]; ok {
+		if set, ok := c.Expression.(clause.Set); ok {
+			for _, assignment := range set {
+				if isPrimary(assignment.Column.Name) {
+					return true
+				}
+			}
+		}
+	}
+
+	assignments, ok := stmt.Dest.(map[string]interface{})
+	if dest, isPtr := stmt.Dest.(*map[string]interface{}); isPtr && dest != nil {
+		assignments, ok = *dest, true
+	}
+	if ok {
+		for name := range assignments {
+			if isPrimary(name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Save sets the model itself, whose primary key is the one of the updated row
+	if stmt.Dest == nil || stmt.Dest == stmt.Model {
+		return false
+	}
+	value := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	if value.Kind() != reflect.Struct || value.Type() != stmt.Schema.ModelType {
+		return false
+	}
+	for _, field := range stmt.Schema.PrimaryFields {
+		if _, zero := field.ValueOf(stmt.Context, value); !zero {
+			return true
+		}
+	}
+	return false
+}
+
+// tagInvalidator returns the Cacher as a TagInvalidator, when it is one and a Tagger is configured
+func (c *Caches) tagInvalidator() (TagInvalidator, bool) {
+	if c.Conf.Tagger == nil {
+		return nil, false
+	}
+	return tagInvalidatorOf(c.Conf.Cacher)
+}
+
+// tagSupporter is implemented by the TagInvalidators wrapping other Cachers, which only invalidate tags when the
+// Cachers they wrap do
+type tagSupporter interface {
+	supportsTags() bool
+}
+
+// tagInvalidatorOf returns the cacher as a TagInvalidator, when it is one which supports the tags, so the mutations
+// of a Cacher which does not are invalidated per table rather than not at all or fully
+func tagInvalidatorOf(cacher Cacher) (TagInvalidator, bool) {
+	invalidator, ok := cacher.(TagInvalidator)
+	if supporter, wraps := cacher.(tagSupporter); ok && wraps {
+		ok = supporter.supportsTags()
+	}
+	return invalidator, ok
+}
+
+// queryTags returns the tags of a query about to be stored, the Tagger is only called for the queries
+// reading from a single known table, since the rows of a query joining other tables tell nothing about the joined rows
+func (c *Caches) queryTags(db *gorm.DB, q *Query[any]) []string {
+	if _, ok := c.tagInvalidator(); !ok {
+		return nil
+	}
+
+	if len(q.Tables) == 1 {
+		if tags := c.Conf.Tagger(db, q); len(tags) > 0 {
+			return tags
+		}
+	}
+	return untaggedTags(q.Tables)
+}
+
+// mutationTags returns the tags to invalidate instead of the tables, nil when the mutation is table scoped
+//
+// The value of a mutated row is only available before the mutation runs for updates and deletes,
+// which is when the invalidation happens. Creates are always table scoped: the primary keys of the created rows
+// may only be known once the callback ran, and a new row may belong to any cached result of its table anyway.
+// Mutations saving associations are table scoped as well, since they write to several tables.
+func (c *Caches) mutationTags(db *gorm.DB, typ queryType, tables []string) []string {
+	if _, ok := c.tagInvalidator(); !ok || typ == uponCreate || len(tables) != 1 {
+		return nil
+	}
+	if typ == uponUpdate && updatesPrimaryKey(db.Statement) {
+		return nil
+	}
+
+	tags := c.Conf.Tagger(db, &Query[any]{
+		Dest:   db.Statement.Model,
+		Tables: tables,
+	})
+	if len(tags) == 0 {
+		return nil
+	}
+	// The untagged values of the table, and the ones reading from unknown tables, may hold the mutated rows as well
+	tags = append(tags, untaggedTags(tables)...)
+	return append(tags, unknownTableTag)
+}
+
+// untaggedTags returns the tags of the values reading from tables the Tagger returned no tags for
+func untaggedTags(tables []string) []string {
+	if len(tables) == 0 {
+		return []string{unknownTableTag}
+	}
+
+	tags := make([]string, 0, len(tables))
+	for _, table := range tables {
+		tags = append(tags, untaggedTagPrefix+table)
+	}
+	return tags
+}
+
+// invalidate invalidates the values a mutation makes stale, by tags when they are known and by tables otherwise
+// The invalidations of the mutations run within a Transaction are deferred until it commits
+func (c *Caches) invalidate(db *gorm.DB, typ queryType) error {
+	tables := mutationTables(db)
+	tags := c.mutationTags(db, typ, tables)
+	if p := c.pendingOf(db); p != nil {
+		p.add(tables, tags)
+		return nil
+	}
+
+	if len(tags) > 0 {
+		invalidator, _ := c.tagInvalidator()
+		c.log(db.Statement.Context, LogInvalidate, db.Statement.Table, "", tags)
+		return invalidator.InvalidateTags(db.Statement.Context, tags...)
+	}
+	c.log(db.Statement.Context, LogInvalidate, db.Statement.Table, "", tables)
+	return c.Conf.Cacher.Invalidate(db.Statement.Context, tables...)
+}
@@ -0,0 +1,409 @@
+package caches
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
+)
+
+type tagsTenantItemModel struct {
+	TenantID uint `gorm:"primaryKey"`
+	ID       uint `gorm:"primaryKey"`
+	Name     string
+}
+
+// newTagsTestDB returns a statement parsed for the model, as it is when the callbacks run
+func newTagsTestDB(t *testing.T, model any) *gorm.DB {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+
+	db = db.Model(model)
+	db.Statement.Dest = model
+	if err := db.Statement.Parse(model); err != nil {
+		t.Fatalf("parsing the model resulted into an unexpected error, %s", err.Error())
+	}
+	db.Statement.ReflectValue = reflect.Indirect(reflect.ValueOf(model))
+	return db
+}
+
+func TestPrimaryKeyTagger(t *testing.T) {
+	user := func(id uint) tablesUserModel {
+		u := tablesUserModel{}
+		u.ID = id
+		return u
+	}
+
+	testCases := map[string]struct {
+		dest     any
+		expected []string
+	}{
+		"single row": {
+			dest:     &[]tablesUserModel{user(42)}[0],
+			expected: []string{"tables_user_models:42"},
+		},
+		"rows": {
+			dest:     &[]tablesUserModel{user(1), user(2)},
+			expected: []string{"tables_user_models:1", "tables_user_models:2"},
+		},
+		"pointer rows": {
+			dest:     &[]*tablesUserModel{{Model: gorm.Model{ID: 1}}},
+			expected: []string{"tables_user_models:1"},
+		},
+		"zero primary key": {
+			dest:     &[]tablesUserModel{user(1), user(0)},
+			expected: nil,
+		},
+		"another type": {
+			dest:     &[]tablesRoleModel{{Model: gorm.Model{ID: 1}}},
+			expected: nil,
+		},
+		"scalar": {
+			dest:     new(int64),
+			expected: nil,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := newTagsTestDB(t, &tablesUserModel{})
+			if act := PrimaryKeyTagger(db, &Query[any]{Dest: tc.dest}); !reflect.DeepEqual(act, tc.expected) {
+				t.Errorf("PrimaryKeyTagger expected to return %v, got %v", tc.expected, act)
+			}
+		})
+	}
+}
+
+func TestCaches_tags(t *testing.T) {
+	ctx := context.Background()
+	newCaches := func(cacher Cacher) *Caches {
+		return &Caches{
+			Conf: &Config{
+				Cacher: cacher,
+				Tagger: PrimaryKeyTagger,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {},
+			},
+		}
+	}
+	store := func(t *testing.T, caches *Caches, key string, model, dest any) {
+		db := newTagsTestDB(t, model)
+		db.Statement.Dest = dest
+		db.Statement.AddClause(clause.From{})
+		db.Statement.SQL.WriteString(key)
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("storing `%s` resulted into an unexpected error, %v", key, db.Error)
+		}
+	}
+	mutate := func(t *testing.T, caches *Caches, typ queryType, model any, prepare ...func(db *gorm.DB)) {
+		db := newTagsTestDB(t, model)
+		for _, fn := range prepare {
+			fn(db)
+		}
+		if err := caches.invalidate(db, typ); err != nil {
+			t.Fatalf("invalidating resulted into an unexpected error, %v", err)
+		}
+	}
+
+	for name, cacher := range map[string]func(t *testing.T) Cacher{
+		"memory cacher": func(t *testing.T) Cacher {
+			return NewMemoryCacher()
+		},
+		"redis cacher": func(t *testing.T) Cacher {
+			cacher, _ := newTestRedisCacher(t)
+			return cacher
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cacher := cacher(t)
+			caches := newCaches(cacher)
+			seed := func() {
+				_ = cacher.Invalidate(ctx)
+				store(t, caches, "profile", &tablesUserModel{}, &tablesUserModel{Model: gorm.Model{ID: 42}})
+				store(t, caches, "list", &tablesUserModel{}, &[]tablesUserModel{{Model: gorm.Model{ID: 1}}, {Model: gorm.Model{ID: 2}}})
+				store(t, caches, "count", &tablesUserModel{}, new(int64))
+				store(t, caches, "orders", &tablesOrderModel{}, new(int64))
+			}
+			assertCached := func(t *testing.T, expected map[string]bool) {
+				for key, exists := range expected {
+					db := &gorm.DB{Statement: &gorm.Statement{}}
+					db.Statement.SQL.WriteString(key)
+					res, _ := cacher.Get(ctx, buildIdentifier(db, "", nil), &Query[any]{Dest: new(any)})
+					if (res != nil) != exists {
+						t.Errorf("expected the existence of `%s` to be %t", key, exists)
+					}
+				}
+			}
+
+			t.Run("update of an uncached row", func(t *testing.T) {
+				seed()
+				mutate(t, caches, uponUpdate, &tablesUserModel{Model: gorm.Model{ID: 42}})
+				assertCached(t, map[string]bool{"profile": false, "list": true, "count": false, "orders": true})
+			})
+
+			t.Run("delete of a listed row", func(t *testing.T) {
+				seed()
+				mutate(t, caches, uponDelete, &tablesUserModel{Model: gorm.Model{ID: 2}})
+				assertCached(t, map[string]bool{"profile": true, "list": false, "count": false, "orders": true})
+			})
+
+			t.Run("update by primary key condition", func(t *testing.T) {
+				seed()
+				mutate(t, caches, uponUpdate, &tablesUserModel{}, func(db *gorm.DB) {
+					db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "id = ?", Vars: []any{42}}}})
+				})
+				assertCached(t, map[string]bool{"profile": false, "list": true, "count": false, "orders": true})
+			})
+
+			t.Run("update of the primary key", func(t *testing.T) {
+				seed()
+				mutate(t, caches, uponUpdate, &tablesUserModel{Model: gorm.Model{ID: 42}}, func(db *gorm.DB) {
+					db.Statement.Dest = map[string]interface{}{"id": 3}
+				})
+				assertCached(t, map[string]bool{"profile": false, "list": false, "count": false, "orders": true})
+			})
+
+			t.Run("update without primary key", func(t *testing.T) {
+				seed()
+				mutate(t, caches, uponUpdate, &tablesUserModel{})
+				assertCached(t, map[string]bool{"profile": false, "list": false, "count": false, "orders": true})
+			})
+
+			t.Run("create", func(t *testing.T) {
+				seed()
+				mutate(t, caches, uponCreate, &tablesUserModel{Model: gorm.Model{ID: 42}})
+				assertCached(t, map[string]bool{"profile": false, "list": false, "count": false, "orders": true})
+			})
+
+			t.Run("composite primary keys", func(t *testing.T) {
+				_ = cacher.Invalidate(ctx)
+				store(t, caches, "item", &tagsTenantItemModel{}, &tagsTenantItemModel{TenantID: 1, ID: 2})
+				store(t, caches, "items", &tagsTenantItemModel{}, &[]tagsTenantItemModel{{TenantID: 1, ID: 3}, {TenantID: 2, ID: 2}})
+
+				mutate(t, caches, uponUpdate, &tagsTenantItemModel{TenantID: 1, ID: 2})
+				assertCached(t, map[string]bool{"item": false, "items": true})
+
+				mutate(t, caches, uponDelete, &tagsTenantItemModel{}, func(db *gorm.DB) {
+					db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+						clause.Expr{SQL: "tenant_id = ? AND id = ?", Vars: []any{2, 2}},
+					}})
+				})
+				assertCached(t, map[string]bool{"items": false})
+			})
+		})
+	}
+
+	t.Run("cacher without tag support", func(t *testing.T) {
+		cacher := &cacherMock{}
+		mutate(t, newCaches(cacher), uponUpdate, &tablesUserModel{Model: gorm.Model{ID: 42}})
+		if !reflect.DeepEqual(cacher.invalidatedTables, []string{"tables_user_models"}) {
+			t.Errorf("expected the invalidation to be table scoped, got %v", cacher.invalidatedTables)
+		}
+	})
+}
+
+func Test_whereTags(t *testing.T) {
+	testCases := map[string]struct {
+		run      func(db *gorm.DB)
+		expected []string
+	}{
+		"equality": {
+			run: func(db *gorm.DB) {
+				db.Model(&tablesUserModel{}).Where("id = ?", 42).Update("name", "ktsivkov")
+			},
+			expected: []string{"tables_user_models:42"},
+		},
+		"qualified equality": {
+			run: func(db *gorm.DB) {
+				db.Model(&tablesUserModel{}).Where("`tables_user_models`.`id` = ?", 42).Update("name", "ktsivkov")
+			},
+			expected: []string{"tables_user_models:42"},
+		},
+		"in list": {
+			run: func(db *gorm.DB) {
+				db.Where("id IN ?", []int{1, 2}).Delete(&tablesUserModel{})
+			},
+			expected: []string{"tables_user_models:1", "tables_user_models:2"},
+		},
+		"primary key condition": {
+			run: func(db *gorm.DB) {
+				db.Delete(&tablesUserModel{}, []int{3, 4})
+			},
+			expected: []string{"tables_user_models:3", "tables_user_models:4"},
+		},
+		"struct condition": {
+			run: func(db *gorm.DB) {
+				db.Where(&tablesUserModel{Model: gorm.Model{ID: 5}}).Find(&[]tablesUserModel{})
+			},
+			expected: []string{"tables_user_models:5"},
+		},
+		"along with other conditions": {
+			run: func(db *gorm.DB) {
+				db.Where("name = ?", "ktsivkov").Where("id = ?", 6).Find(&[]tablesUserModel{})
+			},
+			expected: []string{"tables_user_models:6"},
+		},
+		"or condition": {
+			run: func(db *gorm.DB) {
+				db.Where("id = ?", 7).Or("name = ?", "ktsivkov").Find(&[]tablesUserModel{})
+			},
+			expected: nil,
+		},
+		"or within the sql": {
+			run: func(db *gorm.DB) {
+				db.Where("id = ? OR 1 = 1", 8).Find(&[]tablesUserModel{})
+			},
+			expected: nil,
+		},
+		"another column": {
+			run: func(db *gorm.DB) {
+				db.Where("role_id = ?", 9).Find(&[]tablesUserModel{})
+			},
+			expected: nil,
+		},
+		"another table": {
+			run: func(db *gorm.DB) {
+				db.Where("roles.id = ?", 10).Find(&[]tablesUserModel{})
+			},
+			expected: nil,
+		},
+		"composite key conditions": {
+			run: func(db *gorm.DB) {
+				db.Model(&tagsTenantItemModel{}).Where("tenant_id = ? AND id = ?", 1, 2).Update("name", "ktsivkov")
+			},
+			expected: []string{"tags_tenant_item_models:1,2"},
+		},
+		"composite key combinations": {
+			run: func(db *gorm.DB) {
+				db.Where("id IN ?", []int{2, 3}).Where(&tagsTenantItemModel{TenantID: 1}).Find(&[]tagsTenantItemModel{})
+			},
+			expected: []string{"tags_tenant_item_models:1,2", "tags_tenant_item_models:1,3"},
+		},
+		"composite key tuples": {
+			run: func(db *gorm.DB) {
+				db.Clauses(clause.IN{
+					Column: []clause.Column{{Name: "id"}, {Name: "tenant_id"}},
+					Values: []interface{}{[]interface{}{2, 1}, []interface{}{3, 1}},
+				}).Delete(&tagsTenantItemModel{})
+			},
+			expected: []string{"tags_tenant_item_models:1,2", "tags_tenant_item_models:1,3"},
+		},
+		"partial composite key": {
+			run: func(db *gorm.DB) {
+				db.Where("id = ?", 2).Find(&[]tagsTenantItemModel{})
+			},
+			expected: nil,
+		},
+		"or within composite key conditions": {
+			run: func(db *gorm.DB) {
+				db.Where("tenant_id = ? AND id = ? OR 1 = 1", 1, 2).Find(&[]tagsTenantItemModel{})
+			},
+			expected: nil,
+		},
+		"subquery": {
+			run: func(db *gorm.DB) {
+				db.Where("id IN (?)", db.Model(&tablesOrderModel{}).Select("user_id")).Find(&[]tablesUserModel{})
+			},
+			expected: nil,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+
+			var captured [][]string
+			capture := func(db *gorm.DB) {
+				captured = append(captured, whereTags(db.Statement))
+			}
+			_ = db.Callback().Query().Before("gorm:query").Register("test:capture_tags", capture)
+			_ = db.Callback().Update().Before("gorm:update").Register("test:capture_tags", capture)
+			_ = db.Callback().Delete().Before("gorm:delete").Register("test:capture_tags", capture)
+
+			// A subquery is run through the query callbacks as well when it is built, after the outer statement
+			tc.run(db)
+			if len(captured) == 0 {
+				t.Fatal("expected the statement to be executed")
+			}
+			if !reflect.DeepEqual(captured[0], tc.expected) {
+				t.Errorf("whereTags expected to return %v, got %v", tc.expected, captured[0])
+			}
+		})
+	}
+}
+
+func Test_updatesPrimaryKey(t *testing.T) {
+	testCases := map[string]struct {
+		run      func(db *gorm.DB)
+		expected bool
+	}{
+		"update of another column": {
+			run: func(db *gorm.DB) {
+				db.Model(&tablesUserModel{Model: gorm.Model{ID: 1}}).Update("name", "ktsivkov")
+			},
+			expected: false,
+		},
+		"update of the primary key": {
+			run: func(db *gorm.DB) {
+				db.Model(&tablesUserModel{Model: gorm.Model{ID: 1}}).Update("id", 2)
+			},
+			expected: true,
+		},
+		"updates of the primary key field": {
+			run: func(db *gorm.DB) {
+				db.Model(&tablesUserModel{}).Where("id = ?", 1).Updates(map[string]interface{}{"ID": 2})
+			},
+			expected: true,
+		},
+		"updates with a struct holding the primary key": {
+			run: func(db *gorm.DB) {
+				db.Model(&tablesUserModel{}).Where("id = ?", 1).Updates(&tablesUserModel{Model: gorm.Model{ID: 2}})
+			},
+			expected: true,
+		},
+		"updates with a struct without primary key": {
+			run: func(db *gorm.DB) {
+				db.Model(&tablesUserModel{}).Where("id = ?", 1).Updates(&tablesUserModel{Name: "ktsivkov"})
+			},
+			expected: false,
+		},
+		"save": {
+			run: func(db *gorm.DB) {
+				db.Save(&tablesUserModel{Model: gorm.Model{ID: 1}, Name: "ktsivkov"})
+			},
+			expected: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+			if err != nil {
+				t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+			}
+
+			var captured []bool
+			_ = db.Callback().Update().Before("gorm:update").Register("test:capture_pk_update", func(db *gorm.DB) {
+				captured = append(captured, updatesPrimaryKey(db.Statement))
+			})
+
+			tc.run(db)
+			if len(captured) != 1 {
+				t.Fatalf("expected a single update to be executed, got %d", len(captured))
+			}
+			if captured[0] != tc.expected {
+				t.Errorf("updatesPrimaryKey expected to return %t, got %t", tc.expected, captured[0])
+			}
+		})
+	}
+}
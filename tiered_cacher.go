@@ -0,0 +1,123 @@
+package caches
+
+import (
+	"context"
+	"time"
+)
+
+const defaultTieredL1TTL = time.Second
+
+type TieredCacherOption func(c *TieredCacher)
+
+// WithTieredL1TTL caps the ttl of the values held by the L1 cacher, it defaults to 1s
+// It bounds how long an instance may serve a value another instance invalidated, since the invalidations
+// only reach the L1 cacher of the instance running the mutation.
+func WithTieredL1TTL(ttl time.Duration) TieredCacherOption {
+	return func(c *TieredCacher) {
+		if ttl > 0 {
+			c.l1TTL = ttl
+		}
+	}
+}
+
+// TieredCacher is a Cacher putting a cacher local to the process, e.g. a MemoryCacher, in front of a shared one,
+// e.g. a RedisCacher, saving the round-trips to the shared one for the hot keys
+//
+// Get checks L1 first, then L2, back-filling L1 with the values found in L2.
+// Store writes through both, L2 first, and the invalidations reach L2 before L1, so that a concurrent Get cannot
+// back-fill L1 with a value about to be invalidated. The values are held by L1 for the L1 ttl at most
+// (see WithTieredL1TTL), which bounds the staleness of an instance when another one invalidates L2.
+type TieredCacher struct {
+	l1, l2 Cacher
+	l1TTL  time.Duration
+}
+
+func NewTieredCacher(l1, l2 Cacher, opts ...TieredCacherOption) *TieredCacher {
+	c := &TieredCacher{
+		l1:    l1,
+		l2:    l2,
+		l1TTL: defaultTieredL1TTL,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *TieredCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	if res, err := c.l1.Get(ctx, key, q); err == nil && res != nil && !res.expired() {
+		return res, nil
+	}
+
+	res, err := c.l2.Get(ctx, key, q)
+	if err != nil || res == nil || res.expired() {
+		return res, err
+	}
+
+	// The tables and tags of the value are not part of its payload, so the back-filled value is invalidated
+	// along with the values of unknown tables, which is upon any invalidation
+	filled := *res
+	filled.Tables = nil
+	filled.Tags = []string{unknownTableTag}
+	_ = c.l1.Store(ctx, key, &filled, c.l1TTLOf(ttlUntil(res.ExpiresAt)))
+	return res, nil
+}
+
+func (c *TieredCacher) Store(ctx context.Context, key string, val *Query[any], ttl time.Duration) error {
+	if err := c.l2.Store(ctx, key, val, ttl); err != nil {
+		return err
+	}
+	return c.l1.Store(ctx, key, val, c.l1TTLOf(ttl))
+}
+
+func (c *TieredCacher) Invalidate(ctx context.Context, tables ...string) error {
+	if err := c.l2.Invalidate(ctx, tables...); err != nil {
+		return err
+	}
+	return c.l1.Invalidate(ctx, tables...)
+}
+
+// InvalidateTags invalidates the tags of both cachers, it fails when either of them is not a TagInvalidator, in which
+// case the plugin invalidates the mutations per table instead
+func (c *TieredCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	if !c.supportsTags() {
+		return errTagsUnsupported
+	}
+	if err := c.l2.(TagInvalidator).InvalidateTags(ctx, tags...); err != nil {
+		return err
+	}
+	return c.l1.(TagInvalidator).InvalidateTags(ctx, tags...)
+}
+
+func (c *TieredCacher) supportsTags() bool {
+	_, l1 := tagInvalidatorOf(c.l1)
+	_, l2 := tagInvalidatorOf(c.l2)
+	return l1 && l2
+}
+
+// Ping pings both cachers, L2 first, the ones which are not a HealthChecker are assumed to be healthy
+func (c *TieredCacher) Ping(ctx context.Context) error {
+	if err := pingOf(ctx, c.l2); err != nil {
+		return err
+	}
+	return pingOf(ctx, c.l1)
+}
+
+// l1TTLOf caps the ttl with the L1 ttl, keeping in mind that a zero ttl never expires
+func (c *TieredCacher) l1TTLOf(ttl time.Duration) time.Duration {
+	if shorterTTL(c.l1TTL, ttl) {
+		return c.l1TTL
+	}
+	return ttl
+}
+
+// ttlUntil returns the ttl left until expiresAt, zero when it never expires
+func ttlUntil(expiresAt time.Time) time.Duration {
+	if expiresAt.IsZero() {
+		return 0
+	}
+	if ttl := time.Until(expiresAt); ttl > 0 {
+		return ttl
+	}
+	return time.Nanosecond
+}
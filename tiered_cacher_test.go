@@ -0,0 +1,123 @@
+package caches
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTieredCacher(t *testing.T) {
+	ctx := context.Background()
+	get := func(t *testing.T, cacher Cacher, key string) *mockDest {
+		res, err := cacher.Get(ctx, key, &Query[any]{Dest: &mockDest{}})
+		if err != nil {
+			t.Fatalf("Get resulted into an unexpected error, %v", err)
+		}
+		if res == nil {
+			return nil
+		}
+		return res.Dest.(*mockDest)
+	}
+
+	t.Run("back-fill", func(t *testing.T) {
+		l1, l2 := NewMemoryCacher(), NewMemoryCacher()
+		cacher := NewTieredCacher(l1, l2)
+		_ = l2.Store(ctx, "key", &Query[any]{Dest: &mockDest{Result: "l2"}, Tables: []string{"users"}}, time.Minute)
+
+		if res := get(t, cacher, "key"); res == nil || res.Result != "l2" {
+			t.Fatalf("expected the value to be served from L2, got %+v", res)
+		}
+		if res := get(t, l1, "key"); res == nil || res.Result != "l2" {
+			t.Fatalf("expected L1 to be back-filled, got %+v", res)
+		}
+
+		_ = l2.Invalidate(ctx)
+		if res := get(t, cacher, "key"); res == nil || res.Result != "l2" {
+			t.Errorf("expected the value to be served from L1, got %+v", res)
+		}
+
+		_ = cacher.InvalidateTags(ctx, "orders:1", unknownTableTag)
+		if res := get(t, l1, "key"); res != nil {
+			t.Errorf("expected the back-filled value to be invalidated along with the values of unknown tables, got %+v", res)
+		}
+	})
+
+	t.Run("tags unsupported", func(t *testing.T) {
+		l1 := NewMemoryCacher()
+		cacher := NewTieredCacher(l1, &cacherMock{})
+		_ = l1.Store(ctx, "key", &Query[any]{Dest: &mockDest{Result: "l1"}, Tags: []string{"orders:1"}}, time.Minute)
+
+		if _, ok := tagInvalidatorOf(cacher); ok {
+			t.Error("expected the tiered cacher not to support the tags when a tier does not")
+		}
+		if err := cacher.InvalidateTags(ctx, "orders:2"); err == nil {
+			t.Error("expected the tag invalidation to fail when a tier does not support the tags")
+		}
+		if res := get(t, l1, "key"); res == nil || res.Result != "l1" {
+			t.Errorf("expected L1 not to be invalidated, got %+v", res)
+		}
+		if _, ok := tagInvalidatorOf(NewTieredCacher(l1, NewMemoryCacher())); !ok {
+			t.Error("expected the tiered cacher to support the tags when both tiers do")
+		}
+	})
+
+	t.Run("write-through", func(t *testing.T) {
+		l1, l2 := NewMemoryCacher(), NewMemoryCacher()
+		cacher := NewTieredCacher(l1, l2)
+		if err := cacher.Store(ctx, "key", &Query[any]{Dest: &mockDest{Result: "stored"}, Tables: []string{"users"}}, 0); err != nil {
+			t.Fatalf("Store resulted into an unexpected error, %v", err)
+		}
+
+		for name, c := range map[string]Cacher{"L1": l1, "L2": l2} {
+			if res := get(t, c, "key"); res == nil || res.Result != "stored" {
+				t.Errorf("expected the value to be stored in %s, got %+v", name, res)
+			}
+		}
+
+		if err := cacher.Invalidate(ctx, "users"); err != nil {
+			t.Fatalf("Invalidate resulted into an unexpected error, %v", err)
+		}
+		for name, c := range map[string]Cacher{"L1": l1, "L2": l2} {
+			if res := get(t, c, "key"); res != nil {
+				t.Errorf("expected the value to be invalidated in %s, got %+v", name, res)
+			}
+		}
+	})
+
+	t.Run("l1 ttl", func(t *testing.T) {
+		l1, l2 := NewMemoryCacher(), NewMemoryCacher()
+		cacher := NewTieredCacher(l1, l2, WithTieredL1TTL(20*time.Millisecond))
+		_ = cacher.Store(ctx, "key", &Query[any]{Dest: &mockDest{Result: "v1"}}, 0)
+
+		// Another instance replaces the value in L2
+		_ = l2.Store(ctx, "key", &Query[any]{Dest: &mockDest{Result: "v2"}}, 0)
+		if res := get(t, cacher, "key"); res == nil || res.Result != "v1" {
+			t.Fatalf("expected the value to be served from L1, got %+v", res)
+		}
+
+		time.Sleep(30 * time.Millisecond)
+		if res := get(t, cacher, "key"); res == nil || res.Result != "v2" {
+			t.Errorf("expected the staleness of L1 to be bounded by its ttl, got %+v", res)
+		}
+	})
+
+	t.Run("miss", func(t *testing.T) {
+		cacher := NewTieredCacher(NewMemoryCacher(), NewMemoryCacher())
+		if res := get(t, cacher, "missing"); res != nil {
+			t.Errorf("expected a miss, got %+v", res)
+		}
+	})
+}
+
+func TestTieredCacher_l1TTLOf(t *testing.T) {
+	cacher := NewTieredCacher(nil, nil, WithTieredL1TTL(time.Second))
+	for ttl, expected := range map[time.Duration]time.Duration{
+		0:                      time.Second,
+		time.Minute:            time.Second,
+		500 * time.Millisecond: 500 * time.Millisecond,
+	} {
+		if act := cacher.l1TTLOf(ttl); act != expected {
+			t.Errorf("expected the L1 ttl of %s to be %s, got %s", ttl, expected, act)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package caches
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// tombstones records the tables mutated within the ReadYourWritesWindow, see Config.ReadYourWritesWindow
+//
+// They are held in memory rather than in the Cacher, so checking them costs no round-trip: a read first compares
+// the latest expiry with the clock, which is a single atomic load while no tombstone is live, and only then looks up
+// the expiries of its tables. There is one entry per mutated table, which is overwritten rather than removed.
+type tombstones struct {
+	latest int64 // The latest expiry of all the tombstones in unix nanoseconds, kept first for atomic access
+	all    int64 // The expiry of the tombstone of the mutations whose tables are unknown
+	tables sync.Map
+}
+
+// mark records a tombstone expiring after window for every table, or for all of them when tables is empty
+func (t *tombstones) mark(tables []string, window time.Duration) {
+	expiry := time.Now().Add(window).UnixNano()
+
+	if len(tables) == 0 {
+		storeLater(&t.all, expiry)
+	}
+	for _, table := range tables {
+		v, _ := t.tables.LoadOrStore(table, new(int64))
+		storeLater(v.(*int64), expiry)
+	}
+	storeLater(&t.latest, expiry)
+}
+
+// live reports whether any of the tables holds a live tombstone, the queries reading from unknown tables
+// (see queryTables) are considered to read from the mutated ones
+func (t *tombstones) live(tables []string) bool {
+	now := time.Now().UnixNano()
+	if atomic.LoadInt64(&t.latest) <= now {
+		return false
+	}
+	if len(tables) == 0 || atomic.LoadInt64(&t.all) > now {
+		return true
+	}
+
+	for _, table := range tables {
+		if v, ok := t.tables.Load(table); ok && atomic.LoadInt64(v.(*int64)) > now {
+			return true
+		}
+	}
+	return false
+}
+
+// storeLater stores the expiry unless a later one is already stored
+func storeLater(addr *int64, expiry int64) {
+	for {
+		current := atomic.LoadInt64(addr)
+		if current >= expiry || atomic.CompareAndSwapInt64(addr, current, expiry) {
+			return
+		}
+	}
+}
+
+// markWritten records the tombstones of the tables a mutation wrote to, when the ReadYourWritesWindow is set
+func (c *Caches) markWritten(tables []string) {
+	if c.tombstones != nil {
+		c.tombstones.mark(tables, c.Conf.ReadYourWritesWindow)
+	}
+}
+
+// recentlyWritten reports whether the query reads from a table mutated within the ReadYourWritesWindow
+func (c *Caches) recentlyWritten(db *gorm.DB) bool {
+	return c.tombstones != nil && c.tombstones.live(queryTables(db))
+}
@@ -0,0 +1,115 @@
+package caches
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_ReadYourWritesWindow(t *testing.T) {
+	newCaches := func(t *testing.T, window time.Duration) (*gorm.DB, *int) {
+		var executed int
+		caches := &Caches{Conf: &Config{
+			Cacher:               NewMemoryCacher(),
+			ReadYourWritesWindow: window,
+		}}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			executed++
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: db.Statement.Table}}
+			db.Statement.RowsAffected = 1
+		}
+		return db, &executed
+	}
+	find := func(db *gorm.DB, table string) {
+		db.Table(table).Find(&[]mockDest{})
+	}
+	update := func(db *gorm.DB, table string) {
+		db.Session(&gorm.Session{DryRun: true}).Table(table).Where("id = ?", 1).Update("result", "updated")
+	}
+
+	t.Run("reads of the mutated table skip the cache", func(t *testing.T) {
+		db, executed := newCaches(t, time.Hour)
+		find(db, "users")
+		find(db, "orders")
+		update(db, "users")
+
+		find(db, "users")
+		find(db, "users")
+		find(db, "orders")
+		if *executed != 4 {
+			t.Errorf("expected the reads of the mutated table only to skip the cache, got %d queries", *executed)
+		}
+	})
+
+	t.Run("the cache is used again after the window", func(t *testing.T) {
+		db, executed := newCaches(t, 50*time.Millisecond)
+		update(db, "users")
+		find(db, "users")
+		find(db, "users")
+		if *executed != 2 {
+			t.Fatalf("expected the reads within the window to skip the cache, got %d queries", *executed)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		find(db, "users")
+		find(db, "users")
+		if *executed != 3 {
+			t.Errorf("expected the reads after the window to be cached, got %d queries", *executed)
+		}
+	})
+
+	t.Run("reads of unknown tables skip the cache", func(t *testing.T) {
+		db, executed := newCaches(t, time.Hour)
+		update(db, "users")
+		db.Raw("SELECT * FROM orders").Find(&[]mockDest{})
+		db.Raw("SELECT * FROM orders").Find(&[]mockDest{})
+		if *executed != 2 {
+			t.Errorf("expected the reads of unknown tables to skip the cache, got %d queries", *executed)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		db, executed := newCaches(t, 0)
+		find(db, "users")
+		update(db, "users")
+		find(db, "users")
+		find(db, "users")
+		if *executed != 2 {
+			t.Errorf("expected the reads following the write to be cached, got %d queries", *executed)
+		}
+	})
+}
+
+func Test_tombstones(t *testing.T) {
+	ts := &tombstones{}
+	if ts.live([]string{"users"}) || ts.live(nil) {
+		t.Fatal("expected no tombstone to be live initially")
+	}
+
+	ts.mark([]string{"users"}, time.Hour)
+	ts.mark([]string{"users"}, time.Millisecond) // A shorter window does not shorten a live tombstone
+	time.Sleep(5 * time.Millisecond)
+	if !ts.live([]string{"orders", "users"}) {
+		t.Error("expected the tombstone of the mutated table to be live")
+	}
+	if ts.live([]string{"orders"}) {
+		t.Error("expected the other tables to hold no tombstone")
+	}
+	if !ts.live(nil) {
+		t.Error("expected the unknown tables to be considered mutated")
+	}
+
+	ts.mark(nil, time.Hour)
+	if !ts.live([]string{"orders"}) {
+		t.Error("expected a mutation of unknown tables to mark all the tables")
+	}
+}
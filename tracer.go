@@ -0,0 +1,76 @@
+package caches
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// The names of the spans started by the plugin, see Config.Tracer
+const (
+	SpanQuery        = "caches.query"
+	SpanCheckCache   = "caches.checkCache"
+	SpanEase         = "caches.ease"
+	SpanStoreInCache = "caches.storeInCache"
+)
+
+// The attributes set on the spans
+const (
+	// AttributeTable is the table of the parsed statement, it may be empty for raw queries
+	AttributeTable = "caches.table"
+	// AttributeIdentifier is the digest of the query identifier, the identifier itself may hold bind variables
+	AttributeIdentifier = "caches.identifier"
+	// AttributeHit tells whether the query was served from the Cacher, it is set on the query and checkCache spans
+	AttributeHit = "caches.hit"
+)
+
+// Tracer starts the spans of the cache operations, see Config.Tracer
+// It is shaped after the OpenTelemetry tracer, so that adapting one does not require the plugin to depend on it:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, caches.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+type Tracer interface {
+	// Start impl should start a span nested under the one of ctx, if any, and return a ctx holding the new span
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	// SetAttribute impl should record the attribute, value is either a string or a bool
+	SetAttribute(key string, value any)
+	End()
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, any) {}
+
+func (noopSpan) End() {}
+
+func noopEnd() {}
+
+// startSpan starts a span of the operation on the statement, nesting the spans and the queries run until end is called
+// under it. Without a Tracer, it returns a no-op span, so that tracing costs nothing.
+func (c *Caches) startSpan(db *gorm.DB, name, identifier string) (span Span, end func()) {
+	if c.Conf.Tracer == nil {
+		return noopSpan{}, noopEnd
+	}
+
+	parent := db.Statement.Context
+	if parent == nil {
+		parent = context.Background()
+	}
+
+	ctx, span := c.Conf.Tracer.Start(parent, name)
+	span.SetAttribute(AttributeTable, db.Statement.Table)
+	span.SetAttribute(AttributeIdentifier, redactIdentifier(identifier))
+	db.Statement.Context = ctx
+	return span, func() {
+		db.Statement.Context = parent
+		span.End()
+	}
+}
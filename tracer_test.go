@@ -0,0 +1,123 @@
+package caches
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type spanMock struct {
+	name       string
+	parent     *spanMock
+	attributes map[string]any
+	ended      bool
+}
+
+func (s *spanMock) SetAttribute(key string, value any) {
+	s.attributes[key] = value
+}
+
+func (s *spanMock) End() {
+	s.ended = true
+}
+
+type spanMockKey struct{}
+
+type tracerMock struct {
+	mu    sync.Mutex
+	spans []*spanMock
+}
+
+func (t *tracerMock) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parent, _ := ctx.Value(spanMockKey{}).(*spanMock)
+	span := &spanMock{name: name, parent: parent, attributes: map[string]any{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, spanMockKey{}, span), span
+}
+
+// paths returns the names of the spans along with the ones of their ancestors
+func (t *tracerMock) paths() []string {
+	paths := make([]string, len(t.spans))
+	for i, span := range t.spans {
+		var names []string
+		for s := span; s != nil; s = s.parent {
+			names = append([]string{s.name}, names...)
+		}
+		paths[i] = strings.Join(names, " > ")
+	}
+	return paths
+}
+
+func TestCaches_Tracer(t *testing.T) {
+	type rootKey struct{}
+
+	var queried context.Context
+	tracer := &tracerMock{}
+	caches := &Caches{
+		Conf: &Config{
+			Cacher: &cacherMock{},
+			Tracer: tracer,
+		},
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				queried = db.Statement.Context
+			},
+		},
+	}
+
+	root := context.WithValue(context.Background(), rootKey{}, true)
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Context = root
+		db.Statement.Table = "users"
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	miss := newDB()
+	caches.query(miss)
+	expected := []string{
+		"caches.query",
+		"caches.query > caches.checkCache",
+		"caches.query > caches.ease",
+		"caches.query > caches.storeInCache",
+	}
+	if act := tracer.paths(); strings.Join(act, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected the spans to be %v, got %v", expected, act)
+	}
+	if span, _ := queried.Value(spanMockKey{}).(*spanMock); span == nil || span.name != SpanEase {
+		t.Error("expected the query to run under the ease span")
+	}
+	if queried.Value(rootKey{}) == nil {
+		t.Error("expected the spans to nest under the statement's context")
+	}
+	if miss.Statement.Context != root {
+		t.Error("expected the statement's context to be restored")
+	}
+
+	hit := newDB()
+	caches.query(hit)
+	if len(tracer.spans) != 6 {
+		t.Fatalf("expected a hit to start the query and checkCache spans only, got %v", tracer.paths())
+	}
+
+	for i, span := range tracer.spans {
+		if !span.ended {
+			t.Errorf("expected the span %s to be ended", span.name)
+		}
+		if span.attributes[AttributeTable] != "users" || !strings.HasPrefix(span.attributes[AttributeIdentifier].(string), "fnv64:") {
+			t.Errorf("expected the span %s to have the table and the identifier digest, got %v", span.name, span.attributes)
+		}
+		if hit, ok := span.attributes[AttributeHit]; ok && hit != (i >= 4) {
+			t.Errorf("expected the hit attribute of the span %s to be %t", span.name, i >= 4)
+		}
+	}
+}
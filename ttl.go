@@ -0,0 +1,96 @@
+package caches
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ttlOf resolves the ttl a query is stored for, from the TableTTL rules matching its table or from DefaultTTL
+// When several rules match, the shortest ttl wins, the resolution is memoized per table
+// An invalid pattern never matches, Initialize rejects the ones configured before it runs
+func (c *Caches) ttlOf(db *gorm.DB) time.Duration {
+	table := ruleTable(db.Statement)
+	if len(c.Conf.TableTTL) == 0 || table == "" {
+		return c.Conf.DefaultTTL
+	}
+
+	if ttl, ok := c.tableTTLs.Load(table); ok {
+		return ttl
+	}
+
+	var (
+		ttl     time.Duration
+		matched bool
+	)
+	for pattern, patternTTL := range c.Conf.TableTTL {
+		compiled, err := c.compilePattern(pattern)
+		if err == nil && compiled.MatchString(table) && (!matched || shorterTTL(patternTTL, ttl)) {
+			ttl, matched = patternTTL, true
+		}
+	}
+	if !matched {
+		ttl = c.Conf.DefaultTTL
+	}
+
+	c.tableTTLs.Store(table, ttl, c.maxTableDecisions())
+	return ttl
+}
+
+// storeTTLOf resolves the ttl a query result is stored for, which is capped by NegativeTTL for the empty results
+// and randomized by TTLJitter
+func (c *Caches) storeTTLOf(db *gorm.DB) time.Duration {
+	ttl := c.ttlOf(db)
+	if c.Conf.NegativeTTL > 0 && emptyResult(db) && shorterTTL(c.Conf.NegativeTTL, ttl) {
+		ttl = c.Conf.NegativeTTL
+	}
+	return c.jitter(ttl)
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter randomizes the ttl uniformly within the TTLJitter fraction around it, see jitterTTL
+func (c *Caches) jitter(ttl time.Duration) time.Duration {
+	return jitterTTL(ttl, c.Conf.TTLJitter)
+}
+
+// jitterTTL randomizes the ttl uniformly within the fraction around it, a zero ttl never expires so it is kept
+// The jittered ttl is always positive, since the fraction is below 1
+func jitterTTL(ttl time.Duration, fraction float64) time.Duration {
+	if ttl <= 0 || fraction <= 0 || fraction >= 1 {
+		return ttl
+	}
+
+	jitterMu.Lock()
+	offset := (jitterRand.Float64()*2 - 1) * fraction
+	jitterMu.Unlock()
+
+	if jittered := ttl + time.Duration(float64(ttl)*offset); jittered > 0 {
+		return jittered
+	}
+	return ttl
+}
+
+// emptyResult reports whether the query found no rows
+func emptyResult(db *gorm.DB) bool {
+	if db.Statement.RowsAffected == 0 {
+		return true
+	}
+
+	dest := reflect.Indirect(reflect.ValueOf(db.Statement.Dest))
+	return dest.Kind() == reflect.Slice && dest.Len() == 0
+}
+
+// shorterTTL reports whether a expires before b, keeping in mind that a zero ttl never expires
+func shorterTTL(a, b time.Duration) bool {
+	if a <= 0 {
+		return false
+	}
+	return b <= 0 || a < b
+}
@@ -0,0 +1,232 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_ttlOf(t *testing.T) {
+	conf := &Config{
+		DefaultTTL: time.Minute,
+		TableTTL: map[string]time.Duration{
+			"^sessions$":  30 * time.Second,
+			"^countries$": time.Hour,
+			"^user":       10 * time.Minute,
+			"_audit$":     time.Second,
+			"^forever":    0,
+		},
+	}
+
+	testCases := map[string]time.Duration{
+		"sessions":       30 * time.Second,
+		"countries":      time.Hour,
+		"users":          10 * time.Minute,
+		"user_audit":     time.Second,
+		"forever_audit":  time.Second,
+		"forever":        0,
+		"orders":         time.Minute,
+		"":               time.Minute,
+		"sessions_cache": time.Minute,
+	}
+
+	caches := &Caches{Conf: conf}
+	for table, expected := range testCases {
+		t.Run(table, func(t *testing.T) {
+			db := &gorm.DB{Statement: &gorm.Statement{Table: table}}
+			for i := 0; i < 2; i++ { // The second resolution is served by the memo
+				if act := caches.ttlOf(db); act != expected {
+					t.Errorf("expected the ttl of `%s` to be %s, got %s", table, expected, act)
+				}
+			}
+		})
+	}
+
+	if _, ok := caches.tableTTLs.Load("sessions"); !ok {
+		t.Error("expected the ttl resolution to be memoized per table")
+	}
+}
+
+func TestCaches_NegativeTTL(t *testing.T) {
+	newCaches := func(negativeTTL time.Duration, rows []mockDest) (*Caches, *cacherMock, *int) {
+		cacher := &cacherMock{}
+		var executed int
+		return &Caches{
+			Conf: &Config{
+				Cacher:      cacher,
+				DefaultTTL:  time.Hour,
+				NegativeTTL: negativeTTL,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					executed++
+					db.Statement.RowsAffected = int64(len(rows))
+					switch dest := db.Statement.Dest.(type) {
+					case *[]mockDest:
+						*dest = rows
+					case *mockDest:
+						if len(rows) > 0 {
+							*dest = rows[0]
+						}
+					}
+					// As gorm does upon scanning the rows
+					if db.Statement.RowsAffected == 0 && db.Statement.RaiseErrorOnNotFound {
+						_ = db.AddError(gorm.ErrRecordNotFound)
+					}
+				},
+			},
+		}, cacher, &executed
+	}
+	newDB := func(dest any, first bool) *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = dest
+		db.Statement.RaiseErrorOnNotFound = first
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	t.Run("record not found", func(t *testing.T) {
+		caches, cacher, executed := newCaches(time.Minute, nil)
+
+		for i := 0; i < 2; i++ {
+			db := newDB(&mockDest{}, true)
+			caches.query(db)
+			if !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+				t.Errorf("expected the query to fail with gorm.ErrRecordNotFound, got %v", db.Error)
+			}
+		}
+		if *executed != 1 {
+			t.Errorf("expected the not found result to be served from the cache, the query ran %d times", *executed)
+		}
+		if cacher.ttl != time.Minute {
+			t.Errorf("expected the not found result to be stored for %s, got %s", time.Minute, cacher.ttl)
+		}
+	})
+
+	t.Run("record not found without negative ttl", func(t *testing.T) {
+		caches, _, executed := newCaches(0, nil)
+		for i := 0; i < 2; i++ {
+			caches.query(newDB(&mockDest{}, true))
+		}
+		if *executed != 2 {
+			t.Errorf("expected the not found result not to be stored, the query ran %d times", *executed)
+		}
+	})
+
+	t.Run("empty slice", func(t *testing.T) {
+		caches, cacher, _ := newCaches(time.Minute, nil)
+		db := newDB(&[]mockDest{}, false)
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", db.Error)
+		}
+		if cacher.ttl != time.Minute {
+			t.Errorf("expected the empty result to be stored for %s, got %s", time.Minute, cacher.ttl)
+		}
+	})
+
+	t.Run("rows", func(t *testing.T) {
+		caches, cacher, _ := newCaches(time.Minute, []mockDest{{Result: "found"}})
+		caches.query(newDB(&[]mockDest{}, false))
+		if cacher.ttl != time.Hour {
+			t.Errorf("expected the result with rows to be stored for %s, got %s", time.Hour, cacher.ttl)
+		}
+	})
+
+	t.Run("shorter default ttl", func(t *testing.T) {
+		caches, cacher, _ := newCaches(time.Minute, nil)
+		caches.Conf.DefaultTTL = time.Second
+		caches.query(newDB(&[]mockDest{}, false))
+		if cacher.ttl != time.Second {
+			t.Errorf("expected the shorter default ttl to be kept, got %s", cacher.ttl)
+		}
+	})
+}
+
+func TestCaches_TTLJitter(t *testing.T) {
+	t.Run("jitter", func(t *testing.T) {
+		testCases := map[string]struct {
+			fraction float64
+			ttl      time.Duration
+			jittered bool
+		}{
+			"disabled":        {fraction: 0, ttl: time.Minute},
+			"never expires":   {fraction: 0.5, ttl: 0},
+			"invalid":         {fraction: 1.5, ttl: time.Minute},
+			"jittered":        {fraction: 0.1, ttl: time.Minute, jittered: true},
+			"shortest ttl":    {fraction: 0.9, ttl: time.Nanosecond, jittered: true},
+			"widest fraction": {fraction: 0.99, ttl: time.Second, jittered: true},
+		}
+
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				caches := &Caches{Conf: &Config{TTLJitter: tc.fraction}}
+				for i := 0; i < 1000; i++ {
+					act := caches.jitter(tc.ttl)
+					if !tc.jittered {
+						if act != tc.ttl {
+							t.Fatalf("expected the ttl to be kept, got %s", act)
+						}
+						continue
+					}
+					band := time.Duration(float64(tc.ttl) * tc.fraction)
+					if act <= 0 || act < tc.ttl-band || act > tc.ttl+band {
+						t.Fatalf("expected a positive ttl within %s of %s, got %s", band, tc.ttl, act)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("stored values expire at different times", func(t *testing.T) {
+		cacher := &cacherMock{}
+		caches := &Caches{
+			Conf: &Config{
+				Cacher:     cacher,
+				DefaultTTL: time.Hour,
+				TTLJitter:  0.1,
+			},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					db.Statement.RowsAffected = 1
+				},
+			},
+		}
+
+		const stored = 200
+		var earliest, latest time.Time
+		expirations := make(map[time.Time]bool, stored)
+		for i := 0; i < stored; i++ {
+			db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			db.Statement.Dest = &mockDest{}
+			db.Statement.Table = "users"
+			db.Statement.SQL.WriteString("demo-query")
+			db.Statement.Vars = []interface{}{i}
+			caches.query(db)
+
+			res, _ := cacher.Get(context.Background(), buildIdentifier(db, "", nil), nil)
+			if res == nil {
+				t.Fatalf("expected the result %d to be stored", i)
+			}
+			expiresAt := res.ExpiresAt
+			expirations[expiresAt] = true
+			if earliest.IsZero() || expiresAt.Before(earliest) {
+				earliest = expiresAt
+			}
+			if expiresAt.After(latest) {
+				latest = expiresAt
+			}
+		}
+
+		if len(expirations) < stored/2 {
+			t.Errorf("expected the expirations to be distinct, got %d distinct ones out of %d", len(expirations), stored)
+		}
+		if spread := latest.Sub(earliest); spread < 6*time.Minute || spread > 12*time.Minute {
+			t.Errorf("expected the expirations to be spread across the 12m band, got %s", spread)
+		}
+	})
+}
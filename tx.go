@@ -0,0 +1,148 @@
+package caches
+
+import (
+	"database/sql"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// inTransaction reports whether the statement runs within a transaction
+func inTransaction(db *gorm.DB) bool {
+	committer, ok := db.Statement.ConnPool.(gorm.TxCommitter)
+	return ok && committer != nil && !reflect.ValueOf(committer).IsNil()
+}
+
+// isPointer reports whether the ConnPool is a pointer, which is what its transactions can be tracked by
+func isPointer(pool gorm.ConnPool) bool {
+	return pool != nil && reflect.ValueOf(pool).Kind() == reflect.Ptr
+}
+
+// pendingInvalidations collects the invalidations of the mutations run within a Transaction, until it commits
+type pendingInvalidations struct {
+	mu     sync.Mutex
+	all    bool
+	tables map[string]struct{}
+	tags   map[string]struct{}
+	// written holds the tables the mutations wrote to, whichever way they are invalidated, see Config.ReadYourWritesWindow
+	written map[string]struct{}
+}
+
+func (p *pendingInvalidations) add(tables, tags []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.written == nil {
+		p.written = make(map[string]struct{}, len(tables))
+	}
+	for _, table := range tables {
+		p.written[table] = struct{}{}
+	}
+
+	switch {
+	case len(tags) > 0:
+		if p.tags == nil {
+			p.tags = make(map[string]struct{}, len(tags))
+		}
+		for _, tag := range tags {
+			p.tags[tag] = struct{}{}
+		}
+	case len(tables) > 0:
+		if p.tables == nil {
+			p.tables = make(map[string]struct{}, len(tables))
+		}
+		for _, table := range tables {
+			p.tables[table] = struct{}{}
+		}
+	default:
+		p.all = true
+	}
+}
+
+// pendingOf returns the invalidations pending on the transaction of the statement, if it is run by Transaction
+func (c *Caches) pendingOf(db *gorm.DB) *pendingInvalidations {
+	if !isPointer(db.Statement.ConnPool) {
+		return nil
+	}
+	if p, ok := c.transactions.Load(db.Statement.ConnPool); ok {
+		return p.(*pendingInvalidations)
+	}
+	return nil
+}
+
+// Transaction runs fc within a transaction, as db.Transaction does, deferring the invalidations of its mutations
+// until the transaction commits, they are dropped when it rolls back. The deferred invalidations are collapsed per
+// table and tag, so the many mutations of a batch end up in a single call to the Cacher.
+//
+// The mutations of a transaction started otherwise invalidate the cache when they run, in which case a query run
+// outside of the transaction before it commits may store a value which is stale once it commits.
+// Within any transaction the queries skip both the Cacher and the easer, since a cached value may not be consistent
+// with the snapshot of a transaction, nor may a value read within a transaction be visible to the other ones.
+//
+// A Transaction nested within another one, including one started with db.Transaction, leaves the invalidations
+// to the outermost one. The transaction being committed by the time the invalidations run,
+// their error is returned as is, the cached values of the mutated tables may then be stale.
+func (c *Caches) Transaction(db *gorm.DB, fc func(tx *gorm.DB) error, opts ...*sql.TxOptions) error {
+	if c.Conf.Cacher == nil || inTransaction(db) || !isPointer(db.Statement.ConnPool) {
+		return db.Transaction(fc, opts...)
+	}
+
+	var pending *pendingInvalidations
+	err := db.Transaction(func(tx *gorm.DB) error {
+		pending = &pendingInvalidations{}
+		c.transactions.Store(tx.Statement.ConnPool, pending)
+		defer c.transactions.Delete(tx.Statement.ConnPool)
+		return fc(tx)
+	}, opts...)
+	if err != nil || pending == nil {
+		return err
+	}
+
+	return c.flushInvalidations(db, pending)
+}
+
+// flushInvalidations runs the invalidations collected by a committed transaction
+func (c *Caches) flushInvalidations(db *gorm.DB, p *pendingInvalidations) error {
+	ctx := db.Statement.Context
+	table := db.Statement.Table
+	if p.all {
+		c.markWritten(nil)
+	} else {
+		written := make([]string, 0, len(p.written))
+		for t := range p.written {
+			written = append(written, t)
+		}
+		c.markWritten(written)
+	}
+
+	if p.all {
+		c.log(ctx, LogInvalidate, table, "", nil)
+		return c.Conf.Cacher.Invalidate(ctx)
+	}
+
+	if len(p.tables) > 0 {
+		tables := make([]string, 0, len(p.tables))
+		for t := range p.tables {
+			tables = append(tables, t)
+		}
+		c.log(ctx, LogInvalidate, table, "", tables)
+		if err := c.Conf.Cacher.Invalidate(ctx, tables...); err != nil {
+			return err
+		}
+	}
+
+	if len(p.tags) > 0 {
+		tags := make([]string, 0, len(p.tags))
+		for tag := range p.tags {
+			tags = append(tags, tag)
+		}
+		invalidator, ok := c.tagInvalidator()
+		if !ok {
+			return c.Conf.Cacher.Invalidate(ctx)
+		}
+		c.log(ctx, LogInvalidate, table, "", tags)
+		return invalidator.InvalidateTags(ctx, tags...)
+	}
+	return nil
+}
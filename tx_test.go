@@ -0,0 +1,212 @@
+package caches
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+// connPoolMock is a ConnPool beginning transactions, the statements themselves are never run since it's used in DryRun
+type connPoolMock struct {
+	committed, rolledBack int
+}
+
+func (p *connPoolMock) PrepareContext(context.Context, string) (*sql.Stmt, error) {
+	return nil, errors.New("unsupported")
+}
+
+func (p *connPoolMock) ExecContext(context.Context, string, ...interface{}) (sql.Result, error) {
+	return nil, errors.New("unsupported")
+}
+
+func (p *connPoolMock) QueryContext(context.Context, string, ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("unsupported")
+}
+
+func (p *connPoolMock) QueryRowContext(context.Context, string, ...interface{}) *sql.Row {
+	return nil
+}
+
+func (p *connPoolMock) BeginTx(context.Context, *sql.TxOptions) (gorm.ConnPool, error) {
+	return &txMock{pool: p}, nil
+}
+
+// txMock is a ConnPool within a transaction, like *sql.Tx it cannot begin another one
+type txMock struct {
+	pool *connPoolMock
+}
+
+func (tx *txMock) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return tx.pool.PrepareContext(ctx, query)
+}
+
+func (tx *txMock) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.pool.ExecContext(ctx, query, args...)
+}
+
+func (tx *txMock) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.pool.QueryContext(ctx, query, args...)
+}
+
+func (tx *txMock) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.pool.QueryRowContext(ctx, query, args...)
+}
+
+func (tx *txMock) Commit() error {
+	tx.pool.committed++
+	return nil
+}
+
+func (tx *txMock) Rollback() error {
+	tx.pool.rolledBack++
+	return nil
+}
+
+type invalidationsCacherMock struct {
+	cacherMock
+	invalidations [][]string
+}
+
+func (c *invalidationsCacherMock) Invalidate(_ context.Context, tables ...string) error {
+	sort.Strings(tables)
+	c.invalidations = append(c.invalidations, tables)
+	return nil
+}
+
+func TestCaches_Transaction(t *testing.T) {
+	newDB := func(t *testing.T) (*gorm.DB, *Caches, *invalidationsCacherMock, *int) {
+		cacher := &invalidationsCacherMock{}
+		caches := &Caches{Conf: &Config{Easer: true, Cacher: cacher}}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true, ConnPool: &connPoolMock{}})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		executed := new(int)
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			*executed++
+			db.Statement.RowsAffected = 1
+		}
+		return db, caches, cacher, executed
+	}
+
+	t.Run("reads within a transaction skip the cache", func(t *testing.T) {
+		db, caches, _, executed := newDB(t)
+		db.Find(&[]tablesUserModel{})
+
+		_ = db.Transaction(func(tx *gorm.DB) error {
+			tx.Find(&[]tablesUserModel{})
+			return nil
+		})
+		if *executed != 2 {
+			t.Errorf("expected the read within the transaction to hit the database, it was queried %d times", *executed)
+		}
+		if act := caches.Stats(); act.Stores != 1 || act.Hits != 0 {
+			t.Errorf("expected the read within the transaction neither to be served from nor stored in the cache, got %+v", act)
+		}
+	})
+
+	t.Run("invalidations are deferred until the commit", func(t *testing.T) {
+		db, caches, cacher, _ := newDB(t)
+		err := caches.Transaction(db, func(tx *gorm.DB) error {
+			tx.Model(&tablesUserModel{}).Where("name = ?", "ktsivkov").Update("name", "guest")
+			tx.Where("id = ?", 1).Delete(&tablesOrderModel{})
+			tx.Model(&tablesUserModel{}).Where("name = ?", "guest").Update("name", "ktsivkov")
+			if len(cacher.invalidations) != 0 {
+				t.Errorf("expected the invalidations to be deferred, got %v", cacher.invalidations)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Transaction resulted into an unexpected error, %v", err)
+		}
+
+		expected := [][]string{{"tables_order_models", "tables_user_models"}}
+		if !reflect.DeepEqual(cacher.invalidations, expected) {
+			t.Errorf("expected the tables to be invalidated once upon commit, got %v", cacher.invalidations)
+		}
+	})
+
+	t.Run("tombstones are recorded upon commit", func(t *testing.T) {
+		db, caches, _, _ := newDB(t)
+		caches.tombstones = &tombstones{}
+		caches.Conf.ReadYourWritesWindow = time.Hour
+		_ = caches.Transaction(db, func(tx *gorm.DB) error {
+			tx.Where("id = ?", 1).Delete(&tablesOrderModel{})
+			if caches.tombstones.live([]string{"tables_order_models"}) {
+				t.Error("expected the tombstones to be deferred until the commit")
+			}
+			return nil
+		})
+		if !caches.tombstones.live([]string{"tables_order_models"}) {
+			t.Error("expected the mutated table to hold a tombstone once committed")
+		}
+	})
+
+	t.Run("invalidations of a batch collapse into one", func(t *testing.T) {
+		db, caches, cacher, _ := newDB(t)
+		err := caches.Transaction(db, func(tx *gorm.DB) error {
+			for i := 0; i < 100; i++ {
+				tx.Create(&tablesOrderModel{UserId: uint(i)})
+			}
+			tx.Session(&gorm.Session{DisableNestedTransaction: true}).CreateInBatches(make([]tablesOrderModel, 100), 10)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Transaction resulted into an unexpected error, %v", err)
+		}
+
+		expected := [][]string{{"tables_order_models"}}
+		if !reflect.DeepEqual(cacher.invalidations, expected) {
+			t.Errorf("expected the 110 inserts to invalidate their table once, got %d invalidations", len(cacher.invalidations))
+		}
+	})
+
+	t.Run("invalidations are dropped upon rollback", func(t *testing.T) {
+		db, caches, cacher, _ := newDB(t)
+		err := caches.Transaction(db, func(tx *gorm.DB) error {
+			tx.Where("id = ?", 1).Delete(&tablesOrderModel{})
+			return errors.New("rollback")
+		})
+		if err == nil || err.Error() != "rollback" {
+			t.Fatalf("expected the error of the transaction to be returned, got %v", err)
+		}
+		if len(cacher.invalidations) != 0 {
+			t.Errorf("expected no invalidation upon rollback, got %v", cacher.invalidations)
+		}
+	})
+
+	t.Run("nested transactions", func(t *testing.T) {
+		db, caches, cacher, _ := newDB(t)
+		_ = caches.Transaction(db, func(tx *gorm.DB) error {
+			// The dummy dialector does not support savepoints
+			return caches.Transaction(tx.Session(&gorm.Session{DisableNestedTransaction: true}), func(tx *gorm.DB) error {
+				tx.Where("id = ?", 1).Delete(&tablesOrderModel{})
+				return nil
+			})
+		})
+		if !reflect.DeepEqual(cacher.invalidations, [][]string{{"tables_order_models"}}) {
+			t.Errorf("expected the outermost transaction to invalidate upon its commit, got %v", cacher.invalidations)
+		}
+	})
+
+	t.Run("transactions started otherwise invalidate immediately", func(t *testing.T) {
+		db, _, cacher, _ := newDB(t)
+		_ = db.Transaction(func(tx *gorm.DB) error {
+			tx.Where("id = ?", 1).Delete(&tablesOrderModel{})
+			if len(cacher.invalidations) != 1 {
+				t.Errorf("expected the mutation to invalidate immediately, got %v", cacher.invalidations)
+			}
+			return nil
+		})
+	})
+}
@@ -0,0 +1,117 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+const defaultWarmConcurrency = 4
+
+// WarmError aggregates the errors of the queries which failed to warm the cache
+type WarmError struct {
+	// Errors holds the error of every failed query, in the order of the queries
+	Errors []error
+}
+
+func (e *WarmError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("caches: %d warm queries failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// Warm runs the queries and stores their results in the Cacher, e.g. to populate a cold cache upon deploy
+//
+// Every query func receives a session of db and should run a single finisher on it, e.g.
+//
+//	func(db *gorm.DB) *gorm.DB { return db.Where("active = ?", true).Find(&[]User{}) }
+//
+// The queries are built and identified exactly as the live ones, so the warmed values are the ones the live queries hit.
+// They skip both the Cacher lookup and the easer, so each of them stores its own fresh result.
+// Up to WarmConcurrency queries run in parallel, Warm returns once all of them completed,
+// with a *WarmError holding the errors of the failed ones
+func (c *Caches) Warm(db *gorm.DB, queries []func(*gorm.DB) *gorm.DB) error {
+	if c.Conf == nil || c.Conf.Cacher == nil {
+		return errors.New("caches: Warm requires a Cacher")
+	}
+
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return c.warm(db.WithContext(context.WithValue(ctx, warmContextKey, true)), queries)
+}
+
+// WarmBatch is Warm storing the results of all the queries at once, once they all completed,
+// with a single BatchStore when the Cacher is a BatchCacher, which saves a round-trip per query
+// The query funcs must not reuse their destinations, since the results are only stored once all of them returned.
+// The errors of the store are returned along with the ones of the queries
+func (c *Caches) WarmBatch(db *gorm.DB, queries []func(*gorm.DB) *gorm.DB) error {
+	if c.Conf == nil || c.Conf.Cacher == nil {
+		return errors.New("caches: WarmBatch requires a Cacher")
+	}
+
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	b := &batchStore{}
+	err := c.warm(db.WithContext(context.WithValue(context.WithValue(ctx, warmContextKey, true), batchContextKey, b)), queries)
+
+	storeErrs := c.storeBatch(ctx, b)
+	if len(storeErrs) == 0 {
+		return err
+	}
+
+	var warmErr *WarmError
+	if !errors.As(err, &warmErr) {
+		warmErr = &WarmError{}
+	}
+	warmErr.Errors = append(warmErr.Errors, storeErrs...)
+	return warmErr
+}
+
+// warm runs the queries on warmDB, up to WarmConcurrency of them in parallel
+func (c *Caches) warm(warmDB *gorm.DB, queries []func(*gorm.DB) *gorm.DB) error {
+	concurrency := c.Conf.WarmConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultWarmConcurrency
+	}
+
+	var (
+		wg    sync.WaitGroup
+		slots = make(chan struct{}, concurrency)
+		errs  = make([]error, len(queries))
+	)
+	for i, query := range queries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, query func(*gorm.DB) *gorm.DB) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			if res := query(warmDB); res != nil && res.Error != nil {
+				errs[i] = res.Error
+			}
+		}(i, query)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	if len(failed) > 0 {
+		return &WarmError{Errors: failed}
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_Warm(t *testing.T) {
+	newDB := func(t *testing.T, caches *Caches, queryCb func(db *gorm.DB)) *gorm.DB {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		caches.callbacks[uponQuery] = queryCb
+		return db
+	}
+
+	t.Run("warmed values are hit by live queries", func(t *testing.T) {
+		var executed int64
+		caches := &Caches{Conf: &Config{Easer: true, Cacher: &cacherMock{}}}
+		db := newDB(t, caches, func(db *gorm.DB) {
+			atomic.AddInt64(&executed, 1)
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: "warmed"}}
+			db.Statement.RowsAffected = 1
+		})
+
+		err := caches.Warm(db, []func(*gorm.DB) *gorm.DB{
+			func(db *gorm.DB) *gorm.DB {
+				return db.Table("users").Where("active = ?", true).Find(&[]mockDest{})
+			},
+			func(db *gorm.DB) *gorm.DB {
+				return db.Table("users").Where("active = ?", false).Find(&[]mockDest{})
+			},
+		})
+		if err != nil {
+			t.Fatalf("Warm resulted into an unexpected error, %v", err)
+		}
+		if act := caches.Stats(); act.Stores != 2 || act.Hits != 0 || act.Misses != 0 {
+			t.Errorf("expected both queries to be stored without being looked up, got %+v", act)
+		}
+
+		var users []mockDest
+		db.Table("users").Where("active = ?", true).Find(&users)
+		if len(users) != 1 || users[0].Result != "warmed" {
+			t.Errorf("expected the live query to be served the warmed value, got %+v", users)
+		}
+		if executed != 2 {
+			t.Errorf("expected the live query to hit the cache, the database was queried %d times", executed)
+		}
+	})
+
+	t.Run("warming replaces cached values", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{Cacher: &cacherMock{}}}
+		var result atomic.Value
+		result.Store("stale")
+		db := newDB(t, caches, func(db *gorm.DB) {
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: result.Load().(string)}}
+		})
+		query := func(db *gorm.DB) *gorm.DB {
+			return db.Table("users").Find(&[]mockDest{})
+		}
+
+		query(db)
+		result.Store("fresh")
+		if err := caches.Warm(db, []func(*gorm.DB) *gorm.DB{query}); err != nil {
+			t.Fatalf("Warm resulted into an unexpected error, %v", err)
+		}
+
+		var users []mockDest
+		db.Table("users").Find(&users)
+		if len(users) != 1 || users[0].Result != "fresh" {
+			t.Errorf("expected the warmed value to replace the cached one, got %+v", users)
+		}
+	})
+
+	t.Run("aggregated errors", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{Cacher: &cacherMock{}}}
+		db := newDB(t, caches, func(db *gorm.DB) {
+			if db.Statement.Table == "broken" {
+				_ = db.AddError(errors.New("query-error"))
+			}
+		})
+
+		err := caches.Warm(db, []func(*gorm.DB) *gorm.DB{
+			func(db *gorm.DB) *gorm.DB { return db.Table("broken").Find(&[]mockDest{}) },
+			func(db *gorm.DB) *gorm.DB { return db.Table("users").Find(&[]mockDest{}) },
+			func(db *gorm.DB) *gorm.DB { return db.Table("broken").Find(&[]mockDest{}) },
+		})
+		var warmErr *WarmError
+		if !errors.As(err, &warmErr) || len(warmErr.Errors) != 2 {
+			t.Fatalf("expected a WarmError holding the errors of both failed queries, got %v", err)
+		}
+		if act := caches.Stats(); act.Stores != 1 {
+			t.Errorf("expected the successful query to be stored, got %+v", act)
+		}
+	})
+
+	t.Run("concurrency limit", func(t *testing.T) {
+		var running, maxRunning int64
+		var mu sync.Mutex
+		caches := &Caches{Conf: &Config{Cacher: &cacherMock{}, WarmConcurrency: 2}}
+		db := newDB(t, caches, func(db *gorm.DB) {
+			n := atomic.AddInt64(&running, 1)
+			mu.Lock()
+			if n > maxRunning {
+				maxRunning = n
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt64(&running, -1)
+		})
+
+		queries := make([]func(*gorm.DB) *gorm.DB, 6)
+		for i := range queries {
+			active := i
+			queries[i] = func(db *gorm.DB) *gorm.DB {
+				return db.Table("users").Where("id = ?", active).Find(&[]mockDest{})
+			}
+		}
+		if err := caches.Warm(db, queries); err != nil {
+			t.Fatalf("Warm resulted into an unexpected error, %v", err)
+		}
+		if maxRunning != 2 {
+			t.Errorf("expected up to %d queries to run in parallel, got %d", 2, maxRunning)
+		}
+	})
+
+	t.Run("without cacher", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{Easer: true}}
+		db := newDB(t, caches, func(db *gorm.DB) {})
+		if err := caches.Warm(db, nil); err == nil {
+			t.Error("expected Warm to fail without a Cacher")
+		}
+	})
+}
+
+type batchCacherMock struct {
+	cacherMock
+	batches [][]BatchEntry
+}
+
+func (c *batchCacherMock) BatchGet(ctx context.Context, keys []string, qs []*Query[any]) ([]*Query[any], error) {
+	res := make([]*Query[any], len(keys))
+	for i, key := range keys {
+		res[i], _ = c.Get(ctx, key, qs[i])
+	}
+	return res, nil
+}
+
+func (c *batchCacherMock) BatchStore(ctx context.Context, entries []BatchEntry) error {
+	c.batches = append(c.batches, entries)
+	for _, entry := range entries {
+		_ = c.Store(ctx, entry.Key, entry.Value, entry.TTL)
+	}
+	return nil
+}
+
+func TestCaches_WarmBatch(t *testing.T) {
+	newDB := func(t *testing.T, caches *Caches) *gorm.DB {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		caches.callbacks[uponQuery] = func(db *gorm.DB) {
+			if db.Statement.Table == "broken" {
+				_ = db.AddError(errors.New("query-error"))
+				return
+			}
+			*db.Statement.Dest.(*[]mockDest) = []mockDest{{Result: db.Statement.Table}}
+			db.Statement.RowsAffected = 1
+		}
+		return db
+	}
+	queries := []func(*gorm.DB) *gorm.DB{
+		func(db *gorm.DB) *gorm.DB {
+			return db.Table("users").Find(&[]mockDest{})
+		},
+		func(db *gorm.DB) *gorm.DB {
+			return db.Table("orders").Find(&[]mockDest{})
+		},
+		func(db *gorm.DB) *gorm.DB {
+			return db.Table("broken").Find(&[]mockDest{})
+		},
+	}
+
+	t.Run("batch cacher", func(t *testing.T) {
+		cacher := &batchCacherMock{}
+		caches := &Caches{Conf: &Config{Cacher: cacher}}
+		db := newDB(t, caches)
+
+		var warmErr *WarmError
+		if err := caches.WarmBatch(db, queries); !errors.As(err, &warmErr) || len(warmErr.Errors) != 1 {
+			t.Fatalf("expected the error of the broken query to be returned, got %v", err)
+		}
+		if len(cacher.batches) != 1 || len(cacher.batches[0]) != 2 {
+			t.Fatalf("expected the results to be stored in a single batch, got %+v", cacher.batches)
+		}
+		if act := caches.Stats(); act.Stores != 2 {
+			t.Errorf("expected both stores to be counted, got %+v", act)
+		}
+
+		var orders []mockDest
+		db.Table("orders").Find(&orders)
+		if len(orders) != 1 || orders[0].Result != "orders" || caches.Stats().Hits != 1 {
+			t.Errorf("expected the live query to be served the warmed value, got %+v", orders)
+		}
+	})
+
+	t.Run("single store cacher", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{Cacher: &cacherMock{}}}
+		db := newDB(t, caches)
+
+		if err := caches.WarmBatch(db, queries[:2]); err != nil {
+			t.Fatalf("WarmBatch resulted into an unexpected error, %v", err)
+		}
+		if act := caches.Stats(); act.Stores != 2 {
+			t.Errorf("expected the results to be stored one by one, got %+v", act)
+		}
+	})
+}
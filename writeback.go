@@ -0,0 +1,328 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultWriteBackInterval is the default of Config.WriteBackInterval
+const defaultWriteBackInterval = time.Second
+
+// writeBackBufferedKey is the instance setting of the updates buffered by the write-back mode,
+// which are neither run nor invalidate until they are flushed
+const writeBackBufferedKey = "caches:write_back_buffered"
+
+// counterPattern matches the counter expressions of the write-back mode, e.g. `views + ?` or "`views` - ?"
+var counterPattern = regexp.MustCompile("^\\s*[`\"]?(\\w+)[`\"]?\\s*([+-])\\s*\\?\\s*$")
+
+// writeBack buffers the counter increments of the WriteBackTables, see Config.WriteBackTables
+//
+// The increments of the same column of the same rows, told by the table, the column and the WHERE clause, are summed
+// into a single pending delta, which is flushed as a single UPDATE. The flusher runs once the plugin is initialized
+// and stops upon Close, which flushes what is left.
+type writeBack struct {
+	mu      sync.Mutex
+	db      *gorm.DB
+	pending map[string]*pendingIncrement
+	count   int           // the increments buffered since the last flush
+	flush   chan struct{} // signals the flusher that the threshold was reached
+	stop    chan struct{}
+	stopped chan struct{}
+	closed  bool
+}
+
+type pendingIncrement struct {
+	model  reflect.Type // the model of the update, nil for the updates of a bare db.Table
+	table  string
+	column string
+	where  clause.Where
+	delta  int64
+}
+
+// startWriteBack starts the flusher of the write-back mode, when WriteBackTables is set
+func (c *Caches) startWriteBack(db *gorm.DB) error {
+	if len(c.Conf.WriteBackTables) == 0 {
+		return nil
+	}
+
+	update := db.Callback().Update().Get("gorm:update")
+	if update == nil {
+		return errors.New("caches: the write-back mode requires the gorm:update callback")
+	}
+	if err := db.Callback().Update().Replace("gorm:update", func(db *gorm.DB) {
+		if !c.bufferIncrement(db) {
+			update(db)
+		}
+	}); err != nil {
+		return err
+	}
+
+	interval := c.Conf.WriteBackInterval
+	if interval <= 0 {
+		interval = defaultWriteBackInterval
+	}
+
+	w := &writeBack{
+		db:      db.Session(&gorm.Session{NewDB: true}),
+		pending: make(map[string]*pendingIncrement),
+		flush:   make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	c.writeBack = w
+
+	go func() {
+		defer close(w.stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-w.flush:
+			case <-w.stop:
+				return
+			}
+			_ = c.FlushWrites(context.Background())
+		}
+	}()
+	return nil
+}
+
+// bufferIncrement buffers the update when it is a counter increment of the WriteBackTables, reporting whether it did
+// The counter increments are the updates of a single integer column by an expression of itself, e.g.
+//
+//	db.Model(&PageView{}).Where("page = ?", page).UpdateColumn("views", gorm.Expr("views + ?", 1))
+//
+// which have a WHERE clause, as the other ones are either global or scoped to the primary key of their model,
+// and which run neither in a transaction, whose rollback could not undo them, nor in DryRun mode. The default
+// transaction of gorm is not one, it only holds the update itself.
+func (c *Caches) bufferIncrement(db *gorm.DB) bool {
+	w := c.writeBack
+	stmt := db.Statement
+	if w == nil || db.Error != nil || db.DryRun || stmt.SQL.Len() > 0 {
+		return false
+	}
+	if inUserTransaction(db) {
+		return false
+	}
+	if flushing, _ := stmt.Context.Value(writeBackFlushContextKey).(bool); flushing {
+		return false
+	}
+	key := cacheDecisionKey{table: ruleTable(stmt), model: modelTypeOf(stmt)}
+	if !c.matchTable(c.Conf.WriteBackTables, key) {
+		return false
+	}
+
+	increment, ok := counterIncrement(stmt)
+	if !ok {
+		return false
+	}
+
+	id := increment.id(db)
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return false
+	}
+	if pending, ok := w.pending[id]; ok {
+		pending.delta += increment.delta
+	} else {
+		w.pending[id] = increment
+	}
+	w.count++
+	reached := c.Conf.WriteBackThreshold > 0 && w.count >= c.Conf.WriteBackThreshold
+	w.mu.Unlock()
+
+	if reached {
+		select {
+		case w.flush <- struct{}{}:
+		default: // A flush is already due
+		}
+	}
+	db.InstanceSet(writeBackBufferedKey, true)
+	return true
+}
+
+// inUserTransaction reports whether the update runs within a transaction other than the one gorm begins
+// for every statement unless SkipDefaultTransaction is set, which commits as soon as the statement returns
+func inUserTransaction(db *gorm.DB) bool {
+	if !inTransaction(db) {
+		return false
+	}
+	_, implicit := db.InstanceGet("gorm:started_transaction")
+	return !implicit
+}
+
+// counterIncrement returns the increment of the counter update, which is not one when the second result is false
+func counterIncrement(stmt *gorm.Statement) (*pendingIncrement, bool) {
+	values, ok := stmt.Dest.(map[string]interface{})
+	if !ok || len(values) != 1 || len(stmt.Clauses) != 1 {
+		return nil, false
+	}
+	where, ok := stmt.Clauses["WHERE"].Expression.(clause.Where)
+	if !ok || len(where.Exprs) == 0 {
+		return nil, false
+	}
+	if hasPrimaryKeyValues(stmt) {
+		return nil, false
+	}
+
+	for name, value := range values {
+		column := name
+		if stmt.Schema != nil {
+			if field := stmt.Schema.LookUpField(name); field != nil {
+				column = field.DBName
+			}
+		}
+
+		expr, ok := value.(clause.Expr)
+		if !ok || len(expr.Vars) != 1 {
+			return nil, false
+		}
+		m := counterPattern.FindStringSubmatch(expr.SQL)
+		if m == nil || m[1] != column {
+			return nil, false
+		}
+		delta, ok := integerOf(expr.Vars[0])
+		if !ok {
+			return nil, false
+		}
+		if m[2] == "-" {
+			delta = -delta
+		}
+
+		return &pendingIncrement{
+			model:  modelTypeOf(stmt),
+			table:  stmt.Table,
+			column: column,
+			where:  where,
+			delta:  delta,
+		}, true
+	}
+	return nil, false
+}
+
+// hasPrimaryKeyValues reports whether the model of the statement holds a primary key, which gorm adds to the
+// conditions of the update
+func hasPrimaryKeyValues(stmt *gorm.Statement) bool {
+	if stmt.Schema == nil || !stmt.ReflectValue.IsValid() {
+		return false
+	}
+	v := reflect.Indirect(stmt.ReflectValue)
+	if v.Kind() != reflect.Struct {
+		return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+	}
+	for _, field := range stmt.Schema.PrimaryFields {
+		if _, isZero := field.ValueOf(stmt.Context, v); !isZero {
+			return true
+		}
+	}
+	return false
+}
+
+func integerOf(v interface{}) (int64, bool) {
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), true
+	default:
+		return 0, false
+	}
+}
+
+// id returns what tells the increments of the same rows apart from the other ones, their table, their column and
+// their WHERE clause, built as gorm builds it
+func (p *pendingIncrement) id(db *gorm.DB) string {
+	stmt := &gorm.Statement{DB: db, Table: p.table, Clauses: map[string]clause.Clause{}}
+	p.where.Build(stmt)
+	query, args := canonicalQuery(stmt.SQL.String(), stmt.Vars)
+	return p.table + "\x00" + p.column + "\x00" + query + "\x00" + strings.Join(args, "\x00")
+}
+
+// FlushWrites runs the increments buffered by the write-back mode against the database, see Config.WriteBackTables
+// The increments failing to flush are buffered again, so they are retried by the next flush, and the error of the
+// first of them is returned. It does nothing when WriteBackTables is not set.
+func (c *Caches) FlushWrites(ctx context.Context) error {
+	w := c.writeBack
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = make(map[string]*pendingIncrement)
+	w.count = 0
+	w.mu.Unlock()
+
+	var firstErr error
+	for id, increment := range pending {
+		if increment.delta == 0 {
+			continue
+		}
+		if err := w.run(ctx, increment); err != nil {
+			c.notifyError(increment.table, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			w.rebuffer(id, increment)
+		}
+	}
+	return firstErr
+}
+
+// run runs the increment as a single UPDATE, through the mutation callbacks so it invalidates the cache as usual
+func (w *writeBack) run(ctx context.Context, p *pendingIncrement) error {
+	tx := w.db.WithContext(context.WithValue(ctx, writeBackFlushContextKey, true))
+	if p.model != nil {
+		// The model brings its update clauses, e.g. the soft delete condition
+		tx = tx.Model(reflect.New(p.model).Interface())
+	}
+	return tx.Table(p.table).Clauses(p.where).
+		UpdateColumn(p.column, gorm.Expr("? + ?", clause.Column{Name: p.column}, p.delta)).Error
+}
+
+func (w *writeBack) rebuffer(id string, increment *pendingIncrement) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if pending, ok := w.pending[id]; ok {
+		pending.delta += increment.delta
+	} else {
+		w.pending[id] = increment
+	}
+	w.count++
+}
+
+// closeWriteBack stops the flusher and flushes the buffered increments, the later increments run straight away
+func (c *Caches) closeWriteBack() error {
+	w := c.writeBack
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.stopped
+	return c.FlushWrites(context.Background())
+}
+
+// bufferedWrite reports whether the update was buffered by the write-back mode
+func bufferedWrite(db *gorm.DB) bool {
+	buffered, _ := db.InstanceGet(writeBackBufferedKey)
+	return buffered == true
+}
@@ -0,0 +1,283 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type writeBackPageView struct {
+	ID    uint
+	Page  string
+	Views int
+	Title string
+}
+
+func (writeBackPageView) TableName() string {
+	return "page_views"
+}
+
+// writeBackHarness records the updates rather than running them, and counts the reads per table
+// Its ConnPool begins transactions, so the updates run within the default transaction of gorm as with a real driver
+type writeBackHarness struct {
+	db     *gorm.DB
+	caches *Caches
+	pool   *connPoolMock
+
+	mu      sync.Mutex
+	updates []string
+	reads   map[string]int
+	failing error
+}
+
+func newWriteBackHarness(t *testing.T, conf *Config) *writeBackHarness {
+	pool := &connPoolMock{}
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{ConnPool: pool})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+
+	h := &writeBackHarness{db: db, pool: pool, reads: map[string]int{}}
+	update := db.Callback().Update().Get("gorm:update")
+	if err := db.Callback().Update().Replace("gorm:update", func(db *gorm.DB) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.failing != nil {
+			_ = db.AddError(h.failing)
+			return
+		}
+		// Builds the UPDATE without running it
+		dryRun := *db.Config
+		dryRun.DryRun = true
+		db.Config = &dryRun
+		update(db)
+		h.updates = append(h.updates, db.Dialector.Explain(db.Statement.SQL.String(), db.Statement.Vars...))
+	}); err != nil {
+		t.Fatalf("the update callback replacement resulted into an unexpected error, %s", err.Error())
+	}
+
+	conf.Cacher = NewMemoryCacher()
+	if conf.WriteBackTables == nil {
+		conf.WriteBackTables = []any{"^page_views$"}
+	}
+	if conf.WriteBackInterval == 0 {
+		conf.WriteBackInterval = time.Hour
+	}
+	h.caches = &Caches{Conf: conf}
+	if err := db.Use(h.caches); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	t.Cleanup(func() {
+		h.fail(nil)
+		_ = h.caches.Close()
+	})
+
+	h.caches.callbacks[uponQuery] = func(db *gorm.DB) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.reads[db.Statement.Table]++
+		db.Statement.RowsAffected = 1
+	}
+	return h
+}
+
+func (h *writeBackHarness) increment(page string, n int) *gorm.DB {
+	return h.db.Model(&writeBackPageView{}).Where("page = ?", page).UpdateColumn("views", gorm.Expr("views + ?", n))
+}
+
+func (h *writeBackHarness) recorded() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := append([]string(nil), h.updates...)
+	sort.Strings(res)
+	return res
+}
+
+func (h *writeBackHarness) readsOf(table string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.reads[table]
+}
+
+func (h *writeBackHarness) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failing = err
+}
+
+func TestCaches_WriteBack(t *testing.T) {
+	t.Run("increments are summed until flushed", func(t *testing.T) {
+		h := newWriteBackHarness(t, &Config{})
+		for i := 0; i < 3; i++ {
+			if err := h.increment("home", 1).Error; err != nil {
+				t.Fatalf("the increment resulted into an unexpected error, %v", err)
+			}
+		}
+		h.increment("about", 2)
+		h.db.Model(&writeBackPageView{}).Where("page = ?", "about").Update("Views", gorm.Expr("`views` - ?", 1))
+		if act := h.recorded(); len(act) != 0 {
+			t.Fatalf("expected the increments to be buffered, got %v", act)
+		}
+
+		if err := h.caches.FlushWrites(context.Background()); err != nil {
+			t.Fatalf("the flush resulted into an unexpected error, %v", err)
+		}
+		expected := []string{
+			"UPDATE `page_views` SET `views`=`views` + 1 WHERE page = \"about\"",
+			"UPDATE `page_views` SET `views`=`views` + 3 WHERE page = \"home\"",
+		}
+		if act := h.recorded(); !reflect.DeepEqual(act, expected) {
+			t.Errorf("expected a single update per page, got %v", act)
+		}
+
+		if err := h.caches.FlushWrites(context.Background()); err != nil || len(h.recorded()) != 2 {
+			t.Errorf("expected nothing left to flush, got %v and %v", h.recorded(), err)
+		}
+	})
+
+	t.Run("reads are invalidated by the flush", func(t *testing.T) {
+		h := newWriteBackHarness(t, &Config{})
+		read := func() {
+			h.db.Where("page = ?", "home").Find(&[]writeBackPageView{})
+		}
+
+		read()
+		h.increment("home", 1)
+		read()
+		if act := h.readsOf("page_views"); act != 1 {
+			t.Errorf("expected the buffered increment not to invalidate, got %d reads", act)
+		}
+
+		_ = h.caches.FlushWrites(context.Background())
+		read()
+		if act := h.readsOf("page_views"); act != 2 {
+			t.Errorf("expected the flush to invalidate the table, got %d reads", act)
+		}
+	})
+
+	t.Run("other updates run straight away", func(t *testing.T) {
+		h := newWriteBackHarness(t, &Config{})
+		h.db.Model(&writeBackPageView{}).Where("page = ?", "home").Update("title", "Home")
+		h.db.Model(&writeBackPageView{ID: 1}).UpdateColumn("views", gorm.Expr("views + ?", 1))
+		h.db.Model(&writeBackPageView{}).Where("page = ?", "home").UpdateColumn("views", gorm.Expr("title + ?", 1))
+		h.db.Model(&writeBackPageView{}).Where("page = ?", "home").UpdateColumn("views", gorm.Expr("views + ?", 1.5))
+		h.db.Table("pages").Where("page = ?", "home").UpdateColumn("views", gorm.Expr("views + ?", 1))
+		h.db.Session(&gorm.Session{DryRun: true}).Model(&writeBackPageView{}).Where("page = ?", "home").
+			UpdateColumn("views", gorm.Expr("views + ?", 1))
+
+		// The DryRun update reaches the callback as well, which builds it without running it
+		if act := h.recorded(); len(act) != 6 {
+			t.Errorf("expected the updates other than the counter increments of the table to run, got %v", act)
+		}
+	})
+
+	t.Run("transactions", func(t *testing.T) {
+		h := newWriteBackHarness(t, &Config{})
+		if err := h.increment("home", 1).Error; err != nil {
+			t.Fatalf("the increment resulted into an unexpected error, %v", err)
+		}
+		if act := h.recorded(); len(act) != 0 || h.pool.committed != 1 {
+			t.Fatalf("expected the increment run in the default transaction to be buffered, got %v and %d commits",
+				act, h.pool.committed)
+		}
+
+		_ = h.db.Transaction(func(tx *gorm.DB) error {
+			return tx.Model(&writeBackPageView{}).Where("page = ?", "home").
+				UpdateColumn("views", gorm.Expr("views + ?", 1)).Error
+		})
+		if act := h.recorded(); len(act) != 1 {
+			t.Errorf("expected the increment run in a transaction to run straight away, got %v", act)
+		}
+
+		h.db.Session(&gorm.Session{SkipDefaultTransaction: true}).Model(&writeBackPageView{}).
+			Where("page = ?", "home").UpdateColumn("views", gorm.Expr("views + ?", 1))
+		if err := h.caches.FlushWrites(context.Background()); err != nil {
+			t.Fatalf("the flush resulted into an unexpected error, %v", err)
+		}
+		if act := h.recorded(); len(act) != 2 || act[0] != "UPDATE `page_views` SET `views`=`views` + 2 WHERE page = \"home\"" {
+			t.Errorf("expected the increments run outside of a transaction to be summed, got %v", act)
+		}
+	})
+
+	t.Run("threshold", func(t *testing.T) {
+		h := newWriteBackHarness(t, &Config{WriteBackThreshold: 2})
+		h.increment("home", 1)
+		h.increment("about", 1)
+
+		deadline := time.Now().Add(time.Second)
+		for len(h.recorded()) < 2 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if act := h.recorded(); len(act) != 2 {
+			t.Errorf("expected the threshold to flush the increments, got %v", act)
+		}
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		h := newWriteBackHarness(t, &Config{WriteBackInterval: 20 * time.Millisecond})
+		h.increment("home", 1)
+
+		deadline := time.Now().Add(time.Second)
+		for len(h.recorded()) < 1 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if act := h.recorded(); len(act) != 1 {
+			t.Errorf("expected the interval to flush the increments, got %v", act)
+		}
+	})
+
+	t.Run("failed flushes are retried", func(t *testing.T) {
+		h := newWriteBackHarness(t, &Config{})
+		h.increment("home", 1)
+
+		unavailable := errors.New("unavailable")
+		h.fail(unavailable)
+		if err := h.caches.FlushWrites(context.Background()); !errors.Is(err, unavailable) {
+			t.Fatalf("expected the error of the flush to be returned, got %v", err)
+		}
+
+		h.fail(nil)
+		h.increment("home", 1)
+		_ = h.caches.FlushWrites(context.Background())
+		if act := h.recorded(); !reflect.DeepEqual(act, []string{"UPDATE `page_views` SET `views`=`views` + 2 WHERE page = \"home\""}) {
+			t.Errorf("expected the failed increment to be flushed along with the later one, got %v", act)
+		}
+	})
+
+	t.Run("close flushes the increments", func(t *testing.T) {
+		h := newWriteBackHarness(t, &Config{})
+		h.increment("home", 1)
+		if err := h.caches.Close(); err != nil {
+			t.Fatalf("closing the plugin resulted into an unexpected error, %v", err)
+		}
+		if act := h.recorded(); len(act) != 1 {
+			t.Fatalf("expected Close to flush the increments, got %v", act)
+		}
+
+		h.increment("home", 1)
+		if act := h.recorded(); len(act) != 2 {
+			t.Errorf("expected the increments following Close to run straight away, got %v", act)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{}}
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		if caches.writeBack != nil || caches.FlushWrites(context.Background()) != nil {
+			t.Error("expected the write-back mode to be disabled without WriteBackTables")
+		}
+	})
+}